@@ -5,5 +5,7 @@ go 1.16
 require (
 	github.com/aws/aws-sdk-go v1.40.41
 	github.com/google/uuid v1.3.0
+	github.com/mattn/go-sqlite3 v1.14.8
 	github.com/pkg/errors v0.9.1
+	go.etcd.io/bbolt v1.3.6
 )
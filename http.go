@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/antklim/go-invoice/invoice"
+	"github.com/antklim/go-invoice/rest"
+)
+
+// httpShutdownTimeout is how long requests in flight are waited for on exit
+// before the connections are closed.
+const httpShutdownTimeout = 10 * time.Second
+
+// startHTTP starts serving the REST API of the service on -http-addr. The exit
+// is signaled when the server fails.
+func startHTTP(svc *invoice.Service, exit chan<- struct{}) *http.Server {
+	ln, err := net.Listen("tcp", httpAddr)
+	if err != nil {
+		panic("svc: " + err.Error())
+	}
+
+	srv := &http.Server{
+		Handler:           rest.NewHandler(svc, rest.WithLog(os.Stdout)),
+		ReadHeaderTimeout: httpShutdownTimeout,
+	}
+	go func() {
+		if err := srv.Serve(ln); err != http.ErrServerClosed {
+			fmt.Printf("http server failed: %v\n", err)
+			exit <- struct{}{}
+		}
+	}()
+	fmt.Printf("serving REST API on %s\n", ln.Addr())
+	return srv
+}
+
+// stopHTTP stops accepting requests and waits for requests in flight to
+// finish.
+func stopHTTP(srv *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		fmt.Printf("stop http server failed: %v\n", err)
+	}
+}
@@ -0,0 +1,205 @@
+package invoice
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// BulkStatus is the outcome of a bulk operation for a single invoice.
+type BulkStatus string
+
+// Bulk operation outcomes.
+const (
+	BulkSucceeded BulkStatus = "succeeded" // changed, or would be changed in dry run
+	BulkSkipped   BulkStatus = "skipped"   // invoice status does not allow the change
+	BulkFailed    BulkStatus = "failed"    // invoice not found or storage failed
+)
+
+// BulkResult is the result of a bulk operation for a single invoice. Err
+// explains skipped and failed results.
+type BulkResult struct {
+	ID     string
+	Status BulkStatus
+	Err    error
+}
+
+// BulkReport contains results of a bulk operation in the order of selected
+// invoices.
+type BulkReport struct {
+	DryRun  bool
+	Results []BulkResult
+}
+
+// Count returns the number of results of the status.
+func (r BulkReport) Count(status BulkStatus) int {
+	var n int
+	for _, res := range r.Results {
+		if res.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// Filter selects invoices by their fields, zero fields match any invoice.
+type Filter struct {
+	Status   *Status
+	Customer string // should match exactly
+}
+
+// Match returns true when the invoice matches all filter fields.
+func (f Filter) Match(inv *Invoice) bool {
+	if f.Status != nil && inv.Status != *f.Status {
+		return false
+	}
+	if f.Customer != "" && inv.CustomerName != f.Customer {
+		return false
+	}
+	return true
+}
+
+// Selection selects invoices of a bulk operation by IDs or by filter.
+type Selection struct {
+	ids   []string
+	match func(*Invoice) bool
+}
+
+// SelectIDs selects invoices by IDs.
+func SelectIDs(ids ...string) Selection {
+	return Selection{ids: ids}
+}
+
+// SelectMatching selects invoices matching the filter. The storage should
+// implement Lister.
+func SelectMatching(f Filter) Selection {
+	return Selection{match: f.Match}
+}
+
+// IssueInvoices issues the selected invoices. See BulkReport for the results.
+func (s *Service) IssueInvoices(sel Selection, opts ...BulkOption) (BulkReport, error) {
+	return s.bulk(sel, s.issue, opts)
+}
+
+// PayInvoices pays the selected invoices. See BulkReport for the results.
+func (s *Service) PayInvoices(sel Selection, opts ...BulkOption) (BulkReport, error) {
+	return s.bulk(sel, (*Invoice).Pay, opts)
+}
+
+// CancelInvoices cancels the selected invoices. See BulkReport for the
+// results.
+func (s *Service) CancelInvoices(sel Selection, opts ...BulkOption) (BulkReport, error) {
+	return s.bulk(sel, (*Invoice).Cancel, opts)
+}
+
+// bulk applies the status change to every selected invoice, invoices are
+// found and updated independently by a bounded number of workers. Failure of
+// one invoice does not stop the others. It returns error only when invoices
+// cannot be selected.
+func (s *Service) bulk(sel Selection, change func(*Invoice) error, opts []BulkOption) (BulkReport, error) {
+	bopts := defaultBulkOptions
+	for _, o := range opts {
+		o.apply(&bopts)
+	}
+
+	ids, err := s.selectIDs(sel)
+	if err != nil {
+		return BulkReport{}, err
+	}
+
+	report := BulkReport{DryRun: bopts.dryRun, Results: make([]BulkResult, len(ids))}
+	sem := make(chan struct{}, bopts.concurrency)
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, id string) {
+			defer func() { <-sem; wg.Done() }()
+			report.Results[i] = s.bulkOne(id, change, bopts.dryRun)
+		}(i, id)
+	}
+	wg.Wait()
+
+	return report, nil
+}
+
+func (s *Service) bulkOne(id string, change func(*Invoice) error, dryRun bool) BulkResult {
+	res := BulkResult{ID: id, Status: BulkFailed}
+
+	inv, err := s.mustFindInvoice(id)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+
+	from := inv.Status
+	if err := change(inv); err != nil {
+		res.Status, res.Err = BulkSkipped, err
+		return res
+	}
+
+	if !dryRun {
+		if err := s.update(inv, from); err != nil {
+			res.Err = errors.Wrapf(err, errUpdateFailed, id)
+			return res
+		}
+	}
+
+	res.Status = BulkSucceeded
+	return res
+}
+
+// selectIDs returns IDs of the selected invoices. Repeated IDs are selected
+// once.
+func (s *Service) selectIDs(sel Selection) ([]string, error) {
+	if sel.match == nil {
+		seen := make(map[string]bool, len(sel.ids))
+		var ids []string
+		for _, id := range sel.ids {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+		return ids, nil
+	}
+
+	invs, err := s.findInvoices(sel.match)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(invs))
+	for i, inv := range invs {
+		ids[i] = inv.ID
+	}
+	return ids, nil
+}
+
+// findInvoices returns all invoices of the storage matching the predicate. The
+// storage should implement Lister.
+func (s *Service) findInvoices(match func(*Invoice) bool) ([]Invoice, error) {
+	l, ok := s.strg.(Lister)
+	if !ok {
+		return nil, errors.New("storage does not support listing invoices")
+	}
+
+	var invs []Invoice
+	cursor := ""
+	for {
+		page, next, err := l.ListInvoices(cursor, listBatchSize)
+		if err != nil {
+			return nil, errors.Wrap(err, errListFailed)
+		}
+
+		for i := range page {
+			if match(&page[i]) {
+				invs = append(invs, page[i])
+			}
+		}
+
+		if next == "" {
+			return invs, nil
+		}
+		cursor = next
+	}
+}
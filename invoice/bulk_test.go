@@ -0,0 +1,140 @@
+package invoice_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/antklim/go-invoice/invoice"
+	testapi "github.com/antklim/go-invoice/test/api"
+	"github.com/antklim/go-invoice/test/mocks"
+	"github.com/google/uuid"
+)
+
+func assertResults(t *testing.T, report invoice.BulkReport, want map[string]invoice.BulkStatus) {
+	t.Helper()
+
+	if len(report.Results) != len(want) {
+		t.Fatalf("invalid number of results %d, want %d: %v", len(report.Results), len(want), report.Results)
+	}
+	for _, res := range report.Results {
+		if res.Status != want[res.ID] {
+			t.Errorf("invoice %q result %q (%v), want %q", res.ID, res.Status, res.Err, want[res.ID])
+		}
+		if res.Status != invoice.BulkSucceeded && res.Err == nil {
+			t.Errorf("invoice %q %s result error expected", res.ID, res.Status)
+		}
+	}
+}
+
+func TestIssueInvoices(t *testing.T) {
+	srv, invoiceAPI := serviceSetup()
+
+	t.Run("issues invoices by IDs", func(t *testing.T) {
+		invs, err := invoiceAPI.CreateInvoicesWithStatuses(invoice.Open, invoice.Open, invoice.Paid)
+		if err != nil {
+			t.Fatalf("invoiceAPI.CreateInvoicesWithStatuses() failed: %v", err)
+		}
+		unknown := uuid.NewString()
+		ids := []string{invs[0].ID, invs[1].ID, invs[2].ID, unknown, invs[0].ID}
+
+		report, err := srv.IssueInvoices(invoice.SelectIDs(ids...), invoice.WithConcurrency(2))
+		if err != nil {
+			t.Fatalf("IssueInvoices() failed: %v", err)
+		}
+		assertResults(t, report, map[string]invoice.BulkStatus{
+			invs[0].ID: invoice.BulkSucceeded,
+			invs[1].ID: invoice.BulkSucceeded,
+			invs[2].ID: invoice.BulkSkipped,
+			unknown:    invoice.BulkFailed,
+		})
+		for i, id := range ids[:4] {
+			if report.Results[i].ID != id {
+				t.Errorf("result %d of invoice %q, want %q", i, report.Results[i].ID, id)
+			}
+		}
+		if n := report.Count(invoice.BulkSucceeded); n != 2 {
+			t.Errorf("Count(%q) = %d, want 2", invoice.BulkSucceeded, n)
+		}
+
+		for _, inv := range invs[:2] {
+			if vinv := mustView(t, srv, inv.ID); vinv.Status != invoice.Issued {
+				t.Errorf("invalid invoice.Status %q, want %q", vinv.Status, invoice.Issued)
+			}
+		}
+	})
+
+	t.Run("previews changes in dry run", func(t *testing.T) {
+		inv, err := invoiceAPI.CreateInvoice()
+		if err != nil {
+			t.Fatalf("invoiceAPI.CreateInvoice() failed: %v", err)
+		}
+
+		report, err := srv.IssueInvoices(invoice.SelectIDs(inv.ID), invoice.WithDryRun())
+		if err != nil {
+			t.Fatalf("IssueInvoices() failed: %v", err)
+		}
+		if !report.DryRun {
+			t.Error("dry run report expected")
+		}
+		assertResults(t, report, map[string]invoice.BulkStatus{inv.ID: invoice.BulkSucceeded})
+
+		if vinv := mustView(t, srv, inv.ID); !vinv.Equal(&inv) {
+			t.Errorf("invoice changed in dry run: %v, want %v", vinv, inv)
+		}
+	})
+}
+
+func TestPayInvoices(t *testing.T) {
+	srv, invoiceAPI := serviceSetup()
+
+	customer := uuid.NewString()
+	var want = make(map[string]invoice.BulkStatus)
+	for _, status := range []invoice.Status{invoice.Issued, invoice.Issued, invoice.Open} {
+		inv, err := invoiceAPI.CreateInvoice(testapi.WithCustomerName(customer), testapi.WithStatus(status))
+		if err != nil {
+			t.Fatalf("invoiceAPI.CreateInvoice() failed: %v", err)
+		}
+		if status == invoice.Issued {
+			want[inv.ID] = invoice.BulkSucceeded
+		}
+	}
+	if _, err := invoiceAPI.CreateInvoice(testapi.WithStatus(invoice.Issued)); err != nil {
+		t.Fatalf("invoiceAPI.CreateInvoice() failed: %v", err)
+	}
+
+	issued := invoice.Issued
+	report, err := srv.PayInvoices(invoice.SelectMatching(invoice.Filter{Status: &issued, Customer: customer}))
+	if err != nil {
+		t.Fatalf("PayInvoices() failed: %v", err)
+	}
+	assertResults(t, report, want)
+
+	for id := range want {
+		if vinv := mustView(t, srv, id); vinv.Status != invoice.Paid {
+			t.Errorf("invalid invoice.Status %q, want %q", vinv.Status, invoice.Paid)
+		}
+	}
+}
+
+func TestCancelInvoices(t *testing.T) {
+	t.Run("reports failed update", func(t *testing.T) {
+		inv := invoice.NewInvoice("John Doe")
+		strg := mocks.NewStorage(
+			mocks.WithFoundInvoice(&inv),
+			mocks.WithUpdateInvoiceError(errors.New("storage failed")))
+		srv := invoice.New(strg)
+
+		report, err := srv.CancelInvoices(invoice.SelectIDs(inv.ID))
+		if err != nil {
+			t.Fatalf("CancelInvoices() failed: %v", err)
+		}
+		assertResults(t, report, map[string]invoice.BulkStatus{inv.ID: invoice.BulkFailed})
+	})
+
+	t.Run("fails when storage cannot list invoices", func(t *testing.T) {
+		srv := invoice.New(mocks.NewStorage())
+		if _, err := srv.CancelInvoices(invoice.SelectMatching(invoice.Filter{})); err == nil {
+			t.Error("CancelInvoices() expected to fail")
+		}
+	})
+}
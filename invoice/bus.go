@@ -0,0 +1,253 @@
+package invoice
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// BusEvent is the event the service emits to the bus subscribers after the
+// change is stored: InvoiceCreated, ItemAdded, ItemDeleted, CustomerUpdated,
+// InvoiceIssued, InvoicePaid or InvoiceCanceled. Subscribers switch on the
+// event type.
+type BusEvent interface {
+	// InvoiceID returns the ID of the changed invoice.
+	InvoiceID() string
+}
+
+// InvoiceCreated is emitted when the invoice is created, including invoices
+// created by split and merge.
+type InvoiceCreated struct {
+	Invoice Invoice
+}
+
+func (e InvoiceCreated) InvoiceID() string { return e.Invoice.ID }
+
+// ItemAdded is emitted when the item is added to the invoice.
+type ItemAdded struct {
+	Invoice Invoice // the invoice after the change
+	Item    Item
+}
+
+func (e ItemAdded) InvoiceID() string { return e.Invoice.ID }
+
+// ItemDeleted is emitted when the item is deleted from the invoice.
+type ItemDeleted struct {
+	Invoice Invoice // the invoice after the change
+	Item    Item
+}
+
+func (e ItemDeleted) InvoiceID() string { return e.Invoice.ID }
+
+// CustomerUpdated is emitted when the invoice customer name is updated.
+type CustomerUpdated struct {
+	Invoice Invoice // the invoice after the change
+	From    string  // previous customer name
+}
+
+func (e CustomerUpdated) InvoiceID() string { return e.Invoice.ID }
+
+// InvoiceIssued is emitted when the invoice is issued.
+type InvoiceIssued struct {
+	Invoice Invoice
+}
+
+func (e InvoiceIssued) InvoiceID() string { return e.Invoice.ID }
+
+// InvoicePaid is emitted when the invoice is paid.
+type InvoicePaid struct {
+	Invoice Invoice
+}
+
+func (e InvoicePaid) InvoiceID() string { return e.Invoice.ID }
+
+// InvoiceCanceled is emitted when the invoice is canceled, including invoices
+// canceled by bulk commands, jobs and merge.
+type InvoiceCanceled struct {
+	Invoice Invoice
+}
+
+func (e InvoiceCanceled) InvoiceID() string { return e.Invoice.ID }
+
+// Handler handles the bus events.
+type Handler func(BusEvent)
+
+// Bus delivers the events to the subscribers. Sync subscribers are called by
+// Emit in the order they subscribed. Async subscribers handle the events in
+// their own goroutine from the bounded queue, events emitted when the queue is
+// full are dropped, so slow subscribers do not block the service. Panics of
+// subscribers are recovered and passed to the panic handler.
+type Bus struct {
+	mu   sync.RWMutex
+	subs []*Subscription
+	opts busOptions
+}
+
+// NewBus creates the event bus.
+func NewBus(opts ...BusOption) *Bus {
+	bopts := defaultBusOptions
+	for _, o := range opts {
+		o.apply(&bopts)
+	}
+	return &Bus{opts: bopts}
+}
+
+// Subscribe subscribes the handler to all events. The subscription is sync
+// unless WithAsync option is set.
+func (b *Bus) Subscribe(h Handler, opts ...SubscribeOption) *Subscription {
+	sopts := subscribeOptions{}
+	for _, o := range opts {
+		o.apply(&sopts)
+	}
+
+	sub := &Subscription{bus: b, h: h}
+	if sopts.queueSize > 0 {
+		sub.queue = make(chan BusEvent, sopts.queueSize)
+		sub.done = make(chan struct{})
+		go sub.run()
+	}
+
+	b.mu.Lock()
+	b.subs = append(b.subs, sub)
+	b.mu.Unlock()
+	return sub
+}
+
+// Emit delivers the event to the subscribers.
+func (b *Bus) Emit(e BusEvent) {
+	b.mu.RLock()
+	subs := b.subs
+	b.mu.RUnlock()
+
+	for _, sub := range subs {
+		sub.deliver(e)
+	}
+}
+
+// Close unsubscribes all subscribers, waiting for async subscribers to handle
+// the queued events.
+func (b *Bus) Close() {
+	b.mu.RLock()
+	subs := b.subs
+	b.mu.RUnlock()
+
+	for _, sub := range subs {
+		sub.Unsubscribe()
+	}
+}
+
+func (b *Bus) remove(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	subs := make([]*Subscription, 0, len(b.subs))
+	for _, s := range b.subs {
+		if s != sub {
+			subs = append(subs, s)
+		}
+	}
+	b.subs = subs
+}
+
+// Subscription is the handler subscribed to the bus.
+type Subscription struct {
+	bus     *Bus
+	h       Handler
+	queue   chan BusEvent // nil for sync subscription
+	done    chan struct{}
+	dropped int64
+
+	mu     sync.Mutex // guards queue close
+	closed bool
+}
+
+// Unsubscribe stops delivering events to the handler. Async subscription
+// waits for the handler to handle the queued events, so it should not be
+// called by the handler.
+func (s *Subscription) Unsubscribe() {
+	s.bus.remove(s)
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	if s.queue != nil {
+		close(s.queue)
+	}
+	s.mu.Unlock()
+
+	if s.done != nil {
+		<-s.done
+	}
+}
+
+// Dropped returns the number of events dropped because the queue of the async
+// subscription was full.
+func (s *Subscription) Dropped() int {
+	return int(atomic.LoadInt64(&s.dropped))
+}
+
+func (s *Subscription) deliver(e BusEvent) {
+	if s.queue == nil {
+		s.handle(e)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.queue <- e:
+	default:
+		atomic.AddInt64(&s.dropped, 1)
+	}
+}
+
+func (s *Subscription) run() {
+	defer close(s.done)
+	for e := range s.queue {
+		s.handle(e)
+	}
+}
+
+// handle calls the handler, recovering its panic.
+func (s *Subscription) handle(e BusEvent) {
+	defer func() {
+		if p := recover(); p != nil && s.bus.opts.panicHandler != nil {
+			s.bus.opts.panicHandler(e, p)
+		}
+	}()
+	s.h(e)
+}
+
+// busEvents returns the bus events of the lifecycle events, events of the
+// statuses without bus events are skipped.
+func busEvents(events []Event) []BusEvent {
+	var bevents []BusEvent
+	for _, e := range events {
+		switch e.Type {
+		case EventCreated:
+			bevents = append(bevents, InvoiceCreated{Invoice: e.Invoice.Clone()})
+		case EventType(Issued):
+			bevents = append(bevents, InvoiceIssued{Invoice: e.Invoice.Clone()})
+		case EventType(Paid):
+			bevents = append(bevents, InvoicePaid{Invoice: e.Invoice.Clone()})
+		case EventType(Canceled):
+			bevents = append(bevents, InvoiceCanceled{Invoice: e.Invoice.Clone()})
+		}
+	}
+	return bevents
+}
+
+// emit emits the events to the bus when it is set.
+func (s *Service) emit(events ...BusEvent) {
+	if s.opts.bus == nil {
+		return
+	}
+	for _, e := range events {
+		s.opts.bus.Emit(e)
+	}
+}
@@ -0,0 +1,194 @@
+package invoice_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/antklim/go-invoice/invoice"
+	testapi "github.com/antklim/go-invoice/test/api"
+	"github.com/antklim/go-invoice/test/mocks"
+)
+
+// busEventTypes returns the type names of the events.
+func busEventTypes(events []invoice.BusEvent) []string {
+	types := make([]string, 0, len(events))
+	for _, e := range events {
+		types = append(types, fmt.Sprintf("%T", e))
+	}
+	return types
+}
+
+func assertBusEvents(t *testing.T, sub *mocks.Subscriber, id string, want ...string) {
+	t.Helper()
+
+	got := busEventTypes(sub.EventsOf(id))
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("invalid invoice %q bus events %v, want %v", id, got, want)
+	}
+}
+
+func TestBusEmitsServiceEvents(t *testing.T) {
+	strg := storageSetup()
+	bus := invoice.NewBus()
+	sub := new(mocks.Subscriber)
+	bus.Subscribe(sub.Handle)
+	srv := invoice.New(strg, invoice.WithBus(bus))
+	invoiceAPI := testapi.NewIvoiceAPI(strg)
+
+	t.Run("invoice changes", func(t *testing.T) {
+		inv, err := srv.CreateInvoice("John Doe")
+		if err != nil {
+			t.Fatalf("CreateInvoice() failed: %v", err)
+		}
+		if err := srv.UpdateInvoiceCustomer(inv.ID, "Jane Doe"); err != nil {
+			t.Fatalf("UpdateInvoiceCustomer() failed: %v", err)
+		}
+		pen, err := srv.AddInvoiceItem(inv.ID, "Pen", 100, 2)
+		if err != nil {
+			t.Fatalf("AddInvoiceItem() failed: %v", err)
+		}
+		if _, err := srv.AddInvoiceItem(inv.ID, "Pencil", 50, 1); err != nil {
+			t.Fatalf("AddInvoiceItem() failed: %v", err)
+		}
+		if err := srv.DeleteInvoiceItem(inv.ID, pen.ID); err != nil {
+			t.Fatalf("DeleteInvoiceItem() failed: %v", err)
+		}
+		if err := srv.DeleteInvoiceItem(inv.ID, pen.ID); err != nil {
+			t.Fatalf("DeleteInvoiceItem() of deleted item failed: %v", err)
+		}
+		if err := srv.IssueInvoice(inv.ID); err != nil {
+			t.Fatalf("IssueInvoice() failed: %v", err)
+		}
+		if err := srv.PayInvoice(inv.ID); err != nil {
+			t.Fatalf("PayInvoice() failed: %v", err)
+		}
+
+		assertBusEvents(t, sub, inv.ID, "invoice.InvoiceCreated", "invoice.CustomerUpdated", "invoice.ItemAdded",
+			"invoice.ItemAdded", "invoice.ItemDeleted", "invoice.InvoiceIssued", "invoice.InvoicePaid")
+
+		events := sub.EventsOf(inv.ID)
+		if e := events[1].(invoice.CustomerUpdated); e.From != "John Doe" || e.Invoice.CustomerName != "Jane Doe" {
+			t.Errorf("invalid customer updated event %+v", e)
+		}
+		if e := events[4].(invoice.ItemDeleted); e.Item.ID != pen.ID || len(e.Invoice.Items) != 1 {
+			t.Errorf("invalid item deleted event %+v", e)
+		}
+		if e := events[6].(invoice.InvoicePaid); e.Invoice.Status != invoice.Paid {
+			t.Errorf("invalid paid event %+v", e)
+		}
+	})
+
+	t.Run("nothing emitted when change failed", func(t *testing.T) {
+		inv, err := invoiceAPI.CreateInvoice(testapi.WithStatus(invoice.Paid))
+		if err != nil {
+			t.Fatalf("invoiceAPI.CreateInvoice() failed: %v", err)
+		}
+		if err := srv.CancelInvoice(inv.ID); err == nil {
+			t.Fatal("CancelInvoice() of paid invoice expected to fail")
+		}
+		if _, err := srv.AddInvoiceItem(inv.ID, "Pen", 100, 1); err == nil {
+			t.Fatal("AddInvoiceItem() to paid invoice expected to fail")
+		}
+		assertBusEvents(t, sub, inv.ID)
+	})
+
+	t.Run("merged invoices", func(t *testing.T) {
+		inv1, err := invoiceAPI.CreateInvoiceWithNItems(1)
+		if err != nil {
+			t.Fatalf("invoiceAPI.CreateInvoiceWithNItems() failed: %v", err)
+		}
+		inv2, err := invoiceAPI.CreateInvoiceWithNItems(1)
+		if err != nil {
+			t.Fatalf("invoiceAPI.CreateInvoiceWithNItems() failed: %v", err)
+		}
+
+		merged, err := srv.MergeInvoices([]string{inv1.ID, inv2.ID})
+		if err != nil {
+			t.Fatalf("MergeInvoices() failed: %v", err)
+		}
+		assertBusEvents(t, sub, merged.ID, "invoice.InvoiceCreated")
+		assertBusEvents(t, sub, inv1.ID, "invoice.InvoiceCanceled")
+		assertBusEvents(t, sub, inv2.ID, "invoice.InvoiceCanceled")
+	})
+}
+
+func TestBusSubscriptions(t *testing.T) {
+	created := func() invoice.BusEvent {
+		return invoice.InvoiceCreated{Invoice: invoice.NewInvoice("John Doe")}
+	}
+
+	t.Run("isolates panics", func(t *testing.T) {
+		var panics []interface{}
+		bus := invoice.NewBus(invoice.WithPanicHandler(func(e invoice.BusEvent, p interface{}) {
+			panics = append(panics, p)
+		}))
+		bus.Subscribe(func(invoice.BusEvent) { panic("subscriber failed") })
+		sub := new(mocks.Subscriber)
+		bus.Subscribe(sub.Handle)
+
+		bus.Emit(created())
+		if len(sub.Events) != 1 {
+			t.Errorf("invalid number of handled events %d, want 1", len(sub.Events))
+		}
+		if len(panics) != 1 || panics[0] != "subscriber failed" {
+			t.Errorf("invalid recovered panics %v", panics)
+		}
+	})
+
+	t.Run("unsubscribes", func(t *testing.T) {
+		bus := invoice.NewBus()
+		sub := new(mocks.Subscriber)
+		s := bus.Subscribe(sub.Handle)
+		bus.Emit(created())
+		s.Unsubscribe()
+		bus.Emit(created())
+		if len(sub.Events) != 1 {
+			t.Errorf("invalid number of handled events %d, want 1", len(sub.Events))
+		}
+	})
+
+	t.Run("async subscriber drops events when queue is full", func(t *testing.T) {
+		bus := invoice.NewBus()
+		sub := new(mocks.Subscriber)
+		block, started := make(chan struct{}), make(chan struct{})
+		s := bus.Subscribe(func(e invoice.BusEvent) {
+			if len(sub.Events) == 0 {
+				close(started)
+				<-block
+			}
+			sub.Handle(e)
+		}, invoice.WithAsync(2))
+
+		bus.Emit(created())
+		<-started
+		for i := 0; i < 3; i++ {
+			bus.Emit(created())
+		}
+		if s.Dropped() != 1 {
+			t.Errorf("invalid number of dropped events %d, want 1", s.Dropped())
+		}
+
+		close(block)
+		bus.Close()
+		if len(sub.Events) != 3 {
+			t.Errorf("invalid number of handled events %d, want 3", len(sub.Events))
+		}
+	})
+
+	t.Run("async subscriber panic does not stop it", func(t *testing.T) {
+		bus := invoice.NewBus()
+		sub := new(mocks.Subscriber)
+		bus.Subscribe(func(e invoice.BusEvent) {
+			sub.Handle(e)
+			panic("subscriber failed")
+		}, invoice.WithAsync(10))
+
+		for i := 0; i < 3; i++ {
+			bus.Emit(created())
+		}
+		bus.Close()
+		if len(sub.Events) != 3 {
+			t.Errorf("invalid number of handled events %d, want 3", len(sub.Events))
+		}
+	})
+}
@@ -0,0 +1,264 @@
+package invoice
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DeliveryStatus is the status of the issued invoice email delivery.
+type DeliveryStatus string
+
+const (
+	DeliveryPending DeliveryStatus = "pending" // waits for the next attempt
+	DeliverySent    DeliveryStatus = "sent"
+	DeliveryFailed  DeliveryStatus = "failed" // all attempts failed, not retried
+)
+
+// Delivery is the email delivery of the issued invoice.
+type Delivery struct {
+	Status   DeliveryStatus    `json:"status"`
+	Attempts []DeliveryAttempt `json:"attempts,omitempty"`
+	NextAt   *time.Time        `json:"nextAt,omitempty"` // time of the next attempt of pending delivery
+}
+
+// DeliveryAttempt is the attempt to send the invoice email.
+type DeliveryAttempt struct {
+	At  time.Time `json:"at"`
+	Err string    `json:"error,omitempty"` // empty when the email was sent
+}
+
+func (d *Delivery) Equal(other *Delivery) bool {
+	if d == nil || other == nil {
+		return d == other
+	}
+	if d.Status != other.Status || !timesEqual(d.NextAt, other.NextAt) || len(d.Attempts) != len(other.Attempts) {
+		return false
+	}
+	for i := range d.Attempts {
+		if !d.Attempts[i].At.Equal(other.Attempts[i].At) || d.Attempts[i].Err != other.Attempts[i].Err {
+			return false
+		}
+	}
+	return true
+}
+
+// Clone returns the deep copy of the delivery.
+func (d *Delivery) Clone() *Delivery {
+	if d == nil {
+		return nil
+	}
+	c := *d
+	if d.NextAt != nil {
+		at := *d.NextAt
+		c.NextAt = &at
+	}
+	if d.Attempts != nil {
+		c.Attempts = append([]DeliveryAttempt(nil), d.Attempts...)
+	}
+	return &c
+}
+
+// record adds the attempt made at the time. The failed delivery is retried
+// by the policy until the policy attempts fail in a row.
+func (d *Delivery) record(at time.Time, err error, p DeliveryPolicy) {
+	if err == nil {
+		d.Attempts = append(d.Attempts, DeliveryAttempt{At: at})
+		d.Status, d.NextAt = DeliverySent, nil
+		return
+	}
+
+	d.Attempts = append(d.Attempts, DeliveryAttempt{At: at, Err: err.Error()})
+	failures := 0
+	for i := len(d.Attempts) - 1; i >= 0 && d.Attempts[i].Err != ""; i-- {
+		failures++
+	}
+	if failures >= p.MaxAttempts {
+		d.Status, d.NextAt = DeliveryFailed, nil
+		return
+	}
+	next := at.Add(p.delay(failures))
+	d.Status, d.NextAt = DeliveryPending, &next
+}
+
+// due returns true when the pending delivery should be attempted at now.
+func (d *Delivery) due(now time.Time) bool {
+	return d != nil && d.Status == DeliveryPending && (d.NextAt == nil || !d.NextAt.After(now))
+}
+
+// DeliveryPolicy configures retries of failed deliveries.
+//
+// The delay before the retry n (starting from 1) is min(MaxDelay,
+// BaseDelay * 2^(n-1)). The delivery fails when MaxAttempts attempts in a row
+// failed.
+type DeliveryPolicy struct {
+	MaxAttempts int           // maximum number of attempts, including the first one
+	BaseDelay   time.Duration // delay before the first retry
+	MaxDelay    time.Duration // upper bound of the delay between attempts
+}
+
+// DefaultDeliveryPolicy is used when no delivery policy configured.
+var DefaultDeliveryPolicy = DeliveryPolicy{
+	MaxAttempts: 5,                // nolint:gomnd
+	BaseDelay:   time.Minute,      // nolint:gomnd
+	MaxDelay:    30 * time.Minute, // nolint:gomnd
+}
+
+// delay returns the delay before the retry n, n starts from 1.
+func (p DeliveryPolicy) delay(n int) time.Duration {
+	if backoff := p.BaseDelay << (n - 1); backoff > 0 && backoff < p.MaxDelay {
+		return backoff
+	}
+	return p.MaxDelay
+}
+
+// Email is the email message.
+type Email struct {
+	To      string
+	Subject string
+	Body    string // plain text
+}
+
+// Mailer sends emails.
+type Mailer interface {
+	Send(Email) error
+}
+
+// AddressBook resolves email addresses of customers.
+type AddressBook interface {
+	Address(customer string) (string, error)
+}
+
+var emailTemplate = template.Must(template.New("email").Funcs(template.FuncMap{
+	"money": func(cents int) string { return fmt.Sprintf("%d.%02d", cents/100, cents%100) }, // nolint:gomnd
+	"mul":   func(a, b int) int { return a * b },
+	"date": func(t *time.Time) string {
+		if t == nil {
+			return "-"
+		}
+		return t.Format("2006-01-02")
+	},
+}).Parse(`Dear {{.CustomerName}},
+
+please find your invoice {{.ID}} issued on {{date .Date}} below.
+
+{{range .Items}}{{.ProductName}}: {{.Qty}} x {{money .Price}} = {{money (mul .Qty .Price)}}
+{{end}}
+Total: {{money .Total}}
+`))
+
+// RenderEmail renders the email of the issued invoice.
+func RenderEmail(inv *Invoice, to string) (Email, error) {
+	var body bytes.Buffer
+	if err := emailTemplate.Execute(&body, inv); err != nil {
+		return Email{}, errors.Wrapf(err, "render invoice %q email failed", inv.ID)
+	}
+
+	return Email{
+		To:      to,
+		Subject: fmt.Sprintf("Invoice %s", inv.ID),
+		Body:    body.String(),
+	}, nil
+}
+
+// DeliverInvoice sends the email of the issued invoice now, whatever its
+// delivery status is. The attempt is recorded on the invoice, failed delivery
+// is retried by DeliverPendingInvoices.
+func (s *Service) DeliverInvoice(id string) error {
+	if s.opts.mailer == nil {
+		return &NotConfiguredError{Feature: "mailer"}
+	}
+
+	inv, err := s.mustFindInvoice(id)
+	if err != nil {
+		return err
+	}
+	if inv.Status == Open || inv.Status == Canceled {
+		return statusError("%q invoice cannot be delivered", inv.Status)
+	}
+
+	return s.deliver(inv, time.Now())
+}
+
+// DeliverPendingInvoices sends the emails of invoices which pending delivery
+// attempt is due at now. The storage should implement Lister.
+func (s *Service) DeliverPendingInvoices(now time.Time) (BulkReport, error) {
+	if s.opts.mailer == nil {
+		return BulkReport{}, &NotConfiguredError{Feature: "mailer"}
+	}
+
+	pending := func(inv *Invoice) bool {
+		return inv.Status != Canceled && inv.Delivery.due(now)
+	}
+	invs, err := s.findInvoices(pending)
+	if err != nil {
+		return BulkReport{}, err
+	}
+
+	report := BulkReport{Results: make([]BulkResult, len(invs))}
+	for i := range invs {
+		res := BulkResult{ID: invs[i].ID, Status: BulkFailed}
+		inv, err := s.mustFindInvoice(invs[i].ID)
+		switch {
+		case err != nil:
+			res.Err = err
+		case !pending(inv): // the invoice may be delivered after it was selected
+			res.Status, res.Err = BulkSkipped, fmt.Errorf("invoice %q has no delivery due", inv.ID)
+		default:
+			if res.Err = s.deliver(inv, now); res.Err == nil {
+				res.Status = BulkSucceeded
+			}
+		}
+		report.Results[i] = res
+	}
+	return report, nil
+}
+
+// issue issues the invoice. The invoice delivery is pending when the mailer is
+// configured.
+func (s *Service) issue(inv *Invoice) error {
+	if err := inv.Issue(); err != nil {
+		return err
+	}
+
+	if s.opts.mailer != nil {
+		inv.Delivery = &Delivery{Status: DeliveryPending, NextAt: inv.Date}
+	}
+	return nil
+}
+
+// deliver sends the invoice email and records the attempt on the invoice.
+func (s *Service) deliver(inv *Invoice, now time.Time) error {
+	sendErr := s.send(inv)
+	d := inv.Delivery.Clone()
+	if d == nil {
+		d = &Delivery{}
+	}
+	d.record(now, sendErr, s.opts.deliveryPolicy)
+	inv.Delivery = d
+
+	if err := s.strg.UpdateInvoice(*inv); err != nil {
+		return errors.Wrapf(err, errUpdateFailed, inv.ID)
+	}
+	return sendErr
+}
+
+func (s *Service) send(inv *Invoice) error {
+	to, err := s.opts.addressBook.Address(inv.CustomerName)
+	if err != nil {
+		return errors.Wrapf(err, "deliver invoice %q failed", inv.ID)
+	}
+
+	email, err := RenderEmail(inv, to)
+	if err != nil {
+		return err
+	}
+
+	if err := s.opts.mailer.Send(email); err != nil {
+		return errors.Wrapf(err, "deliver invoice %q failed", inv.ID)
+	}
+	return nil
+}
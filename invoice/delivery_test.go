@@ -0,0 +1,182 @@
+package invoice_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/antklim/go-invoice/invoice"
+	"github.com/antklim/go-invoice/mailer"
+	testapi "github.com/antklim/go-invoice/test/api"
+	"github.com/antklim/go-invoice/test/mocks"
+)
+
+var addressBook = mailer.AddressBook{"John Doe": "john@example.com"}
+
+func deliverySetup(m invoice.Mailer) (*invoice.Service, *testapi.Invoice) {
+	strg := storageSetup()
+	policy := invoice.DeliveryPolicy{MaxAttempts: 3, BaseDelay: time.Minute, MaxDelay: 90 * time.Second}
+	srv := invoice.New(strg, invoice.WithMailer(m, addressBook), invoice.WithDeliveryPolicy(policy))
+	return srv, testapi.NewIvoiceAPI(strg)
+}
+
+func TestRenderEmail(t *testing.T) {
+	date := time.Date(2021, time.October, 1, 9, 0, 0, 0, time.UTC)
+	inv := invoice.NewInvoice("John Doe")
+	inv.Date = &date
+	inv.Items = []invoice.Item{invoice.NewItem("pen", 150, 2), invoice.NewItem("ruler", 1005, 1)}
+
+	e, err := invoice.RenderEmail(&inv, "john@example.com")
+	if err != nil {
+		t.Fatalf("RenderEmail() failed: %v", err)
+	}
+	if e.To != "john@example.com" || e.Subject != "Invoice "+inv.ID {
+		t.Errorf("invalid email to %q subject %q", e.To, e.Subject)
+	}
+	for _, want := range []string{"Dear John Doe,", "issued on 2021-10-01", "pen: 2 x 1.50 = 3.00", "ruler: 1 x 10.05 = 10.05",
+		"Total: 13.05"} {
+		if !strings.Contains(e.Body, want) {
+			t.Errorf("email body %q does not contain %q", e.Body, want)
+		}
+	}
+}
+
+func TestIssueInvoiceDelivery(t *testing.T) {
+	t.Run("delivers issued invoice", func(t *testing.T) {
+		m := mocks.NewMailer(nil)
+		srv, invoiceAPI := deliverySetup(m)
+		inv, err := invoiceAPI.CreateInvoice()
+		if err != nil {
+			t.Fatalf("invoiceAPI.CreateInvoice() failed: %v", err)
+		}
+
+		if err := srv.IssueInvoice(inv.ID); err != nil {
+			t.Fatalf("IssueInvoice(%q) failed: %v", inv.ID, err)
+		}
+		if len(m.Emails) != 1 || m.Emails[0].To != "john@example.com" {
+			t.Errorf("invalid emails %v", m.Emails)
+		}
+		d := mustView(t, srv, inv.ID).Delivery
+		if d == nil || d.Status != invoice.DeliverySent || len(d.Attempts) != 1 || d.NextAt != nil {
+			t.Errorf("invalid invoice.Delivery %+v", d)
+		}
+	})
+
+	t.Run("issues invoice when delivery fails", func(t *testing.T) {
+		srv, invoiceAPI := deliverySetup(mocks.NewMailer(errors.New("connection refused")))
+		inv, err := invoiceAPI.CreateInvoice()
+		if err != nil {
+			t.Fatalf("invoiceAPI.CreateInvoice() failed: %v", err)
+		}
+
+		if err := srv.IssueInvoice(inv.ID); err != nil {
+			t.Fatalf("IssueInvoice(%q) failed: %v", inv.ID, err)
+		}
+		vinv := mustView(t, srv, inv.ID)
+		d := vinv.Delivery
+		if vinv.Status != invoice.Issued || d == nil || d.Status != invoice.DeliveryPending || len(d.Attempts) != 1 {
+			t.Fatalf("invalid invoice status %q, delivery %+v", vinv.Status, d)
+		}
+		if want := d.Attempts[0].At.Add(time.Minute); d.NextAt == nil || !d.NextAt.Equal(want) {
+			t.Errorf("invalid invoice.Delivery.NextAt %v, want %v", d.NextAt, want)
+		}
+		if !strings.Contains(d.Attempts[0].Err, "connection refused") {
+			t.Errorf("invalid attempt error %q", d.Attempts[0].Err)
+		}
+	})
+
+	t.Run("does not deliver without mailer", func(t *testing.T) {
+		srv, invoiceAPI := serviceSetup()
+		inv, err := invoiceAPI.CreateInvoice()
+		if err != nil {
+			t.Fatalf("invoiceAPI.CreateInvoice() failed: %v", err)
+		}
+
+		if err := srv.IssueInvoice(inv.ID); err != nil {
+			t.Fatalf("IssueInvoice(%q) failed: %v", inv.ID, err)
+		}
+		if d := mustView(t, srv, inv.ID).Delivery; d != nil {
+			t.Errorf("invalid invoice.Delivery %+v, want nil", d)
+		}
+	})
+}
+
+func TestDeliverPendingInvoices(t *testing.T) {
+	m := mocks.NewMailer(errors.New("connection refused"))
+	srv, invoiceAPI := deliverySetup(m)
+	inv, err := invoiceAPI.CreateInvoice()
+	if err != nil {
+		t.Fatalf("invoiceAPI.CreateInvoice() failed: %v", err)
+	}
+	if err := srv.IssueInvoice(inv.ID); err != nil {
+		t.Fatalf("IssueInvoice(%q) failed: %v", inv.ID, err)
+	}
+	first := mustView(t, srv, inv.ID).Delivery.Attempts[0].At
+
+	// Not retried before the backoff delay passes.
+	report, err := srv.DeliverPendingInvoices(first.Add(30 * time.Second))
+	if err != nil {
+		t.Fatalf("DeliverPendingInvoices() failed: %v", err)
+	}
+	assertResults(t, report, map[string]invoice.BulkStatus{})
+
+	// Second attempt fails, third is delayed by min(2m, 90s).
+	second := first.Add(time.Minute)
+	report, err = srv.DeliverPendingInvoices(second)
+	if err != nil {
+		t.Fatalf("DeliverPendingInvoices() failed: %v", err)
+	}
+	assertResults(t, report, map[string]invoice.BulkStatus{inv.ID: invoice.BulkFailed})
+	d := mustView(t, srv, inv.ID).Delivery
+	if want := second.Add(90 * time.Second); d.Status != invoice.DeliveryPending || !d.NextAt.Equal(want) {
+		t.Errorf("invalid delivery status %q next at %v, want pending at %v", d.Status, d.NextAt, want)
+	}
+
+	// Third failed attempt fails delivery.
+	report, err = srv.DeliverPendingInvoices(second.Add(90 * time.Second))
+	if err != nil {
+		t.Fatalf("DeliverPendingInvoices() failed: %v", err)
+	}
+	assertResults(t, report, map[string]invoice.BulkStatus{inv.ID: invoice.BulkFailed})
+	if d := mustView(t, srv, inv.ID).Delivery; d.Status != invoice.DeliveryFailed || d.NextAt != nil || len(d.Attempts) != 3 {
+		t.Errorf("invalid invoice.Delivery %+v, want failed after 3 attempts", d)
+	}
+
+	// Failed delivery is sent manually.
+	m.Fail(nil)
+	if err := srv.DeliverInvoice(inv.ID); err != nil {
+		t.Fatalf("DeliverInvoice(%q) failed: %v", inv.ID, err)
+	}
+	if d := mustView(t, srv, inv.ID).Delivery; d.Status != invoice.DeliverySent || len(d.Attempts) != 4 {
+		t.Errorf("invalid invoice.Delivery %+v, want sent", d)
+	}
+	if len(m.Emails) != 1 {
+		t.Errorf("invalid number of emails %d, want 1", len(m.Emails))
+	}
+}
+
+func TestDeliverInvoiceErrors(t *testing.T) {
+	srv, invoiceAPI := deliverySetup(mocks.NewMailer(nil))
+
+	open, err := invoiceAPI.CreateInvoice()
+	if err != nil {
+		t.Fatalf("invoiceAPI.CreateInvoice() failed: %v", err)
+	}
+	if err := srv.DeliverInvoice(open.ID); err == nil {
+		t.Errorf("DeliverInvoice(%q) of open invoice expected to fail", open.ID)
+	}
+
+	unknown, err := invoiceAPI.CreateInvoice(testapi.WithCustomerName("Jane Doe"), testapi.WithStatus(invoice.Issued))
+	if err != nil {
+		t.Fatalf("invoiceAPI.CreateInvoice() failed: %v", err)
+	}
+	if err := srv.DeliverInvoice(unknown.ID); !errors.Is(err, mailer.ErrNoAddress) {
+		t.Errorf("DeliverInvoice(%q) error = %v, want %v", unknown.ID, err, mailer.ErrNoAddress)
+	}
+
+	noMailer, _ := serviceSetup()
+	if err := noMailer.DeliverInvoice(open.ID); err == nil {
+		t.Error("DeliverInvoice() without mailer expected to fail")
+	}
+}
@@ -0,0 +1,272 @@
+package invoice
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// CollectionsStep is the name of the dunning ladder step escalating the invoice
+// to collections.
+const CollectionsStep = "collections"
+
+// DefaultDunningLadder is the dunning ladder spec of the reminder three days
+// before the due date, the notice on the due date, two notices a week apart
+// after it, the final notice and the escalation to collections.
+const DefaultDunningLadder = "reminder@-3d,due@0d,first@7d,second@14d,final@21d,collections@28d"
+
+// DunningStep is the notice sent Offset after the invoice due date.
+type DunningStep struct {
+	Name   string
+	Offset time.Duration
+}
+
+// DunningLadder is the sequence of notices sent to the customer of the unpaid
+// invoice. The invoice which all notices were sent is escalated to collections
+// Collections after its due date, zero Collections disables escalation.
+type DunningLadder struct {
+	Steps       []DunningStep
+	Collections time.Duration
+}
+
+// ParseDunningLadder parses the comma separated steps "name@offset", where the
+// offset is the Go duration or the number of days like "-3d" relative to the
+// due date. Steps should be in the order of offsets. The step named
+// "collections" is the escalation, it should be the last one.
+func ParseDunningLadder(spec string) (DunningLadder, error) {
+	var l DunningLadder
+	if strings.TrimSpace(spec) == "" {
+		return l, errors.New("empty dunning ladder")
+	}
+
+	seen := make(map[string]bool)
+	escalated := false
+	for _, s := range strings.Split(spec, ",") {
+		parts := strings.Split(strings.TrimSpace(s), "@")
+		if len(parts) != 2 || parts[0] == "" {
+			return DunningLadder{}, fmt.Errorf("invalid dunning step %q, want name@offset", s)
+		}
+		name := parts[0]
+		offset, err := parseOffset(parts[1])
+		if err != nil {
+			return DunningLadder{}, errors.Wrapf(err, "invalid dunning step %q offset", name)
+		}
+
+		switch {
+		case seen[name]:
+			return DunningLadder{}, fmt.Errorf("dunning step %q repeated", name)
+		case escalated:
+			return DunningLadder{}, fmt.Errorf("dunning step %q after %s", name, CollectionsStep)
+		case len(l.Steps) > 0 && offset <= l.Steps[len(l.Steps)-1].Offset:
+			return DunningLadder{}, fmt.Errorf("dunning step %q is not after step %q", name, l.Steps[len(l.Steps)-1].Name)
+		}
+		seen[name] = true
+
+		if name == CollectionsStep {
+			if offset <= 0 {
+				return DunningLadder{}, fmt.Errorf("dunning step %q should be after due date", name)
+			}
+			l.Collections, escalated = offset, true
+			continue
+		}
+		l.Steps = append(l.Steps, DunningStep{Name: name, Offset: offset})
+	}
+	return l, nil
+}
+
+// parseOffset parses the duration, which also can be the number of days.
+func parseOffset(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// next returns the step of the ladder due for the invoice at now. Only the
+// latest of steps due at once is sent. Escalation is due when all steps were
+// sent. Invoices that are paid, disputed or not issued are not dunned.
+func (l DunningLadder) next(inv *Invoice, terms time.Duration, now time.Time) (string, bool) {
+	due := inv.DueDate(terms)
+	if (inv.Status != Issued && inv.Status != Overdue) || due == nil {
+		return "", false
+	}
+
+	step := ""
+	for i := inv.DunningLevel; i < len(l.Steps); i++ {
+		if due.Add(l.Steps[i].Offset).After(now) {
+			break
+		}
+		step = l.Steps[i].Name
+	}
+	if step != "" {
+		return step, true
+	}
+
+	if inv.DunningLevel >= len(l.Steps) && l.Collections > 0 && !due.Add(l.Collections).After(now) {
+		return CollectionsStep, true
+	}
+	return "", false
+}
+
+// level returns the number of steps sent after the step is sent.
+func (l DunningLadder) level(step string) int {
+	for i, s := range l.Steps {
+		if s.Name == step {
+			return i + 1
+		}
+	}
+	return len(l.Steps)
+}
+
+// Notice is the dunning notice of the unpaid invoice.
+type Notice struct {
+	InvoiceID    string    `json:"invoiceId"`
+	CustomerName string    `json:"customerName"`
+	Step         string    `json:"step"`
+	Final        bool      `json:"final,omitempty"` // the last notice before escalation
+	DueDate      time.Time `json:"dueDate"`
+	At           time.Time `json:"at"`
+}
+
+// Notifier sends dunning notices.
+type Notifier interface {
+	Notify(Notice) error
+}
+
+// NoticeWriter writes notices as JSON lines.
+type NoticeWriter struct {
+	sync.Mutex
+	w io.Writer
+}
+
+var _ Notifier = (*NoticeWriter)(nil)
+
+func NewNoticeWriter(w io.Writer) *NoticeWriter {
+	return &NoticeWriter{w: w}
+}
+
+func (n *NoticeWriter) Notify(notice Notice) error {
+	data, err := json.Marshal(notice)
+	if err != nil {
+		return err
+	}
+
+	n.Lock()
+	defer n.Unlock()
+	_, err = n.w.Write(append(data, '\n'))
+	return err
+}
+
+// RunDunning sends the notices of the ladder steps due at now and escalates
+// invoices to collections. The notice is sent before the invoice dunning state
+// is updated, so the notice of the failed update is sent again by the next
+// run. Paid and disputed invoices are not dunned. The storage should implement
+// Lister.
+func (s *Service) RunDunning(now time.Time, terms time.Duration, ladder DunningLadder) (BulkReport, error) {
+	if s.opts.notifier == nil {
+		return BulkReport{}, &NotConfiguredError{Feature: "dunning notifier"}
+	}
+
+	invs, err := s.findInvoices(func(inv *Invoice) bool {
+		_, ok := ladder.next(inv, terms, now)
+		return ok
+	})
+	if err != nil {
+		return BulkReport{}, err
+	}
+
+	report := BulkReport{Results: make([]BulkResult, len(invs))}
+	for i, inv := range invs {
+		report.Results[i] = s.dunOne(inv.ID, now, terms, ladder)
+	}
+	return report, nil
+}
+
+func (s *Service) dunOne(id string, now time.Time, terms time.Duration, ladder DunningLadder) BulkResult {
+	res := BulkResult{ID: id, Status: BulkFailed}
+
+	inv, err := s.mustFindInvoice(id)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+
+	// the invoice may be paid after it was selected
+	step, ok := ladder.next(inv, terms, now)
+	if !ok {
+		res.Status, res.Err = BulkSkipped, fmt.Errorf("invoice %q has no dunning step due", id)
+		return res
+	}
+
+	from := inv.Status
+	notice := Notice{
+		InvoiceID:    inv.ID,
+		CustomerName: inv.CustomerName,
+		Step:         step,
+		DueDate:      *inv.DueDate(terms),
+		At:           now,
+	}
+	if step == CollectionsStep {
+		if err := inv.Escalate(); err != nil {
+			res.Err = err
+			return res
+		}
+	} else {
+		inv.DunningLevel = ladder.level(step)
+		notice.Final = inv.DunningLevel == len(ladder.Steps)
+	}
+	inv.DunningAt = &now
+
+	if err := s.opts.notifier.Notify(notice); err != nil {
+		res.Err = errors.Wrapf(err, "notify invoice %q failed", id)
+		return res
+	}
+
+	if err := s.update(inv, from); err != nil {
+		res.Err = errors.Wrapf(err, errUpdateFailed, id)
+		return res
+	}
+
+	res.Status = BulkSucceeded
+	return res
+}
+
+// DisputeInvoice sets unpaid invoice to the disputed status, dunning of the
+// invoice is suspended until the dispute is resolved.
+func (s *Service) DisputeInvoice(id string) error {
+	return s.changeStatus(id, (*Invoice).Dispute)
+}
+
+// ResolveInvoiceDispute sets disputed invoice back to the issued status,
+// dunning of the invoice continues from the last sent step.
+func (s *Service) ResolveInvoiceDispute(id string) error {
+	return s.changeStatus(id, (*Invoice).ResolveDispute)
+}
+
+func (s *Service) changeStatus(id string, change func(*Invoice) error) error {
+	inv, err := s.mustFindInvoice(id)
+	if err != nil {
+		return err
+	}
+
+	from := inv.Status
+	if err := change(inv); err != nil {
+		return err
+	}
+
+	if err := s.update(inv, from); err != nil {
+		return errors.Wrapf(err, errUpdateFailed, inv.ID)
+	}
+
+	return nil
+}
@@ -0,0 +1,224 @@
+package invoice_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/antklim/go-invoice/invoice"
+	testapi "github.com/antklim/go-invoice/test/api"
+	"github.com/antklim/go-invoice/test/mocks"
+)
+
+func TestParseDunningLadder(t *testing.T) {
+	day := 24 * time.Hour
+	l, err := invoice.ParseDunningLadder(invoice.DefaultDunningLadder)
+	if err != nil {
+		t.Fatalf("ParseDunningLadder() failed: %v", err)
+	}
+	want := []invoice.DunningStep{
+		{Name: "reminder", Offset: -3 * day},
+		{Name: "due", Offset: 0},
+		{Name: "first", Offset: 7 * day},
+		{Name: "second", Offset: 14 * day},
+		{Name: "final", Offset: 21 * day},
+	}
+	if len(l.Steps) != len(want) {
+		t.Fatalf("invalid steps %v, want %v", l.Steps, want)
+	}
+	for i := range want {
+		if l.Steps[i] != want[i] {
+			t.Errorf("invalid step %d %v, want %v", i, l.Steps[i], want[i])
+		}
+	}
+	if l.Collections != 28*day {
+		t.Errorf("invalid collections offset %v, want %v", l.Collections, 28*day)
+	}
+
+	for _, spec := range []string{
+		"",
+		"due",
+		"@1d",
+		"due@tomorrow",
+		"due@1d,due@2d",
+		"late@2d,due@1d",
+		"due@0d,collections@7d,late@14d",
+		"collections@-1d",
+	} {
+		if _, err := invoice.ParseDunningLadder(spec); err == nil {
+			t.Errorf("ParseDunningLadder(%q) expected to fail", spec)
+		}
+	}
+}
+
+func TestRunDunning(t *testing.T) {
+	terms := 10 * 24 * time.Hour
+	ladder, err := invoice.ParseDunningLadder("reminder@-1d,due@0d,final@2d,collections@5d")
+	if err != nil {
+		t.Fatalf("ParseDunningLadder() failed: %v", err)
+	}
+	now := time.Now()
+	issuedAgo := func(d time.Duration) *time.Time {
+		date := now.Add(-terms - d)
+		return &date
+	}
+
+	t.Run("sends due notices and escalates", func(t *testing.T) {
+		strg := storageSetup()
+		notifier := mocks.NewNotifier(nil)
+		srv := invoice.New(strg, invoice.WithNotifier(notifier))
+		invoiceAPI := testapi.NewIvoiceAPI(strg)
+
+		create := func(opts ...testapi.InvoiceOption) invoice.Invoice {
+			t.Helper()
+			inv, err := invoiceAPI.CreateInvoice(opts...)
+			if err != nil {
+				t.Fatalf("invoiceAPI.CreateInvoice() failed: %v", err)
+			}
+			return inv
+		}
+		// due in a day, reminder is due
+		remind := create(testapi.WithStatus(invoice.Issued), testapi.WithIssueaDate(issuedAgo(-24*time.Hour)))
+		// three days overdue, reminder and due notices were missed
+		final := create(testapi.WithStatus(invoice.Overdue), testapi.WithIssueaDate(issuedAgo(72*time.Hour)))
+		// all notices sent
+		escalate := create(testapi.WithStatus(invoice.Overdue), testapi.WithIssueaDate(issuedAgo(6*24*time.Hour)),
+			testapi.WithDunningLevel(3))
+		// not dunned
+		create(testapi.WithStatus(invoice.Issued), testapi.WithIssueaDate(issuedAgo(-48*time.Hour)))
+		create(testapi.WithStatus(invoice.Overdue), testapi.WithIssueaDate(issuedAgo(time.Hour)), testapi.WithDunningLevel(2))
+		create(testapi.WithStatus(invoice.Paid), testapi.WithIssueaDate(issuedAgo(72*time.Hour)))
+		create(testapi.WithStatus(invoice.Disputed), testapi.WithIssueaDate(issuedAgo(72*time.Hour)))
+
+		report, err := srv.RunDunning(now, terms, ladder)
+		if err != nil {
+			t.Fatalf("RunDunning() failed: %v", err)
+		}
+		assertResults(t, report, map[string]invoice.BulkStatus{
+			remind.ID:   invoice.BulkSucceeded,
+			final.ID:    invoice.BulkSucceeded,
+			escalate.ID: invoice.BulkSucceeded,
+		})
+
+		steps := make(map[string]invoice.Notice)
+		for _, n := range notifier.Notices {
+			steps[n.InvoiceID] = n
+		}
+		for id, want := range map[string]struct {
+			step  string
+			final bool
+			level int
+		}{
+			remind.ID:   {step: "reminder", level: 1},
+			final.ID:    {step: "final", final: true, level: 3},
+			escalate.ID: {step: invoice.CollectionsStep, level: 3},
+		} {
+			n := steps[id]
+			if n.Step != want.step || n.Final != want.final || !n.At.Equal(now) {
+				t.Errorf("invalid invoice %q notice %v, want step %q", id, n, want.step)
+			}
+			vinv := mustView(t, srv, id)
+			if vinv.DunningLevel != want.level || vinv.DunningAt == nil || !vinv.DunningAt.Equal(now) {
+				t.Errorf("invalid invoice %q dunning level %d at %v, want level %d", id, vinv.DunningLevel, vinv.DunningAt, want.level)
+			}
+		}
+		if vinv := mustView(t, srv, escalate.ID); vinv.Status != invoice.Collections {
+			t.Errorf("invalid invoice.Status %q, want %q", vinv.Status, invoice.Collections)
+		}
+
+		// repeated run sends nothing
+		report, err = srv.RunDunning(now, terms, ladder)
+		if err != nil {
+			t.Fatalf("RunDunning() failed: %v", err)
+		}
+		assertResults(t, report, map[string]invoice.BulkStatus{})
+	})
+
+	t.Run("suppressed when invoice paid", func(t *testing.T) {
+		strg := storageSetup()
+		notifier := mocks.NewNotifier(nil)
+		srv := invoice.New(strg, invoice.WithNotifier(notifier))
+		invoiceAPI := testapi.NewIvoiceAPI(strg)
+
+		inv, err := invoiceAPI.CreateInvoice(testapi.WithStatus(invoice.Overdue), testapi.WithIssueaDate(issuedAgo(time.Hour)),
+			testapi.WithDunningLevel(1))
+		if err != nil {
+			t.Fatalf("invoiceAPI.CreateInvoice() failed: %v", err)
+		}
+		if err := srv.PayInvoice(inv.ID); err != nil {
+			t.Fatalf("PayInvoice(%q) failed: %v", inv.ID, err)
+		}
+
+		report, err := srv.RunDunning(now, terms, ladder)
+		if err != nil {
+			t.Fatalf("RunDunning() failed: %v", err)
+		}
+		assertResults(t, report, map[string]invoice.BulkStatus{})
+		if len(notifier.Notices) != 0 {
+			t.Errorf("invalid notices %v, want none", notifier.Notices)
+		}
+	})
+
+	t.Run("reports failed notices", func(t *testing.T) {
+		strg := storageSetup()
+		srv := invoice.New(strg, invoice.WithNotifier(mocks.NewNotifier(errors.New("send failed"))))
+		invoiceAPI := testapi.NewIvoiceAPI(strg)
+
+		inv, err := invoiceAPI.CreateInvoice(testapi.WithStatus(invoice.Overdue), testapi.WithIssueaDate(issuedAgo(time.Hour)))
+		if err != nil {
+			t.Fatalf("invoiceAPI.CreateInvoice() failed: %v", err)
+		}
+
+		report, err := srv.RunDunning(now, terms, ladder)
+		if err != nil {
+			t.Fatalf("RunDunning() failed: %v", err)
+		}
+		assertResults(t, report, map[string]invoice.BulkStatus{inv.ID: invoice.BulkFailed})
+		if vinv := mustView(t, srv, inv.ID); vinv.DunningLevel != 0 {
+			t.Errorf("invalid invoice.DunningLevel %d, want 0", vinv.DunningLevel)
+		}
+	})
+
+	t.Run("fails when notifier not configured", func(t *testing.T) {
+		srv, _ := serviceSetup()
+		if _, err := srv.RunDunning(now, terms, ladder); err == nil {
+			t.Error("RunDunning() expected to fail")
+		}
+	})
+}
+
+func TestDisputeInvoice(t *testing.T) {
+	srv, invoiceAPI := serviceSetup()
+
+	inv, err := invoiceAPI.CreateInvoice(testapi.WithStatus(invoice.Overdue))
+	if err != nil {
+		t.Fatalf("invoiceAPI.CreateInvoice() failed: %v", err)
+	}
+	if err := srv.DisputeInvoice(inv.ID); err != nil {
+		t.Fatalf("DisputeInvoice(%q) failed: %v", inv.ID, err)
+	}
+	if vinv := mustView(t, srv, inv.ID); vinv.Status != invoice.Disputed {
+		t.Errorf("invalid invoice.Status %q, want %q", vinv.Status, invoice.Disputed)
+	}
+	if err := srv.DisputeInvoice(inv.ID); err == nil {
+		t.Errorf("DisputeInvoice(%q) of disputed invoice expected to fail", inv.ID)
+	}
+
+	if err := srv.ResolveInvoiceDispute(inv.ID); err != nil {
+		t.Fatalf("ResolveInvoiceDispute(%q) failed: %v", inv.ID, err)
+	}
+	if vinv := mustView(t, srv, inv.ID); vinv.Status != invoice.Issued {
+		t.Errorf("invalid invoice.Status %q, want %q", vinv.Status, invoice.Issued)
+	}
+	if err := srv.ResolveInvoiceDispute(inv.ID); err == nil {
+		t.Errorf("ResolveInvoiceDispute(%q) of issued invoice expected to fail", inv.ID)
+	}
+
+	open, err := invoiceAPI.CreateInvoice()
+	if err != nil {
+		t.Fatalf("invoiceAPI.CreateInvoice() failed: %v", err)
+	}
+	if err := srv.DisputeInvoice(open.ID); err == nil {
+		t.Errorf("DisputeInvoice(%q) of open invoice expected to fail", open.ID)
+	}
+}
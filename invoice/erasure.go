@@ -0,0 +1,45 @@
+package invoice
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// Erasure is the record of customer personal data erasure. It does not
+// contain the erased personal data.
+type Erasure struct {
+	ID         string    `json:"id"`
+	At         time.Time `json:"at"`
+	InvoiceIDs []string  `json:"invoiceIds"` // anonymized invoices
+}
+
+// ErasureLog records customer personal data erasures.
+type ErasureLog interface {
+	LogErasure(Erasure) error
+}
+
+// ErasureLogWriter writes erasures as JSON lines.
+type ErasureLogWriter struct {
+	sync.Mutex
+	w io.Writer
+}
+
+var _ ErasureLog = (*ErasureLogWriter)(nil)
+
+func NewErasureLogWriter(w io.Writer) *ErasureLogWriter {
+	return &ErasureLogWriter{w: w}
+}
+
+func (l *ErasureLogWriter) LogErasure(e Erasure) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	l.Lock()
+	defer l.Unlock()
+	_, err = l.w.Write(append(data, '\n'))
+	return err
+}
@@ -0,0 +1,36 @@
+package invoice_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/antklim/go-invoice/invoice"
+)
+
+func TestErasureLogWriter(t *testing.T) {
+	var buf bytes.Buffer
+	l := invoice.NewErasureLogWriter(&buf)
+
+	erasures := []invoice.Erasure{
+		{ID: "1", At: time.Now().UTC(), InvoiceIDs: []string{"a", "b"}},
+		{ID: "2", At: time.Now().UTC(), InvoiceIDs: []string{"c"}},
+	}
+	for _, e := range erasures {
+		if err := l.LogErasure(e); err != nil {
+			t.Fatalf("LogErasure(%+v) failed: %v", e, err)
+		}
+	}
+
+	dec := json.NewDecoder(&buf)
+	for _, want := range erasures {
+		var got invoice.Erasure
+		if err := dec.Decode(&got); err != nil {
+			t.Fatalf("decode logged erasure failed: %v", err)
+		}
+		if got.ID != want.ID || !got.At.Equal(want.At) || len(got.InvoiceIDs) != len(want.InvoiceIDs) {
+			t.Errorf("logged erasure %+v, want %+v", got, want)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package invoice
+
+import "fmt"
+
+// NotFoundError is returned when the invoice is not found.
+type NotFoundError struct {
+	ID string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf(errNotFound, e.ID)
+}
+
+// StatusError is returned when the invoice status does not allow the change.
+type StatusError struct {
+	Status Status
+	format string // message format of the quoted status
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf(e.format, e.Status)
+}
+
+func statusError(format string, s Status) error {
+	return &StatusError{Status: s, format: format}
+}
+
+// ValidationError is returned when the change details are not valid.
+type ValidationError struct {
+	msg string
+}
+
+func (e *ValidationError) Error() string {
+	return e.msg
+}
+
+func validationErrorf(format string, args ...interface{}) error {
+	return &ValidationError{msg: fmt.Sprintf(format, args...)}
+}
+
+// ConflictError is returned by storages when the invoice was updated by
+// another writer since it was read.
+type ConflictError struct {
+	ID string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("invoice %q was updated concurrently", e.ID)
+}
+
+// NotConfiguredError is returned when the service is not configured for the
+// requested operation.
+type NotConfiguredError struct {
+	Feature string
+}
+
+func (e *NotConfiguredError) Error() string {
+	return fmt.Sprintf("%s is not configured", e.Feature)
+}
@@ -0,0 +1,163 @@
+package invoice
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// EventCreated is the type of the event of the created invoice. Events of
+// invoice status changes have the "invoice.<status>" type, see EventType.
+const EventCreated = "invoice.created"
+
+// EventType returns the type of the event of the invoice status change to s.
+func EventType(s Status) string {
+	return "invoice." + s.String()
+}
+
+// EventTypes returns all event types.
+func EventTypes() []string {
+	types := []string{EventCreated}
+	for s := Issued; statusName[s] != ""; s++ {
+		types = append(types, EventType(s))
+	}
+	return types
+}
+
+// Event is the invoice lifecycle event: the invoice creation or status change.
+// The ID is kept when the event is published again, so subscribers can skip
+// duplicates by it.
+type Event struct {
+	ID      string
+	Type    string
+	At      time.Time
+	From    string  // previous status, empty when the invoice created
+	Invoice Invoice // the invoice after the change
+}
+
+// Publisher receives the events after the invoice changes are stored. It
+// should not block the service.
+type Publisher interface {
+	Publish(Event) error
+}
+
+// Outbox is implemented by storages that keep events in the outbox. Events
+// written by the transaction, see EventWriter, are stored together with the
+// transaction changes, so the event of a stored change is never lost. Events
+// stay in the outbox until deleted, see Relay.
+type Outbox interface {
+	Transactor
+	// OutboxEvents returns up to limit events of the outbox. Events of every
+	// invoice are returned in the order they were written.
+	OutboxEvents(limit int) ([]Event, error)
+	// DeleteOutboxEvents deletes the events from the outbox, events not in the
+	// outbox are skipped.
+	DeleteOutboxEvents(events []Event) error
+}
+
+// EventWriter is implemented by the transaction storages of Outbox storages.
+// Written events are stored in the outbox when the transaction commits.
+type EventWriter interface {
+	WriteEvent(Event) error
+}
+
+func newEvent(typ string, inv *Invoice, from string) Event {
+	return Event{
+		ID:      uuid.NewString(),
+		Type:    typ,
+		At:      time.Now(),
+		From:    from,
+		Invoice: inv.Clone(),
+	}
+}
+
+// createdEvents returns the event of the created invoice.
+func createdEvents(inv *Invoice) []Event {
+	return []Event{newEvent(EventCreated, inv, "")}
+}
+
+// changeEvents returns the event of the invoice status change from the status,
+// none when the status is the same.
+func changeEvents(inv *Invoice, from Status) []Event {
+	if inv.Status == from {
+		return nil
+	}
+	return []Event{newEvent(EventType(inv.Status), inv, from.String())}
+}
+
+// add stores the new invoice with its created event.
+func (s *Service) add(inv *Invoice) error {
+	return s.store(func(strg Storage) error {
+		return strg.AddInvoice(*inv)
+	}, createdEvents(inv))
+}
+
+// update stores the changed invoice with the event of its status change from
+// the status.
+func (s *Service) update(inv *Invoice, from Status) error {
+	return s.store(func(strg Storage) error {
+		return strg.UpdateInvoice(*inv)
+	}, changeEvents(inv, from))
+}
+
+// store writes the change and its events. With the outbox enabled the events
+// are written in the same transaction as the change, otherwise they are
+// published after the change is stored.
+func (s *Service) store(write func(Storage) error, events []Event) error {
+	if !s.opts.outbox || len(events) == 0 {
+		if err := write(s.strg); err != nil {
+			return err
+		}
+		s.notify(events)
+		return nil
+	}
+
+	err := s.inTx(func(tx Storage) error {
+		if err := write(tx); err != nil {
+			return err
+		}
+		return s.writeEvents(tx, events)
+	})
+	if err != nil {
+		return err
+	}
+	s.notify(events)
+	return nil
+}
+
+// writeEvents writes the events to the outbox of the transaction when the
+// outbox is enabled.
+func (s *Service) writeEvents(tx Storage, events []Event) error {
+	if !s.opts.outbox {
+		return nil
+	}
+
+	w, ok := tx.(EventWriter)
+	if !ok {
+		return errors.New("storage does not support outbox")
+	}
+	for _, e := range events {
+		if err := w.WriteEvent(e); err != nil {
+			return errors.Wrapf(err, "write event of invoice %q failed", e.Invoice.ID)
+		}
+	}
+	return nil
+}
+
+// notify publishes the events of the stored change and emits them to the bus.
+func (s *Service) notify(events []Event) {
+	s.publish(events)
+	s.emit(busEvents(events)...)
+}
+
+// publish publishes the events when the outbox is disabled. Publish errors are
+// ignored, the outbox should be used when events must not be lost.
+func (s *Service) publish(events []Event) {
+	if s.opts.outbox || s.opts.publisher == nil {
+		return
+	}
+	for _, e := range events {
+		_ = s.opts.publisher.Publish(e)
+	}
+}
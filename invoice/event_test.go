@@ -0,0 +1,138 @@
+package invoice_test
+
+import (
+	"testing"
+
+	"github.com/antklim/go-invoice/invoice"
+	testapi "github.com/antklim/go-invoice/test/api"
+	"github.com/antklim/go-invoice/test/mocks"
+)
+
+// eventsOf returns the types of events published of the invoice.
+func eventsOf(p *mocks.Publisher, id string) []string {
+	p.Lock()
+	defer p.Unlock()
+
+	var types []string
+	for _, e := range p.Events {
+		if e.Invoice.ID == id {
+			types = append(types, e.Type)
+		}
+	}
+	return types
+}
+
+func assertEvents(t *testing.T, p *mocks.Publisher, id string, want ...string) {
+	t.Helper()
+
+	got := eventsOf(p, id)
+	if len(got) != len(want) {
+		t.Fatalf("invalid invoice %q events %v, want %v", id, got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("invalid invoice %q events %v, want %v", id, got, want)
+			return
+		}
+	}
+}
+
+func TestEventTypes(t *testing.T) {
+	want := []string{
+		"invoice.created", "invoice.issued", "invoice.paid", "invoice.canceled",
+		"invoice.overdue", "invoice.disputed", "invoice.collections",
+	}
+	got := invoice.EventTypes()
+	if len(got) != len(want) {
+		t.Fatalf("invalid event types %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("invalid event types %v, want %v", got, want)
+			break
+		}
+	}
+}
+
+func TestPublishEvents(t *testing.T) {
+	strg := storageSetup()
+	pub := mocks.NewPublisher(nil)
+	srv := invoice.New(strg, invoice.WithPublisher(pub))
+	invoiceAPI := testapi.NewIvoiceAPI(strg)
+
+	t.Run("created, issued and paid invoice", func(t *testing.T) {
+		inv, err := srv.CreateInvoice("John Doe")
+		if err != nil {
+			t.Fatalf("CreateInvoice() failed: %v", err)
+		}
+		if _, err := srv.AddInvoiceItem(inv.ID, "Pen", 100, 2); err != nil {
+			t.Fatalf("AddInvoiceItem() failed: %v", err)
+		}
+		if err := srv.IssueInvoice(inv.ID); err != nil {
+			t.Fatalf("IssueInvoice() failed: %v", err)
+		}
+		if err := srv.PayInvoice(inv.ID); err != nil {
+			t.Fatalf("PayInvoice() failed: %v", err)
+		}
+
+		assertEvents(t, pub, inv.ID, "invoice.created", "invoice.issued", "invoice.paid")
+		pub.Lock()
+		last := pub.Events[len(pub.Events)-1]
+		pub.Unlock()
+		if last.From != "issued" || last.Invoice.Status != invoice.Paid || last.ID == "" || last.At.IsZero() {
+			t.Errorf("invalid paid event %+v", last)
+		}
+	})
+
+	t.Run("nothing published when change failed", func(t *testing.T) {
+		inv, err := invoiceAPI.CreateInvoice(testapi.WithStatus(invoice.Paid))
+		if err != nil {
+			t.Fatalf("invoiceAPI.CreateInvoice() failed: %v", err)
+		}
+		if err := srv.CancelInvoice(inv.ID); err == nil {
+			t.Fatal("CancelInvoice() of paid invoice expected to fail")
+		}
+		assertEvents(t, pub, inv.ID)
+	})
+
+	t.Run("bulk changes", func(t *testing.T) {
+		invs, err := invoiceAPI.CreateInvoicesWithStatuses(invoice.Open, invoice.Open)
+		if err != nil {
+			t.Fatalf("invoiceAPI.CreateInvoicesWithStatuses() failed: %v", err)
+		}
+		ids := []string{invs[0].ID, invs[1].ID}
+
+		if _, err := srv.CancelInvoices(invoice.SelectIDs(ids...), invoice.WithDryRun()); err != nil {
+			t.Fatalf("CancelInvoices() dry run failed: %v", err)
+		}
+		for _, id := range ids {
+			assertEvents(t, pub, id)
+		}
+
+		if _, err := srv.CancelInvoices(invoice.SelectIDs(ids...)); err != nil {
+			t.Fatalf("CancelInvoices() failed: %v", err)
+		}
+		for _, id := range ids {
+			assertEvents(t, pub, id, "invoice.canceled")
+		}
+	})
+
+	t.Run("merged invoices", func(t *testing.T) {
+		inv1, err := invoiceAPI.CreateInvoiceWithNItems(1)
+		if err != nil {
+			t.Fatalf("invoiceAPI.CreateInvoiceWithNItems() failed: %v", err)
+		}
+		inv2, err := invoiceAPI.CreateInvoiceWithNItems(1)
+		if err != nil {
+			t.Fatalf("invoiceAPI.CreateInvoiceWithNItems() failed: %v", err)
+		}
+
+		merged, err := srv.MergeInvoices([]string{inv1.ID, inv2.ID})
+		if err != nil {
+			t.Fatalf("MergeInvoices() failed: %v", err)
+		}
+		assertEvents(t, pub, merged.ID, "invoice.created")
+		assertEvents(t, pub, inv1.ID, "invoice.canceled")
+		assertEvents(t, pub, inv2.ID, "invoice.canceled")
+	})
+}
@@ -15,23 +15,48 @@ const (
 	Issued
 	Paid
 	Canceled
+	Overdue
+	Disputed
+	Collections
 )
 
 var statusName = map[Status]string{
-	Open:     "open",
-	Issued:   "issued",
-	Paid:     "paid",
-	Canceled: "canceled",
+	Open:        "open",
+	Issued:      "issued",
+	Paid:        "paid",
+	Canceled:    "canceled",
+	Overdue:     "overdue",
+	Disputed:    "disputed",
+	Collections: "collections",
 }
 
 func (s Status) String() string { return statusName[s] }
 
+// ParseStatus returns the status by its name.
+func ParseStatus(s string) (Status, error) {
+	for status, name := range statusName {
+		if name == s {
+			return status, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown invoice status %q", s)
+}
+
+// Redacted replaces personal data of the anonymized customer.
+const Redacted = "[redacted]"
+
 type Invoice struct {
 	ID           string
 	CustomerName string
 	Date         *time.Time // issue date
+	IssueAt      *time.Time // scheduled issue time of the open invoice
 	Status       Status
 	Items        []Item
+	Origins      []string   // IDs of invoices which items were moved to the invoice
+	Successors   []string   // IDs of invoices the invoice items were moved to
+	DunningLevel int        // number of dunning ladder steps sent
+	DunningAt    *time.Time // time of the last dunning notice
+	Delivery     *Delivery  // email delivery of the issued invoice, nil when not delivered
 	CreatedAt    time.Time
 	UpdatedAt    time.Time
 }
@@ -47,17 +72,67 @@ func (inv *Invoice) Equal(other *Invoice) bool {
 	return inv.ID == other.ID &&
 		inv.CustomerName == other.CustomerName &&
 		invDatesEqual &&
+		timesEqual(inv.IssueAt, other.IssueAt) &&
+		inv.DunningLevel == other.DunningLevel &&
+		timesEqual(inv.DunningAt, other.DunningAt) &&
+		inv.Delivery.Equal(other.Delivery) &&
 		inv.Status == other.Status &&
 		inv.itemsEqual(other.Items) &&
+		idsEqual(inv.Origins, other.Origins) &&
+		idsEqual(inv.Successors, other.Successors) &&
 		inv.CreatedAt.Equal(other.CreatedAt) &&
 		inv.UpdatedAt.Equal(other.UpdatedAt)
 }
 
+// Clone returns the deep copy of the invoice, that does not share issue date
+// and items with the original.
+func (inv *Invoice) Clone() Invoice {
+	c := *inv
+	if inv.Date != nil {
+		date := *inv.Date
+		c.Date = &date
+	}
+	if inv.IssueAt != nil {
+		at := *inv.IssueAt
+		c.IssueAt = &at
+	}
+	if inv.DunningAt != nil {
+		at := *inv.DunningAt
+		c.DunningAt = &at
+	}
+	c.Delivery = inv.Delivery.Clone()
+	if inv.Items != nil {
+		c.Items = append([]Item(nil), inv.Items...)
+	}
+	if inv.Origins != nil {
+		c.Origins = append([]string(nil), inv.Origins...)
+	}
+	if inv.Successors != nil {
+		c.Successors = append([]string(nil), inv.Successors...)
+	}
+	return c
+}
+
+// Anonymize replaces customer personal data with Redacted. Unlike other
+// changes, it is allowed in any status: amounts, dates and items are kept.
+// Delivery errors are redacted too, as they may contain the customer address.
+func (inv *Invoice) Anonymize() {
+	inv.CustomerName = Redacted
+	if inv.Delivery != nil {
+		inv.Delivery = inv.Delivery.Clone() // the delivery may be shared with the invoice copies
+		for i := range inv.Delivery.Attempts {
+			if inv.Delivery.Attempts[i].Err != "" {
+				inv.Delivery.Attempts[i].Err = Redacted
+			}
+		}
+	}
+}
+
 // UpdateCustomerName sets new customer name. It returns error when invoice
 // cannot be updated.
 func (inv *Invoice) UpdateCustomerName(name string) error {
 	if inv.Status != Open {
-		return fmt.Errorf("%q invoice cannot be updated", inv.Status)
+		return statusError("%q invoice cannot be updated", inv.Status)
 	}
 
 	inv.CustomerName = name
@@ -90,7 +165,7 @@ func (inv *Invoice) ContainsItem(id string) bool {
 // cannot be added.
 func (inv *Invoice) AddItem(item Item) error {
 	if inv.Status != Open {
-		return fmt.Errorf("item cannot be added to %q invoice", inv.Status)
+		return statusError("item cannot be added to %q invoice", inv.Status)
 	}
 
 	inv.Items = append(inv.Items, item)
@@ -102,7 +177,7 @@ func (inv *Invoice) AddItem(item Item) error {
 // the items collection.
 func (inv *Invoice) DeleteItem(id string) (bool, error) {
 	if inv.Status != Open {
-		return false, fmt.Errorf("item cannot be deleted from %q invoice", inv.Status)
+		return false, statusError("item cannot be deleted from %q invoice", inv.Status)
 	}
 
 	idx := inv.FindItemIndex(func(item Item) bool {
@@ -117,23 +192,148 @@ func (inv *Invoice) DeleteItem(id string) (bool, error) {
 	return true, nil
 }
 
+// TakeItem removes qty of the item from the invoice and returns the removed
+// part. The item removed completely when qty equals the item quantity. The part
+// keeps the item ID and creation time, and the ID of the invoice the item was
+// first added to. It returns error when the item cannot be taken.
+func (inv *Invoice) TakeItem(id string, qty int) (Item, error) {
+	if inv.Status != Open {
+		return Item{}, statusError("item cannot be moved from %q invoice", inv.Status)
+	}
+
+	idx := inv.FindItemIndex(func(item Item) bool {
+		return item.ID == id
+	})
+	if idx == -1 {
+		return Item{}, validationErrorf("item %q not found", id)
+	}
+
+	item := inv.Items[idx]
+	if qty < 1 || qty > item.Qty {
+		return Item{}, validationErrorf("invalid item %q qty %d, should be from 1 to %d", id, qty, item.Qty)
+	}
+
+	if qty == item.Qty {
+		inv.Items = append(inv.Items[:idx], inv.Items[idx+1:]...)
+	} else {
+		inv.Items[idx].Qty -= qty
+	}
+
+	part := item
+	part.Qty = qty
+	if part.Origin == "" {
+		part.Origin = inv.ID
+	}
+	return part, nil
+}
+
+// PutItem adds the item moved from another invoice. The item quantity is added
+// to the item of the same ID, for example to join parts of the split item. It
+// returns error when the item cannot be put.
+func (inv *Invoice) PutItem(item Item) error {
+	if inv.Status != Open {
+		return statusError("item cannot be moved to %q invoice", inv.Status)
+	}
+
+	idx := inv.FindItemIndex(func(other Item) bool {
+		return other.ID == item.ID
+	})
+	if idx == -1 {
+		inv.Items = append(inv.Items, item)
+		return nil
+	}
+
+	other := inv.Items[idx]
+	if other.ProductName != item.ProductName || other.Price != item.Price {
+		return validationErrorf("item %q differs from the item of the same ID", item.ID)
+	}
+	inv.Items[idx].Qty += item.Qty
+	return nil
+}
+
+// ScheduleIssue sets the time the open invoice should be issued at, nil
+// cancels the scheduled issue. It returns error when invoice is not open.
+func (inv *Invoice) ScheduleIssue(at *time.Time) error {
+	if inv.Status != Open {
+		return statusError("%q invoice issue cannot be scheduled", inv.Status)
+	}
+
+	inv.IssueAt = at
+	return nil
+}
+
 // Issue sets invoice to issued state. It returns error when invoice is not
 // issueable.
 func (inv *Invoice) Issue() error {
 	if inv.Status != Open {
-		return fmt.Errorf("%q invoice cannot be issued", inv.Status)
+		return statusError("%q invoice cannot be issued", inv.Status)
 	}
 
 	inv.Status = Issued
 	now := time.Now()
 	inv.Date = &now
+	inv.IssueAt = nil
+	return nil
+}
+
+// DueDate returns the payment due date of the issued invoice, nil when the
+// invoice has not been issued.
+func (inv *Invoice) DueDate(terms time.Duration) *time.Time {
+	if inv.Date == nil {
+		return nil
+	}
+	due := inv.Date.Add(terms)
+	return &due
+}
+
+// MarkOverdue sets issued invoice to overdue state. It returns error when
+// invoice is not issued.
+func (inv *Invoice) MarkOverdue() error {
+	if inv.Status != Issued {
+		return statusError("%q invoice cannot be marked overdue", inv.Status)
+	}
+
+	inv.Status = Overdue
+	return nil
+}
+
+// Dispute sets unpaid invoice to disputed state, dunning of disputed invoice
+// is suspended. It returns error when invoice cannot be disputed.
+func (inv *Invoice) Dispute() error {
+	if !inv.unpaid() {
+		return statusError("%q invoice cannot be disputed", inv.Status)
+	}
+
+	inv.Status = Disputed
+	return nil
+}
+
+// ResolveDispute sets disputed invoice back to issued state. It returns error
+// when invoice is not disputed.
+func (inv *Invoice) ResolveDispute() error {
+	if inv.Status != Disputed {
+		return statusError("%q invoice dispute cannot be resolved", inv.Status)
+	}
+
+	inv.Status = Issued
+	return nil
+}
+
+// Escalate sets issued or overdue invoice to collections state. It returns
+// error when invoice cannot be escalated.
+func (inv *Invoice) Escalate() error {
+	if inv.Status != Issued && inv.Status != Overdue {
+		return statusError("%q invoice cannot be escalated to collections", inv.Status)
+	}
+
+	inv.Status = Collections
 	return nil
 }
 
 // Pay sets invoice to paid state. It returns error when invoice is not payable.
 func (inv *Invoice) Pay() error {
-	if inv.Status != Issued {
-		return fmt.Errorf("%q invoice cannot be paid", inv.Status)
+	if inv.Status != Disputed && !inv.unpaid() {
+		return statusError("%q invoice cannot be paid", inv.Status)
 	}
 
 	inv.Status = Paid
@@ -144,24 +344,41 @@ func (inv *Invoice) Pay() error {
 // cancelable.
 func (inv *Invoice) Cancel() error {
 	if inv.Status == Canceled || inv.Status == Paid {
-		return fmt.Errorf("%q invoice cannot be canceled", inv.Status)
+		return statusError("%q invoice cannot be canceled", inv.Status)
 	}
 
 	inv.Status = Canceled
 	return nil
 }
 
+// Total returns the sum of the invoice items prices in cents.
+func (inv *Invoice) Total() int {
+	total := 0
+	for _, item := range inv.Items {
+		total += item.Price * item.Qty
+	}
+	return total
+}
+
+// unpaid returns true when invoice is issued and waits for payment.
+func (inv *Invoice) unpaid() bool {
+	return inv.Status == Issued || inv.Status == Overdue || inv.Status == Collections
+}
+
+// itemsEqual compares items regardless of their order. Sorted copies are
+// compared, so both invoices are left as they are.
 func (inv *Invoice) itemsEqual(otherItems []Item) bool {
 	if len(inv.Items) != len(otherItems) {
 		return false
 	}
 
-	sort.Sort(byItemID(inv.Items))
-	sort.Sort(byItemID(otherItems))
+	items := append([]Item(nil), inv.Items...)
+	other := append([]Item(nil), otherItems...)
+	sort.Sort(byItemID(items))
+	sort.Sort(byItemID(other))
 
-	for i, item := range inv.Items {
-		item := item
-		if !otherItems[i].Equal(&item) {
+	for i := range items {
+		if !other[i].Equal(&items[i]) {
 			return false
 		}
 	}
@@ -174,6 +391,7 @@ type Item struct {
 	ProductName string
 	Price       int // price in cents
 	Qty         int
+	Origin      string // ID of the invoice the item was first added to, empty when not moved
 	CreatedAt   time.Time
 }
 
@@ -182,6 +400,7 @@ func (item *Item) Equal(other *Item) bool {
 		item.ProductName == other.ProductName &&
 		item.Price == other.Price &&
 		item.Qty == other.Qty &&
+		item.Origin == other.Origin &&
 		item.CreatedAt.Equal(other.CreatedAt)
 }
 
@@ -204,7 +423,29 @@ func (item *Item) Validate() error {
 		return nil
 	}
 
-	return fmt.Errorf("item details not valid: %s", strings.Join(errors, ", "))
+	return validationErrorf("item details not valid: %s", strings.Join(errors, ", "))
+}
+
+// idsEqual returns true when both lists contain the same IDs in the same order,
+// nil and empty lists are equal.
+func idsEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// timesEqual returns true when both times are nil or equal.
+func timesEqual(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
 }
 
 type byItemID []Item
@@ -0,0 +1,197 @@
+package invoice
+
+type options struct {
+	erasureLog     ErasureLog
+	notifier       Notifier
+	mailer         Mailer
+	addressBook    AddressBook
+	deliveryPolicy DeliveryPolicy
+	publisher      Publisher
+	outbox         bool
+	bus            *Bus
+}
+
+var defaultOptions = options{
+	deliveryPolicy: DefaultDeliveryPolicy,
+}
+
+type Option interface {
+	apply(*options)
+}
+
+type funcOption struct {
+	f func(*options)
+}
+
+func (f *funcOption) apply(o *options) {
+	f.f(o)
+}
+
+func newFuncOption(f func(*options)) Option {
+	return &funcOption{f: f}
+}
+
+// WithErasureLog sets the log of customer personal data erasures. Erasures
+// are not logged by default.
+func WithErasureLog(v ErasureLog) Option {
+	return newFuncOption(func(o *options) {
+		o.erasureLog = v
+	})
+}
+
+// WithNotifier sets where dunning notices are sent. Invoices cannot be dunned
+// by default.
+func WithNotifier(v Notifier) Option {
+	return newFuncOption(func(o *options) {
+		o.notifier = v
+	})
+}
+
+// WithMailer sets the mailer and the address book of customers, issued
+// invoices are delivered by email. Invoices are not delivered by default.
+func WithMailer(m Mailer, book AddressBook) Option {
+	return newFuncOption(func(o *options) {
+		o.mailer, o.addressBook = m, book
+	})
+}
+
+// WithDeliveryPolicy sets the retry policy of failed deliveries.
+// DefaultDeliveryPolicy is used by default.
+func WithDeliveryPolicy(p DeliveryPolicy) Option {
+	return newFuncOption(func(o *options) {
+		o.deliveryPolicy = p
+	})
+}
+
+// WithPublisher sets the publisher of invoice events. Events are not published
+// by default.
+func WithPublisher(v Publisher) Option {
+	return newFuncOption(func(o *options) {
+		o.publisher = v
+	})
+}
+
+// WithOutbox makes the service write events to the storage outbox in the same
+// transaction as the change, instead of publishing them. The storage should
+// implement Outbox, the events are published by Relay. Outbox is disabled by
+// default.
+func WithOutbox() Option {
+	return newFuncOption(func(o *options) {
+		o.outbox = true
+	})
+}
+
+// WithBus sets the bus the service emits events to after the changes are
+// stored. Events are not emitted by default.
+func WithBus(v *Bus) Option {
+	return newFuncOption(func(o *options) {
+		o.bus = v
+	})
+}
+
+type busOptions struct {
+	panicHandler func(BusEvent, interface{})
+}
+
+var defaultBusOptions = busOptions{}
+
+type BusOption interface {
+	apply(*busOptions)
+}
+
+type funcBusOption struct {
+	f func(*busOptions)
+}
+
+func (f *funcBusOption) apply(o *busOptions) {
+	f.f(o)
+}
+
+func newFuncBusOption(f func(*busOptions)) BusOption {
+	return &funcBusOption{f: f}
+}
+
+// WithPanicHandler sets the function called with the event and the recovered
+// value when a subscriber panics. Panics are ignored by default.
+func WithPanicHandler(v func(BusEvent, interface{})) BusOption {
+	return newFuncBusOption(func(o *busOptions) {
+		o.panicHandler = v
+	})
+}
+
+type subscribeOptions struct {
+	queueSize int
+}
+
+type SubscribeOption interface {
+	apply(*subscribeOptions)
+}
+
+type funcSubscribeOption struct {
+	f func(*subscribeOptions)
+}
+
+func (f *funcSubscribeOption) apply(o *subscribeOptions) {
+	f.f(o)
+}
+
+func newFuncSubscribeOption(f func(*subscribeOptions)) SubscribeOption {
+	return &funcSubscribeOption{f: f}
+}
+
+// WithAsync makes the subscription async with the queue of the size. Values
+// less than 1 are ignored.
+func WithAsync(queueSize int) SubscribeOption {
+	return newFuncSubscribeOption(func(o *subscribeOptions) {
+		if queueSize > 0 {
+			o.queueSize = queueSize
+		}
+	})
+}
+
+// DefaultBulkConcurrency is the default number of invoices processed by a bulk
+// operation at once.
+const DefaultBulkConcurrency = 4
+
+type bulkOptions struct {
+	concurrency int
+	dryRun      bool
+}
+
+var defaultBulkOptions = bulkOptions{
+	concurrency: DefaultBulkConcurrency,
+}
+
+type BulkOption interface {
+	apply(*bulkOptions)
+}
+
+type funcBulkOption struct {
+	f func(*bulkOptions)
+}
+
+func (f *funcBulkOption) apply(o *bulkOptions) {
+	f.f(o)
+}
+
+func newFuncBulkOption(f func(*bulkOptions)) BulkOption {
+	return &funcBulkOption{f: f}
+}
+
+// WithConcurrency sets the number of invoices processed at once. Values less
+// than 1 are ignored.
+func WithConcurrency(v int) BulkOption {
+	return newFuncBulkOption(func(o *bulkOptions) {
+		if v > 0 {
+			o.concurrency = v
+		}
+	})
+}
+
+// WithDryRun makes the bulk operation report what it would do without
+// changing invoices.
+func WithDryRun() BulkOption {
+	return newFuncBulkOption(func(o *bulkOptions) {
+		o.dryRun = true
+	})
+}
@@ -0,0 +1,64 @@
+package invoice
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultRelayBatch is the default number of events relayed at once.
+const DefaultRelayBatch = 100
+
+// Relay publishes the events of the storage outbox and deletes published
+// events from it. Events are published at least once: an event published but
+// not deleted, for example when the process stopped, is published again with
+// the same ID. Events of every invoice are published in the order they were
+// written, after the failed event later events of its invoice wait for the
+// next run.
+type Relay struct {
+	mu     sync.Mutex // one run at a time
+	outbox Outbox
+	pub    Publisher
+}
+
+func NewRelay(outbox Outbox, pub Publisher) *Relay {
+	return &Relay{outbox: outbox, pub: pub}
+}
+
+// Relay publishes up to limit events of the outbox. It returns the number of
+// published events and the error of the first failed event.
+func (r *Relay) Relay(limit int) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	events, err := r.outbox.OutboxEvents(limit)
+	if err != nil {
+		return 0, errors.Wrap(err, "read outbox failed")
+	}
+
+	var (
+		published []Event
+		failed    = make(map[string]bool) // invoices of failed events
+		firstErr  error
+	)
+	for _, e := range events {
+		if failed[e.Invoice.ID] {
+			continue
+		}
+		if err := r.pub.Publish(e); err != nil {
+			failed[e.Invoice.ID] = true
+			if firstErr == nil {
+				firstErr = errors.Wrapf(err, "publish event %q of invoice %q failed", e.ID, e.Invoice.ID)
+			}
+			continue
+		}
+		published = append(published, e)
+	}
+
+	if len(published) > 0 {
+		if err := r.outbox.DeleteOutboxEvents(published); err != nil {
+			return 0, errors.Wrap(err, "delete relayed events failed")
+		}
+	}
+	return len(published), firstErr
+}
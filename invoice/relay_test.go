@@ -0,0 +1,113 @@
+package invoice_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/antklim/go-invoice/invoice"
+	"github.com/antklim/go-invoice/test/mocks"
+)
+
+func TestRelay(t *testing.T) {
+	strg := storageSetup()
+	outbox, ok := strg.(invoice.Outbox)
+	if !ok {
+		t.Skip("storage does not support outbox")
+	}
+	srv := invoice.New(strg, invoice.WithOutbox())
+	pub := mocks.NewPublisher(nil)
+	relay := invoice.NewRelay(outbox, pub)
+
+	t.Run("publishes stored events in order", func(t *testing.T) {
+		inv, err := srv.CreateInvoice("John Doe")
+		if err != nil {
+			t.Fatalf("CreateInvoice() failed: %v", err)
+		}
+		if _, err := srv.AddInvoiceItem(inv.ID, "Pen", 100, 2); err != nil {
+			t.Fatalf("AddInvoiceItem() failed: %v", err)
+		}
+		if err := srv.IssueInvoice(inv.ID); err != nil {
+			t.Fatalf("IssueInvoice() failed: %v", err)
+		}
+		assertEvents(t, pub, inv.ID)
+
+		n, err := relay.Relay(invoice.DefaultRelayBatch)
+		if err != nil {
+			t.Fatalf("Relay() failed: %v", err)
+		}
+		if n != 2 {
+			t.Errorf("invalid number of relayed events %d, want 2", n)
+		}
+		assertEvents(t, pub, inv.ID, "invoice.created", "invoice.issued")
+
+		if n, err := relay.Relay(invoice.DefaultRelayBatch); err != nil || n != 0 {
+			t.Errorf("Relay() of empty outbox = %d, %v, want 0, nil", n, err)
+		}
+	})
+
+	t.Run("keeps events when publishing failed", func(t *testing.T) {
+		pub.Reset()
+		inv, err := srv.CreateInvoice("John Doe")
+		if err != nil {
+			t.Fatalf("CreateInvoice() failed: %v", err)
+		}
+		if err := srv.CancelInvoice(inv.ID); err != nil {
+			t.Fatalf("CancelInvoice() failed: %v", err)
+		}
+
+		pub.Fail(errors.New("publisher unavailable"))
+		if n, err := relay.Relay(invoice.DefaultRelayBatch); err == nil || n != 0 {
+			t.Fatalf("Relay() = %d, %v, want 0 and error", n, err)
+		}
+
+		pub.Fail(nil)
+		if _, err := relay.Relay(invoice.DefaultRelayBatch); err != nil {
+			t.Fatalf("Relay() failed: %v", err)
+		}
+		assertEvents(t, pub, inv.ID, "invoice.created", "invoice.canceled")
+	})
+
+	t.Run("relays limited batch", func(t *testing.T) {
+		pub.Reset()
+		inv, err := srv.CreateInvoice("John Doe")
+		if err != nil {
+			t.Fatalf("CreateInvoice() failed: %v", err)
+		}
+		if err := srv.CancelInvoice(inv.ID); err != nil {
+			t.Fatalf("CancelInvoice() failed: %v", err)
+		}
+
+		if n, err := relay.Relay(1); err != nil || n != 1 {
+			t.Fatalf("Relay(1) = %d, %v, want 1, nil", n, err)
+		}
+		assertEvents(t, pub, inv.ID, "invoice.created")
+		if n, err := relay.Relay(1); err != nil || n != 1 {
+			t.Fatalf("Relay(1) = %d, %v, want 1, nil", n, err)
+		}
+		assertEvents(t, pub, inv.ID, "invoice.created", "invoice.canceled")
+	})
+
+	t.Run("merged invoices", func(t *testing.T) {
+		pub.Reset()
+		var ids []string
+		for i := 0; i < 2; i++ {
+			inv, err := srv.CreateInvoice("John Doe")
+			if err != nil {
+				t.Fatalf("CreateInvoice() failed: %v", err)
+			}
+			ids = append(ids, inv.ID)
+		}
+		merged, err := srv.MergeInvoices(ids)
+		if err != nil {
+			t.Fatalf("MergeInvoices() failed: %v", err)
+		}
+
+		if _, err := relay.Relay(invoice.DefaultRelayBatch); err != nil {
+			t.Fatalf("Relay() failed: %v", err)
+		}
+		assertEvents(t, pub, merged.ID, "invoice.created")
+		for _, id := range ids {
+			assertEvents(t, pub, id, "invoice.created", "invoice.canceled")
+		}
+	})
+}
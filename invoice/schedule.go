@@ -0,0 +1,70 @@
+package invoice
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultPaymentTerms is the time the issued invoice should be paid within.
+const DefaultPaymentTerms = 30 * 24 * time.Hour
+
+// ScheduleInvoiceIssue sets the time the open invoice should be issued at by
+// IssueScheduledInvoices, nil cancels the scheduled issue.
+func (s *Service) ScheduleInvoiceIssue(id string, at *time.Time) error {
+	inv, err := s.mustFindInvoice(id)
+	if err != nil {
+		return err
+	}
+
+	if err := inv.ScheduleIssue(at); err != nil {
+		return err
+	}
+
+	if err := s.strg.UpdateInvoice(*inv); err != nil {
+		return errors.Wrapf(err, errUpdateFailed, id)
+	}
+
+	return nil
+}
+
+// IssueScheduledInvoices issues open invoices scheduled to be issued at or
+// before now. The storage should implement Lister.
+func (s *Service) IssueScheduledInvoices(now time.Time, opts ...BulkOption) (BulkReport, error) {
+	scheduled := func(inv *Invoice) bool {
+		return inv.Status == Open && inv.IssueAt != nil && !inv.IssueAt.After(now)
+	}
+	return s.bulk(Selection{match: scheduled},
+		guarded(scheduled, "is not scheduled to be issued", s.issue), opts)
+}
+
+// MarkOverdueInvoices marks overdue issued invoices which due date, the issue
+// date plus terms, is before now. The storage should implement Lister.
+func (s *Service) MarkOverdueInvoices(now time.Time, terms time.Duration, opts ...BulkOption) (BulkReport, error) {
+	overdue := func(inv *Invoice) bool {
+		due := inv.DueDate(terms)
+		return inv.Status == Issued && due != nil && due.Before(now)
+	}
+	return s.bulk(Selection{match: overdue}, guarded(overdue, "is not overdue", (*Invoice).MarkOverdue), opts)
+}
+
+// CancelStaleInvoices cancels open invoices not updated since before. Invoices
+// scheduled to be issued are not stale. The storage should implement Lister.
+func (s *Service) CancelStaleInvoices(before time.Time, opts ...BulkOption) (BulkReport, error) {
+	stale := func(inv *Invoice) bool {
+		return inv.Status == Open && inv.IssueAt == nil && inv.UpdatedAt.Before(before)
+	}
+	return s.bulk(Selection{match: stale}, guarded(stale, "is not stale", (*Invoice).Cancel), opts)
+}
+
+// guarded returns the change applied only to invoices still matching, as the
+// invoice may change after it was selected.
+func guarded(match func(*Invoice) bool, reason string, change func(*Invoice) error) func(*Invoice) error {
+	return func(inv *Invoice) error {
+		if !match(inv) {
+			return fmt.Errorf("invoice %q %s", inv.ID, reason)
+		}
+		return change(inv)
+	}
+}
@@ -0,0 +1,120 @@
+package invoice_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/antklim/go-invoice/invoice"
+	testapi "github.com/antklim/go-invoice/test/api"
+)
+
+func TestIssueScheduledInvoices(t *testing.T) {
+	srv, invoiceAPI := serviceSetup()
+
+	now := time.Now()
+	past, future := now.Add(-time.Minute), now.Add(time.Hour)
+	due, err := invoiceAPI.CreateInvoice(testapi.WithIssueAt(&past))
+	if err != nil {
+		t.Fatalf("invoiceAPI.CreateInvoice() failed: %v", err)
+	}
+	for _, opt := range []testapi.InvoiceOption{testapi.WithIssueAt(&future), testapi.WithIssueAt(nil)} {
+		if _, err := invoiceAPI.CreateInvoice(opt); err != nil {
+			t.Fatalf("invoiceAPI.CreateInvoice() failed: %v", err)
+		}
+	}
+
+	report, err := srv.IssueScheduledInvoices(now)
+	if err != nil {
+		t.Fatalf("IssueScheduledInvoices() failed: %v", err)
+	}
+	assertResults(t, report, map[string]invoice.BulkStatus{due.ID: invoice.BulkSucceeded})
+
+	vinv := mustView(t, srv, due.ID)
+	if vinv.Status != invoice.Issued || vinv.IssueAt != nil {
+		t.Errorf("invalid issued invoice status %q, issue at %v", vinv.Status, vinv.IssueAt)
+	}
+}
+
+func TestScheduleInvoiceIssue(t *testing.T) {
+	srv, invoiceAPI := serviceSetup()
+
+	inv, err := invoiceAPI.CreateInvoice()
+	if err != nil {
+		t.Fatalf("invoiceAPI.CreateInvoice() failed: %v", err)
+	}
+	at := time.Now().Add(time.Hour)
+	if err := srv.ScheduleInvoiceIssue(inv.ID, &at); err != nil {
+		t.Fatalf("ScheduleInvoiceIssue(%q) failed: %v", inv.ID, err)
+	}
+	if vinv := mustView(t, srv, inv.ID); vinv.IssueAt == nil || !vinv.IssueAt.Equal(at) {
+		t.Errorf("invalid invoice.IssueAt %v, want %v", vinv.IssueAt, at)
+	}
+
+	issued, err := invoiceAPI.CreateInvoice(testapi.WithStatus(invoice.Issued))
+	if err != nil {
+		t.Fatalf("invoiceAPI.CreateInvoice() failed: %v", err)
+	}
+	if err := srv.ScheduleInvoiceIssue(issued.ID, &at); err == nil {
+		t.Errorf("ScheduleInvoiceIssue(%q) of issued invoice expected to fail", issued.ID)
+	}
+}
+
+func TestMarkOverdueInvoices(t *testing.T) {
+	srv, invoiceAPI := serviceSetup()
+
+	now := time.Now()
+	terms := 24 * time.Hour
+	longAgo, recently := now.Add(-2*terms), now.Add(-time.Hour)
+	overdue, err := invoiceAPI.CreateInvoice(testapi.WithStatus(invoice.Issued), testapi.WithIssueaDate(&longAgo))
+	if err != nil {
+		t.Fatalf("invoiceAPI.CreateInvoice() failed: %v", err)
+	}
+	for _, opts := range [][]testapi.InvoiceOption{
+		{testapi.WithStatus(invoice.Issued), testapi.WithIssueaDate(&recently)},
+		{testapi.WithStatus(invoice.Paid), testapi.WithIssueaDate(&longAgo)},
+	} {
+		if _, err := invoiceAPI.CreateInvoice(opts...); err != nil {
+			t.Fatalf("invoiceAPI.CreateInvoice() failed: %v", err)
+		}
+	}
+
+	report, err := srv.MarkOverdueInvoices(now, terms)
+	if err != nil {
+		t.Fatalf("MarkOverdueInvoices() failed: %v", err)
+	}
+	assertResults(t, report, map[string]invoice.BulkStatus{overdue.ID: invoice.BulkSucceeded})
+
+	if vinv := mustView(t, srv, overdue.ID); vinv.Status != invoice.Overdue {
+		t.Errorf("invalid invoice.Status %q, want %q", vinv.Status, invoice.Overdue)
+	}
+}
+
+func TestCancelStaleInvoices(t *testing.T) {
+	srv, invoiceAPI := serviceSetup()
+
+	now := time.Now()
+	old := now.Add(-48 * time.Hour)
+	stale, err := invoiceAPI.CreateInvoice(testapi.WithUpdatedAt(old))
+	if err != nil {
+		t.Fatalf("invoiceAPI.CreateInvoice() failed: %v", err)
+	}
+	for _, opts := range [][]testapi.InvoiceOption{
+		{},
+		{testapi.WithUpdatedAt(old), testapi.WithIssueAt(&now)},
+		{testapi.WithUpdatedAt(old), testapi.WithStatus(invoice.Issued)},
+	} {
+		if _, err := invoiceAPI.CreateInvoice(opts...); err != nil {
+			t.Fatalf("invoiceAPI.CreateInvoice() failed: %v", err)
+		}
+	}
+
+	report, err := srv.CancelStaleInvoices(now.Add(-24 * time.Hour))
+	if err != nil {
+		t.Fatalf("CancelStaleInvoices() failed: %v", err)
+	}
+	assertResults(t, report, map[string]invoice.BulkStatus{stale.ID: invoice.BulkSucceeded})
+
+	if vinv := mustView(t, srv, stale.ID); vinv.Status != invoice.Canceled {
+		t.Errorf("invalid invoice.Status %q, want %q", vinv.Status, invoice.Canceled)
+	}
+}
@@ -1,7 +1,6 @@
 package invoice
 
 import (
-	"fmt"
 	"time"
 
 	"github.com/google/uuid"
@@ -13,26 +12,44 @@ var (
 	errFindFailed   = "find invoice %q failed"
 	errUpdateFailed = "update invoice %q failed"
 	errNotFound     = "invoice %q not found"
+	errListFailed   = "list invoices failed"
+)
+
+const (
+	// listBatchSize is the number of invoices listed from the storage at once.
+	listBatchSize = 100
+	// erasureBatchSize is the number of invoices anonymized in one transaction,
+	// it keeps transactions within the storages limits.
+	erasureBatchSize = 25
 )
 
 type Service struct {
 	strg Storage
+	opts options
 }
 
 // New initiates a new instance of the service.
-func New(strg Storage) *Service {
-	return &Service{strg: strg}
+func New(strg Storage, opts ...Option) *Service {
+	sopts := defaultOptions
+	for _, o := range opts {
+		o.apply(&sopts)
+	}
+
+	if _, ok := strg.(Outbox); sopts.outbox && !ok {
+		panic("invoice: storage does not support outbox")
+	}
+	return &Service{strg: strg, opts: sopts}
 }
 
 // CreateInvoice generates and stores an invoice. A new invoice generated with
 // the provided customer name. Invoice and any occurred error returned.
 func (s *Service) CreateInvoice(customerName string) (Invoice, error) {
 	inv := NewInvoice(customerName)
-	err := s.strg.AddInvoice(inv)
+	err := s.add(&inv)
 	if err != nil {
-		err = errors.Wrap(err, errCreateFailed)
+		return inv, errors.Wrap(err, errCreateFailed)
 	}
-	return inv, err
+	return inv, nil
 }
 
 // ViewInvoice finds an invoice by invoice ID. It returns non nil pointer to the
@@ -51,6 +68,7 @@ func (s *Service) UpdateInvoiceCustomer(id, name string) error {
 		return err
 	}
 
+	from := inv.CustomerName
 	if err := inv.UpdateCustomerName(name); err != nil {
 		return err
 	}
@@ -59,6 +77,7 @@ func (s *Service) UpdateInvoiceCustomer(id, name string) error {
 		return errors.Wrapf(err, errUpdateFailed, id)
 	}
 
+	s.emit(CustomerUpdated{Invoice: inv.Clone(), From: from})
 	return nil
 }
 
@@ -84,6 +103,7 @@ func (s *Service) AddInvoiceItem(invID, productName string, price, qty int) (Ite
 		return Item{}, errors.Wrapf(err, errUpdateFailed, invID)
 	}
 
+	s.emit(ItemAdded{Invoice: inv.Clone(), Item: item})
 	return item, nil
 }
 
@@ -96,6 +116,11 @@ func (s *Service) DeleteInvoiceItem(invID, itemID string) error {
 		return err
 	}
 
+	var item Item
+	if idx := inv.FindItemIndex(func(it Item) bool { return it.ID == itemID }); idx != -1 {
+		item = inv.Items[idx]
+	}
+
 	ok, err := inv.DeleteItem(itemID)
 	if err != nil {
 		return err
@@ -106,6 +131,7 @@ func (s *Service) DeleteInvoiceItem(invID, itemID string) error {
 		if err := s.strg.UpdateInvoice(*inv); err != nil {
 			return errors.Wrapf(err, errUpdateFailed, invID)
 		}
+		s.emit(ItemDeleted{Invoice: inv.Clone(), Item: item})
 	}
 
 	return nil
@@ -113,21 +139,29 @@ func (s *Service) DeleteInvoiceItem(invID, itemID string) error {
 
 // IssueInvoice sets invoice the the issued status. If invoice not found
 // by provided ID or any issue occurred during invoice lookup or update an error
-// returned. Only invoices in "open" status are allowed to be issued.
+// returned. Only invoices in "open" status are allowed to be issued. When the
+// mailer is configured the issued invoice is delivered immediately, delivery
+// failure is not returned: it is recorded and retried by
+// DeliverPendingInvoices.
 func (s *Service) IssueInvoice(id string) error {
 	inv, err := s.mustFindInvoice(id)
 	if err != nil {
 		return err
 	}
 
-	if err := inv.Issue(); err != nil {
+	from := inv.Status
+	if err := s.issue(inv); err != nil {
 		return err
 	}
 
-	if err := s.strg.UpdateInvoice(*inv); err != nil {
+	if err := s.update(inv, from); err != nil {
 		return errors.Wrapf(err, errUpdateFailed, inv.ID)
 	}
 
+	if inv.Delivery != nil {
+		_ = s.DeliverInvoice(id)
+	}
+
 	return nil
 }
 
@@ -140,11 +174,12 @@ func (s *Service) CancelInvoice(id string) error {
 		return err
 	}
 
+	from := inv.Status
 	if err := inv.Cancel(); err != nil {
 		return err
 	}
 
-	if err := s.strg.UpdateInvoice(*inv); err != nil {
+	if err := s.update(inv, from); err != nil {
 		return errors.Wrapf(err, errUpdateFailed, inv.ID)
 	}
 
@@ -153,36 +188,118 @@ func (s *Service) CancelInvoice(id string) error {
 
 // PayInvoice sets invoice to the paid status. If invoice not found
 // by provided ID or any issue occurred during invoice lookup or update an error
-// returned. Only invoices in "issued" status are allowed to be paid.
+// returned. Issued, overdue, disputed and collections invoices are allowed to
+// be paid, paying the invoice stops its dunning.
 func (s *Service) PayInvoice(id string) error {
 	inv, err := s.mustFindInvoice(id)
 	if err != nil {
 		return err
 	}
 
+	from := inv.Status
 	if err := inv.Pay(); err != nil {
 		return err
 	}
 
-	if err := s.strg.UpdateInvoice(*inv); err != nil {
+	if err := s.update(inv, from); err != nil {
 		return errors.Wrapf(err, errUpdateFailed, inv.ID)
 	}
 
 	return nil
 }
 
+// FindCustomerInvoices returns all invoices of the customer. The customer name
+// should match exactly. The storage should implement Lister.
+func (s *Service) FindCustomerInvoices(name string) ([]Invoice, error) {
+	return s.findInvoices(Filter{Customer: name}.Match)
+}
+
+// AnonymizeCustomer redacts the customer personal data from all invoices of
+// the customer. Invoices are kept with their IDs, amounts and dates, as they
+// are required for tax retention. Invoices are anonymized in transactions of
+// erasureBatchSize invoices, when the storage supports transactions. The
+// erasure is logged when the service has the erasure log, including the partial
+// erasure when a transaction fails. Failed erasure can be repeated, it
+// anonymizes the remaining invoices.
+func (s *Service) AnonymizeCustomer(name string) (Erasure, error) {
+	if name == "" || name == Redacted {
+		return Erasure{}, validationErrorf("invalid customer name %q", name)
+	}
+
+	invs, err := s.FindCustomerInvoices(name)
+	if err != nil {
+		return Erasure{}, err
+	}
+
+	erasure := Erasure{ID: uuid.NewString(), At: time.Now()}
+	for start := 0; start < len(invs); start += erasureBatchSize {
+		end := start + erasureBatchSize
+		if end > len(invs) {
+			end = len(invs)
+		}
+
+		var ids []string
+		err = s.inTx(func(tx Storage) error {
+			ids = ids[:0]
+			return anonymize(tx, name, invs[start:end], &ids)
+		})
+		if err == nil || !s.transactional() {
+			erasure.InvoiceIDs = append(erasure.InvoiceIDs, ids...)
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	if s.opts.erasureLog != nil && len(erasure.InvoiceIDs) > 0 {
+		if lerr := s.opts.erasureLog.LogErasure(erasure); lerr != nil && err == nil {
+			err = errors.Wrapf(lerr, "log erasure %q failed", erasure.ID)
+		}
+	}
+	return erasure, err
+}
+
+// anonymize re-reads invoices in the storage and anonymizes the ones still
+// belonging to the customer. IDs of anonymized invoices appended to ids.
+func anonymize(strg Storage, name string, invs []Invoice, ids *[]string) error {
+	for _, found := range invs {
+		inv, err := strg.FindInvoice(found.ID)
+		if err != nil {
+			return errors.Wrapf(err, errFindFailed, found.ID)
+		}
+		if inv == nil || inv.CustomerName != name {
+			continue
+		}
+
+		inv.Anonymize()
+		if err := strg.UpdateInvoice(*inv); err != nil {
+			return errors.Wrapf(err, errUpdateFailed, inv.ID)
+		}
+		*ids = append(*ids, inv.ID)
+	}
+	return nil
+}
+
+// inTx runs f in the storage transaction. Storages that do not support
+// transactions are changed by f directly.
+func (s *Service) inTx(f func(tx Storage) error) error {
+	if t, ok := s.strg.(Transactor); ok {
+		return t.RunInTx(f)
+	}
+	return f(s.strg)
+}
+
+// transactional returns true when the storage supports transactions.
+func (s *Service) transactional() bool {
+	_, ok := s.strg.(Transactor)
+	return ok
+}
+
 // mustFindInvoice searches for the invoice by id. If invoice not found or other
 // issues occurred during invoice lookup an error returned. It returns a non-nil
 // pointer to the found invoice.
 func (s *Service) mustFindInvoice(id string) (*Invoice, error) {
-	inv, err := s.findInvoice(id)
-	if err != nil {
-		return nil, err
-	}
-	if inv == nil {
-		return nil, fmt.Errorf(errNotFound, id)
-	}
-	return inv, nil
+	return mustFindInvoice(s.strg, id)
 }
 
 // findInvoice searches for the invoice by id. It returns error only when
@@ -191,7 +308,24 @@ func (s *Service) mustFindInvoice(id string) (*Invoice, error) {
 // Invoice pointer is nil in error case or when invoice not found. Otherwise a
 // non-nil pointer to the found invoice returned.
 func (s *Service) findInvoice(id string) (*Invoice, error) {
-	inv, err := s.strg.FindInvoice(id)
+	return findInvoice(s.strg, id)
+}
+
+// mustFindInvoice searches for the invoice by id in the storage, it is
+// mustFindInvoice method for transaction storages.
+func mustFindInvoice(strg Storage, id string) (*Invoice, error) {
+	inv, err := findInvoice(strg, id)
+	if err != nil {
+		return nil, err
+	}
+	if inv == nil {
+		return nil, &NotFoundError{ID: id}
+	}
+	return inv, nil
+}
+
+func findInvoice(strg Storage, id string) (*Invoice, error) {
+	inv, err := strg.FindInvoice(id)
 	if err != nil {
 		return nil, errors.Wrapf(err, errFindFailed, id)
 	}
@@ -666,6 +666,20 @@ func TestPayInvoice(t *testing.T) {
 			t.Errorf("invalid invoice.UpdatedAt %v, want it to be after %v", vinv.UpdatedAt, inv.UpdatedAt)
 		}
 	})
+
+	t.Run("successfully pays overdue invoice", func(t *testing.T) {
+		inv, err := invoiceAPI.CreateInvoice(testapi.WithStatus(invoice.Overdue))
+		if err != nil {
+			t.Fatalf("invoiceAPI.CreateInvoice() failed: %v", err)
+		}
+
+		if err := srv.PayInvoice(inv.ID); err != nil {
+			t.Fatalf("PayInvoice(%q) failed: %v", inv.ID, err)
+		}
+		if vinv := mustView(t, srv, inv.ID); vinv.Status != invoice.Paid {
+			t.Errorf("invalid invoice.Status %q, want %q", vinv.Status, invoice.Paid)
+		}
+	})
 }
 
 func TestCancelInvoice(t *testing.T) {
@@ -760,3 +774,126 @@ func TestCancelInvoice(t *testing.T) {
 		}
 	})
 }
+
+func TestAnonymizeCustomer(t *testing.T) {
+	strg := storageSetup()
+	invoiceAPI := testapi.NewIvoiceAPI(strg)
+	erasureLog := mocks.NewErasureLog(nil)
+	srv := invoice.New(strg, invoice.WithErasureLog(erasureLog))
+
+	t.Run("redacts delivery errors", func(t *testing.T) {
+		at := time.Now()
+		d := &invoice.Delivery{
+			Status:   invoice.DeliverySent,
+			Attempts: []invoice.DeliveryAttempt{{At: at, Err: "550 john@example.com: no such user"}, {At: at}},
+		}
+		inv, err := invoiceAPI.CreateInvoice(testapi.WithCustomerName(uuid.NewString()), testapi.WithStatus(invoice.Issued),
+			testapi.WithDelivery(d))
+		if err != nil {
+			t.Fatalf("invoiceAPI.CreateInvoice() failed: %v", err)
+		}
+
+		if _, err := srv.AnonymizeCustomer(inv.CustomerName); err != nil {
+			t.Fatalf("AnonymizeCustomer(%q) failed: %v", inv.CustomerName, err)
+		}
+		attempts := mustView(t, srv, inv.ID).Delivery.Attempts
+		if attempts[0].Err != invoice.Redacted || attempts[1].Err != "" {
+			t.Errorf("invalid delivery attempts %v, want first error redacted", attempts)
+		}
+	})
+
+	t.Run("redacts customer name from all customer invoices", func(t *testing.T) {
+		customer := uuid.NewString()
+		var invoices []invoice.Invoice
+		for _, status := range []invoice.Status{invoice.Open, invoice.Issued, invoice.Paid, invoice.Canceled} {
+			inv, err := invoiceAPI.CreateInvoice(
+				testapi.WithCustomerName(customer),
+				testapi.WithStatus(status),
+				testapi.WithItems(testapi.ItemFactory()))
+			if err != nil {
+				t.Fatalf("invoiceAPI.CreateInvoice() failed: %v", err)
+			}
+			invoices = append(invoices, inv)
+		}
+		other, err := invoiceAPI.CreateInvoice(testapi.WithCustomerName(uuid.NewString()))
+		if err != nil {
+			t.Fatalf("invoiceAPI.CreateInvoice() failed: %v", err)
+		}
+
+		erasure, err := srv.AnonymizeCustomer(customer)
+		if err != nil {
+			t.Fatalf("AnonymizeCustomer(%q) failed: %v", customer, err)
+		}
+		if len(erasure.InvoiceIDs) != len(invoices) {
+			t.Errorf("invalid erasure.InvoiceIDs %v, want %d invoices", erasure.InvoiceIDs, len(invoices))
+		}
+
+		for _, inv := range invoices {
+			vinv, err := srv.ViewInvoice(inv.ID)
+			if err != nil {
+				t.Fatalf("ViewInvoice(%q) failed: %v", inv.ID, err)
+			}
+
+			// everything but personal data and update time kept
+			want := inv
+			want.CustomerName = invoice.Redacted
+			want.UpdatedAt = vinv.UpdatedAt
+			if !vinv.Equal(&want) {
+				t.Errorf("ViewInvoice(%q) = %v, want %v", inv.ID, vinv, want)
+			}
+		}
+
+		if vinv, err := srv.ViewInvoice(other.ID); err != nil || vinv.CustomerName != other.CustomerName {
+			t.Errorf("other customer invoice changed: %v, %v", vinv, err)
+		}
+
+		if invs, err := srv.FindCustomerInvoices(customer); err != nil || len(invs) != 0 {
+			t.Errorf("FindCustomerInvoices(%q) = %v, %v, want no invoices", customer, invs, err)
+		}
+	})
+
+	t.Run("logs erasure", func(t *testing.T) {
+		inv, err := invoiceAPI.CreateInvoice(testapi.WithCustomerName(uuid.NewString()))
+		if err != nil {
+			t.Fatalf("invoiceAPI.CreateInvoice() failed: %v", err)
+		}
+
+		erasure, err := srv.AnonymizeCustomer(inv.CustomerName)
+		if err != nil {
+			t.Fatalf("AnonymizeCustomer(%q) failed: %v", inv.CustomerName, err)
+		}
+
+		logged := erasureLog.Erasures[len(erasureLog.Erasures)-1]
+		if logged.ID != erasure.ID || len(logged.InvoiceIDs) != 1 || logged.InvoiceIDs[0] != inv.ID {
+			t.Errorf("invalid logged erasure %+v, want %+v", logged, erasure)
+		}
+	})
+
+	t.Run("fails when erasure log fails", func(t *testing.T) {
+		inv, err := invoiceAPI.CreateInvoice(testapi.WithCustomerName(uuid.NewString()))
+		if err != nil {
+			t.Fatalf("invoiceAPI.CreateInvoice() failed: %v", err)
+		}
+
+		e := errors.New("log unavailable")
+		srv := invoice.New(strg, invoice.WithErasureLog(mocks.NewErasureLog(e)))
+		if _, err := srv.AnonymizeCustomer(inv.CustomerName); err == nil {
+			t.Errorf("expected AnonymizeCustomer(%q) to fail due to log error", inv.CustomerName)
+		}
+	})
+
+	t.Run("fails when customer name is invalid", func(t *testing.T) {
+		for _, name := range []string{"", invoice.Redacted} {
+			if _, err := srv.AnonymizeCustomer(name); err == nil {
+				t.Errorf("expected AnonymizeCustomer(%q) to fail", name)
+			}
+		}
+	})
+
+	t.Run("fails when storage cannot list invoices", func(t *testing.T) {
+		srv := invoice.New(mocks.NewStorage())
+		if _, err := srv.AnonymizeCustomer("John Doe"); err == nil {
+			t.Error("expected AnonymizeCustomer() to fail")
+		}
+	})
+}
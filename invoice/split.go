@@ -0,0 +1,137 @@
+package invoice
+
+import "github.com/pkg/errors"
+
+// SplitInvoice moves items of the open invoice to a new open invoice of the
+// same customer. qtys maps item ID to the quantity to move: the item is moved
+// whole when the quantity equals the item quantity, otherwise the item is split
+// and both parts keep the item ID. The invoice should keep at least one item.
+// The new invoice references the invoice in Origins, and the invoice references
+// the new one in Successors. Both invoices are written in one transaction when
+// the storage supports transactions. It returns the new invoice.
+func (s *Service) SplitInvoice(id string, qtys map[string]int) (Invoice, error) {
+	if len(qtys) == 0 {
+		return Invoice{}, validationErrorf("no items to split")
+	}
+
+	var part Invoice
+	var events []Event
+	err := s.inTx(func(tx Storage) error {
+		inv, err := mustFindInvoice(tx, id)
+		if err != nil {
+			return err
+		}
+
+		for itemID := range qtys {
+			if !inv.ContainsItem(itemID) {
+				return validationErrorf("item %q not found", itemID)
+			}
+		}
+
+		part = NewInvoice(inv.CustomerName)
+		part.Origins = []string{inv.ID}
+		items := append([]Item(nil), inv.Items...)
+		for _, item := range items {
+			qty, ok := qtys[item.ID]
+			if !ok {
+				continue
+			}
+			moved, err := inv.TakeItem(item.ID, qty)
+			if err != nil {
+				return err
+			}
+			part.Items = append(part.Items, moved)
+		}
+		if len(inv.Items) == 0 {
+			return validationErrorf("invoice %q cannot be split: all items moved", inv.ID)
+		}
+		inv.Successors = append(inv.Successors, part.ID)
+
+		if err := tx.AddInvoice(part); err != nil {
+			return errors.Wrap(err, errCreateFailed)
+		}
+		if err := tx.UpdateInvoice(*inv); err != nil {
+			return errors.Wrapf(err, errUpdateFailed, inv.ID)
+		}
+		events = createdEvents(&part)
+		return s.writeEvents(tx, events)
+	})
+	if err != nil {
+		return Invoice{}, err
+	}
+	s.notify(events)
+	return part, nil
+}
+
+// MergeInvoices moves items of the open invoices of the same customer to a new
+// open invoice. Items of the same ID, for example parts of the split item, are
+// joined. The merged invoices are canceled and keep their items as history. The
+// new invoice references the merged invoices in Origins, and every merged
+// invoice references the new one in Successors. All invoices are written in one
+// transaction when the storage supports transactions. It returns the new
+// invoice.
+func (s *Service) MergeInvoices(ids []string) (Invoice, error) {
+	if len(ids) < 2 { // nolint:gomnd
+		return Invoice{}, validationErrorf("at least two invoices should be merged")
+	}
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			return Invoice{}, validationErrorf("invoice %q merged twice", id)
+		}
+		seen[id] = true
+	}
+
+	var merged Invoice
+	var events []Event
+	err := s.inTx(func(tx Storage) error {
+		invs := make([]*Invoice, len(ids))
+		for i, id := range ids {
+			inv, err := mustFindInvoice(tx, id)
+			if err != nil {
+				return err
+			}
+			if i > 0 && inv.CustomerName != invs[0].CustomerName {
+				return validationErrorf("invoice %q customer differs from invoice %q customer", inv.ID, invs[0].ID)
+			}
+			invs[i] = inv
+		}
+
+		merged = NewInvoice(invs[0].CustomerName)
+		merged.Origins = append([]string(nil), ids...)
+		for _, inv := range invs {
+			src := inv.Clone() // canceled invoice keeps its items
+			for _, item := range inv.Items {
+				moved, err := src.TakeItem(item.ID, item.Qty)
+				if err != nil {
+					return err
+				}
+				if err := merged.PutItem(moved); err != nil {
+					return err
+				}
+			}
+		}
+
+		if err := tx.AddInvoice(merged); err != nil {
+			return errors.Wrap(err, errCreateFailed)
+		}
+		events = createdEvents(&merged)
+		for _, inv := range invs {
+			from := inv.Status
+			if err := inv.Cancel(); err != nil {
+				return err
+			}
+			inv.Successors = append(inv.Successors, merged.ID)
+			if err := tx.UpdateInvoice(*inv); err != nil {
+				return errors.Wrapf(err, errUpdateFailed, inv.ID)
+			}
+			events = append(events, changeEvents(inv, from)...)
+		}
+		return s.writeEvents(tx, events)
+	})
+	if err != nil {
+		return Invoice{}, err
+	}
+	s.notify(events)
+	return merged, nil
+}
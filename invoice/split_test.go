@@ -0,0 +1,230 @@
+package invoice_test
+
+import (
+	"testing"
+
+	"github.com/antklim/go-invoice/invoice"
+	testapi "github.com/antklim/go-invoice/test/api"
+)
+
+func mustView(t *testing.T, srv *invoice.Service, id string) *invoice.Invoice {
+	t.Helper()
+
+	inv, err := srv.ViewInvoice(id)
+	if err != nil {
+		t.Fatalf("ViewInvoice(%q) failed: %v", id, err)
+	}
+	if inv == nil {
+		t.Fatalf("ViewInvoice(%q) invoice expected, got nil", id)
+	}
+	return inv
+}
+
+func findItem(inv *invoice.Invoice, id string) *invoice.Item {
+	for i := range inv.Items {
+		if inv.Items[i].ID == id {
+			return &inv.Items[i]
+		}
+	}
+	return nil
+}
+
+func TestSplitInvoice(t *testing.T) {
+	srv, invoiceAPI := serviceSetup()
+
+	t.Run("moves items and quantities to new invoice", func(t *testing.T) {
+		inv, err := invoiceAPI.CreateInvoiceWithNItems(3)
+		if err != nil {
+			t.Fatalf("invoiceAPI.CreateInvoiceWithNItems() failed: %v", err)
+		}
+		whole, split, kept := inv.Items[0], inv.Items[1], inv.Items[2]
+
+		part, err := srv.SplitInvoice(inv.ID, map[string]int{whole.ID: whole.Qty, split.ID: 1})
+		if err != nil {
+			t.Fatalf("SplitInvoice(%q) failed: %v", inv.ID, err)
+		}
+
+		vpart := mustView(t, srv, part.ID)
+		if vpart.Status != invoice.Open || vpart.CustomerName != inv.CustomerName {
+			t.Errorf("invalid split invoice %v", vpart)
+		}
+		if len(vpart.Origins) != 1 || vpart.Origins[0] != inv.ID {
+			t.Errorf("invalid split invoice.Origins %v, want [%s]", vpart.Origins, inv.ID)
+		}
+		if len(vpart.Items) != 2 {
+			t.Fatalf("invalid split invoice.Items %v, want 2 items", vpart.Items)
+		}
+		for _, want := range []invoice.Item{whole, split} {
+			if want.ID == split.ID {
+				want.Qty = 1
+			}
+			want.Origin = inv.ID
+			if item := findItem(vpart, want.ID); item == nil || !item.Equal(&want) {
+				t.Errorf("invalid moved item %v, want %v", item, want)
+			}
+		}
+
+		vinv := mustView(t, srv, inv.ID)
+		if len(vinv.Successors) != 1 || vinv.Successors[0] != part.ID {
+			t.Errorf("invalid invoice.Successors %v, want [%s]", vinv.Successors, part.ID)
+		}
+		if findItem(vinv, whole.ID) != nil {
+			t.Errorf("moved item %q kept on invoice", whole.ID)
+		}
+		if item := findItem(vinv, split.ID); item == nil || item.Qty != split.Qty-1 {
+			t.Errorf("invalid split item %v, want qty %d", item, split.Qty-1)
+		}
+		if item := findItem(vinv, kept.ID); item == nil || item.Qty != kept.Qty {
+			t.Errorf("invalid kept item %v", item)
+		}
+	})
+
+	t.Run("fails and changes nothing", func(t *testing.T) {
+		issued, err := invoiceAPI.CreateInvoiceWithNItems(2, testapi.WithStatus(invoice.Issued))
+		if err != nil {
+			t.Fatalf("invoiceAPI.CreateInvoiceWithNItems() failed: %v", err)
+		}
+		open, err := invoiceAPI.CreateInvoiceWithNItems(2)
+		if err != nil {
+			t.Fatalf("invoiceAPI.CreateInvoiceWithNItems() failed: %v", err)
+		}
+
+		testCases := []struct {
+			desc string
+			inv  invoice.Invoice
+			qtys map[string]int
+		}{
+			{desc: "no items", inv: open},
+			{desc: "not open invoice", inv: issued, qtys: map[string]int{issued.Items[0].ID: 1}},
+			{desc: "unknown item", inv: open, qtys: map[string]int{"unknown": 1}},
+			{desc: "invalid qty", inv: open, qtys: map[string]int{open.Items[0].ID: open.Items[0].Qty + 1}},
+			{desc: "all items moved", inv: open, qtys: map[string]int{
+				open.Items[0].ID: open.Items[0].Qty,
+				open.Items[1].ID: open.Items[1].Qty,
+			}},
+		}
+
+		for _, tC := range testCases {
+			t.Run(tC.desc, func(t *testing.T) {
+				if _, err := srv.SplitInvoice(tC.inv.ID, tC.qtys); err == nil {
+					t.Fatalf("SplitInvoice(%q) expected to fail", tC.inv.ID)
+				}
+
+				vinv := mustView(t, srv, tC.inv.ID)
+				if !vinv.Equal(&tC.inv) {
+					t.Errorf("invoice changed: %v, want %v", vinv, tC.inv)
+				}
+			})
+		}
+	})
+}
+
+func TestMergeInvoices(t *testing.T) {
+	srv, invoiceAPI := serviceSetup()
+
+	t.Run("moves items to new invoice and cancels merged", func(t *testing.T) {
+		inv1, err := invoiceAPI.CreateInvoiceWithNItems(2)
+		if err != nil {
+			t.Fatalf("invoiceAPI.CreateInvoiceWithNItems() failed: %v", err)
+		}
+		inv2, err := invoiceAPI.CreateInvoiceWithNItems(1)
+		if err != nil {
+			t.Fatalf("invoiceAPI.CreateInvoiceWithNItems() failed: %v", err)
+		}
+
+		merged, err := srv.MergeInvoices([]string{inv1.ID, inv2.ID})
+		if err != nil {
+			t.Fatalf("MergeInvoices() failed: %v", err)
+		}
+
+		vmerged := mustView(t, srv, merged.ID)
+		if vmerged.Status != invoice.Open || len(vmerged.Items) != 3 {
+			t.Errorf("invalid merged invoice %v", vmerged)
+		}
+		if len(vmerged.Origins) != 2 || vmerged.Origins[0] != inv1.ID || vmerged.Origins[1] != inv2.ID {
+			t.Errorf("invalid merged invoice.Origins %v", vmerged.Origins)
+		}
+
+		for _, inv := range []invoice.Invoice{inv1, inv2} {
+			for _, item := range inv.Items {
+				if moved := findItem(vmerged, item.ID); moved == nil || moved.Qty != item.Qty || moved.Origin != inv.ID {
+					t.Errorf("invalid moved item %v, want item %q from %q", moved, item.ID, inv.ID)
+				}
+			}
+
+			vinv := mustView(t, srv, inv.ID)
+			if vinv.Status != invoice.Canceled {
+				t.Errorf("invalid invoice.Status %q, want %q", vinv.Status, invoice.Canceled)
+			}
+			if len(vinv.Successors) != 1 || vinv.Successors[0] != merged.ID {
+				t.Errorf("invalid invoice.Successors %v, want [%s]", vinv.Successors, merged.ID)
+			}
+			if len(vinv.Items) != len(inv.Items) {
+				t.Errorf("invalid canceled invoice.Items %v, want items kept", vinv.Items)
+			}
+		}
+	})
+
+	t.Run("joins split items", func(t *testing.T) {
+		inv, err := invoiceAPI.CreateInvoiceWithNItems(2)
+		if err != nil {
+			t.Fatalf("invoiceAPI.CreateInvoiceWithNItems() failed: %v", err)
+		}
+		item := inv.Items[0]
+		part, err := srv.SplitInvoice(inv.ID, map[string]int{item.ID: 1})
+		if err != nil {
+			t.Fatalf("SplitInvoice(%q) failed: %v", inv.ID, err)
+		}
+
+		merged, err := srv.MergeInvoices([]string{inv.ID, part.ID})
+		if err != nil {
+			t.Fatalf("MergeInvoices() failed: %v", err)
+		}
+
+		vmerged := mustView(t, srv, merged.ID)
+		if joined := findItem(vmerged, item.ID); joined == nil || joined.Qty != item.Qty || joined.Origin != inv.ID {
+			t.Errorf("invalid joined item %v, want qty %d from %q", joined, item.Qty, inv.ID)
+		}
+	})
+
+	t.Run("fails and changes nothing", func(t *testing.T) {
+		open, err := invoiceAPI.CreateInvoiceWithNItems(1)
+		if err != nil {
+			t.Fatalf("invoiceAPI.CreateInvoiceWithNItems() failed: %v", err)
+		}
+		other, err := invoiceAPI.CreateInvoiceWithNItems(1, testapi.WithCustomerName("Jane Doe"))
+		if err != nil {
+			t.Fatalf("invoiceAPI.CreateInvoiceWithNItems() failed: %v", err)
+		}
+		issued, err := invoiceAPI.CreateInvoiceWithNItems(1, testapi.WithStatus(invoice.Issued))
+		if err != nil {
+			t.Fatalf("invoiceAPI.CreateInvoiceWithNItems() failed: %v", err)
+		}
+
+		testCases := []struct {
+			desc string
+			ids  []string
+		}{
+			{desc: "single invoice", ids: []string{open.ID}},
+			{desc: "repeated invoice", ids: []string{open.ID, open.ID}},
+			{desc: "different customers", ids: []string{open.ID, other.ID}},
+			{desc: "not open invoice", ids: []string{open.ID, issued.ID}},
+			{desc: "unknown invoice", ids: []string{open.ID, "unknown"}},
+		}
+
+		for _, tC := range testCases {
+			t.Run(tC.desc, func(t *testing.T) {
+				if _, err := srv.MergeInvoices(tC.ids); err == nil {
+					t.Fatalf("MergeInvoices(%v) expected to fail", tC.ids)
+				}
+
+				for _, inv := range []invoice.Invoice{open, other, issued} {
+					inv := inv
+					if vinv := mustView(t, srv, inv.ID); !vinv.Equal(&inv) {
+						t.Errorf("invoice changed: %v, want %v", vinv, inv)
+					}
+				}
+			})
+		}
+	})
+}
@@ -9,3 +9,22 @@ type Storage interface {
 type StorageFactory interface {
 	MakeStorage() Storage
 }
+
+// Lister is implemented by storages that can list all invoices. Invoices are
+// listed in pages: ListInvoices returns up to limit invoices starting from the
+// cursor and the cursor of the next page. Empty cursor starts from the first
+// page, empty next cursor means there are no more invoices. A page can contain
+// fewer invoices than limit, or none, before the last page.
+type Lister interface {
+	ListInvoices(cursor string, limit int) (invs []Invoice, next string, err error)
+}
+
+// Transactor is implemented by storages that can change several invoices
+// atomically. RunInTx calls f with the storage of the transaction: invoices
+// added and updated through it are visible to its FindInvoice, and written
+// together when f returns nil. Nothing is written when f fails or the commit
+// fails. The commit fails when an invoice updated by the transaction was
+// changed by another writer after the transaction read it.
+type Transactor interface {
+	RunInTx(f func(tx Storage) error) error
+}
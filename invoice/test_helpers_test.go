@@ -1,22 +1,72 @@
 package invoice_test
 
 import (
+	"fmt"
 	"os"
+	"path/filepath"
+	"testing"
 
 	"github.com/antklim/go-invoice/invoice"
 	"github.com/antklim/go-invoice/storage"
 	testapi "github.com/antklim/go-invoice/test/api"
+	"github.com/antklim/go-invoice/test/fakes"
 )
 
+// defaultTestStorages are the storages the tests run against when TEST_STORAGE
+// is not set, so plain go test checks both in-memory and DynamoDB semantics.
+var defaultTestStorages = []string{"memory", "dynamo-fake"}
+
+// testStorage is the storage of the current tests run.
+var testStorage string
+
+// TestMain runs the tests against the TEST_STORAGE storage, or against every
+// default test storage when it is not set.
+func TestMain(m *testing.M) {
+	if s := os.Getenv("TEST_STORAGE"); s != "" {
+		testStorage = s
+		os.Exit(m.Run())
+	}
+
+	code := 0
+	for _, s := range defaultTestStorages {
+		testStorage = s
+		if c := m.Run(); c != 0 {
+			fmt.Printf("tests failed with %s storage\n", s)
+			code = c
+		}
+	}
+	os.Exit(code)
+}
+
 func storageSetup() invoice.Storage {
 	var f invoice.StorageFactory
-	switch os.Getenv("TEST_STORAGE") {
+	switch testStorage {
 	case "dynamo":
 		tableName := "invoices"
 		if os.Getenv("TEST_STORAGE_TABLE") != "" {
 			tableName = os.Getenv("TEST_STORAGE_TABLE")
 		}
 		f = storage.NewDynamo(tableName, storage.WithEndpoint(os.Getenv("TEST_AWS_ENDPOINT")))
+	case "dynamo-fake":
+		client := fakes.NewDynamoDB(fakes.WithTable("invoices", "pk", "sk"),
+			fakes.WithIndex("invoices", "outbox", "outbox", "outboxAt"))
+		f = storage.NewDynamo("invoices", storage.WithClient(client))
+	case "sqlite":
+		f = storage.NewSQLite(":memory:")
+	case "memory-wal":
+		dir, err := os.MkdirTemp("", "go-invoice-test")
+		if err != nil {
+			panic(err)
+		}
+		f = storage.NewDurableMemory(dir)
+	case "memory-sharded":
+		f = storage.NewShardedMemory(0)
+	case "bolt":
+		dir, err := os.MkdirTemp("", "go-invoice-test")
+		if err != nil {
+			panic(err)
+		}
+		f = storage.NewBolt(filepath.Join(dir, "invoices.bolt"))
 	default:
 		f = new(storage.Memory)
 	}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/antklim/go-invoice/cli"
+	"github.com/antklim/go-invoice/invoice"
+	"github.com/antklim/go-invoice/scheduler"
+)
+
+// schedulerStopTimeout is how long running jobs are waited for on exit before
+// they are canceled.
+const schedulerStopTimeout = 30 * time.Second
+
+// scheduledJob is the job run on the schedule spec, empty spec disables it.
+type scheduledJob struct {
+	name, spec string
+	job        scheduler.JobFunc
+}
+
+// initScheduler registers the jobs of the set schedules and starts the
+// scheduler. Job failures are printed.
+func initScheduler(svc *invoice.Service) *scheduler.Scheduler {
+	ladder, err := invoice.ParseDunningLadder(dunningLadder)
+	if err != nil {
+		panic("svc: " + err.Error())
+	}
+
+	opts := []scheduler.Option{scheduler.WithJitter(jitter), scheduler.WithLog(os.Stdout)}
+	if schedulerState != "" {
+		opts = append(opts, scheduler.WithState(scheduler.NewFileState(schedulerState)))
+	}
+	s := scheduler.New(opts...)
+
+	jobs := []scheduledJob{
+		{"issue-scheduled", issueSchedule, func(_ context.Context, t scheduler.Tick) error {
+			return bulkJobError(svc.IssueScheduledInvoices(t.At))
+		}},
+		{"mark-overdue", overdueSchedule, func(_ context.Context, t scheduler.Tick) error {
+			return bulkJobError(svc.MarkOverdueInvoices(t.At, paymentTerms))
+		}},
+		{"cancel-stale", staleSchedule, func(_ context.Context, t scheduler.Tick) error {
+			return bulkJobError(svc.CancelStaleInvoices(t.At.Add(-staleAfter)))
+		}},
+		{"dunning", dunningSchedule, func(_ context.Context, t scheduler.Tick) error {
+			return bulkJobError(svc.RunDunning(t.At, paymentTerms, ladder))
+		}},
+	}
+	if mailerType != "" {
+		jobs = append(jobs, scheduledJob{"deliver-invoices", deliverSchedule, func(_ context.Context, t scheduler.Tick) error {
+			return bulkJobError(svc.DeliverPendingInvoices(t.At))
+		}})
+	}
+	for _, j := range jobs {
+		if j.spec == "" {
+			continue
+		}
+		if err := s.Register(j.name, j.spec, canceled(j.job)); err != nil {
+			panic("svc: " + err.Error())
+		}
+	}
+
+	if err := s.Start(); err != nil {
+		panic("svc: start scheduler failed: " + err.Error())
+	}
+	return s
+}
+
+// canceled returns the job that does not start when its context is canceled,
+// as invoice operations cannot be canceled once started.
+func canceled(job scheduler.JobFunc) scheduler.JobFunc {
+	return func(ctx context.Context, t scheduler.Tick) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return job(ctx, t)
+	}
+}
+
+// bulkJobError returns error when the bulk operation or any of its invoices
+// failed.
+func bulkJobError(report invoice.BulkReport, err error) error {
+	if err != nil {
+		return err
+	}
+
+	if n := report.Count(invoice.BulkFailed); n > 0 {
+		var errs []string
+		for _, res := range report.Results {
+			if res.Status == invoice.BulkFailed {
+				errs = append(errs, res.Err.Error())
+			}
+		}
+		return fmt.Errorf("%d of %d invoice(s) failed: %s", n, len(report.Results), strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// stopScheduler waits for running jobs to finish.
+func stopScheduler(s *scheduler.Scheduler) {
+	ctx, cancel := context.WithTimeout(context.Background(), schedulerStopTimeout)
+	defer cancel()
+	if err := s.Stop(ctx); err != nil {
+		fmt.Printf("stop scheduler failed: %v\n", err)
+	}
+}
+
+func jobsHandler(s *scheduler.Scheduler) cli.RunnerFunc {
+	return func(out io.Writer, args ...string) {
+		infos := s.Jobs()
+		if len(infos) == 0 {
+			fmt.Fprint(out, "no jobs scheduled\n")
+			return
+		}
+
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0) // nolint:gomnd
+		fmt.Fprintln(w, "JOB\tSCHEDULE\tLAST RUN\tRESULT\tNEXT RUN")
+		for _, info := range infos {
+			last, result := "-", "-"
+			if !info.Last.Scheduled.IsZero() {
+				last, result = info.Last.Started.Format(time.RFC3339), "ok"
+				if info.Last.Err != "" {
+					result = info.Last.Err
+				}
+			}
+			if info.Running {
+				result = "running"
+			}
+			next := "-"
+			if !info.Next.IsZero() {
+				next = info.Next.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", info.Name, info.Spec, last, result, next)
+		}
+		w.Flush()
+	}
+}
+
+func runJobHandler(s *scheduler.Scheduler) cli.RunnerFunc {
+	return func(out io.Writer, args ...string) {
+		if len(args) == 0 || args[0] == "" {
+			fmt.Fprint(out, "run job failed: missing job name\n")
+			return
+		}
+
+		name := strings.TrimSpace(args[0])
+		r, err := s.RunNow(name)
+		if err != nil {
+			fmt.Fprintf(out, "run job failed: %v\n", err)
+			return
+		}
+		if r.Err != "" {
+			fmt.Fprintf(out, "job %q failed: %s\n", name, r.Err)
+			return
+		}
+
+		fmt.Fprintf(out, "job %q successfully run in %s\n", name, r.Finished.Sub(r.Started).Round(time.Millisecond))
+	}
+}
+
+// scheduleIssueHandler schedules the invoice issue at the RFC 3339 time, or
+// cancels the scheduled issue when the time is "none".
+func scheduleIssueHandler(svc *invoice.Service) cli.RunnerFunc {
+	return func(out io.Writer, args ...string) {
+		if len(args) < 2 || args[0] == "" || args[1] == "" {
+			fmt.Fprint(out, "schedule invoice issue failed: missing invoice ID and/or issue time\n")
+			return
+		}
+
+		invID, arg := strings.TrimSpace(args[0]), strings.TrimSpace(args[1])
+		var at *time.Time
+		if arg != "none" {
+			t, err := time.Parse(time.RFC3339, arg)
+			if err != nil {
+				fmt.Fprintf(out, "schedule invoice issue failed: invalid issue time: %v\n", err)
+				return
+			}
+			at = &t
+		}
+
+		if err := svc.ScheduleInvoiceIssue(invID, at); err != nil {
+			fmt.Fprintf(out, "schedule invoice issue failed: %v\n", err)
+			return
+		}
+
+		if at == nil {
+			fmt.Fprintf(out, "%q invoice scheduled issue successfully canceled\n", invID)
+			return
+		}
+		fmt.Fprintf(out, "%q invoice successfully scheduled to be issued at %s\n", invID, at.Format(time.RFC3339))
+	}
+}
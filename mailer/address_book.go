@@ -0,0 +1,49 @@
+package mailer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/mail"
+	"os"
+
+	"github.com/antklim/go-invoice/invoice"
+	"github.com/pkg/errors"
+)
+
+// ErrNoAddress is returned when the customer has no address.
+var ErrNoAddress = errors.New("customer address not found")
+
+// AddressBook maps customer names to email addresses.
+type AddressBook map[string]string
+
+var _ invoice.AddressBook = AddressBook(nil)
+
+// LoadAddressBook reads the address book from the JSON file of the object with
+// customer names keys and email addresses values.
+func LoadAddressBook(path string) (AddressBook, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "read address book failed")
+	}
+
+	var book AddressBook
+	if err := json.Unmarshal(data, &book); err != nil {
+		return nil, errors.Wrap(err, "parse address book failed")
+	}
+	for customer, addr := range book {
+		if _, err := mail.ParseAddress(addr); err != nil {
+			return nil, fmt.Errorf("invalid customer %q address %q", customer, addr)
+		}
+	}
+	return book, nil
+}
+
+// Address returns the customer address, ErrNoAddress when the customer is not
+// in the book. The error does not contain the customer name.
+func (b AddressBook) Address(customer string) (string, error) {
+	addr, ok := b[customer]
+	if !ok {
+		return "", ErrNoAddress
+	}
+	return addr, nil
+}
@@ -0,0 +1,3 @@
+// Package mailer implements invoice.Mailer: SMTP mailer and maildir mailer that
+// writes emails to local files, and the JSON file address book of customers.
+package mailer
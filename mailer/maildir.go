@@ -0,0 +1,71 @@
+package mailer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/antklim/go-invoice/invoice"
+	"github.com/pkg/errors"
+)
+
+// Maildir writes emails to the maildir directory, so they can be read by
+// local mail clients. Every email is written to the tmp subdirectory and then
+// moved to the new one.
+type Maildir struct {
+	dir  string
+	from string
+	host string
+
+	mu  sync.Mutex
+	seq int
+}
+
+var _ invoice.Mailer = (*Maildir)(nil)
+
+// NewMaildir creates the mailer writing emails from the address to the dir.
+// The maildir subdirectories are created when they do not exist.
+func NewMaildir(dir, from string) (*Maildir, error) {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0700); err != nil { // nolint:gomnd
+			return nil, errors.Wrapf(err, "create maildir %q failed", dir)
+		}
+	}
+
+	host, err := os.Hostname()
+	if err != nil {
+		host = "localhost"
+	}
+	return &Maildir{dir: dir, from: from, host: host}, nil
+}
+
+func (m *Maildir) Send(e invoice.Email) error {
+	now := time.Now()
+	msg, err := message(m.from, e, now)
+	if err != nil {
+		return err
+	}
+
+	name := m.fileName(now)
+	tmp := filepath.Join(m.dir, "tmp", name)
+	if err := os.WriteFile(tmp, msg, 0600); err != nil { // nolint:gomnd
+		return errors.Wrap(err, "write maildir email failed")
+	}
+	if err := os.Rename(tmp, filepath.Join(m.dir, "new", name)); err != nil {
+		os.Remove(tmp) // nolint:errcheck
+		return errors.Wrap(err, "write maildir email failed")
+	}
+	return nil
+}
+
+// fileName returns the unique name of the email file.
+func (m *Maildir) fileName(now time.Time) string {
+	m.mu.Lock()
+	m.seq++
+	seq := m.seq
+	m.mu.Unlock()
+
+	return fmt.Sprintf("%d.M%dP%dQ%d.%s", now.Unix(), now.Nanosecond()/1000, os.Getpid(), seq, m.host) // nolint:gomnd
+}
@@ -0,0 +1,151 @@
+package mailer_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/antklim/go-invoice/invoice"
+	"github.com/antklim/go-invoice/mailer"
+	"github.com/antklim/go-invoice/test/fakes"
+)
+
+const from = "invoices@example.com"
+
+var email = invoice.Email{
+	To:      "john@example.com",
+	Subject: "Invoice 1",
+	Body:    "Dear John,\n.\nTotal: 1.00\n",
+}
+
+func TestSMTP(t *testing.T) {
+	srv, err := fakes.NewSMTPServer()
+	if err != nil {
+		t.Fatalf("NewSMTPServer() failed: %v", err)
+	}
+	defer srv.Close()
+
+	m, err := mailer.NewSMTP(srv.Addr(), from)
+	if err != nil {
+		t.Fatalf("NewSMTP() failed: %v", err)
+	}
+
+	if err := m.Send(email); err != nil {
+		t.Fatalf("Send() failed: %v", err)
+	}
+	msgs := srv.Messages()
+	if len(msgs) != 1 {
+		t.Fatalf("invalid number of messages %d, want 1", len(msgs))
+	}
+	msg := msgs[0]
+	if msg.From != from || len(msg.To) != 1 || msg.To[0] != email.To {
+		t.Errorf("invalid message envelope from %q to %v", msg.From, msg.To)
+	}
+	for _, want := range []string{
+		"From: " + from + "\r\n",
+		"To: " + email.To + "\r\n",
+		"Subject: Invoice 1\r\n",
+		"\r\n\r\nDear John,\r\n.\r\nTotal: 1.00\r\n",
+	} {
+		if !strings.Contains(msg.Data, want) {
+			t.Errorf("message %q does not contain %q", msg.Data, want)
+		}
+	}
+
+	srv.Reject(1)
+	if err := m.Send(email); err == nil || !strings.Contains(err.Error(), "451") {
+		t.Errorf("Send() error = %v, want 451 error", err)
+	}
+	if err := m.Send(email); err != nil {
+		t.Errorf("Send() after rejected message failed: %v", err)
+	}
+}
+
+func TestSMTPErrors(t *testing.T) {
+	if _, err := mailer.NewSMTP("localhost", from); err == nil {
+		t.Error("NewSMTP() without port expected to fail")
+	}
+
+	srv, err := fakes.NewSMTPServer()
+	if err != nil {
+		t.Fatalf("NewSMTPServer() failed: %v", err)
+	}
+	addr := srv.Addr()
+	srv.Close()
+
+	m, err := mailer.NewSMTP(addr, from)
+	if err != nil {
+		t.Fatalf("NewSMTP() failed: %v", err)
+	}
+	if err := m.Send(email); err == nil {
+		t.Error("Send() to closed server expected to fail")
+	}
+
+	for _, e := range []invoice.Email{
+		{To: "john", Subject: "Invoice"},
+		{To: "john@example.com", Subject: "Invoice\r\nBcc: eve@example.com"},
+	} {
+		if err := m.Send(e); err == nil || strings.Contains(err.Error(), "connect") {
+			t.Errorf("Send(%v) error = %v, want invalid email error", e, err)
+		}
+	}
+}
+
+func TestMaildir(t *testing.T) {
+	dir := t.TempDir()
+	m, err := mailer.NewMaildir(dir, from)
+	if err != nil {
+		t.Fatalf("NewMaildir() failed: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := m.Send(email); err != nil {
+			t.Fatalf("Send() failed: %v", err)
+		}
+	}
+
+	files, err := os.ReadDir(filepath.Join(dir, "new"))
+	if err != nil {
+		t.Fatalf("ReadDir() failed: %v", err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("invalid number of emails %d, want 2", len(files))
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "new", files[0].Name()))
+	if err != nil {
+		t.Fatalf("ReadFile() failed: %v", err)
+	}
+	if !strings.Contains(string(data), "To: "+email.To+"\r\n") {
+		t.Errorf("invalid email %q", data)
+	}
+	if tmp, _ := os.ReadDir(filepath.Join(dir, "tmp")); len(tmp) != 0 {
+		t.Errorf("unexpected tmp files %v", tmp)
+	}
+}
+
+func TestLoadAddressBook(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "addresses.json")
+	if err := os.WriteFile(path, []byte(`{"John Doe": "john@example.com"}`), 0600); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+
+	book, err := mailer.LoadAddressBook(path)
+	if err != nil {
+		t.Fatalf("LoadAddressBook() failed: %v", err)
+	}
+	if addr, err := book.Address("John Doe"); err != nil || addr != "john@example.com" {
+		t.Errorf("Address() = %q, %v, want john@example.com", addr, err)
+	}
+	if _, err := book.Address("Jane Doe"); err != mailer.ErrNoAddress {
+		t.Errorf("Address() of unknown customer error = %v, want %v", err, mailer.ErrNoAddress)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"John Doe": "john"}`), 0600); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	if _, err := mailer.LoadAddressBook(path); err == nil {
+		t.Error("LoadAddressBook() of invalid address expected to fail")
+	}
+}
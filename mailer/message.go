@@ -0,0 +1,44 @@
+package mailer
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/antklim/go-invoice/invoice"
+	"github.com/google/uuid"
+)
+
+// message formats the email as the RFC 5322 message with CRLF line endings.
+func message(from string, e invoice.Email, date time.Time) ([]byte, error) {
+	if _, err := mail.ParseAddress(from); err != nil {
+		return nil, fmt.Errorf("invalid sender address %q", from)
+	}
+	if _, err := mail.ParseAddress(e.To); err != nil {
+		return nil, fmt.Errorf("invalid recipient address %q", e.To)
+	}
+	if strings.ContainsAny(e.Subject, "\r\n") {
+		return nil, fmt.Errorf("invalid subject %q", e.Subject)
+	}
+
+	var b bytes.Buffer
+	header := func(name, value string) {
+		fmt.Fprintf(&b, "%s: %s\r\n", name, value)
+	}
+	header("From", from)
+	header("To", e.To)
+	header("Subject", mime.QEncoding.Encode("utf-8", e.Subject))
+	header("Date", date.Format(time.RFC1123Z))
+	header("Message-ID", "<"+uuid.NewString()+"@go-invoice>")
+	header("MIME-Version", "1.0")
+	header("Content-Type", "text/plain; charset=utf-8")
+	header("Content-Transfer-Encoding", "8bit")
+	b.WriteString("\r\n")
+
+	body := strings.ReplaceAll(e.Body, "\r\n", "\n")
+	b.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return b.Bytes(), nil
+}
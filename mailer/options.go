@@ -0,0 +1,51 @@
+package mailer
+
+import "time"
+
+type options struct {
+	username string
+	password string
+	timeout  time.Duration
+}
+
+// DefaultTimeout is the default time limit of sending one email.
+const DefaultTimeout = 30 * time.Second
+
+var defaultOptions = options{
+	timeout: DefaultTimeout,
+}
+
+type Option interface {
+	apply(*options)
+}
+
+type funcOption struct {
+	f func(*options)
+}
+
+func (f *funcOption) apply(o *options) {
+	f.f(o)
+}
+
+func newFuncOption(f func(*options)) Option {
+	return &funcOption{f: f}
+}
+
+// WithAuth sets the credentials of the SMTP PLAIN authentication. The
+// credentials are sent only over TLS or to localhost. Emails are sent without
+// authentication by default.
+func WithAuth(username, password string) Option {
+	return newFuncOption(func(o *options) {
+		o.username, o.password = username, password
+	})
+}
+
+// WithTimeout sets the time limit of sending one email, including the
+// connection. DefaultTimeout is used by default.
+func WithTimeout(v time.Duration) Option {
+	return newFuncOption(func(o *options) {
+		if v > 0 {
+			o.timeout = v
+		}
+	})
+}
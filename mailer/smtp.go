@@ -0,0 +1,90 @@
+package mailer
+
+import (
+	"crypto/tls"
+	"net"
+	"net/smtp"
+	"time"
+
+	"github.com/antklim/go-invoice/invoice"
+	"github.com/pkg/errors"
+)
+
+// SMTP sends emails to the SMTP server. The connection is upgraded with
+// STARTTLS when the server supports it. Every email is sent over a new
+// connection.
+type SMTP struct {
+	addr string
+	host string
+	from string
+	opts options
+}
+
+var _ invoice.Mailer = (*SMTP)(nil)
+
+// NewSMTP creates the mailer sending emails from the address to the server at
+// addr (host:port).
+func NewSMTP(addr, from string, opts ...Option) (*SMTP, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid SMTP server address %q", addr)
+	}
+
+	mopts := defaultOptions
+	for _, o := range opts {
+		o.apply(&mopts)
+	}
+
+	return &SMTP{addr: addr, host: host, from: from, opts: mopts}, nil
+}
+
+func (s *SMTP) Send(e invoice.Email) error {
+	msg, err := message(s.from, e, time.Now())
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.DialTimeout("tcp", s.addr, s.opts.timeout)
+	if err != nil {
+		return errors.Wrap(err, "connect to SMTP server failed")
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(s.opts.timeout)); err != nil {
+		return err
+	}
+
+	c, err := smtp.NewClient(conn, s.host)
+	if err != nil {
+		return errors.Wrap(err, "SMTP handshake failed")
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: s.host}); err != nil { // nolint:gosec
+			return errors.Wrap(err, "SMTP STARTTLS failed")
+		}
+	}
+	if s.opts.username != "" {
+		if err := c.Auth(smtp.PlainAuth("", s.opts.username, s.opts.password, s.host)); err != nil {
+			return errors.Wrap(err, "SMTP authentication failed")
+		}
+	}
+
+	if err := c.Mail(s.from); err != nil {
+		return errors.Wrap(err, "SMTP MAIL failed")
+	}
+	if err := c.Rcpt(e.To); err != nil {
+		return errors.Wrap(err, "SMTP RCPT failed")
+	}
+	w, err := c.Data()
+	if err != nil {
+		return errors.Wrap(err, "SMTP DATA failed")
+	}
+	if _, err := w.Write(msg); err != nil {
+		return errors.Wrap(err, "SMTP DATA failed")
+	}
+	if err := w.Close(); err != nil {
+		return errors.Wrap(err, "SMTP DATA failed")
+	}
+	return c.Quit()
+}
@@ -4,31 +4,132 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"syscall"
+	"text/tabwriter"
+	"time"
 
 	"github.com/antklim/go-invoice/cli"
 	"github.com/antklim/go-invoice/invoice"
+	"github.com/antklim/go-invoice/mailer"
 	"github.com/antklim/go-invoice/storage"
+	"github.com/antklim/go-invoice/storage/cache"
+	"github.com/antklim/go-invoice/storage/dynamo"
+	"github.com/antklim/go-invoice/storage/encrypt"
+	"github.com/antklim/go-invoice/storage/memory"
 )
 
 var (
 	storageType string
 	tableName   string
 	awsEndpoint string
+	dsn         string
+	boltPath    string
+	backupDir   string
+	walDir      string
+	walSync     string
+	shards      int
+	keyFile     string
+	erasureLog  string
+	cacheSize   int
+	cacheTTL    time.Duration
+	legacyTable string
+	maxAttempts int
+
+	schedulerState  string
+	jitter          time.Duration
+	issueSchedule   string
+	overdueSchedule string
+	staleSchedule   string
+	dunningSchedule string
+	paymentTerms    time.Duration
+	staleAfter      time.Duration
+	dunningLadder   string
+	noticesFile     string
+
+	mailerType       string
+	smtpAddr         string
+	smtpUser         string
+	mailFrom         string
+	maildir          string
+	addressBook      string
+	deliverSchedule  string
+	deliveryAttempts int
+
+	webhooksState string
+	relayInterval time.Duration
+
+	httpAddr string
 )
 
 func initFlags() {
-	flag.StringVar(&storageType, "storage", "memory", "Storage to where to save invoices [memory|dynamo]")
+	flag.StringVar(&storageType, "storage", "memory", "Storage to where to save invoices [memory|dynamo|sqlite|bolt]")
 	flag.StringVar(&tableName, "table", "invoices", "Storage table name")
 	flag.StringVar(&awsEndpoint, "endpoint", "", "Custom AWS endpoint to connect to DynamoDB")
+	flag.StringVar(&dsn, "dsn", "invoices.db", "SQLite database file name or URI")
+	flag.StringVar(&boltPath, "path", "invoices.bolt", "bbolt database file path")
+	flag.StringVar(&backupDir, "backup-dir", "",
+		"Directory of the memory storage CSV backup restored on start and written on exit, backup disabled when empty")
+	flag.StringVar(&walDir, "wal-dir", "",
+		"Directory of the memory storage write-ahead log and snapshots, storage is not durable when empty")
+	flag.StringVar(&walSync, "wal-sync", memory.SyncAlways.String(), "Write-ahead log sync policy [always|interval|never]")
+	flag.IntVar(&shards, "shards", 0, "Number of the memory storage shards, storage is not sharded when zero")
+	flag.StringVar(&keyFile, "encryption-keys", "",
+		"JSON file of the keys encrypting customer personal data, data is not encrypted when empty")
+	flag.StringVar(&erasureLog, "erasure-log", "", "File to append customer personal data erasures to, erasures not logged when empty")
+	flag.IntVar(&cacheSize, "cache-size", 0, "Maximum number of invoices cached in memory, cache disabled when zero")
+	flag.DurationVar(&cacheTTL, "cache-ttl", time.Minute, "How long invoice stays in cache, zero means forever")
+	flag.IntVar(&maxAttempts, "max-attempts", dynamo.DefaultRetryPolicy.MaxAttempts,
+		"Maximum number of attempts of DynamoDB calls failed with transient errors")
+	flag.StringVar(&legacyTable, "migrate-legacy-table", "", "DynamoDB table of the legacy layout to migrate invoices from on start")
+	flag.StringVar(&schedulerState, "scheduler-state", "",
+		"File to keep the last runs of scheduled jobs in, they are kept in memory when empty")
+	flag.DurationVar(&jitter, "jitter", 30*time.Second, "Maximum random delay of scheduled job runs") // nolint:gomnd
+	flag.StringVar(&issueSchedule, "issue-schedule", "",
+		"Schedule of issuing invoices scheduled to be issued, e.g. \"*/5 * * * *\", job disabled when empty")
+	flag.StringVar(&overdueSchedule, "overdue-schedule", "",
+		"Schedule of marking overdue invoices not paid within payment terms, e.g. @hourly, job disabled when empty")
+	flag.StringVar(&staleSchedule, "stale-schedule", "",
+		"Schedule of canceling open invoices not updated within -stale-after, e.g. @daily, job disabled when empty")
+	flag.StringVar(&dunningSchedule, "dunning-schedule", "",
+		"Schedule of sending dunning notices of unpaid invoices, e.g. \"0 9 * * *\", job disabled when empty")
+	flag.DurationVar(&paymentTerms, "payment-terms", invoice.DefaultPaymentTerms, "Time the issued invoice should be paid within")
+	flag.DurationVar(&staleAfter, "stale-after", 90*24*time.Hour, "Time after which not updated open invoice is stale") // nolint:gomnd
+	flag.StringVar(&dunningLadder, "dunning-ladder", invoice.DefaultDunningLadder,
+		"Dunning notices as name@offset from due date, the collections step escalates invoice to collections")
+	flag.StringVar(&noticesFile, "notices", "", "File to append sent dunning notices to, stdout when empty or -")
+	flag.StringVar(&mailerType, "mailer", "", "Mailer delivering issued invoices [smtp|maildir], invoices not delivered when empty")
+	flag.StringVar(&smtpAddr, "smtp-addr", "localhost:25", "SMTP server host:port")
+	flag.StringVar(&smtpUser, "smtp-user", "", "SMTP user name, the password is read from SMTP_PASSWORD environment variable")
+	flag.StringVar(&mailFrom, "mail-from", "invoices@localhost", "Sender address of invoice emails")
+	flag.StringVar(&maildir, "maildir", "mail", "Maildir directory the maildir mailer writes emails to")
+	flag.StringVar(&addressBook, "address-book", "addresses.json", "JSON file of customer names to email addresses")
+	flag.StringVar(&deliverSchedule, "deliver-schedule", "* * * * *",
+		"Schedule of retrying failed invoice deliveries, job disabled when empty")
+	flag.IntVar(&deliveryAttempts, "delivery-attempts", invoice.DefaultDeliveryPolicy.MaxAttempts,
+		"Maximum number of failed invoice delivery attempts in a row")
+	flag.StringVar(&webhooksState, "webhooks-state", "",
+		"File to keep webhook endpoints, pending deliveries and dead letters in, they are kept in memory when empty")
+	flag.DurationVar(&relayInterval, "relay-interval", time.Second,
+		"How often events of the storage outbox are relayed to webhooks, used by storages with the outbox")
+	flag.StringVar(&httpAddr, "http-addr", ":8080", "Address the http command serves the REST API on")
 	flag.Parse()
 }
 
-func initCli(exit chan<- struct{}, svc *invoice.Service) *cli.Cli {
+// backuper is implemented by storages that can backup and restore invoices.
+type backuper interface {
+	Backup(dir string) error
+	Restore(dir string) error
+}
+
+// initCli registers the commands. The onRestore is called after invoices are
+// restored from a backup.
+func initCli(exit chan<- struct{}, svc *invoice.Service, strg invoice.Storage, onRestore func()) *cli.Cli {
 	if exit == nil {
 		panic("cli: nil exit channel")
 	}
@@ -41,30 +142,206 @@ func initCli(exit chan<- struct{}, svc *invoice.Service) *cli.Cli {
 	c.Handle("view", "View invoice.", viewHandler(svc))
 	c.Handle("issue", "Issue invoice.", issueHandler(svc))
 	c.Handle("pay", "Pay invoice.", payHandler(svc))
+	c.Handle("deliver", "Send issued invoice email now.", deliverHandler(svc))
 	c.Handle("cancel", "Cancel invoice.", cancelHandler(svc))
+	c.Handle("dispute", "Dispute invoice, dunning is suspended.", disputeHandler(svc))
+	c.Handle("resolve-dispute", "Resolve invoice dispute, dunning continues.", resolveDisputeHandler(svc))
 	c.Handle("add-item", "Add invoice item.", addItemHandler(svc))
 	c.Handle("delete-item", "Delete invoice item.", deleteItemHandler(svc))
 	c.Handle("update-customer", "Update invoice customer.", updateCustomerHandler(svc))
+	c.Handle("schedule-issue", "Schedule invoice issue.", scheduleIssueHandler(svc))
+	c.Handle("bulk-issue", "Issue invoices by IDs or filter.", bulkHandler("issue", svc.IssueInvoices))
+	c.Handle("bulk-pay", "Pay invoices by IDs or filter.", bulkHandler("pay", svc.PayInvoices))
+	c.Handle("bulk-cancel", "Cancel invoices by IDs or filter.", bulkHandler("cancel", svc.CancelInvoices))
+	c.Handle("split", "Move invoice items to a new invoice.", splitHandler(svc))
+	c.Handle("merge", "Move items of invoices to a new invoice and cancel them.", mergeHandler(svc))
+	c.Handle("anonymize-customer", "Redact customer personal data from all customer invoices.", anonymizeCustomerHandler(svc))
+	if b, ok := strg.(backuper); ok {
+		c.Handle("backup", "Backup invoices to CSV files in directory.", backupHandler(b))
+		c.Handle("restore", "Restore invoices from CSV files in directory.", restoreHandler(b, onRestore))
+	}
 	return c
 }
 
-func initService() *invoice.Service {
+func initStorage() invoice.Storage {
 	var f invoice.StorageFactory
 	switch storageType {
 	case "memory":
-		f = new(storage.Memory)
+		if shards > 0 {
+			if walDir != "" || backupDir != "" {
+				panic("svc: sharded memory storage is not durable and does not support backups")
+			}
+			f = storage.NewShardedMemory(shards)
+			break
+		}
+		if walDir == "" {
+			f = new(storage.Memory)
+			break
+		}
+		policy, err := memory.ParseSyncPolicy(walSync)
+		if err != nil {
+			panic("svc: " + err.Error())
+		}
+		f = storage.NewDurableMemory(walDir, memory.WithSyncPolicy(policy))
 	case "dynamo":
-		f = storage.NewDynamo(tableName, storage.WithEndpoint(awsEndpoint))
+		retryPolicy := dynamo.DefaultRetryPolicy
+		retryPolicy.MaxAttempts = maxAttempts
+		f = storage.NewDynamo(tableName,
+			storage.WithEndpoint(awsEndpoint),
+			storage.WithRetryPolicy(retryPolicy))
+	case "sqlite":
+		f = storage.NewSQLite(dsn)
+	case "bolt":
+		f = storage.NewBolt(boltPath)
 	default:
 		panic("svc: unknown storage " + storageType)
 	}
 
 	strg := f.MakeStorage()
-	return invoice.New(strg)
+	if legacyTable != "" {
+		migrateLegacyTable(strg)
+	}
+	if backupDir != "" {
+		restoreBackup(strg)
+	}
+	return strg
+}
+
+// initEncryption wraps the storage with the encryption of personal data when
+// the key file set. It returns nil encrypting storage when encryption disabled.
+func initEncryption(strg invoice.Storage) (invoice.Storage, *encrypt.Encrypt) {
+	if keyFile == "" {
+		return strg, nil
+	}
+
+	keys, err := encrypt.LoadKeyFile(keyFile)
+	if err != nil {
+		panic("svc: " + err.Error())
+	}
+	enc := encrypt.New(strg, keys)
+	return enc, enc
+}
+
+// initCache wraps the storage with the cache when cache enabled. It returns
+// the storage to use and the function that purges the cache.
+func initCache(strg invoice.Storage) (invoice.Storage, func()) {
+	if cacheSize <= 0 {
+		return strg, func() {}
+	}
+
+	c := cache.New(strg, cache.WithSize(cacheSize), cache.WithTTL(cacheTTL))
+	return c, c.Purge
+}
+
+// openErasureLog opens the erasure log file for appending. It returns nil when
+// the file is not set.
+func openErasureLog() *os.File {
+	if erasureLog == "" {
+		return nil
+	}
+
+	f, err := os.OpenFile(erasureLog, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		panic("svc: open erasure log failed: " + err.Error())
+	}
+	return f
+}
+
+// openNotices opens the dunning notices file for appending, or returns stdout
+// when the file is not set.
+func openNotices() *os.File {
+	if noticesFile == "" || noticesFile == "-" {
+		return os.Stdout
+	}
+
+	f, err := os.OpenFile(noticesFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		panic("svc: open notices failed: " + err.Error())
+	}
+	return f
+}
+
+// initMailer returns the service options of the invoice delivery, none when
+// the mailer is not set.
+func initMailer() []invoice.Option {
+	var m invoice.Mailer
+	var err error
+	switch mailerType {
+	case "":
+		return nil
+	case "smtp":
+		var opts []mailer.Option
+		if smtpUser != "" {
+			opts = append(opts, mailer.WithAuth(smtpUser, os.Getenv("SMTP_PASSWORD")))
+		}
+		m, err = mailer.NewSMTP(smtpAddr, mailFrom, opts...)
+	case "maildir":
+		m, err = mailer.NewMaildir(maildir, mailFrom)
+	default:
+		panic("svc: unknown mailer " + mailerType)
+	}
+	if err != nil {
+		panic("svc: " + err.Error())
+	}
+
+	book, err := mailer.LoadAddressBook(addressBook)
+	if err != nil {
+		panic("svc: " + err.Error())
+	}
+
+	policy := invoice.DefaultDeliveryPolicy
+	policy.MaxAttempts = deliveryAttempts
+	return []invoice.Option{invoice.WithMailer(m, book), invoice.WithDeliveryPolicy(policy)}
+}
+
+// restoreBackup restores invoices from the backup directory when the backup
+// exists.
+func restoreBackup(strg invoice.Storage) {
+	b, ok := strg.(backuper)
+	if !ok {
+		panic("svc: backup not supported by " + storageType + " storage")
+	}
+
+	if _, err := os.Stat(filepath.Join(backupDir, memory.InvoicesFile)); os.IsNotExist(err) {
+		return
+	}
+	if err := b.Restore(backupDir); err != nil {
+		panic("svc: restore backup failed: " + err.Error())
+	}
+	fmt.Printf("invoices restored from %q\n", backupDir)
+}
+
+// writeBackup writes invoices to the backup directory on exit.
+func writeBackup(strg invoice.Storage) {
+	if err := strg.(backuper).Backup(backupDir); err != nil {
+		fmt.Printf("backup failed: %v\n", err)
+		return
+	}
+	fmt.Printf("invoices backed up to %q\n", backupDir)
+}
+
+// migrateLegacyTable copies invoices from the legacy layout DynamoDB table to
+// the storage table.
+func migrateLegacyTable(strg invoice.Storage) {
+	m, ok := strg.(interface {
+		MigrateLegacyTable(string) (int, error)
+	})
+	if !ok {
+		panic("svc: legacy table migration not supported by " + storageType + " storage")
+	}
+
+	n, err := m.MigrateLegacyTable(legacyTable)
+	if err != nil {
+		panic("svc: legacy table migration failed: " + err.Error())
+	}
+	fmt.Printf("%d invoice(s) migrated from %q table\n", n, legacyTable)
 }
 
 func main() {
 	initFlags()
+	if flag.Arg(0) == "migrate" {
+		os.Exit(runMigrate(flag.Args()[1:]))
+	}
 
 	fmt.Println("Welcome to go-invoice.")
 
@@ -72,16 +349,64 @@ func main() {
 	osSignals := make(chan os.Signal, 1)
 	signal.Notify(osSignals, syscall.SIGINT, syscall.SIGTERM)
 
-	svc := initService()
-
-	c := initCli(exit, svc)
-	go c.Run()
+	strg := initStorage()
+	svcStrg, enc := initEncryption(strg)
+	svcStrg, purgeCache := initCache(svcStrg)
+	svcOpts := initMailer()
+	if erasures := openErasureLog(); erasures != nil {
+		defer erasures.Close()
+		svcOpts = append(svcOpts, invoice.WithErasureLog(invoice.NewErasureLogWriter(erasures)))
+	}
+	notices := openNotices()
+	if notices != os.Stdout {
+		defer notices.Close()
+	}
+	hooks := initWebhooks()
+	publishing, stopRelay := initPublishing(strg, svcStrg, hooks)
+	svc := invoice.New(svcStrg, append(svcOpts,
+		invoice.WithNotifier(invoice.NewNoticeWriter(notices)),
+		publishing)...)
+	sched := initScheduler(svc)
+
+	var srv *http.Server
+	if flag.Arg(0) == "http" {
+		srv = startHTTP(svc, exit)
+	} else {
+		c := initCli(exit, svc, strg, purgeCache)
+		if enc != nil {
+			c.Handle("rotate-keys", "Re-encrypt invoices with the current encryption key.", rotateKeysHandler(enc))
+		}
+		c.Handle("jobs", "List scheduled jobs.", jobsHandler(sched))
+		c.Handle("run-job", "Run scheduled job now.", runJobHandler(sched))
+		c.Handle("webhook-add", "Add webhook endpoint URL receiving the listed events, all when none.", webhookAddHandler(hooks))
+		c.Handle("webhook-list", "List webhook endpoints.", webhookListHandler(hooks))
+		c.Handle("webhook-remove", "Remove webhook endpoint and its pending deliveries.", webhookRemoveHandler(hooks))
+		c.Handle("webhook-dead", "List webhook deliveries failed all attempts.", webhookDeadHandler(hooks))
+		c.Handle("webhook-redeliver", "Queue webhook dead letter for delivery again.", webhookRedeliverHandler(hooks))
+		go c.Run()
+	}
 
 	select {
 	case <-osSignals:
 		fmt.Println()
 	case <-exit:
 	}
+
+	if srv != nil {
+		stopHTTP(srv)
+	}
+	stopScheduler(sched)
+	stopRelay()
+	stopWebhooks(hooks)
+
+	if backupDir != "" {
+		writeBackup(strg)
+	}
+	if c, ok := svcStrg.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			fmt.Printf("close storage failed: %v\n", err)
+		}
+	}
 	fmt.Println("\nBye!")
 }
 
@@ -121,6 +446,9 @@ func viewHandler(svc *invoice.Service) cli.RunnerFunc {
 		}
 
 		fmt.Fprintf(out, "%#v\n", inv)
+		if inv.Delivery != nil {
+			fmt.Fprintf(out, "delivery: %+v\n", *inv.Delivery)
+		}
 	}
 }
 
@@ -178,6 +506,60 @@ func cancelHandler(svc *invoice.Service) cli.RunnerFunc {
 	}
 }
 
+func deliverHandler(svc *invoice.Service) cli.RunnerFunc {
+	return func(out io.Writer, args ...string) {
+		if len(args) == 0 || args[0] == "" {
+			fmt.Fprint(out, "deliver invoice failed: missing invoice ID\n")
+			return
+		}
+
+		invID := strings.TrimSpace(args[0])
+		err := svc.DeliverInvoice(invID)
+		if err != nil {
+			fmt.Fprintf(out, "deliver invoice failed: %v\n", err)
+			return
+		}
+
+		fmt.Fprintf(out, "%q invoice successfully delivered\n", invID)
+	}
+}
+
+func disputeHandler(svc *invoice.Service) cli.RunnerFunc {
+	return func(out io.Writer, args ...string) {
+		if len(args) == 0 || args[0] == "" {
+			fmt.Fprint(out, "dispute invoice failed: missing invoice ID\n")
+			return
+		}
+
+		invID := strings.TrimSpace(args[0])
+		err := svc.DisputeInvoice(invID)
+		if err != nil {
+			fmt.Fprintf(out, "dispute invoice failed: %v\n", err)
+			return
+		}
+
+		fmt.Fprintf(out, "%q invoice successfully disputed\n", invID)
+	}
+}
+
+func resolveDisputeHandler(svc *invoice.Service) cli.RunnerFunc {
+	return func(out io.Writer, args ...string) {
+		if len(args) == 0 || args[0] == "" {
+			fmt.Fprint(out, "resolve invoice dispute failed: missing invoice ID\n")
+			return
+		}
+
+		invID := strings.TrimSpace(args[0])
+		err := svc.ResolveInvoiceDispute(invID)
+		if err != nil {
+			fmt.Fprintf(out, "resolve invoice dispute failed: %v\n", err)
+			return
+		}
+
+		fmt.Fprintf(out, "%q invoice dispute successfully resolved\n", invID)
+	}
+}
+
 func addItemHandler(svc *invoice.Service) cli.RunnerFunc {
 	return func(out io.Writer, args ...string) {
 		if len(args) < 4 || args[0] == "" || args[1] == "" || args[2] == "" || args[3] == "" {
@@ -243,3 +625,261 @@ func updateCustomerHandler(svc *invoice.Service) cli.RunnerFunc {
 		fmt.Fprintf(out, "%q invoice customer successfully updated\n", invID)
 	}
 }
+
+type bulkFunc func(invoice.Selection, ...invoice.BulkOption) (invoice.BulkReport, error)
+
+// bulkHandler runs the bulk operation and prints the summary table. Arguments
+// are invoice IDs or filters "status=<status>" and "customer=<name>", and
+// optional "--dry-run" to preview the operation.
+func bulkHandler(action string, run bulkFunc) cli.RunnerFunc {
+	return func(out io.Writer, args ...string) {
+		sel, opts, err := parseBulkArgs(args)
+		if err != nil {
+			fmt.Fprintf(out, "bulk %s failed: %v\n", action, err)
+			return
+		}
+
+		report, err := run(sel, opts...)
+		if err != nil {
+			fmt.Fprintf(out, "bulk %s failed: %v\n", action, err)
+			return
+		}
+
+		printBulkReport(out, action, report)
+	}
+}
+
+func parseBulkArgs(args []string) (invoice.Selection, []invoice.BulkOption, error) {
+	var (
+		ids       []string
+		filter    invoice.Filter
+		filtered  bool
+		opts      []invoice.BulkOption
+		selection invoice.Selection
+	)
+	for _, arg := range args {
+		arg = strings.TrimSpace(arg)
+		switch {
+		case arg == "":
+		case arg == "--dry-run":
+			opts = append(opts, invoice.WithDryRun())
+		case strings.HasPrefix(arg, "status="):
+			status, err := invoice.ParseStatus(strings.TrimPrefix(arg, "status="))
+			if err != nil {
+				return selection, nil, err
+			}
+			filter.Status, filtered = &status, true
+		case strings.HasPrefix(arg, "customer="):
+			filter.Customer, filtered = strings.TrimPrefix(arg, "customer="), true
+		default:
+			ids = append(ids, arg)
+		}
+	}
+
+	switch {
+	case filtered && len(ids) > 0:
+		return selection, nil, fmt.Errorf("invoice IDs and filters cannot be combined")
+	case filtered:
+		selection = invoice.SelectMatching(filter)
+	case len(ids) > 0:
+		selection = invoice.SelectIDs(ids...)
+	default:
+		return selection, nil, fmt.Errorf("missing invoice IDs or filters")
+	}
+	return selection, opts, nil
+}
+
+func printBulkReport(out io.Writer, action string, report invoice.BulkReport) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0) // nolint:gomnd
+	fmt.Fprintln(w, "INVOICE\tRESULT\tDETAILS")
+	for _, res := range report.Results {
+		var details string
+		if res.Err != nil {
+			details = res.Err.Error()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", res.ID, res.Status, details)
+	}
+	w.Flush()
+
+	var dryRun string
+	if report.DryRun {
+		dryRun = " (dry run, nothing changed)"
+	}
+	fmt.Fprintf(out, "bulk %s of %d invoice(s): %d succeeded, %d skipped, %d failed%s\n", action, len(report.Results),
+		report.Count(invoice.BulkSucceeded), report.Count(invoice.BulkSkipped), report.Count(invoice.BulkFailed), dryRun)
+}
+
+// splitHandler moves items to a new invoice. Every item argument is the item ID
+// followed by the quantity to move, "<item ID>:<qty>", the whole item moved
+// when the quantity is omitted.
+func splitHandler(svc *invoice.Service) cli.RunnerFunc {
+	return func(out io.Writer, args ...string) {
+		if len(args) < 2 || args[0] == "" || args[1] == "" {
+			fmt.Fprint(out, "split invoice failed: missing invoice ID and/or items\n")
+			return
+		}
+
+		invID := strings.TrimSpace(args[0])
+		inv, err := svc.ViewInvoice(invID)
+		if err != nil {
+			fmt.Fprintf(out, "split invoice failed: %v\n", err)
+			return
+		}
+		if inv == nil {
+			fmt.Fprintf(out, "%q invoice not found\n", invID)
+			return
+		}
+
+		qtys := make(map[string]int)
+		for _, arg := range args[1:] {
+			itemID, qty, err := parseSplitItem(inv, strings.TrimSpace(arg))
+			if err != nil {
+				fmt.Fprintf(out, "split invoice failed: %v\n", err)
+				return
+			}
+			qtys[itemID] = qty
+		}
+
+		part, err := svc.SplitInvoice(invID, qtys)
+		if err != nil {
+			fmt.Fprintf(out, "split invoice failed: %v\n", err)
+			return
+		}
+
+		fmt.Fprintf(out, "%d item(s) of invoice %q successfully moved to invoice %q\n", len(part.Items), invID, part.ID)
+	}
+}
+
+// parseSplitItem parses "<item ID>[:<qty>]" argument, the quantity of the
+// invoice item used when omitted.
+func parseSplitItem(inv *invoice.Invoice, arg string) (string, int, error) {
+	itemID, qtyArg := arg, ""
+	if i := strings.LastIndex(arg, ":"); i != -1 {
+		itemID, qtyArg = arg[:i], arg[i+1:]
+	}
+
+	if qtyArg != "" {
+		qty, err := strconv.Atoi(qtyArg)
+		if err != nil {
+			return "", 0, fmt.Errorf("invalid item %q qty argument: %v", itemID, err)
+		}
+		return itemID, qty, nil
+	}
+
+	for _, item := range inv.Items {
+		if item.ID == itemID {
+			return itemID, item.Qty, nil
+		}
+	}
+	return "", 0, fmt.Errorf("item %q not found", itemID)
+}
+
+func mergeHandler(svc *invoice.Service) cli.RunnerFunc {
+	return func(out io.Writer, args ...string) {
+		var ids []string
+		for _, arg := range args {
+			if id := strings.TrimSpace(arg); id != "" {
+				ids = append(ids, id)
+			}
+		}
+		if len(ids) < 2 { // nolint:gomnd
+			fmt.Fprint(out, "merge invoices failed: at least two invoice IDs required\n")
+			return
+		}
+
+		merged, err := svc.MergeInvoices(ids)
+		if err != nil {
+			fmt.Fprintf(out, "merge invoices failed: %v\n", err)
+			return
+		}
+
+		fmt.Fprintf(out, "%d invoice(s) successfully merged to invoice %q\n", len(ids), merged.ID)
+	}
+}
+
+// anonymizeCustomerHandler shows the number of the customer invoices, and
+// anonymizes them only when the command repeated with "confirm" argument.
+func anonymizeCustomerHandler(svc *invoice.Service) cli.RunnerFunc {
+	return func(out io.Writer, args ...string) {
+		if len(args) == 0 || args[0] == "" {
+			fmt.Fprint(out, "anonymize customer failed: missing customer name\n")
+			return
+		}
+
+		name := strings.TrimSpace(args[0])
+		if len(args) < 2 || strings.TrimSpace(args[1]) != "confirm" {
+			invs, err := svc.FindCustomerInvoices(name)
+			if err != nil {
+				fmt.Fprintf(out, "anonymize customer failed: %v\n", err)
+				return
+			}
+			if len(invs) == 0 {
+				fmt.Fprintf(out, "no invoices of %q customer found\n", name)
+				return
+			}
+
+			fmt.Fprintf(out, "%d invoice(s) of %q customer found, personal data will be erased permanently\n", len(invs), name)
+			fmt.Fprintf(out, "to confirm run: anonymize-customer %s,confirm\n", name)
+			return
+		}
+
+		erasure, err := svc.AnonymizeCustomer(name)
+		if err != nil {
+			fmt.Fprintf(out, "anonymize customer failed after %d invoice(s) anonymized: %v\n", len(erasure.InvoiceIDs), err)
+			return
+		}
+		if len(erasure.InvoiceIDs) == 0 {
+			fmt.Fprintf(out, "no invoices of %q customer found\n", name)
+			return
+		}
+
+		fmt.Fprintf(out, "%d invoice(s) successfully anonymized, erasure %q logged\n", len(erasure.InvoiceIDs), erasure.ID)
+	}
+}
+
+func backupHandler(b backuper) cli.RunnerFunc {
+	return func(out io.Writer, args ...string) {
+		if len(args) == 0 || args[0] == "" {
+			fmt.Fprint(out, "backup failed: missing backup directory\n")
+			return
+		}
+
+		dir := strings.TrimSpace(args[0])
+		if err := b.Backup(dir); err != nil {
+			fmt.Fprintf(out, "backup failed: %v\n", err)
+			return
+		}
+
+		fmt.Fprintf(out, "invoices successfully backed up to %q\n", dir)
+	}
+}
+
+func restoreHandler(b backuper, onRestore func()) cli.RunnerFunc {
+	return func(out io.Writer, args ...string) {
+		if len(args) == 0 || args[0] == "" {
+			fmt.Fprint(out, "restore failed: missing backup directory\n")
+			return
+		}
+
+		dir := strings.TrimSpace(args[0])
+		if err := b.Restore(dir); err != nil {
+			fmt.Fprintf(out, "restore failed: %v\n", err)
+			return
+		}
+		onRestore()
+
+		fmt.Fprintf(out, "invoices successfully restored from %q\n", dir)
+	}
+}
+
+func rotateKeysHandler(enc *encrypt.Encrypt) cli.RunnerFunc {
+	return func(out io.Writer, args ...string) {
+		n, err := enc.Rotate()
+		if err != nil {
+			fmt.Fprintf(out, "rotate keys failed after %d invoice(s) re-encrypted: %v\n", n, err)
+			return
+		}
+
+		fmt.Fprintf(out, "%d invoice(s) successfully re-encrypted\n", n)
+	}
+}
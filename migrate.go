@@ -0,0 +1,157 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/antklim/go-invoice/invoice"
+	"github.com/antklim/go-invoice/storage"
+	"github.com/antklim/go-invoice/storage/dynamo"
+	"github.com/antklim/go-invoice/storage/memory"
+	"github.com/antklim/go-invoice/storage/migrate"
+)
+
+const storageSpecUsage = "memory:<backup-dir>, memory-wal:<wal-dir>, sqlite:<dsn>, bolt:<path> or dynamo:<table>"
+
+// runMigrate runs the migrate mode: it copies all invoices from one storage
+// to another. It returns the process exit code.
+func runMigrate(args []string) int {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	from := fs.String("from", "", "Source storage: "+storageSpecUsage)
+	to := fs.String("to", "", "Target storage: "+storageSpecUsage)
+	batchSize := fs.Int("batch-size", 100, "Number of invoices read from the source storage at once") // nolint:gomnd
+	checkpoint := fs.String("checkpoint", "", "File to save the migration progress to and resume from")
+	dryRun := fs.Bool("dry-run", false, "Read both storages and report what would be copied, without writing")
+	onConflict := fs.String("on-conflict", migrate.ConflictFail.String(),
+		"What to do with invoices existing in the target storage [fail|skip|overwrite]")
+	_ = fs.Parse(args)
+
+	if *from == "" || *to == "" {
+		fmt.Println("migrate failed: both --from and --to storages required")
+		fs.Usage()
+		return 2 // nolint:gomnd
+	}
+	policy, err := migrate.ParseConflictPolicy(*onConflict)
+	if err != nil {
+		fmt.Printf("migrate failed: %v\n", err)
+		return 2 // nolint:gomnd
+	}
+
+	src, closeSrc, err := openMigrateStorage(*from, false)
+	if err != nil {
+		fmt.Printf("migrate failed: source storage: %v\n", err)
+		return 1
+	}
+	defer closeSrc()
+	dst, closeDst, err := openMigrateStorage(*to, !*dryRun)
+	if err != nil {
+		fmt.Printf("migrate failed: target storage: %v\n", err)
+		return 1
+	}
+	defer closeDst()
+
+	r, err := migrate.Run(src, dst,
+		migrate.WithBatchSize(*batchSize),
+		migrate.WithCheckpoint(*checkpoint),
+		migrate.WithDryRun(*dryRun),
+		migrate.WithConflictPolicy(policy))
+	printMigrateReport(r, *dryRun)
+	if err != nil {
+		fmt.Printf("migrate failed: %v\n", err)
+		return 1
+	}
+	if len(r.Missing) > 0 || len(r.Mismatched) > 0 {
+		fmt.Println("migrate failed: verification found differences")
+		return 1
+	}
+	return 0
+}
+
+// openMigrateStorage opens the storage by its spec. The returned function
+// closes the storage. When the storage is written, the memory storage is
+// backed up to its directory on close.
+func openMigrateStorage(spec string, write bool) (invoice.Storage, func(), error) {
+	kind, arg := spec, ""
+	if i := strings.Index(spec, ":"); i >= 0 {
+		kind, arg = spec[:i], spec[i+1:]
+	}
+	if arg == "" {
+		return nil, nil, fmt.Errorf("invalid storage %q, want %s", spec, storageSpecUsage)
+	}
+
+	var f invoice.StorageFactory
+	switch kind {
+	case "memory":
+		return openBackupMemory(arg, write)
+	case "memory-wal":
+		f = storage.NewDurableMemory(arg)
+	case "sqlite":
+		f = storage.NewSQLite(arg)
+	case "bolt":
+		f = storage.NewBolt(arg)
+	case "dynamo":
+		retryPolicy := dynamo.DefaultRetryPolicy
+		retryPolicy.MaxAttempts = maxAttempts
+		f = storage.NewDynamo(arg,
+			storage.WithEndpoint(awsEndpoint),
+			storage.WithRetryPolicy(retryPolicy))
+	default:
+		return nil, nil, fmt.Errorf("unknown storage %q, want %s", kind, storageSpecUsage)
+	}
+
+	strg := f.MakeStorage()
+	return strg, func() {
+		if c, ok := strg.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				fmt.Printf("close storage failed: %v\n", err)
+			}
+		}
+	}, nil
+}
+
+// openBackupMemory makes the memory storage restored from the backup
+// directory when the backup exists.
+func openBackupMemory(dir string, write bool) (invoice.Storage, func(), error) {
+	strg := memory.New()
+	if _, err := os.Stat(filepath.Join(dir, memory.InvoicesFile)); err == nil {
+		if err := strg.Restore(dir); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return strg, func() {
+		if !write {
+			return
+		}
+		if err := strg.Backup(dir); err != nil {
+			fmt.Printf("backup failed: %v\n", err)
+			return
+		}
+		fmt.Printf("invoices backed up to %q\n", dir)
+	}, nil
+}
+
+func printMigrateReport(r migrate.Report, dryRun bool) {
+	if dryRun {
+		fmt.Println("dry-run, nothing written")
+	}
+	if r.Resumed {
+		fmt.Println("migration resumed from checkpoint")
+	}
+	fmt.Printf("read: %d, added: %d, existing: %d, conflicts: %d, overwritten: %d, skipped: %d\n",
+		r.Read, r.Added, r.Existing, r.Conflicts, r.Overwritten, r.Skipped)
+	if dryRun {
+		return
+	}
+	fmt.Printf("verified: %d, missing: %d, mismatched: %d\n", r.Verified, len(r.Missing), len(r.Mismatched))
+	for _, id := range r.Missing {
+		fmt.Printf("missing invoice %q\n", id)
+	}
+	for _, id := range r.Mismatched {
+		fmt.Printf("mismatched invoice %q\n", id)
+	}
+}
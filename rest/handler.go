@@ -0,0 +1,306 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/antklim/go-invoice/invoice"
+)
+
+// Handler serves the invoice service REST API:
+//
+//	POST   /invoices                          create invoice
+//	POST   /invoices/merge                    merge invoices
+//	GET    /invoices/{id}                     view invoice
+//	PATCH  /invoices/{id}/customer            update invoice customer
+//	POST   /invoices/{id}/items               add invoice item
+//	DELETE /invoices/{id}/items/{itemID}      delete invoice item
+//	POST   /invoices/{id}/{action}            issue, pay, cancel, dispute,
+//	                                          resolve-dispute or deliver invoice
+//	POST   /invoices/{id}/schedule-issue      schedule invoice issue
+//	POST   /invoices/{id}/split               move invoice items to new invoice
+//
+// Requests and responses are JSON, failed requests respond with Error.
+type Handler struct {
+	svc  *invoice.Service
+	opts options
+}
+
+// NewHandler creates the REST API handler of the service.
+func NewHandler(svc *invoice.Service, opts ...Option) *Handler {
+	if svc == nil {
+		panic("rest: nil invoice service")
+	}
+
+	hopts := defaultOptions
+	for _, o := range opts {
+		o.apply(&hopts)
+	}
+	return &Handler{svc: svc, opts: hopts}
+}
+
+// action is the invoice status change.
+type action func(svc *invoice.Service, id string) error
+
+var actions = map[string]action{
+	"issue":           (*invoice.Service).IssueInvoice,
+	"pay":             (*invoice.Service).PayInvoice,
+	"cancel":          (*invoice.Service).CancelInvoice,
+	"dispute":         (*invoice.Service).DisputeInvoice,
+	"resolve-dispute": (*invoice.Service).ResolveInvoiceDispute,
+	"deliver":         (*invoice.Service).DeliverInvoice,
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	for _, p := range path {
+		if p == "" {
+			h.fail(w, http.StatusNotFound, "resource not found")
+			return
+		}
+	}
+	if path[0] != "invoices" {
+		h.fail(w, http.StatusNotFound, "resource not found")
+		return
+	}
+
+	switch {
+	case len(path) == 1:
+		h.route(w, r, http.MethodPost, h.createInvoice)
+	case len(path) == 2 && path[1] == "merge" && r.Method == http.MethodPost:
+		h.mergeInvoices(w, r)
+	case len(path) == 2:
+		h.route(w, r, http.MethodGet, func(w http.ResponseWriter, r *http.Request) {
+			h.viewInvoice(w, path[1])
+		})
+	case len(path) == 3 && path[2] == "customer":
+		h.route(w, r, http.MethodPatch, func(w http.ResponseWriter, r *http.Request) {
+			h.updateCustomer(w, r, path[1])
+		})
+	case len(path) == 3 && path[2] == "items":
+		h.route(w, r, http.MethodPost, func(w http.ResponseWriter, r *http.Request) {
+			h.addItem(w, r, path[1])
+		})
+	case len(path) == 4 && path[2] == "items":
+		h.route(w, r, http.MethodDelete, func(w http.ResponseWriter, r *http.Request) {
+			h.deleteItem(w, path[1], path[3])
+		})
+	case len(path) == 3 && path[2] == "schedule-issue":
+		h.route(w, r, http.MethodPost, func(w http.ResponseWriter, r *http.Request) {
+			h.scheduleIssue(w, r, path[1])
+		})
+	case len(path) == 3 && path[2] == "split":
+		h.route(w, r, http.MethodPost, func(w http.ResponseWriter, r *http.Request) {
+			h.splitInvoice(w, r, path[1])
+		})
+	case len(path) == 3 && actions[path[2]] != nil:
+		h.route(w, r, http.MethodPost, func(w http.ResponseWriter, r *http.Request) {
+			h.changeStatus(w, path[1], actions[path[2]])
+		})
+	default:
+		h.fail(w, http.StatusNotFound, "resource not found")
+	}
+}
+
+// route calls the handler when the request method is the method.
+func (h *Handler) route(w http.ResponseWriter, r *http.Request, method string, handler http.HandlerFunc) {
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		h.fail(w, http.StatusMethodNotAllowed, fmt.Sprintf("method %s not allowed", r.Method))
+		return
+	}
+	handler(w, r)
+}
+
+func (h *Handler) createInvoice(w http.ResponseWriter, r *http.Request) {
+	var req CustomerRequest
+	if !h.decode(w, r, &req) {
+		return
+	}
+	name := strings.TrimSpace(req.CustomerName)
+	if name == "" {
+		h.fail(w, http.StatusBadRequest, "missing customer name")
+		return
+	}
+
+	inv, err := h.svc.CreateInvoice(name)
+	if err != nil {
+		h.error(w, err)
+		return
+	}
+	w.Header().Set("Location", "/invoices/"+inv.ID)
+	h.respond(w, http.StatusCreated, newInvoice(&inv))
+}
+
+func (h *Handler) viewInvoice(w http.ResponseWriter, id string) {
+	inv, err := h.svc.ViewInvoice(id)
+	if err != nil {
+		h.error(w, err)
+		return
+	}
+	if inv == nil {
+		h.error(w, &invoice.NotFoundError{ID: id})
+		return
+	}
+	h.respond(w, http.StatusOK, newInvoice(inv))
+}
+
+func (h *Handler) updateCustomer(w http.ResponseWriter, r *http.Request, id string) {
+	var req CustomerRequest
+	if !h.decode(w, r, &req) {
+		return
+	}
+	name := strings.TrimSpace(req.CustomerName)
+	if name == "" {
+		h.fail(w, http.StatusBadRequest, "missing customer name")
+		return
+	}
+
+	if err := h.svc.UpdateInvoiceCustomer(id, name); err != nil {
+		h.error(w, err)
+		return
+	}
+	h.viewInvoice(w, id)
+}
+
+func (h *Handler) addItem(w http.ResponseWriter, r *http.Request, id string) {
+	var req ItemRequest
+	if !h.decode(w, r, &req) {
+		return
+	}
+
+	item, err := h.svc.AddInvoiceItem(id, strings.TrimSpace(req.ProductName), req.Price, req.Qty)
+	if err != nil {
+		h.error(w, err)
+		return
+	}
+	h.respond(w, http.StatusCreated, newItem(item))
+}
+
+func (h *Handler) deleteItem(w http.ResponseWriter, id, itemID string) {
+	if err := h.svc.DeleteInvoiceItem(id, itemID); err != nil {
+		h.error(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) changeStatus(w http.ResponseWriter, id string, change action) {
+	if err := change(h.svc, id); err != nil {
+		h.error(w, err)
+		return
+	}
+	h.viewInvoice(w, id)
+}
+
+func (h *Handler) scheduleIssue(w http.ResponseWriter, r *http.Request, id string) {
+	var req ScheduleRequest
+	if !h.decode(w, r, &req) {
+		return
+	}
+
+	if err := h.svc.ScheduleInvoiceIssue(id, req.IssueAt); err != nil {
+		h.error(w, err)
+		return
+	}
+	h.viewInvoice(w, id)
+}
+
+func (h *Handler) splitInvoice(w http.ResponseWriter, r *http.Request, id string) {
+	var req SplitRequest
+	if !h.decode(w, r, &req) {
+		return
+	}
+
+	part, err := h.svc.SplitInvoice(id, req.Items)
+	if err != nil {
+		h.error(w, err)
+		return
+	}
+	w.Header().Set("Location", "/invoices/"+part.ID)
+	h.respond(w, http.StatusCreated, newInvoice(&part))
+}
+
+func (h *Handler) mergeInvoices(w http.ResponseWriter, r *http.Request) {
+	var req MergeRequest
+	if !h.decode(w, r, &req) {
+		return
+	}
+
+	merged, err := h.svc.MergeInvoices(req.InvoiceIDs)
+	if err != nil {
+		h.error(w, err)
+		return
+	}
+	w.Header().Set("Location", "/invoices/"+merged.ID)
+	h.respond(w, http.StatusCreated, newInvoice(&merged))
+}
+
+// errBodyTooLarge is the message of the error MaxBytesReader returns when the
+// body exceeds the limit.
+const errBodyTooLarge = "http: request body too large"
+
+// decode decodes the JSON request body to v. It responds with the error and
+// returns false when the body is not valid.
+func (h *Handler) decode(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, h.opts.maxBodySize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		if err == io.EOF {
+			h.fail(w, http.StatusBadRequest, "missing request body")
+			return false
+		}
+		if err.Error() == errBodyTooLarge {
+			h.fail(w, http.StatusRequestEntityTooLarge, "request body too large")
+			return false
+		}
+		h.fail(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return false
+	}
+	if dec.More() {
+		h.fail(w, http.StatusBadRequest, "invalid request body: unexpected data after JSON value")
+		return false
+	}
+	return true
+}
+
+// error responds with the status of the service error. Internal errors are
+// logged and not shown to the client.
+func (h *Handler) error(w http.ResponseWriter, err error) {
+	var (
+		notFound      *invoice.NotFoundError
+		status        *invoice.StatusError
+		conflict      *invoice.ConflictError
+		validation    *invoice.ValidationError
+		notConfigured *invoice.NotConfiguredError
+	)
+	switch {
+	case errors.As(err, &notFound):
+		h.fail(w, http.StatusNotFound, err.Error())
+	case errors.As(err, &status), errors.As(err, &conflict):
+		h.fail(w, http.StatusConflict, err.Error())
+	case errors.As(err, &validation):
+		h.fail(w, http.StatusBadRequest, err.Error())
+	case errors.As(err, &notConfigured):
+		h.fail(w, http.StatusNotImplemented, err.Error())
+	default:
+		fmt.Fprintf(h.opts.log, "request failed: %v\n", err)
+		h.fail(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+	}
+}
+
+func (h *Handler) fail(w http.ResponseWriter, code int, msg string) {
+	h.respond(w, code, Error{Error: msg})
+}
+
+func (h *Handler) respond(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		fmt.Fprintf(h.opts.log, "write response failed: %v\n", err)
+	}
+}
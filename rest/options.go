@@ -0,0 +1,50 @@
+package rest
+
+import "io"
+
+// DefaultMaxBodySize is the default maximum size of the request body.
+const DefaultMaxBodySize = 1 << 20
+
+type options struct {
+	log         io.Writer
+	maxBodySize int64
+}
+
+var defaultOptions = options{
+	log:         io.Discard,
+	maxBodySize: DefaultMaxBodySize,
+}
+
+type Option interface {
+	apply(*options)
+}
+
+type funcOption struct {
+	f func(*options)
+}
+
+func (f *funcOption) apply(o *options) {
+	f.f(o)
+}
+
+func newFuncOption(f func(*options)) Option {
+	return &funcOption{f: f}
+}
+
+// WithLog sets where internal errors of requests are written. They are
+// discarded by default.
+func WithLog(v io.Writer) Option {
+	return newFuncOption(func(o *options) {
+		o.log = v
+	})
+}
+
+// WithMaxBodySize sets the maximum size of the request body in bytes.
+// DefaultMaxBodySize is used by default, values less than 1 are ignored.
+func WithMaxBodySize(v int64) Option {
+	return newFuncOption(func(o *options) {
+		if v > 0 {
+			o.maxBodySize = v
+		}
+	})
+}
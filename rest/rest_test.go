@@ -0,0 +1,229 @@
+package rest_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/antklim/go-invoice/invoice"
+	"github.com/antklim/go-invoice/rest"
+	"github.com/antklim/go-invoice/storage/memory"
+	"github.com/antklim/go-invoice/test/mocks"
+)
+
+type client struct {
+	t   *testing.T
+	srv *httptest.Server
+}
+
+func newClient(t *testing.T, svc *invoice.Service, opts ...rest.Option) *client {
+	srv := httptest.NewServer(rest.NewHandler(svc, opts...))
+	t.Cleanup(srv.Close)
+	return &client{t: t, srv: srv}
+}
+
+// do sends the request with the body and decodes the response body to v when
+// it is not nil. It returns the response status code.
+func (c *client) do(method, path, body string, v interface{}) int {
+	c.t.Helper()
+
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+	req, err := http.NewRequest(method, c.srv.URL+path, r)
+	if err != nil {
+		c.t.Fatalf("NewRequest() failed: %v", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		c.t.Fatalf("%s %s failed: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	if v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			c.t.Fatalf("%s %s invalid response body: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func (c *client) mustCreate(name string) rest.Invoice {
+	c.t.Helper()
+
+	var inv rest.Invoice
+	if code := c.do(http.MethodPost, "/invoices", `{"customerName":"`+name+`"}`, &inv); code != http.StatusCreated {
+		c.t.Fatalf("create invoice status %d, want %d", code, http.StatusCreated)
+	}
+	return inv
+}
+
+func (c *client) mustAddItem(id string) rest.Item {
+	c.t.Helper()
+
+	var item rest.Item
+	body := `{"productName":"Pen","price":150,"qty":2}`
+	if code := c.do(http.MethodPost, "/invoices/"+id+"/items", body, &item); code != http.StatusCreated {
+		c.t.Fatalf("add item status %d, want %d", code, http.StatusCreated)
+	}
+	return item
+}
+
+func TestInvoiceLifecycle(t *testing.T) {
+	c := newClient(t, invoice.New(memory.New()))
+
+	inv := c.mustCreate("John Doe")
+	if inv.ID == "" || inv.CustomerName != "John Doe" || inv.Status != "open" || len(inv.Items) != 0 {
+		t.Fatalf("invalid created invoice %+v", inv)
+	}
+
+	var updated rest.Invoice
+	if code := c.do(http.MethodPatch, "/invoices/"+inv.ID+"/customer", `{"customerName":"Jane Doe"}`, &updated); code != http.StatusOK {
+		t.Fatalf("update customer status %d, want %d", code, http.StatusOK)
+	}
+	if updated.CustomerName != "Jane Doe" {
+		t.Errorf("invalid updated invoice %+v", updated)
+	}
+
+	pen := c.mustAddItem(inv.ID)
+	pencil := c.mustAddItem(inv.ID)
+	if code := c.do(http.MethodDelete, "/invoices/"+inv.ID+"/items/"+pencil.ID, "", nil); code != http.StatusNoContent {
+		t.Fatalf("delete item status %d, want %d", code, http.StatusNoContent)
+	}
+
+	for _, action := range []string{"issue", "pay"} {
+		if code := c.do(http.MethodPost, "/invoices/"+inv.ID+"/"+action, "", &updated); code != http.StatusOK {
+			t.Fatalf("%s invoice status %d, want %d", action, code, http.StatusOK)
+		}
+	}
+
+	var viewed rest.Invoice
+	if code := c.do(http.MethodGet, "/invoices/"+inv.ID, "", &viewed); code != http.StatusOK {
+		t.Fatalf("view invoice status %d, want %d", code, http.StatusOK)
+	}
+	if viewed.Status != "paid" || viewed.Date == nil || viewed.Total != 300 ||
+		len(viewed.Items) != 1 || viewed.Items[0].ID != pen.ID {
+		t.Errorf("invalid viewed invoice %+v", viewed)
+	}
+}
+
+func TestSplitMerge(t *testing.T) {
+	c := newClient(t, invoice.New(memory.New()))
+	inv := c.mustCreate("John Doe")
+	item := c.mustAddItem(inv.ID)
+
+	var part rest.Invoice
+	body := `{"items":{"` + item.ID + `":1}}`
+	if code := c.do(http.MethodPost, "/invoices/"+inv.ID+"/split", body, &part); code != http.StatusCreated {
+		t.Fatalf("split invoice status %d, want %d", code, http.StatusCreated)
+	}
+	if len(part.Items) != 1 || part.Items[0].Qty != 1 {
+		t.Errorf("invalid split invoice %+v", part)
+	}
+
+	var merged rest.Invoice
+	body = `{"invoiceIds":["` + inv.ID + `","` + part.ID + `"]}`
+	if code := c.do(http.MethodPost, "/invoices/merge", body, &merged); code != http.StatusCreated {
+		t.Fatalf("merge invoices status %d, want %d", code, http.StatusCreated)
+	}
+	if merged.Total != 300 {
+		t.Errorf("invalid merged invoice %+v", merged)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	c := newClient(t, invoice.New(memory.New()))
+	inv := c.mustCreate("John Doe")
+	if code := c.do(http.MethodPost, "/invoices/"+inv.ID+"/cancel", "", nil); code != http.StatusOK {
+		t.Fatalf("cancel invoice status %d, want %d", code, http.StatusOK)
+	}
+
+	testCases := []struct {
+		desc   string
+		method string
+		path   string
+		body   string
+		code   int
+		err    string
+	}{
+		{"unknown invoice", http.MethodGet, "/invoices/123", "", http.StatusNotFound, `invoice "123" not found`},
+		{"action on unknown invoice", http.MethodPost, "/invoices/123/issue", "", http.StatusNotFound,
+			`invoice "123" not found`},
+		{"not allowed status change", http.MethodPost, "/invoices/" + inv.ID + "/pay", "", http.StatusConflict,
+			`"canceled" invoice cannot be paid`},
+		{"item of canceled invoice", http.MethodPost, "/invoices/" + inv.ID + "/items",
+			`{"productName":"Pen","price":1,"qty":1}`, http.StatusConflict, `item cannot be added to "canceled" invoice`},
+		{"invalid item", http.MethodPost, "/invoices/" + inv.ID + "/items", `{"productName":"","price":0,"qty":1}`,
+			http.StatusBadRequest, "item details not valid: product name cannot be blank, price should be positive"},
+		{"missing customer name", http.MethodPost, "/invoices", `{"customerName":" "}`, http.StatusBadRequest,
+			"missing customer name"},
+		{"missing body", http.MethodPost, "/invoices", "", http.StatusBadRequest, "missing request body"},
+		{"unknown field", http.MethodPost, "/invoices", `{"customer":"John"}`, http.StatusBadRequest,
+			`invalid request body: json: unknown field "customer"`},
+		{"invalid field type", http.MethodPost, "/invoices/" + inv.ID + "/items", `{"price":"1"}`, http.StatusBadRequest,
+			"invalid request body: json: cannot unmarshal string into Go struct field ItemRequest.price of type int"},
+		{"one invoice merged", http.MethodPost, "/invoices/merge", `{"invoiceIds":["1"]}`, http.StatusBadRequest,
+			"at least two invoices should be merged"},
+		{"method not allowed", http.MethodDelete, "/invoices/" + inv.ID, "", http.StatusMethodNotAllowed,
+			"method DELETE not allowed"},
+		{"unknown action", http.MethodPost, "/invoices/" + inv.ID + "/refund", "", http.StatusNotFound, "resource not found"},
+		{"unknown resource", http.MethodGet, "/customers", "", http.StatusNotFound, "resource not found"},
+		{"mailer not configured", http.MethodPost, "/invoices/" + inv.ID + "/deliver", "", http.StatusNotImplemented,
+			"mailer is not configured"},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			var resp rest.Error
+			code := c.do(tC.method, tC.path, tC.body, &resp)
+			if code != tC.code || resp.Error != tC.err {
+				t.Errorf("response %d %q, want %d %q", code, resp.Error, tC.code, tC.err)
+			}
+		})
+	}
+
+	t.Run("body too large", func(t *testing.T) {
+		c := newClient(t, invoice.New(memory.New()), rest.WithMaxBodySize(16))
+
+		var resp rest.Error
+		code := c.do(http.MethodPost, "/invoices", `{"customerName":"John Doe"}`, &resp)
+		if code != http.StatusRequestEntityTooLarge || resp.Error != "request body too large" {
+			t.Errorf("response %d %q, want 413", code, resp.Error)
+		}
+	})
+
+	t.Run("concurrent update", func(t *testing.T) {
+		inv := invoice.NewInvoice("John Doe")
+		strg := mocks.NewStorage(mocks.WithFoundInvoice(&inv),
+			mocks.WithUpdateInvoiceError(&invoice.ConflictError{ID: inv.ID}))
+		c := newClient(t, invoice.New(strg))
+
+		var resp rest.Error
+		code := c.do(http.MethodPatch, "/invoices/"+inv.ID+"/customer", `{"customerName":"Jane Doe"}`, &resp)
+		if want := fmt.Sprintf("invoice %q was updated concurrently", inv.ID); code != http.StatusConflict ||
+			!strings.Contains(resp.Error, want) {
+			t.Errorf("response %d %q, want %d %q", code, resp.Error, http.StatusConflict, want)
+		}
+	})
+
+	t.Run("storage failure", func(t *testing.T) {
+		var log bytes.Buffer
+		strg := mocks.NewStorage(mocks.WithFindInvoiceError(errors.New("connection refused")))
+		c := newClient(t, invoice.New(strg), rest.WithLog(&log))
+
+		var resp rest.Error
+		code := c.do(http.MethodGet, "/invoices/123", "", &resp)
+		if code != http.StatusInternalServerError || resp.Error != "Internal Server Error" {
+			t.Errorf("response %d %q, want 500", code, resp.Error)
+		}
+		if !strings.Contains(log.String(), "connection refused") {
+			t.Errorf("internal error not logged: %q", log.String())
+		}
+	})
+}
@@ -0,0 +1,96 @@
+package rest
+
+import (
+	"time"
+
+	"github.com/antklim/go-invoice/invoice"
+)
+
+// Invoice is the JSON view of the invoice, prices are in cents.
+type Invoice struct {
+	ID           string     `json:"id"`
+	CustomerName string     `json:"customerName"`
+	Status       string     `json:"status"`
+	Date         *time.Time `json:"date,omitempty"`
+	IssueAt      *time.Time `json:"issueAt,omitempty"`
+	Items        []Item     `json:"items"`
+	Total        int        `json:"total"`
+	Origins      []string   `json:"origins,omitempty"`
+	Successors   []string   `json:"successors,omitempty"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	UpdatedAt    time.Time  `json:"updatedAt"`
+}
+
+type Item struct {
+	ID          string    `json:"id"`
+	ProductName string    `json:"productName"`
+	Price       int       `json:"price"`
+	Qty         int       `json:"qty"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// Error is the body of the failed request response.
+type Error struct {
+	Error string `json:"error"`
+}
+
+func newInvoice(inv *invoice.Invoice) Invoice {
+	items := make([]Item, len(inv.Items))
+	for i := range inv.Items {
+		items[i] = newItem(inv.Items[i])
+	}
+
+	return Invoice{
+		ID:           inv.ID,
+		CustomerName: inv.CustomerName,
+		Status:       inv.Status.String(),
+		Date:         inv.Date,
+		IssueAt:      inv.IssueAt,
+		Items:        items,
+		Total:        inv.Total(),
+		Origins:      inv.Origins,
+		Successors:   inv.Successors,
+		CreatedAt:    inv.CreatedAt,
+		UpdatedAt:    inv.UpdatedAt,
+	}
+}
+
+func newItem(item invoice.Item) Item {
+	return Item{
+		ID:          item.ID,
+		ProductName: item.ProductName,
+		Price:       item.Price,
+		Qty:         item.Qty,
+		CreatedAt:   item.CreatedAt,
+	}
+}
+
+// CustomerRequest is the body of the invoice create and customer update
+// requests.
+type CustomerRequest struct {
+	CustomerName string `json:"customerName"`
+}
+
+// ItemRequest is the body of the add item request.
+type ItemRequest struct {
+	ProductName string `json:"productName"`
+	Price       int    `json:"price"`
+	Qty         int    `json:"qty"`
+}
+
+// ScheduleRequest is the body of the schedule issue request, the schedule is
+// cleared when the time is null.
+type ScheduleRequest struct {
+	IssueAt *time.Time `json:"issueAt"`
+}
+
+// SplitRequest is the body of the split request, the quantities to move by
+// item IDs.
+type SplitRequest struct {
+	Items map[string]int `json:"items"`
+}
+
+// MergeRequest is the body of the merge request.
+type MergeRequest struct {
+	InvoiceIDs []string `json:"invoiceIds"`
+}
@@ -0,0 +1,19 @@
+package scheduler
+
+import "time"
+
+// Clock tells the time and waits for it. Scheduler uses the system clock
+// unless another clock set, for example the fake clock in tests.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+// SystemClock is the clock of the operating system.
+var SystemClock Clock = systemClock{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
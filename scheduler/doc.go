@@ -0,0 +1,4 @@
+// Package scheduler runs jobs on cron-like schedules. Every job runs at most
+// once at a time, the last run of every job is kept in the state, so missed
+// runs are caught up after restart.
+package scheduler
@@ -0,0 +1,66 @@
+package scheduler
+
+import (
+	"io"
+	"time"
+)
+
+type options struct {
+	clock  Clock
+	state  State
+	jitter time.Duration
+	log    io.Writer
+}
+
+var defaultOptions = options{
+	clock: SystemClock,
+	log:   io.Discard,
+}
+
+type Option interface {
+	apply(*options)
+}
+
+type funcOption struct {
+	f func(*options)
+}
+
+func (f *funcOption) apply(o *options) {
+	f.f(o)
+}
+
+func newFuncOption(f func(*options)) Option {
+	return &funcOption{f: f}
+}
+
+// WithClock sets the clock the scheduler tells the time by. The system clock
+// is used by default.
+func WithClock(v Clock) Option {
+	return newFuncOption(func(o *options) {
+		o.clock = v
+	})
+}
+
+// WithState sets where the last runs of jobs are kept. By default they are
+// kept in memory and lost on restart.
+func WithState(v State) Option {
+	return newFuncOption(func(o *options) {
+		o.state = v
+	})
+}
+
+// WithJitter sets the maximum random delay of every scheduled run, so jobs of
+// the same schedule do not start at once. Runs are not delayed by default.
+func WithJitter(v time.Duration) Option {
+	return newFuncOption(func(o *options) {
+		o.jitter = v
+	})
+}
+
+// WithLog sets where failed and skipped runs are reported. They are not
+// reported by default.
+func WithLog(v io.Writer) Option {
+	return newFuncOption(func(o *options) {
+		o.log = v
+	})
+}
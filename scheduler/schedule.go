@@ -0,0 +1,216 @@
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Schedule tells when the job runs.
+type Schedule interface {
+	// Next returns the first run time after t, zero time when the job does not
+	// run after t.
+	Next(t time.Time) time.Time
+}
+
+// descriptors are the predefined cron schedules.
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// ParseSchedule parses the schedule spec. The spec is the cron expression of
+// five fields: minute, hour, day of month, month and day of week. Every field
+// is "*" or a list of values and ranges with optional steps, for example
+// "*/15 9-17 * * mon-fri". Predefined schedules "@hourly", "@daily",
+// "@weekly", "@monthly" and "@yearly" are supported, and "@every <duration>"
+// runs the job with the fixed interval, for example "@every 90s". Cron
+// schedules are evaluated in the location of the time they are asked about.
+func ParseSchedule(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(spec, "@every ") {
+		d, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(spec, "@every ")))
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid schedule %q", spec)
+		}
+		if d < time.Second {
+			return nil, fmt.Errorf("invalid schedule %q: interval should be at least 1s", spec)
+		}
+		return every(d), nil
+	}
+	expr := spec
+	if d, ok := descriptors[spec]; ok {
+		expr = d
+	}
+
+	c, err := parseCron(expr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid schedule %q", spec)
+	}
+	return c, nil
+}
+
+type every time.Duration
+
+func (e every) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(e))
+}
+
+// field describes the cron expression field.
+type field struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var (
+	minuteField = field{name: "minute", min: 0, max: 59}
+	hourField   = field{name: "hour", min: 0, max: 23}
+	domField    = field{name: "day of month", min: 1, max: 31}
+	monthField  = field{name: "month", min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	dowField = field{name: "day of week", min: 0, max: 7, names: map[string]int{ // 0 and 7 are Sunday
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+// cron is the schedule of the cron expression. Every field is the bit set of
+// matching values.
+type cron struct {
+	minute, hour, dom, month, dow uint64
+	// When both days of month and days of week are restricted, the day matches
+	// either of them, as in cron.
+	domAny, dowAny bool
+}
+
+func parseCron(expr string) (*cron, error) {
+	parts := strings.Fields(expr)
+	if len(parts) != 5 { // nolint:gomnd
+		return nil, fmt.Errorf("got %d fields, want 5", len(parts))
+	}
+
+	var c cron
+	var err error
+	for i, f := range []struct {
+		field
+		bits *uint64
+	}{
+		{minuteField, &c.minute},
+		{hourField, &c.hour},
+		{domField, &c.dom},
+		{monthField, &c.month},
+		{dowField, &c.dow},
+	} {
+		if *f.bits, err = f.parse(parts[i]); err != nil {
+			return nil, err
+		}
+	}
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	c.domAny, c.dowAny = strings.HasPrefix(parts[2], "*"), strings.HasPrefix(parts[4], "*")
+	return &c, nil
+}
+
+// parse parses the comma separated list of "*", values and ranges, every of
+// them with the optional step, for example "1,10-20/2,*/15".
+func (f field) parse(expr string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(expr, ",") {
+		rng, step := part, 1
+		if i := strings.Index(part, "/"); i != -1 {
+			var err error
+			rng = part[:i]
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid %s step %q", f.name, part[i+1:])
+			}
+		}
+
+		var lo, hi int
+		switch i := strings.Index(rng, "-"); {
+		case rng == "*":
+			lo, hi = f.min, f.max
+		case i != -1:
+			var err error
+			if lo, err = f.value(rng[:i]); err != nil {
+				return 0, err
+			}
+			if hi, err = f.value(rng[i+1:]); err != nil {
+				return 0, err
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("invalid %s range %q", f.name, rng)
+			}
+		default:
+			var err error
+			if lo, err = f.value(rng); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if step > 1 {
+				hi = f.max
+			}
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+func (f field) value(s string) (int, error) {
+	if v, ok := f.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("invalid %s %q, should be from %d to %d", f.name, s, f.min, f.max)
+	}
+	return v, nil
+}
+
+// cronSearchYears limits the search of the next run time, so schedules that
+// never match, for example on February 30th, do not search forever.
+const cronSearchYears = 5
+
+func (c *cron) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(cronSearchYears, 0, 0)
+
+	for t.Before(limit) {
+		switch {
+		case c.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (c *cron) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domAny || c.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
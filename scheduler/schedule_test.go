@@ -0,0 +1,65 @@
+package scheduler_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/antklim/go-invoice/scheduler"
+)
+
+func TestParseSchedule(t *testing.T) {
+	from := time.Date(2021, time.September, 30, 10, 17, 45, 0, time.UTC) // Thursday
+
+	testCases := []struct {
+		spec string
+		want time.Time
+	}{
+		{spec: "* * * * *", want: time.Date(2021, time.September, 30, 10, 18, 0, 0, time.UTC)},
+		{spec: "*/15 * * * *", want: time.Date(2021, time.September, 30, 10, 30, 0, 0, time.UTC)},
+		{spec: "5,20-25/5 * * * *", want: time.Date(2021, time.September, 30, 10, 20, 0, 0, time.UTC)},
+		{spec: "0 9 * * *", want: time.Date(2021, time.October, 1, 9, 0, 0, 0, time.UTC)},
+		{spec: "30 8 * * mon-fri", want: time.Date(2021, time.October, 1, 8, 30, 0, 0, time.UTC)},
+		{spec: "0 0 * * 7", want: time.Date(2021, time.October, 3, 0, 0, 0, 0, time.UTC)},
+		{spec: "0 0 13 * fri", want: time.Date(2021, time.October, 1, 0, 0, 0, 0, time.UTC)},
+		{spec: "0 0 29 feb *", want: time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		{spec: "0 0 30 feb *", want: time.Time{}},
+		{spec: "@hourly", want: time.Date(2021, time.September, 30, 11, 0, 0, 0, time.UTC)},
+		{spec: "@daily", want: time.Date(2021, time.October, 1, 0, 0, 0, 0, time.UTC)},
+		{spec: "@monthly", want: time.Date(2021, time.October, 1, 0, 0, 0, 0, time.UTC)},
+		{spec: "@yearly", want: time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{spec: "@every 90s", want: from.Add(90 * time.Second)},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.spec, func(t *testing.T) {
+			s, err := scheduler.ParseSchedule(tC.spec)
+			if err != nil {
+				t.Fatalf("ParseSchedule(%q) failed: %v", tC.spec, err)
+			}
+			if got := s.Next(from); !got.Equal(tC.want) {
+				t.Errorf("Next(%v) = %v, want %v", from, got, tC.want)
+			}
+		})
+	}
+}
+
+func TestParseScheduleErrors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"10-5 * * * *",
+		"* * * * funday",
+		"@every 1ms",
+		"@every day",
+		"@fortnightly",
+	} {
+		if _, err := scheduler.ParseSchedule(spec); err == nil {
+			t.Errorf("ParseSchedule(%q) expected to fail", spec)
+		}
+	}
+}
@@ -0,0 +1,316 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrRunning is returned when the job is run while it is running.
+	ErrRunning = errors.New("job is already running")
+	// ErrStopped is returned when the job is run after the scheduler stopped.
+	ErrStopped = errors.New("scheduler is stopped")
+)
+
+// Tick tells the job which run it is.
+type Tick struct {
+	At   time.Time // schedule time of the run, the time it was asked for manual runs
+	Last time.Time // schedule time of the previous run, zero when the job has not run
+}
+
+// Job is the work the scheduler runs. It should return soon after ctx is
+// canceled.
+type Job interface {
+	Run(ctx context.Context, t Tick) error
+}
+
+type JobFunc func(ctx context.Context, t Tick) error
+
+func (f JobFunc) Run(ctx context.Context, t Tick) error {
+	return f(ctx, t)
+}
+
+// JobInfo describes the registered job.
+type JobInfo struct {
+	Name    string
+	Spec    string
+	Last    Run       // zero when the job has not run
+	Next    time.Time // zero when the scheduler is not started or the job does not run again
+	Running bool
+}
+
+type job struct {
+	name     string
+	spec     string
+	schedule Schedule
+	job      Job
+	last     Run
+	next     time.Time
+	running  bool
+}
+
+// Scheduler runs the registered jobs on their schedules. Every job runs at
+// most once at a time: the scheduled run is skipped while the job is running,
+// and missed runs are not repeated. The first run after start catches up the
+// run missed while the scheduler was stopped, if any.
+type Scheduler struct {
+	mu      sync.Mutex
+	jobs    []*job
+	byName  map[string]*job
+	started bool
+	stopped bool
+	rnd     *rand.Rand
+
+	stop   chan struct{}
+	ctx    context.Context // canceled when running jobs should return
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	logMu  sync.Mutex
+	opts   options
+}
+
+// New creates the scheduler.
+func New(opts ...Option) *Scheduler {
+	sopts := defaultOptions
+	for _, o := range opts {
+		o.apply(&sopts)
+	}
+	if sopts.state == nil {
+		sopts.state = NewMemoryState()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Scheduler{
+		byName: make(map[string]*job),
+		rnd:    rand.New(rand.NewSource(time.Now().UnixNano())), // nolint:gosec
+		stop:   make(chan struct{}),
+		ctx:    ctx,
+		cancel: cancel,
+		opts:   sopts,
+	}
+}
+
+// Register adds the job run on the schedule spec, see ParseSchedule. Jobs
+// cannot be registered after the scheduler started.
+func (s *Scheduler) Register(name, spec string, j Job) error {
+	if name == "" {
+		return errors.New("blank job name")
+	}
+	if j == nil {
+		return fmt.Errorf("job %q is nil", name)
+	}
+	schedule, err := ParseSchedule(spec)
+	if err != nil {
+		return errors.Wrapf(err, "job %q", name)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started {
+		return fmt.Errorf("job %q registered after scheduler started", name)
+	}
+	if _, ok := s.byName[name]; ok {
+		return fmt.Errorf("job %q registered twice", name)
+	}
+
+	jb := &job{name: name, spec: spec, schedule: schedule, job: j}
+	s.jobs = append(s.jobs, jb)
+	s.byName[name] = jb
+	return nil
+}
+
+// Start reads the last runs from the state and starts running jobs.
+func (s *Scheduler) Start() error {
+	runs, err := s.opts.state.LastRuns()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started || s.stopped {
+		return errors.New("scheduler cannot be started twice")
+	}
+	s.started = true
+
+	now := s.opts.clock.Now()
+	for _, j := range s.jobs {
+		j.last = runs[j.name]
+		if j.last.Scheduled.IsZero() {
+			j.next = j.schedule.Next(now)
+		} else if j.next = j.schedule.Next(j.last.Scheduled); !j.next.IsZero() && j.next.Before(now) {
+			j.next = now // catch up the missed run
+		}
+
+		s.wg.Add(1)
+		go s.loop(j)
+	}
+	return nil
+}
+
+// Stop stops running jobs. It waits for running jobs to finish until ctx is
+// done, then cancels the context of running jobs, waits for them to return and
+// returns ctx error.
+func (s *Scheduler) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return nil
+	}
+	s.stopped = true
+	close(s.stop)
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		s.cancel()
+		return nil
+	case <-ctx.Done():
+		s.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// RunNow runs the job immediately, unless it is running. Job error is reported
+// in the run.
+func (s *Scheduler) RunNow(name string) (Run, error) {
+	s.mu.Lock()
+	j, ok := s.byName[name]
+	if !ok {
+		s.mu.Unlock()
+		return Run{}, fmt.Errorf("job %q not found", name)
+	}
+	if s.stopped {
+		s.mu.Unlock()
+		return Run{}, ErrStopped
+	}
+	s.wg.Add(1)
+	s.mu.Unlock()
+	defer s.wg.Done()
+
+	return s.run(j, s.opts.clock.Now())
+}
+
+// Jobs returns the registered jobs in the order of registration.
+func (s *Scheduler) Jobs() []JobInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	infos := make([]JobInfo, len(s.jobs))
+	for i, j := range s.jobs {
+		infos[i] = JobInfo{Name: j.name, Spec: j.spec, Last: j.last, Next: j.next, Running: j.running}
+	}
+	return infos
+}
+
+// loop runs the job on schedule until the scheduler stops.
+func (s *Scheduler) loop(j *job) {
+	defer s.wg.Done()
+
+	for {
+		s.mu.Lock()
+		at := j.next
+		if at.IsZero() {
+			s.mu.Unlock()
+			return
+		}
+		delay := at.Sub(s.opts.clock.Now()) + s.jitter()
+		s.mu.Unlock()
+
+		select {
+		case <-s.stop:
+			return
+		case <-s.opts.clock.After(delay):
+		}
+		select {
+		case <-s.stop:
+			return
+		default:
+		}
+
+		if _, err := s.run(j, at); err != nil {
+			s.logf("job %q run at %s skipped: %v", j.name, at, err)
+		}
+
+		s.mu.Lock()
+		now := s.opts.clock.Now()
+		if j.next = j.schedule.Next(at); !j.next.IsZero() && j.next.Before(now) {
+			j.next = j.schedule.Next(now) // runs missed while the job was running are skipped
+		}
+		s.mu.Unlock()
+	}
+}
+
+// run runs the job and saves the run to the state. It returns ErrRunning when
+// the job is running.
+func (s *Scheduler) run(j *job, at time.Time) (Run, error) {
+	s.mu.Lock()
+	if j.running {
+		s.mu.Unlock()
+		return Run{}, ErrRunning
+	}
+	j.running = true
+	last := j.last.Scheduled
+	s.mu.Unlock()
+
+	r := Run{Scheduled: at, Started: s.opts.clock.Now()}
+	if err := s.runJob(j, Tick{At: at, Last: last}); err != nil {
+		r.Err = err.Error()
+		s.logf("job %q run at %s failed: %v", j.name, at, err)
+	}
+	r.Finished = s.opts.clock.Now()
+
+	if err := s.opts.state.SaveRun(j.name, r); err != nil {
+		s.logf("job %q run at %s not saved: %v", j.name, at, err)
+	}
+
+	s.mu.Lock()
+	j.running = false
+	j.last = r
+	s.mu.Unlock()
+	return r, nil
+}
+
+// runJob runs the job, a panic of the job is returned as error.
+func (s *Scheduler) runJob(j *job, t Tick) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("job panicked: %v", p)
+		}
+	}()
+	return j.job.Run(s.ctx, t)
+}
+
+// jitter returns the random delay of the scheduled run. It should be called
+// with the lock held.
+func (s *Scheduler) jitter() time.Duration {
+	if s.opts.jitter <= 0 {
+		return 0
+	}
+	return time.Duration(s.rnd.Int63n(int64(s.opts.jitter)))
+}
+
+// logf writes the line to the log, time arguments are formatted as RFC3339.
+func (s *Scheduler) logf(format string, args ...interface{}) {
+	for i, arg := range args {
+		if t, ok := arg.(time.Time); ok {
+			args[i] = t.Format(time.RFC3339)
+		}
+	}
+
+	s.logMu.Lock()
+	defer s.logMu.Unlock()
+	fmt.Fprintf(s.opts.log, format+"\n", args...)
+}
@@ -0,0 +1,260 @@
+package scheduler_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/antklim/go-invoice/scheduler"
+	"github.com/antklim/go-invoice/test/fakes"
+)
+
+var start = time.Date(2021, time.September, 30, 10, 0, 30, 0, time.UTC)
+
+// recorder is the job that sends its ticks.
+func recorder() (scheduler.JobFunc, <-chan scheduler.Tick) {
+	ticks := make(chan scheduler.Tick, 10)
+	return func(_ context.Context, t scheduler.Tick) error {
+		ticks <- t
+		return nil
+	}, ticks
+}
+
+func receive(t *testing.T, ticks <-chan scheduler.Tick) scheduler.Tick {
+	t.Helper()
+
+	select {
+	case tick := <-ticks:
+		return tick
+	case <-time.After(time.Second):
+		t.Fatal("job did not run")
+	}
+	return scheduler.Tick{}
+}
+
+func mustStop(t *testing.T, s *scheduler.Scheduler) {
+	t.Helper()
+
+	if err := s.Stop(context.Background()); err != nil {
+		t.Fatalf("Stop() failed: %v", err)
+	}
+}
+
+func TestSchedulerRunsJobs(t *testing.T) {
+	clock := fakes.NewClock(start)
+	s := scheduler.New(scheduler.WithClock(clock))
+	job, ticks := recorder()
+	if err := s.Register("job", "*/5 * * * *", job); err != nil {
+		t.Fatalf("Register() failed: %v", err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatalf("Start() failed: %v", err)
+	}
+	defer mustStop(t, s)
+
+	first := time.Date(2021, time.September, 30, 10, 5, 0, 0, time.UTC)
+	clock.BlockUntil(1)
+	clock.Advance(5 * time.Minute)
+	if tick := receive(t, ticks); !tick.At.Equal(first) || !tick.Last.IsZero() {
+		t.Errorf("invalid first tick %v", tick)
+	}
+
+	clock.BlockUntil(1)
+	clock.Advance(5 * time.Minute)
+	if tick := receive(t, ticks); !tick.At.Equal(first.Add(5*time.Minute)) || !tick.Last.Equal(first) {
+		t.Errorf("invalid second tick %v", tick)
+	}
+
+	clock.BlockUntil(1)
+	infos := s.Jobs()
+	if len(infos) != 1 || infos[0].Name != "job" || !infos[0].Next.Equal(first.Add(10*time.Minute)) {
+		t.Errorf("invalid jobs %v", infos)
+	}
+}
+
+func TestSchedulerCatchesUpMissedRun(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scheduler.json")
+	clock := fakes.NewClock(start)
+	job, ticks := recorder()
+
+	s := scheduler.New(scheduler.WithClock(clock), scheduler.WithState(scheduler.NewFileState(path)))
+	if err := s.Register("job", "@hourly", job); err != nil {
+		t.Fatalf("Register() failed: %v", err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatalf("Start() failed: %v", err)
+	}
+	clock.BlockUntil(1)
+	clock.Advance(time.Hour)
+	ran := receive(t, ticks)
+	mustStop(t, s)
+
+	// Restarted three hours later it runs once for the missed runs.
+	clock = fakes.NewClock(start.Add(3 * time.Hour))
+	s = scheduler.New(scheduler.WithClock(clock), scheduler.WithState(scheduler.NewFileState(path)))
+	if err := s.Register("job", "@hourly", job); err != nil {
+		t.Fatalf("Register() failed: %v", err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatalf("Start() failed: %v", err)
+	}
+	defer mustStop(t, s)
+
+	if tick := receive(t, ticks); !tick.Last.Equal(ran.At) || !tick.At.Equal(clock.Now()) {
+		t.Errorf("invalid catch up tick %v, want last run at %v", tick, ran.At)
+	}
+	clock.BlockUntil(1)
+	select {
+	case tick := <-ticks:
+		t.Errorf("unexpected tick %v", tick)
+	default:
+	}
+}
+
+func TestSchedulerJitter(t *testing.T) {
+	clock := fakes.NewClock(start)
+	s := scheduler.New(scheduler.WithClock(clock), scheduler.WithJitter(time.Minute))
+	job, ticks := recorder()
+	if err := s.Register("job", "@hourly", job); err != nil {
+		t.Fatalf("Register() failed: %v", err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatalf("Start() failed: %v", err)
+	}
+	defer mustStop(t, s)
+
+	clock.BlockUntil(1)
+	clock.Advance(time.Hour + time.Minute)
+	if tick, want := receive(t, ticks), time.Date(2021, time.September, 30, 11, 0, 0, 0, time.UTC); !tick.At.Equal(want) {
+		t.Errorf("invalid tick at %v, want %v", tick.At, want)
+	}
+}
+
+func TestSchedulerRunNow(t *testing.T) {
+	clock := fakes.NewClock(start)
+	state := scheduler.NewMemoryState()
+	s := scheduler.New(scheduler.WithClock(clock), scheduler.WithState(state))
+
+	started, release := make(chan struct{}), make(chan struct{})
+	err := s.Register("job", "@daily", scheduler.JobFunc(func(context.Context, scheduler.Tick) error {
+		started <- struct{}{}
+		<-release
+		return errors.New("job failed")
+	}))
+	if err != nil {
+		t.Fatalf("Register() failed: %v", err)
+	}
+
+	done := make(chan scheduler.Run)
+	go func() {
+		r, err := s.RunNow("job")
+		if err != nil {
+			t.Errorf("RunNow() failed: %v", err)
+		}
+		done <- r
+	}()
+	<-started
+
+	if _, err := s.RunNow("job"); !errors.Is(err, scheduler.ErrRunning) {
+		t.Errorf("RunNow() of running job error = %v, want %v", err, scheduler.ErrRunning)
+	}
+	if infos := s.Jobs(); !infos[0].Running {
+		t.Error("job expected to be running")
+	}
+
+	close(release)
+	r := <-done
+	if r.Err != "job failed" || !r.Scheduled.Equal(start) {
+		t.Errorf("invalid run %v", r)
+	}
+	runs, err := state.LastRuns()
+	if err != nil {
+		t.Fatalf("LastRuns() failed: %v", err)
+	}
+	if runs["job"] != r {
+		t.Errorf("invalid saved run %v, want %v", runs["job"], r)
+	}
+
+	if _, err := s.RunNow("unknown"); err == nil {
+		t.Error("RunNow() of unknown job expected to fail")
+	}
+	mustStop(t, s)
+	if _, err := s.RunNow("job"); !errors.Is(err, scheduler.ErrStopped) {
+		t.Errorf("RunNow() after stop error = %v, want %v", err, scheduler.ErrStopped)
+	}
+}
+
+func TestSchedulerStop(t *testing.T) {
+	t.Run("waits for running job", func(t *testing.T) {
+		s := scheduler.New()
+		started, finished := make(chan struct{}), make(chan struct{})
+		err := s.Register("job", "@daily", scheduler.JobFunc(func(context.Context, scheduler.Tick) error {
+			close(started)
+			time.Sleep(10 * time.Millisecond)
+			close(finished)
+			return nil
+		}))
+		if err != nil {
+			t.Fatalf("Register() failed: %v", err)
+		}
+
+		go s.RunNow("job") // nolint:errcheck
+		<-started
+		mustStop(t, s)
+		select {
+		case <-finished:
+		default:
+			t.Error("Stop() returned before job finished")
+		}
+	})
+
+	t.Run("cancels running job when stop times out", func(t *testing.T) {
+		s := scheduler.New()
+		started := make(chan struct{})
+		err := s.Register("job", "@daily", scheduler.JobFunc(func(ctx context.Context, _ scheduler.Tick) error {
+			close(started)
+			<-ctx.Done()
+			return ctx.Err()
+		}))
+		if err != nil {
+			t.Fatalf("Register() failed: %v", err)
+		}
+
+		go s.RunNow("job") // nolint:errcheck
+		<-started
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		if err := s.Stop(ctx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Stop() error = %v, want %v", err, context.DeadlineExceeded)
+		}
+	})
+}
+
+func TestSchedulerRegisterErrors(t *testing.T) {
+	job, _ := recorder()
+	s := scheduler.New()
+	if err := s.Register("job", "@daily", job); err != nil {
+		t.Fatalf("Register() failed: %v", err)
+	}
+
+	testCases := []struct {
+		desc string
+		name string
+		spec string
+		job  scheduler.Job
+	}{
+		{desc: "blank name", spec: "@daily", job: job},
+		{desc: "nil job", name: "nil", spec: "@daily"},
+		{desc: "invalid schedule", name: "invalid", spec: "daily", job: job},
+		{desc: "registered twice", name: "job", spec: "@hourly", job: job},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			if err := s.Register(tC.name, tC.spec, tC.job); err == nil {
+				t.Errorf("Register(%q, %q) expected to fail", tC.name, tC.spec)
+			}
+		})
+	}
+}
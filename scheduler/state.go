@@ -0,0 +1,140 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Run is the record of the job run.
+type Run struct {
+	Scheduled time.Time `json:"scheduled"` // schedule time the run is for
+	Started   time.Time `json:"started"`
+	Finished  time.Time `json:"finished"`
+	Err       string    `json:"error,omitempty"`
+}
+
+// State keeps the last run of every job between scheduler restarts.
+type State interface {
+	// LastRuns returns the last runs by job names.
+	LastRuns() (map[string]Run, error)
+	SaveRun(job string, r Run) error
+}
+
+// MemoryState keeps the last runs in memory, they are lost on restart.
+type MemoryState struct {
+	sync.Mutex
+	runs map[string]Run
+}
+
+var _ State = (*MemoryState)(nil)
+
+func NewMemoryState() *MemoryState {
+	return &MemoryState{runs: make(map[string]Run)}
+}
+
+func (m *MemoryState) LastRuns() (map[string]Run, error) {
+	m.Lock()
+	defer m.Unlock()
+	runs := make(map[string]Run, len(m.runs))
+	for job, r := range m.runs {
+		runs[job] = r
+	}
+	return runs, nil
+}
+
+func (m *MemoryState) SaveRun(job string, r Run) error {
+	m.Lock()
+	defer m.Unlock()
+	m.runs[job] = r
+	return nil
+}
+
+// FileState keeps the last runs in the JSON file. The file is replaced on
+// every saved run through a temporary file, so it is never torn.
+type FileState struct {
+	sync.Mutex
+	path string
+	runs map[string]Run // nil until the file read
+}
+
+var _ State = (*FileState)(nil)
+
+func NewFileState(path string) *FileState {
+	return &FileState{path: path}
+}
+
+// LastRuns reads the file. There are no runs when the file does not exist.
+func (f *FileState) LastRuns() (map[string]Run, error) {
+	f.Lock()
+	defer f.Unlock()
+	if err := f.load(); err != nil {
+		return nil, err
+	}
+
+	runs := make(map[string]Run, len(f.runs))
+	for job, r := range f.runs {
+		runs[job] = r
+	}
+	return runs, nil
+}
+
+func (f *FileState) SaveRun(job string, r Run) error {
+	f.Lock()
+	defer f.Unlock()
+	if err := f.load(); err != nil {
+		return err
+	}
+
+	f.runs[job] = r
+	data, err := json.MarshalIndent(f.runs, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "write scheduler state failed")
+	}
+	return errors.Wrap(writeFile(f.path, data), "write scheduler state failed")
+}
+
+func (f *FileState) load() error {
+	if f.runs != nil {
+		return nil
+	}
+
+	runs := make(map[string]Run)
+	data, err := os.ReadFile(f.path)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "read scheduler state failed")
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &runs); err != nil {
+			return errors.Wrapf(err, "scheduler state %q invalid", f.path)
+		}
+	}
+	f.runs = runs
+	return nil
+}
+
+// writeFile replaces the file with data, written to a temporary file first.
+func writeFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -1,7 +1,10 @@
 #!/bin/bash
 
 aws dynamodb create-table --table-name invoices \
-  --attribute-definitions AttributeName=pk,AttributeType=S \
-  --key-schema AttributeName=pk,KeyType=HASH \
+  --attribute-definitions AttributeName=pk,AttributeType=S AttributeName=sk,AttributeType=S \
+    AttributeName=outbox,AttributeType=N AttributeName=outboxAt,AttributeType=S \
+  --key-schema AttributeName=pk,KeyType=HASH AttributeName=sk,KeyType=RANGE \
+  --global-secondary-indexes \
+    "IndexName=outbox,KeySchema=[{AttributeName=outbox,KeyType=HASH},{AttributeName=outboxAt,KeyType=RANGE}],Projection={ProjectionType=ALL},ProvisionedThroughput={ReadCapacityUnits=5,WriteCapacityUnits=5}" \
   --provisioned-throughput ReadCapacityUnits=5,WriteCapacityUnits=5 \
   --endpoint-url ${AWS_ENDPOINT_URL}
@@ -0,0 +1,225 @@
+package bolt
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/antklim/go-invoice/invoice"
+	"github.com/pkg/errors"
+	"go.etcd.io/bbolt"
+)
+
+var invoicesBucket = []byte("invoices")
+
+// Bolt stores invoices in the invoices bucket keyed by invoice ID and
+// maintains secondary indexes of invoice status, customer name and issue date.
+// Every operation runs in a single bbolt transaction. Read-write transactions
+// are executed one at a time, so they are serializable.
+type Bolt struct {
+	db *bbolt.DB
+}
+
+var _ invoice.Storage = (*Bolt)(nil)
+var _ invoice.Lister = (*Bolt)(nil)
+var _ invoice.Transactor = (*Bolt)(nil)
+
+// boltTx is the storage of the read-write bbolt transaction.
+type boltTx struct {
+	tx *bbolt.Tx
+}
+
+func (t boltTx) AddInvoice(inv invoice.Invoice) error { return addInvoice(t.tx, inv) }
+
+func (t boltTx) FindInvoice(id string) (*invoice.Invoice, error) { return getInvoice(t.tx, id) }
+
+func (t boltTx) UpdateInvoice(inv invoice.Invoice) error { return updateInvoice(t.tx, inv) }
+
+// New creates storage on top of the opened database. It creates the invoices
+// and index buckets when they do not exist.
+func New(db *bbolt.DB) (*Bolt, error) {
+	err := db.Update(func(tx *bbolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(invoicesBucket); err != nil {
+			return err
+		}
+		for _, idx := range indexes {
+			if _, err := tx.CreateBucketIfNotExists(idx.bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "create buckets failed")
+	}
+
+	return &Bolt{db: db}, nil
+}
+
+// Close closes the database, releasing the file lock.
+func (b *Bolt) Close() error {
+	return b.db.Close()
+}
+
+func (b *Bolt) AddInvoice(inv invoice.Invoice) error {
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		return addInvoice(tx, inv)
+	})
+}
+
+func (b *Bolt) FindInvoice(id string) (*invoice.Invoice, error) {
+	var inv *invoice.Invoice
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		var err error
+		inv, err = getInvoice(tx, id)
+		return err
+	})
+	return inv, err
+}
+
+func (b *Bolt) UpdateInvoice(inv invoice.Invoice) error {
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		return updateInvoice(tx, inv)
+	})
+}
+
+// RunInTx runs f in a single read-write bbolt transaction. Read-write
+// transactions are executed one at a time, so no concurrent change is possible.
+func (b *Bolt) RunInTx(f func(tx invoice.Storage) error) error {
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		return f(boltTx{tx: tx})
+	})
+}
+
+// ListInvoices lists invoices ordered by ID. The cursor is the ID of the last
+// invoice of the previous page.
+func (b *Bolt) ListInvoices(cursor string, limit int) ([]invoice.Invoice, string, error) {
+	var (
+		invs []invoice.Invoice
+		next string
+	)
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(invoicesBucket).Cursor()
+		k, v := c.Seek([]byte(cursor))
+		if k != nil && string(k) == cursor {
+			k, v = c.Next()
+		}
+
+		for ; k != nil; k, v = c.Next() {
+			if limit > 0 && len(invs) == limit {
+				next = invs[limit-1].ID
+				return nil
+			}
+
+			inv, err := unmarshalInvoice(v)
+			if err != nil {
+				return errors.Wrapf(err, "invoice %q unmarshal failed", k)
+			}
+			invs = append(invs, inv)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return invs, next, nil
+}
+
+// FindInvoicesByStatus returns invoices in the status ordered by invoice ID.
+func (b *Bolt) FindInvoicesByStatus(status invoice.Status) ([]invoice.Invoice, error) {
+	v := statusValue(status)
+	return b.findIndexed(statusIndex, v, func(value string) bool {
+		return value == v
+	})
+}
+
+// FindInvoicesByCustomer returns invoices of the customer ordered by invoice
+// ID. The customer name should match exactly.
+func (b *Bolt) FindInvoicesByCustomer(name string) ([]invoice.Invoice, error) {
+	return b.findIndexed(customerIndex, name, func(value string) bool {
+		return value == name
+	})
+}
+
+// FindInvoicesByDate returns invoices issued in [from, to) time range ordered
+// by issue date. Invoices that were not issued have no date and never returned.
+func (b *Bolt) FindInvoicesByDate(from, to time.Time) ([]invoice.Invoice, error) {
+	until := dateValue(to)
+	return b.findIndexed(dateIndex, dateValue(from), func(value string) bool {
+		return value < until
+	})
+}
+
+func (b *Bolt) findIndexed(idx index, from string, in func(value string) bool) ([]invoice.Invoice, error) {
+	var invs []invoice.Invoice
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		for _, id := range idx.ids(tx, from, in) {
+			inv, err := getInvoice(tx, id)
+			if err != nil {
+				return err
+			}
+			if inv == nil {
+				return fmt.Errorf("invoice %q indexed in %s but not found", id, idx.bucket)
+			}
+			invs = append(invs, *inv)
+		}
+		return nil
+	})
+	return invs, err
+}
+
+func addInvoice(tx *bbolt.Tx, inv invoice.Invoice) error {
+	if tx.Bucket(invoicesBucket).Get([]byte(inv.ID)) != nil {
+		return fmt.Errorf("invoice %q exists", inv.ID)
+	}
+	return putInvoice(tx, inv)
+}
+
+func updateInvoice(tx *bbolt.Tx, inv invoice.Invoice) error {
+	cur, err := getInvoice(tx, inv.ID)
+	if err != nil {
+		return err
+	}
+	if cur == nil {
+		return fmt.Errorf("invoice %q not found", inv.ID)
+	}
+
+	for _, idx := range indexes {
+		if err := idx.delete(tx, *cur); err != nil {
+			return errors.Wrapf(err, "delete invoice %q index failed", inv.ID)
+		}
+	}
+
+	inv.UpdatedAt = time.Now()
+	return putInvoice(tx, inv)
+}
+
+func getInvoice(tx *bbolt.Tx, id string) (*invoice.Invoice, error) {
+	data := tx.Bucket(invoicesBucket).Get([]byte(id))
+	if data == nil {
+		return nil, nil
+	}
+
+	inv, err := unmarshalInvoice(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invoice %q unmarshal failed", id)
+	}
+	return &inv, nil
+}
+
+// putInvoice stores invoice record and adds it to the indexes.
+func putInvoice(tx *bbolt.Tx, inv invoice.Invoice) error {
+	data, err := marshalInvoice(inv)
+	if err != nil {
+		return errors.Wrapf(err, "invoice %q marshal failed", inv.ID)
+	}
+	if err := tx.Bucket(invoicesBucket).Put([]byte(inv.ID), data); err != nil {
+		return errors.Wrapf(err, "put invoice %q failed", inv.ID)
+	}
+
+	for _, idx := range indexes {
+		if err := idx.put(tx, inv); err != nil {
+			return errors.Wrapf(err, "put invoice %q index failed", inv.ID)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,233 @@
+package bolt_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/antklim/go-invoice/invoice"
+	"github.com/antklim/go-invoice/storage/bolt"
+	"github.com/antklim/go-invoice/storage/storagetest"
+	"go.etcd.io/bbolt"
+)
+
+func newStorage(t *testing.T) *bolt.Bolt {
+	t.Helper()
+
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "invoices.bolt"), 0600, nil)
+	if err != nil {
+		t.Fatalf("bbolt.Open() failed: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	strg, err := bolt.New(db)
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	return strg
+}
+
+func invoiceIDs(invs []invoice.Invoice) []string {
+	var ids []string
+	for _, inv := range invs {
+		ids = append(ids, inv.ID)
+	}
+	return ids
+}
+
+func equalIDs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestClose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "invoices.bolt")
+	db, err := bbolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatalf("bbolt.Open() failed: %v", err)
+	}
+	strg, err := bolt.New(db)
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	if err := strg.Close(); err != nil {
+		t.Fatalf("Close() failed: %v", err)
+	}
+
+	// the file lock is released, so the file can be opened again
+	db, err = bbolt.Open(path, 0600, &bbolt.Options{Timeout: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("bbolt.Open() of closed storage file failed: %v", err)
+	}
+	db.Close()
+}
+
+func TestFindInvoice(t *testing.T) {
+	strg := newStorage(t)
+	inv := invoice.NewInvoice("John Doe")
+	inv.Items = append(inv.Items, invoice.NewItem("pen", 100, 2))
+
+	vinv, err := strg.FindInvoice(inv.ID)
+	if err != nil {
+		t.Errorf("FindInvoice(%q) failed: %v", inv.ID, err)
+	}
+	if vinv != nil {
+		t.Errorf("FindInvoice(%q) no invoice expected, got %v", inv.ID, vinv)
+	}
+
+	if err := strg.AddInvoice(inv); err != nil {
+		t.Fatalf("AddInvoice(%v) failed: %v", inv, err)
+	}
+	if err := strg.AddInvoice(inv); err == nil {
+		t.Errorf("repeated AddInvoice(%v) expected to fail", inv)
+	}
+
+	vinv, err = strg.FindInvoice(inv.ID)
+	if err != nil {
+		t.Fatalf("FindInvoice(%q) failed: %v", inv.ID, err)
+	}
+	if vinv == nil {
+		t.Fatalf("FindInvoice(%q) invoice expected, got nil", inv.ID)
+	}
+	if !vinv.Equal(&inv) {
+		t.Errorf("FindInvoice(%q) = %v, want %v", inv.ID, vinv, inv)
+	}
+}
+
+func TestUpdateInvoice(t *testing.T) {
+	strg := newStorage(t)
+	inv := invoice.NewInvoice("John Doe")
+
+	if err := strg.UpdateInvoice(inv); err == nil {
+		t.Errorf("UpdateInvoice(%v) of not existing invoice expected to fail", inv)
+	}
+
+	if err := strg.AddInvoice(inv); err != nil {
+		t.Fatalf("AddInvoice(%v) failed: %v", inv, err)
+	}
+
+	newCustomer := "new customer"
+	inv.CustomerName = newCustomer
+	if err := strg.UpdateInvoice(inv); err != nil {
+		t.Fatalf("UpdateInvoice(%v) failed: %v", inv, err)
+	}
+
+	vinv, err := strg.FindInvoice(inv.ID)
+	if err != nil {
+		t.Fatalf("FindInvoice(%q) failed: %v", inv.ID, err)
+	}
+	if vinv.CustomerName != newCustomer {
+		t.Errorf("invalid updated invoice.CustomerName %q, want %q", vinv.CustomerName, newCustomer)
+	}
+	if !vinv.UpdatedAt.After(inv.UpdatedAt) {
+		t.Errorf("invalid udated invoice.UpdatedAt %s, want after %s",
+			vinv.UpdatedAt.Format(time.RFC3339),
+			inv.UpdatedAt.Format(time.RFC3339))
+	}
+}
+
+func TestIndexes(t *testing.T) {
+	strg := newStorage(t)
+	day := time.Date(2021, time.September, 1, 0, 0, 0, 0, time.UTC)
+
+	open := invoice.NewInvoice("John Doe")
+	issued := invoice.NewInvoice("John Doe")
+	issuedAt := day.Add(10 * time.Hour)
+	issued.Date = &issuedAt
+	issued.Status = invoice.Issued
+	other := invoice.NewInvoice("Jane Doe")
+	otherIssuedAt := day.Add(48 * time.Hour)
+	other.Date = &otherIssuedAt
+	other.Status = invoice.Issued
+
+	for _, inv := range []invoice.Invoice{open, issued, other} {
+		if err := strg.AddInvoice(inv); err != nil {
+			t.Fatalf("AddInvoice(%v) failed: %v", inv, err)
+		}
+	}
+
+	// issued invoice paid: status index entry moved, customer name changed
+	issued.Status = invoice.Paid
+	issued.CustomerName = "Jane Doe"
+	if err := strg.UpdateInvoice(issued); err != nil {
+		t.Fatalf("UpdateInvoice(%v) failed: %v", issued, err)
+	}
+
+	sorted := func(ids ...string) []string {
+		if len(ids) == 2 && ids[0] > ids[1] {
+			ids[0], ids[1] = ids[1], ids[0]
+		}
+		return ids
+	}
+
+	testCases := []struct {
+		desc string
+		find func() ([]invoice.Invoice, error)
+		want []string
+	}{
+		{
+			desc: "by status open",
+			find: func() ([]invoice.Invoice, error) { return strg.FindInvoicesByStatus(invoice.Open) },
+			want: []string{open.ID},
+		},
+		{
+			desc: "by status issued",
+			find: func() ([]invoice.Invoice, error) { return strg.FindInvoicesByStatus(invoice.Issued) },
+			want: []string{other.ID},
+		},
+		{
+			desc: "by status paid",
+			find: func() ([]invoice.Invoice, error) { return strg.FindInvoicesByStatus(invoice.Paid) },
+			want: []string{issued.ID},
+		},
+		{
+			desc: "by status canceled",
+			find: func() ([]invoice.Invoice, error) { return strg.FindInvoicesByStatus(invoice.Canceled) },
+		},
+		{
+			desc: "by customer",
+			find: func() ([]invoice.Invoice, error) { return strg.FindInvoicesByCustomer("Jane Doe") },
+			want: sorted(issued.ID, other.ID),
+		},
+		{
+			desc: "by customer name prefix",
+			find: func() ([]invoice.Invoice, error) { return strg.FindInvoicesByCustomer("Jane") },
+		},
+		{
+			desc: "by date of the day",
+			find: func() ([]invoice.Invoice, error) { return strg.FindInvoicesByDate(day, day.Add(24*time.Hour)) },
+			want: []string{issued.ID},
+		},
+		{
+			desc: "by date range",
+			find: func() ([]invoice.Invoice, error) { return strg.FindInvoicesByDate(day, day.Add(72*time.Hour)) },
+			want: []string{issued.ID, other.ID},
+		},
+	}
+
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			invs, err := tC.find()
+			if err != nil {
+				t.Fatalf("find failed: %v", err)
+			}
+			if got := invoiceIDs(invs); !equalIDs(got, tC.want) {
+				t.Errorf("found invoices %v, want %v", got, tC.want)
+			}
+		})
+	}
+}
+
+func TestConformance(t *testing.T) {
+	strg := newStorage(t)
+	storagetest.Run(t, storagetest.FactoryFunc(func() invoice.Storage {
+		return strg
+	}))
+}
@@ -0,0 +1,3 @@
+// Package bolt contains storage implementation on top of the embedded bbolt
+// key-value database file.
+package bolt
@@ -0,0 +1,109 @@
+package bolt
+
+import (
+	"bytes"
+	"strconv"
+	"time"
+
+	"github.com/antklim/go-invoice/invoice"
+	"go.etcd.io/bbolt"
+)
+
+// indexDateLayout is the fixed width layout of the issue date in the index
+// keys, so that lexicographical order of the keys is the order of the dates.
+const indexDateLayout = "2006-01-02T15:04:05.000000000Z"
+
+// keyDelim separates indexed value and invoice ID in the index key.
+const keyDelim = 0
+
+// index is a secondary index of invoices. It is stored in a separate bucket,
+// where keys are the indexed value and the invoice ID joined with keyDelim, and
+// values are empty. Keys of the same value are stored next to each other and
+// ordered by invoice ID.
+type index struct {
+	bucket []byte
+	value  func(inv invoice.Invoice) (string, bool) // returns false when invoice not indexed
+}
+
+var (
+	statusIndex = index{
+		bucket: []byte("idx_status"),
+		value: func(inv invoice.Invoice) (string, bool) {
+			return statusValue(inv.Status), true
+		},
+	}
+
+	customerIndex = index{
+		bucket: []byte("idx_customer"),
+		value: func(inv invoice.Invoice) (string, bool) {
+			return inv.CustomerName, true
+		},
+	}
+
+	dateIndex = index{
+		bucket: []byte("idx_date"),
+		value: func(inv invoice.Invoice) (string, bool) {
+			if inv.Date == nil {
+				return "", false
+			}
+			return dateValue(*inv.Date), true
+		},
+	}
+)
+
+var indexes = []index{statusIndex, customerIndex, dateIndex}
+
+func statusValue(s invoice.Status) string {
+	return strconv.Itoa(int(s))
+}
+
+func dateValue(t time.Time) string {
+	return t.UTC().Format(indexDateLayout)
+}
+
+func indexKey(value, id string) []byte {
+	key := make([]byte, 0, len(value)+len(id)+1)
+	key = append(key, value...)
+	key = append(key, keyDelim)
+	return append(key, id...)
+}
+
+// splitIndexKey returns indexed value and invoice ID of the index key.
+func splitIndexKey(key []byte) (string, string) {
+	i := bytes.LastIndexByte(key, keyDelim)
+	if i == -1 {
+		return string(key), ""
+	}
+	return string(key[:i]), string(key[i+1:])
+}
+
+func (idx index) put(tx *bbolt.Tx, inv invoice.Invoice) error {
+	v, ok := idx.value(inv)
+	if !ok {
+		return nil
+	}
+	return tx.Bucket(idx.bucket).Put(indexKey(v, inv.ID), []byte{})
+}
+
+func (idx index) delete(tx *bbolt.Tx, inv invoice.Invoice) error {
+	v, ok := idx.value(inv)
+	if !ok {
+		return nil
+	}
+	return tx.Bucket(idx.bucket).Delete(indexKey(v, inv.ID))
+}
+
+// ids returns IDs of the invoices with the indexed value starting from the
+// from value while in returns true.
+func (idx index) ids(tx *bbolt.Tx, from string, in func(value string) bool) []string {
+	var ids []string
+	c := tx.Bucket(idx.bucket).Cursor()
+	for k, _ := c.Seek([]byte(from)); k != nil; k, _ = c.Next() {
+		v, id := splitIndexKey(k)
+		if !in(v) {
+			break
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
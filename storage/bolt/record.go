@@ -0,0 +1,115 @@
+package bolt
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/antklim/go-invoice/invoice"
+)
+
+// bInvoice is the invoice record stored in the invoices bucket.
+type bInvoice struct {
+	ID           string            `json:"id"`
+	CustomerName string            `json:"customerName"`
+	Date         *time.Time        `json:"issueDate,omitempty"`
+	IssueAt      *time.Time        `json:"issueAt,omitempty"`
+	DunningLevel int               `json:"dunningLevel,omitempty"`
+	DunningAt    *time.Time        `json:"dunningAt,omitempty"`
+	Delivery     *invoice.Delivery `json:"delivery,omitempty"`
+	Status       int               `json:"status"`
+	Items        []bItem           `json:"items,omitempty"`
+	Origins      []string          `json:"origins,omitempty"`
+	Successors   []string          `json:"successors,omitempty"`
+	CreatedAt    time.Time         `json:"createdAt"`
+	UpdatedAt    time.Time         `json:"updatedAt"`
+}
+
+func newBinvoice(inv invoice.Invoice) bInvoice {
+	items := make([]bItem, len(inv.Items))
+	for i, item := range inv.Items {
+		items[i] = newBitem(item)
+	}
+
+	return bInvoice{
+		ID:           inv.ID,
+		CustomerName: inv.CustomerName,
+		Date:         inv.Date,
+		IssueAt:      inv.IssueAt,
+		DunningLevel: inv.DunningLevel,
+		DunningAt:    inv.DunningAt,
+		Delivery:     inv.Delivery,
+		Status:       int(inv.Status),
+		Items:        items,
+		Origins:      inv.Origins,
+		Successors:   inv.Successors,
+		CreatedAt:    inv.CreatedAt,
+		UpdatedAt:    inv.UpdatedAt,
+	}
+}
+
+func (b bInvoice) invoice() invoice.Invoice {
+	var items []invoice.Item
+	for _, item := range b.Items {
+		items = append(items, item.item())
+	}
+
+	return invoice.Invoice{
+		ID:           b.ID,
+		CustomerName: b.CustomerName,
+		Date:         b.Date,
+		IssueAt:      b.IssueAt,
+		DunningLevel: b.DunningLevel,
+		DunningAt:    b.DunningAt,
+		Delivery:     b.Delivery,
+		Status:       invoice.Status(b.Status),
+		Items:        items,
+		Origins:      b.Origins,
+		Successors:   b.Successors,
+		CreatedAt:    b.CreatedAt,
+		UpdatedAt:    b.UpdatedAt,
+	}
+}
+
+// bItem is the invoice item record stored as a part of the invoice record.
+type bItem struct {
+	ID          string    `json:"id"`
+	ProductName string    `json:"productName"`
+	Price       int       `json:"price"`
+	Qty         int       `json:"qty"`
+	Origin      string    `json:"origin,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+func newBitem(item invoice.Item) bItem {
+	return bItem{
+		ID:          item.ID,
+		ProductName: item.ProductName,
+		Price:       item.Price,
+		Qty:         item.Qty,
+		Origin:      item.Origin,
+		CreatedAt:   item.CreatedAt,
+	}
+}
+
+func (b bItem) item() invoice.Item {
+	return invoice.Item{
+		ID:          b.ID,
+		ProductName: b.ProductName,
+		Price:       b.Price,
+		Qty:         b.Qty,
+		Origin:      b.Origin,
+		CreatedAt:   b.CreatedAt,
+	}
+}
+
+func marshalInvoice(inv invoice.Invoice) ([]byte, error) {
+	return json.Marshal(newBinvoice(inv))
+}
+
+func unmarshalInvoice(data []byte) (invoice.Invoice, error) {
+	var b bInvoice
+	if err := json.Unmarshal(data, &b); err != nil {
+		return invoice.Invoice{}, err
+	}
+	return b.invoice(), nil
+}
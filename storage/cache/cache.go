@@ -0,0 +1,270 @@
+package cache
+
+import (
+	"container/list"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/antklim/go-invoice/invoice"
+	"github.com/pkg/errors"
+)
+
+// Stats contains cache counters.
+type Stats struct {
+	Hits        int64 // invoices found in the cache
+	Misses      int64 // invoices read from the storage
+	Evictions   int64 // invoices evicted to keep the cache size
+	Expirations int64 // invoices removed because of expired TTL
+	Size        int   // number of cached invoices
+}
+
+type entry struct {
+	inv     invoice.Invoice
+	expires time.Time // zero when entry never expires
+}
+
+// Cache is the invoice storage decorator that caches found invoices by ID. A
+// cached invoice is invalidated when it is added or updated through the cache.
+// Changes made to the storage directly are visible after the invoice expired.
+type Cache struct {
+	strg invoice.Storage
+	opts options
+	now  func() time.Time
+
+	sync.Mutex // guards fields below
+	entries    map[string]*list.Element
+	lru        *list.List // entries in the order of use, most recently used first
+	gen        uint64     // incremented on every invalidation
+	stats      Stats
+}
+
+var _ invoice.Storage = (*Cache)(nil)
+var _ invoice.Lister = (*Cache)(nil)
+var _ invoice.Transactor = (*Cache)(nil)
+var _ invoice.Outbox = (*Cache)(nil)
+
+// New creates caching decorator of the storage.
+func New(strg invoice.Storage, opts ...Option) *Cache {
+	copts := defaultOptions
+	for _, o := range opts {
+		o.apply(&copts)
+	}
+
+	return &Cache{
+		strg:    strg,
+		opts:    copts,
+		now:     time.Now,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+func (c *Cache) AddInvoice(inv invoice.Invoice) error {
+	defer c.invalidate(inv.ID)
+	return c.strg.AddInvoice(inv)
+}
+
+// FindInvoice returns the cached invoice or reads it from the storage and
+// caches it. Not found invoices are not cached.
+func (c *Cache) FindInvoice(id string) (*invoice.Invoice, error) {
+	if inv, ok := c.get(id); ok {
+		return inv, nil
+	}
+
+	c.Lock()
+	gen := c.gen
+	c.Unlock()
+
+	inv, err := c.strg.FindInvoice(id)
+	if err != nil || inv == nil {
+		return inv, err
+	}
+
+	c.put(gen, *inv)
+	return inv, nil
+}
+
+// UpdateInvoice updates the invoice in the storage and invalidates the cached
+// one. Cached invoice invalidated even when update fails, because the storage
+// state is unknown.
+func (c *Cache) UpdateInvoice(inv invoice.Invoice) error {
+	defer c.invalidate(inv.ID)
+	return c.strg.UpdateInvoice(inv)
+}
+
+// ListInvoices lists invoices of the storage, bypassing the cache. It fails
+// when the storage cannot list invoices.
+func (c *Cache) ListInvoices(cursor string, limit int) ([]invoice.Invoice, string, error) {
+	l, ok := c.strg.(invoice.Lister)
+	if !ok {
+		return nil, "", errors.New("storage does not support listing invoices")
+	}
+	return l.ListInvoices(cursor, limit)
+}
+
+// RunInTx runs f in the storage transaction, bypassing the cache. Invoices
+// added and updated by the transaction are invalidated when it ends, whether
+// it committed or not. It fails when the storage does not support transactions.
+func (c *Cache) RunInTx(f func(tx invoice.Storage) error) error {
+	t, ok := c.strg.(invoice.Transactor)
+	if !ok {
+		return errors.New("storage does not support transactions")
+	}
+
+	var ids []string
+	defer func() {
+		for _, id := range ids {
+			c.invalidate(id)
+		}
+	}()
+
+	return t.RunInTx(func(tx invoice.Storage) error {
+		return f(&trackedTx{Storage: tx, ids: &ids})
+	})
+}
+
+// OutboxEvents returns the events of the storage outbox. It fails when the
+// storage has no outbox.
+func (c *Cache) OutboxEvents(limit int) ([]invoice.Event, error) {
+	o, ok := c.strg.(invoice.Outbox)
+	if !ok {
+		return nil, errors.New("storage does not support outbox")
+	}
+	return o.OutboxEvents(limit)
+}
+
+// DeleteOutboxEvents deletes the events from the storage outbox. It fails when
+// the storage has no outbox.
+func (c *Cache) DeleteOutboxEvents(events []invoice.Event) error {
+	o, ok := c.strg.(invoice.Outbox)
+	if !ok {
+		return errors.New("storage does not support outbox")
+	}
+	return o.DeleteOutboxEvents(events)
+}
+
+// Stats returns cache counters.
+func (c *Cache) Stats() Stats {
+	c.Lock()
+	defer c.Unlock()
+
+	stats := c.stats
+	stats.Size = c.lru.Len()
+	return stats
+}
+
+// Purge removes all invoices from the cache. It should be called after the
+// storage was changed directly, for example restored from a backup.
+func (c *Cache) Purge() {
+	c.Lock()
+	defer c.Unlock()
+
+	c.gen++
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+}
+
+// Close closes the storage when it is closeable.
+func (c *Cache) Close() error {
+	if closer, ok := c.strg.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (c *Cache) get(id string) (*invoice.Invoice, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	el, ok := c.entries[id]
+	if !ok {
+		c.stats.Misses++
+		return nil, false
+	}
+
+	e := el.Value.(*entry)
+	if !e.expires.IsZero() && !c.now().Before(e.expires) {
+		c.remove(el)
+		c.stats.Expirations++
+		c.stats.Misses++
+		return nil, false
+	}
+
+	c.lru.MoveToFront(el)
+	c.stats.Hits++
+	inv := e.inv.Clone()
+	return &inv, true
+}
+
+// put caches the invoice read from the storage. The invoice is not cached when
+// any invoice was invalidated since the read started at the generation gen:
+// the read could return the invoice state before invalidation.
+func (c *Cache) put(gen uint64, inv invoice.Invoice) {
+	if c.opts.size <= 0 {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	if c.gen != gen {
+		return
+	}
+
+	e := &entry{inv: inv.Clone()}
+	if c.opts.ttl > 0 {
+		e.expires = c.now().Add(c.opts.ttl)
+	}
+
+	if el, ok := c.entries[inv.ID]; ok {
+		el.Value = e
+		c.lru.MoveToFront(el)
+		return
+	}
+
+	c.entries[inv.ID] = c.lru.PushFront(e)
+	for c.lru.Len() > c.opts.size {
+		c.remove(c.lru.Back())
+		c.stats.Evictions++
+	}
+}
+
+func (c *Cache) invalidate(id string) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.gen++
+	if el, ok := c.entries[id]; ok {
+		c.remove(el)
+	}
+}
+
+func (c *Cache) remove(el *list.Element) {
+	e := c.lru.Remove(el).(*entry)
+	delete(c.entries, e.inv.ID)
+}
+
+// trackedTx records IDs of invoices changed in the transaction.
+type trackedTx struct {
+	invoice.Storage
+	ids *[]string
+}
+
+func (t *trackedTx) AddInvoice(inv invoice.Invoice) error {
+	*t.ids = append(*t.ids, inv.ID)
+	return t.Storage.AddInvoice(inv)
+}
+
+func (t *trackedTx) UpdateInvoice(inv invoice.Invoice) error {
+	*t.ids = append(*t.ids, inv.ID)
+	return t.Storage.UpdateInvoice(inv)
+}
+
+func (t *trackedTx) WriteEvent(e invoice.Event) error {
+	w, ok := t.Storage.(invoice.EventWriter)
+	if !ok {
+		return errors.New("storage does not support outbox")
+	}
+	return w.WriteEvent(e)
+}
@@ -0,0 +1,240 @@
+package cache_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/antklim/go-invoice/invoice"
+	"github.com/antklim/go-invoice/storage/cache"
+	"github.com/antklim/go-invoice/storage/memory"
+	"github.com/antklim/go-invoice/storage/storagetest"
+	"github.com/antklim/go-invoice/test/mocks"
+)
+
+func newCache(t *testing.T, opts ...cache.Option) (*cache.Cache, *mocks.SpyStorage, []invoice.Invoice) {
+	t.Helper()
+
+	spy := mocks.NewSpyStorage(memory.New())
+	c := cache.New(spy, opts...)
+
+	var invs []invoice.Invoice
+	for _, name := range []string{"John Doe", "Jane Doe", "Bob Smith"} {
+		inv := invoice.NewInvoice(name)
+		if err := c.AddInvoice(inv); err != nil {
+			t.Fatalf("AddInvoice(%v) failed: %v", inv, err)
+		}
+		invs = append(invs, inv)
+	}
+	return c, spy, invs
+}
+
+func findInvoice(t *testing.T, c *cache.Cache, id string) *invoice.Invoice {
+	t.Helper()
+
+	inv, err := c.FindInvoice(id)
+	if err != nil {
+		t.Fatalf("FindInvoice(%q) failed: %v", id, err)
+	}
+	return inv
+}
+
+func TestFindInvoice(t *testing.T) {
+	t.Run("reads invoice from storage once", func(t *testing.T) {
+		c, spy, invs := newCache(t)
+
+		for i := 0; i < 3; i++ {
+			if inv := findInvoice(t, c, invs[0].ID); inv == nil || inv.ID != invs[0].ID {
+				t.Fatalf("FindInvoice(%q) = %v, want invoice %q", invs[0].ID, inv, invs[0].ID)
+			}
+		}
+
+		if got, want := spy.CalledTimes("FindInvoice"), 1; got != want {
+			t.Errorf("storage.FindInvoice() called %d times, want %d call(s)", got, want)
+		}
+		want := cache.Stats{Hits: 2, Misses: 1, Size: 1}
+		if got := c.Stats(); got != want {
+			t.Errorf("invalid cache stats %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("does not cache not found invoices", func(t *testing.T) {
+		c, spy, _ := newCache(t)
+		inv := invoice.NewInvoice("John Doe")
+
+		if vinv := findInvoice(t, c, inv.ID); vinv != nil {
+			t.Fatalf("FindInvoice(%q) no invoice expected, got %v", inv.ID, vinv)
+		}
+		if err := c.AddInvoice(inv); err != nil {
+			t.Fatalf("AddInvoice(%v) failed: %v", inv, err)
+		}
+		if vinv := findInvoice(t, c, inv.ID); vinv == nil {
+			t.Fatalf("FindInvoice(%q) invoice expected, got nil", inv.ID)
+		}
+
+		if got, want := spy.CalledTimes("FindInvoice"), 2; got != want {
+			t.Errorf("storage.FindInvoice() called %d times, want %d call(s)", got, want)
+		}
+	})
+
+	t.Run("returns copies of cached invoice", func(t *testing.T) {
+		c, _, invs := newCache(t)
+		id := invs[0].ID
+
+		inv := findInvoice(t, c, id)
+		inv.CustomerName = "changed"
+		inv.Items = append(inv.Items, invoice.NewItem("pen", 100, 1))
+
+		cached := findInvoice(t, c, id)
+		if cached.CustomerName != invs[0].CustomerName || len(cached.Items) != 0 {
+			t.Errorf("cached invoice changed through returned invoice: %v", cached)
+		}
+	})
+
+	t.Run("evicts least recently used invoice", func(t *testing.T) {
+		c, spy, invs := newCache(t, cache.WithSize(2))
+
+		findInvoice(t, c, invs[0].ID)
+		findInvoice(t, c, invs[1].ID)
+		findInvoice(t, c, invs[0].ID) // invs[1] is the least recently used now
+		findInvoice(t, c, invs[2].ID)
+
+		findInvoice(t, c, invs[0].ID)
+		if got, want := spy.CalledTimes("FindInvoice"), 3; got != want {
+			t.Errorf("storage.FindInvoice() called %d times, want %d call(s)", got, want)
+		}
+		findInvoice(t, c, invs[1].ID)
+		if got, want := spy.CalledTimes("FindInvoice"), 4; got != want {
+			t.Errorf("storage.FindInvoice() called %d times, want %d call(s)", got, want)
+		}
+
+		stats := c.Stats()
+		if stats.Evictions != 2 || stats.Size != 2 {
+			t.Errorf("invalid cache stats %+v, want 2 evictions and size 2", stats)
+		}
+	})
+
+	t.Run("expires invoices", func(t *testing.T) {
+		c, spy, invs := newCache(t, cache.WithTTL(time.Minute))
+		now := time.Now()
+		cache.SetClock(c, func() time.Time { return now })
+
+		findInvoice(t, c, invs[0].ID)
+		now = now.Add(59 * time.Second)
+		findInvoice(t, c, invs[0].ID)
+		if got, want := spy.CalledTimes("FindInvoice"), 1; got != want {
+			t.Errorf("storage.FindInvoice() called %d times, want %d call(s)", got, want)
+		}
+
+		now = now.Add(time.Second)
+		findInvoice(t, c, invs[0].ID)
+		if got, want := spy.CalledTimes("FindInvoice"), 2; got != want {
+			t.Errorf("storage.FindInvoice() called %d times, want %d call(s)", got, want)
+		}
+		if got := c.Stats().Expirations; got != 1 {
+			t.Errorf("invalid cache expirations %d, want 1", got)
+		}
+	})
+}
+
+func TestUpdateInvoice(t *testing.T) {
+	c, spy, invs := newCache(t)
+	inv := findInvoice(t, c, invs[0].ID)
+
+	inv.CustomerName = "new customer"
+	if err := c.UpdateInvoice(*inv); err != nil {
+		t.Fatalf("UpdateInvoice(%v) failed: %v", inv, err)
+	}
+
+	vinv := findInvoice(t, c, inv.ID)
+	if vinv.CustomerName != inv.CustomerName {
+		t.Errorf("invalid updated invoice.CustomerName %q, want %q", vinv.CustomerName, inv.CustomerName)
+	}
+	if got, want := spy.CalledTimes("FindInvoice"), 2; got != want {
+		t.Errorf("storage.FindInvoice() called %d times, want %d call(s)", got, want)
+	}
+}
+
+// blockingStorage blocks FindInvoice calls until unblocked.
+type blockingStorage struct {
+	invoice.Storage
+	found   chan struct{}
+	unblock chan struct{}
+}
+
+func (s *blockingStorage) FindInvoice(id string) (*invoice.Invoice, error) {
+	inv, err := s.Storage.FindInvoice(id)
+	s.found <- struct{}{}
+	<-s.unblock
+	return inv, err
+}
+
+func TestStaleReadNotCached(t *testing.T) {
+	strg := memory.New()
+	inv := invoice.NewInvoice("John Doe")
+	if err := strg.AddInvoice(inv); err != nil {
+		t.Fatalf("AddInvoice(%v) failed: %v", inv, err)
+	}
+
+	blocking := &blockingStorage{Storage: strg, found: make(chan struct{}), unblock: make(chan struct{})}
+	c := cache.New(blocking)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = c.FindInvoice(inv.ID)
+	}()
+
+	// invoice updated after it was read, but before the read result cached
+	<-blocking.found
+	inv.CustomerName = "new customer"
+	if err := c.UpdateInvoice(inv); err != nil {
+		t.Fatalf("UpdateInvoice(%v) failed: %v", inv, err)
+	}
+	close(blocking.unblock)
+	<-done
+
+	go func() { <-blocking.found }()
+	vinv := findInvoice(t, c, inv.ID)
+	if vinv.CustomerName != inv.CustomerName {
+		t.Errorf("stale invoice cached, invoice.CustomerName %q, want %q", vinv.CustomerName, inv.CustomerName)
+	}
+}
+
+func TestConcurrentAccess(t *testing.T) {
+	c, _, invs := newCache(t, cache.WithSize(2))
+
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				inv := invs[(w+i)%len(invs)]
+				vinv, err := c.FindInvoice(inv.ID)
+				if err != nil || vinv == nil {
+					t.Errorf("FindInvoice(%q) = %v, %v", inv.ID, vinv, err)
+					return
+				}
+				if i%10 == 0 {
+					vinv.Items = append(vinv.Items, invoice.NewItem("pen", 100, 1))
+					if err := c.UpdateInvoice(*vinv); err != nil {
+						t.Errorf("UpdateInvoice(%v) failed: %v", vinv, err)
+						return
+					}
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if stats := c.Stats(); stats.Size > 2 {
+		t.Errorf("cache size %d exceeds limit 2", stats.Size)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, storagetest.FactoryFunc(func() invoice.Storage {
+		return cache.New(memory.New(), cache.WithSize(2))
+	}))
+}
@@ -0,0 +1,2 @@
+// Package cache contains read-through caching decorator of invoice storage.
+package cache
@@ -0,0 +1,8 @@
+package cache
+
+import "time"
+
+// SetClock replaces the clock used to expire cached invoices.
+func SetClock(c *Cache, now func() time.Time) {
+	c.now = now
+}
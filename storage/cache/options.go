@@ -0,0 +1,45 @@
+package cache
+
+import "time"
+
+type options struct {
+	size int
+	ttl  time.Duration
+}
+
+var defaultOptions = options{
+	size: 1000,        // nolint:gomnd
+	ttl:  time.Minute, // nolint:gomnd
+}
+
+type Option interface {
+	apply(*options)
+}
+
+type funcOption struct {
+	f func(*options)
+}
+
+func (f *funcOption) apply(o *options) {
+	f.f(o)
+}
+
+func newFuncOption(f func(*options)) Option {
+	return &funcOption{f: f}
+}
+
+// WithSize sets the maximum number of cached invoices. The least recently used
+// invoice is evicted when the cache is full.
+func WithSize(v int) Option {
+	return newFuncOption(func(o *options) {
+		o.size = v
+	})
+}
+
+// WithTTL sets how long invoice stays in the cache. Zero TTL means that
+// invoices never expire and are only evicted or invalidated.
+func WithTTL(v time.Duration) Option {
+	return newFuncOption(func(o *options) {
+		o.ttl = v
+	})
+}
@@ -0,0 +1,134 @@
+package dynamo
+
+import (
+	"reflect"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+// keyAttributes are the primary key attributes of the table rows. They cannot
+// be updated.
+var keyAttributes = map[string]struct{}{
+	"pk": {},
+	"sk": {},
+}
+
+// changeSet describes field-level changes between the stored invoice and its
+// new state: header row attributes to set or remove, item rows to put and item
+// rows to delete.
+type changeSet struct {
+	header  expression.UpdateBuilder
+	puts    []dItem
+	deletes []dItem
+}
+
+func newChangeSet(cur, next *dInvoice) (*changeSet, error) {
+	header, err := headerChanges(cur, next)
+	if err != nil {
+		return nil, err
+	}
+
+	puts, deletes := itemChanges(cur.Items, next.Items)
+	return &changeSet{
+		header:  header,
+		puts:    puts,
+		deletes: deletes,
+	}, nil
+}
+
+func (cs *changeSet) itemsChanged() bool {
+	return len(cs.puts) > 0 || len(cs.deletes) > 0
+}
+
+// headerChanges builds an update of the header row attributes that differ
+// between the stored header cur and the header next. Items embedded in the
+// legacy header row are removed from it.
+func headerChanges(cur, next *dInvoice) (expression.UpdateBuilder, error) {
+	var update expression.UpdateBuilder
+
+	curAttrs, err := dynamodbattribute.MarshalMap(cur.header())
+	if err != nil {
+		return update, err
+	}
+
+	nextAttrs, err := dynamodbattribute.MarshalMap(next.header())
+	if err != nil {
+		return update, err
+	}
+
+	for _, name := range sortedAttributeNames(nextAttrs) {
+		if _, ok := keyAttributes[name]; ok {
+			continue
+		}
+		if reflect.DeepEqual(curAttrs[name], nextAttrs[name]) {
+			continue
+		}
+		update = update.Set(expression.Name(name), expression.Value(nextAttrs[name]))
+	}
+
+	for _, name := range sortedAttributeNames(curAttrs) {
+		if _, ok := nextAttrs[name]; !ok {
+			update = update.Remove(expression.Name(name))
+		}
+	}
+
+	if hasEmbeddedItems(cur) {
+		update = update.Remove(expression.Name("items"))
+	}
+
+	return update, nil
+}
+
+// itemChanges returns item rows that should be put and deleted to turn the
+// stored item rows cur into the rows next.
+func itemChanges(cur, next []dItem) (puts, deletes []dItem) {
+	stored := make(map[string]dItem, len(cur))
+	for _, di := range cur {
+		if di.SK != "" { // items embedded in the legacy header row have no own key
+			stored[di.ID] = di
+		}
+	}
+
+	for i := range next {
+		di := next[i]
+		if sdi, ok := stored[di.ID]; ok {
+			delete(stored, di.ID)
+			if sdi.equal(&di) {
+				continue
+			}
+		}
+		puts = append(puts, di)
+	}
+
+	// keep deletes in the order of stored items
+	for _, di := range cur {
+		if _, ok := stored[di.ID]; ok {
+			deletes = append(deletes, di)
+		}
+	}
+
+	return puts, deletes
+}
+
+func hasEmbeddedItems(dInv *dInvoice) bool {
+	for _, di := range dInv.Items {
+		if di.SK == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// sortedAttributeNames returns attribute names in a stable order, so the same
+// change produces the same update expression.
+func sortedAttributeNames(attrs map[string]*dynamodb.AttributeValue) []string {
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
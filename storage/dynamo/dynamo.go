@@ -3,32 +3,56 @@ package dynamo
 import (
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/antklim/go-invoice/invoice"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
 	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
 )
 
-var errUnknownUnmarshalSource = errors.New("unknown unmarshal source")
+var (
+	errUnknownUnmarshalSource = errors.New("unknown unmarshal source")
+	errTooManyWrites          = errors.New("too many item changes in a single write")
+)
 
 const (
 	dKeyDelim        = "#"
 	dInvoicePKPrefix = "INVOICE"
+	dItemSKPrefix    = "ITEM"
+
+	// dMaxTransactItems is the maximum number of actions DynamoDB accepts in
+	// a single TransactWriteItems call.
+	dMaxTransactItems = 100
+
+	// dConditionalCheckFailed is the cancellation reason code reported for the
+	// transaction action which condition check failed.
+	dConditionalCheckFailed = "ConditionalCheckFailed"
 )
 
+// dInvoice is an invoice header row. Invoice items stored as separate rows of
+// the same item collection (partition). Items attribute only populated by
+// rows of the legacy layout, where all the items embedded in the header row.
 type dInvoice struct {
-	PK           string     `dynamodbav:"pk"`
-	ID           string     `dynamodbav:"id"`
-	CustomerName string     `dynamodbav:"customerName"`
-	Date         *time.Time `dynamodbav:"issueDate"`
-	Status       int        `dynamodbav:"status"`
-	Items        []dItem    `dynamodbav:"items"`
-	CreatedAt    time.Time  `dynamodbav:"createdAt"`
-	UpdatedAt    time.Time  `dynamodbav:"updatedAt"`
+	PK           string            `dynamodbav:"pk"`
+	SK           string            `dynamodbav:"sk"`
+	ID           string            `dynamodbav:"id"`
+	CustomerName string            `dynamodbav:"customerName"`
+	Date         *time.Time        `dynamodbav:"issueDate"`
+	IssueAt      *time.Time        `dynamodbav:"issueAt,omitempty"`
+	DunningLevel int               `dynamodbav:"dunningLevel,omitempty"`
+	DunningAt    *time.Time        `dynamodbav:"dunningAt,omitempty"`
+	Delivery     *invoice.Delivery `dynamodbav:"delivery,omitempty"`
+	Status       int               `dynamodbav:"status"`
+	Items        []dItem           `dynamodbav:"items,omitempty"`
+	Origins      []string          `dynamodbav:"origins,omitempty"`
+	Successors   []string          `dynamodbav:"successors,omitempty"`
+	CreatedAt    time.Time         `dynamodbav:"createdAt"`
+	UpdatedAt    time.Time         `dynamodbav:"updatedAt"`
 }
 
 func (dInv *dInvoice) InvoiceMarshal() invoice.Invoice {
@@ -42,44 +66,98 @@ func (dInv *dInvoice) InvoiceMarshal() invoice.Invoice {
 		ID:           dInv.ID,
 		CustomerName: dInv.CustomerName,
 		Date:         dInv.Date,
+		IssueAt:      dInv.IssueAt,
+		DunningLevel: dInv.DunningLevel,
+		DunningAt:    dInv.DunningAt,
+		Delivery:     dInv.Delivery,
 		Status:       invoice.Status(dInv.Status),
 		Items:        items,
+		Origins:      dInv.Origins,
+		Successors:   dInv.Successors,
 		CreatedAt:    dInv.CreatedAt,
 		UpdatedAt:    dInv.UpdatedAt,
 	}
 }
 
+// header returns a copy of the invoice header row without embedded items.
+func (dInv *dInvoice) header() dInvoice {
+	h := *dInv
+	h.Items = nil
+	return h
+}
+
 func invoiceUnmarshal(inv invoice.Invoice) *dInvoice {
+	pk := dInvoicePartitionKey(inv.ID)
+
 	dItems := make([]dItem, 0, len(inv.Items))
 	for _, invItem := range inv.Items {
 		dItem := invoiceItemUnmarshal(invItem)
+		dItem.PK = pk
+		dItem.SK = dItemSortKey(invItem.ID)
 		dItems = append(dItems, dItem)
 	}
 
-	pk := dInvoicePartitionKey(inv.ID)
 	return &dInvoice{
 		PK:           pk,
+		SK:           dInvoiceSortKey(inv.ID),
 		ID:           inv.ID,
 		CustomerName: inv.CustomerName,
 		Date:         inv.Date,
+		IssueAt:      inv.IssueAt,
+		DunningLevel: inv.DunningLevel,
+		DunningAt:    inv.DunningAt,
+		Delivery:     inv.Delivery,
 		Status:       int(inv.Status),
 		Items:        dItems,
+		Origins:      inv.Origins,
+		Successors:   inv.Successors,
 		CreatedAt:    inv.CreatedAt,
 		UpdatedAt:    inv.UpdatedAt,
 	}
 }
 
-func getItemOutputUnmarshal(output *dynamodb.GetItemOutput) (*dInvoice, error) {
-	if output == nil || len(output.Item) == 0 {
-		return nil, nil
+// rowsUnmarshal assembles an invoice from the rows of its item collection. It
+// returns nil when the collection does not contain the invoice header row.
+func rowsUnmarshal(rows []map[string]*dynamodb.AttributeValue) (*dInvoice, error) {
+	var (
+		dInv   *dInvoice
+		dItems []dItem
+	)
+
+	for _, row := range rows {
+		var key struct {
+			SK string `dynamodbav:"sk"`
+		}
+		if err := dynamodbattribute.UnmarshalMap(row, &key); err != nil {
+			return nil, err
+		}
+
+		if strings.HasPrefix(key.SK, dEventSKPrefix+dKeyDelim) {
+			continue // outbox events are not part of the invoice
+		}
+		if strings.HasPrefix(key.SK, dItemSKPrefix+dKeyDelim) {
+			var di dItem
+			if err := dynamodbattribute.UnmarshalMap(row, &di); err != nil {
+				return nil, err
+			}
+			dItems = append(dItems, di)
+			continue
+		}
+
+		var h dInvoice
+		if err := dynamodbattribute.UnmarshalMap(row, &h); err != nil {
+			return nil, err
+		}
+		dInv = &h
 	}
 
-	var dInv dInvoice
-	if err := dynamodbattribute.UnmarshalMap(output.Item, &dInv); err != nil {
-		return nil, err
+	if dInv == nil {
+		return nil, nil
 	}
 
-	return &dInv, nil
+	// legacy rows keep items embedded, they are merged with the item rows
+	dInv.Items = append(dInv.Items, dItems...)
+	return dInv, nil
 }
 
 // unmarshalDinvoice unmarshals value v to an instance of dInvoice.
@@ -88,8 +166,8 @@ func unmarshalDinvoice(v interface{}) (*dInvoice, error) {
 		return invoiceUnmarshal(inv), nil
 	}
 
-	if output, ok := v.(*dynamodb.GetItemOutput); ok {
-		return getItemOutputUnmarshal(output)
+	if rows, ok := v.([]map[string]*dynamodb.AttributeValue); ok {
+		return rowsUnmarshal(rows)
 	}
 
 	return nil, errUnknownUnmarshalSource
@@ -100,11 +178,26 @@ func dInvoicePartitionKey(id string) string {
 	return fmt.Sprintf("%s%s%s", dInvoicePKPrefix, dKeyDelim, id)
 }
 
+// dInvoiceSortKey builds invoice header row sort key based on invoice id.
+func dInvoiceSortKey(id string) string {
+	return dInvoicePartitionKey(id)
+}
+
+// dItemSortKey builds invoice item row sort key based on item id.
+func dItemSortKey(id string) string {
+	return fmt.Sprintf("%s%s%s", dItemSKPrefix, dKeyDelim, id)
+}
+
+// dItem is an invoice item row. Partition and sort keys are empty for the
+// items embedded in the legacy header row.
 type dItem struct {
+	PK          string    `dynamodbav:"pk,omitempty"`
+	SK          string    `dynamodbav:"sk,omitempty"`
 	ID          string    `dynamodbav:"id"`
 	ProductName string    `dynamodbav:"productName"`
 	Price       int       `dynamodbav:"price"`
 	Qty         int       `dynamodbav:"qty"`
+	Origin      string    `dynamodbav:"origin,omitempty"`
 	CreatedAt   time.Time `dynamodbav:"createdAt"`
 }
 
@@ -114,74 +207,125 @@ func (di *dItem) InvoiceItemMarshal() invoice.Item {
 		ProductName: di.ProductName,
 		Price:       di.Price,
 		Qty:         di.Qty,
+		Origin:      di.Origin,
 		CreatedAt:   di.CreatedAt,
 	}
 }
 
+func (di *dItem) equal(other *dItem) bool {
+	item, otherItem := di.InvoiceItemMarshal(), other.InvoiceItemMarshal()
+	return di.PK == other.PK && di.SK == other.SK && item.Equal(&otherItem)
+}
+
 func invoiceItemUnmarshal(item invoice.Item) dItem {
 	return dItem{
 		ID:          item.ID,
 		ProductName: item.ProductName,
 		Price:       item.Price,
 		Qty:         item.Qty,
+		Origin:      item.Origin,
 		CreatedAt:   item.CreatedAt,
 	}
 }
 
+// API is the part of the DynamoDB client used by the storage. Calls are made
+// with the context of the retry attempt.
 type API interface {
-	GetItem(*dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error)
-	PutItem(*dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error)
+	QueryWithContext(aws.Context, *dynamodb.QueryInput, ...request.Option) (*dynamodb.QueryOutput, error)
+	ScanWithContext(aws.Context, *dynamodb.ScanInput, ...request.Option) (*dynamodb.ScanOutput, error)
+	TransactWriteItemsWithContext(aws.Context, *dynamodb.TransactWriteItemsInput,
+		...request.Option) (*dynamodb.TransactWriteItemsOutput, error)
+	UpdateItemWithContext(aws.Context, *dynamodb.UpdateItemInput, ...request.Option) (*dynamodb.UpdateItemOutput, error)
 }
 
 type Dynamo struct {
-	client API
+	client *retryAPI
 	table  string
 }
 
 var _ invoice.Storage = (*Dynamo)(nil)
+var _ invoice.Transactor = (*Dynamo)(nil)
+
+// New creates DynamoDB storage. Calls to DynamoDB failed with transient errors
+// retried according to DefaultRetryPolicy, unless other policy provided with
+// WithRetryPolicy option.
+func New(client API, table string, opts ...Option) *Dynamo {
+	dopts := defaultOptions
+	for _, o := range opts {
+		o.apply(&dopts)
+	}
 
-func New(client API, table string) *Dynamo {
 	return &Dynamo{
-		client: client,
+		client: newRetryAPI(client, dopts.retryPolicy),
 		table:  table,
 	}
 }
 
+// RetryStats returns counters of DynamoDB calls and retries made by storage.
+func (d *Dynamo) RetryStats() RetryStats {
+	return d.client.counters.stats()
+}
+
+// AddInvoice puts the invoice header row, when it does not exist, with the
+// item rows in a single transaction. Item rows not fitting the transaction are
+// put after it in batches, a failure there leaves the invoice with part of its
+// items.
 func (d *Dynamo) AddInvoice(inv invoice.Invoice) error {
-	cond := expression.Name("id").NotEqual(expression.Value(inv.ID))
-	expr, err := expression.NewBuilder().
-		WithCondition(cond).
-		Build()
+	writes, err := d.addWrites(inv)
 	if err != nil {
 		return err
 	}
 
-	err = d.upsertInvoice(inv, expr)
+	n := len(writes)
+	if n > dMaxTransactItems {
+		n = dMaxTransactItems
+	}
+	err = d.transactWrite(writes[:n])
 	if isConditionalCheckError(err) {
 		return fmt.Errorf("invoice %q exists", inv.ID)
 	}
+	if err != nil {
+		return err
+	}
 
-	return err
+	return d.batchWrite(writes[n:])
 }
 
-func (d *Dynamo) FindInvoice(id string) (*invoice.Invoice, error) {
-	primaryKey := map[string]string{"pk": dInvoicePartitionKey(id)}
-	pk, err := dynamodbattribute.MarshalMap(primaryKey)
+// addWrites returns write actions that put the invoice header row, when it does
+// not exist, and the item rows.
+func (d *Dynamo) addWrites(inv invoice.Invoice) ([]*dynamodb.TransactWriteItem, error) {
+	cond := expression.Name("id").NotEqual(expression.Value(inv.ID))
+	expr, err := expression.NewBuilder().
+		WithCondition(cond).
+		Build()
 	if err != nil {
 		return nil, err
 	}
 
-	input := &dynamodb.GetItemInput{
-		TableName: aws.String(d.table),
-		Key:       pk,
+	dInv, err := unmarshalDinvoice(inv)
+	if err != nil {
+		return nil, err
 	}
 
-	result, err := d.client.GetItem(input)
+	header, err := d.putHeader(dInv, expr)
 	if err != nil {
 		return nil, err
 	}
 
-	dInv, err := unmarshalDinvoice(result)
+	writes := []*dynamodb.TransactWriteItem{header}
+	for i := range dInv.Items {
+		put, err := d.putItem(&dInv.Items[i])
+		if err != nil {
+			return nil, err
+		}
+		writes = append(writes, put)
+	}
+
+	return writes, nil
+}
+
+func (d *Dynamo) FindInvoice(id string) (*invoice.Invoice, error) {
+	dInv, err := d.findDinvoice(id)
 	if err != nil {
 		return nil, err
 	}
@@ -193,50 +337,291 @@ func (d *Dynamo) FindInvoice(id string) (*invoice.Invoice, error) {
 	return &inv, nil
 }
 
+// UpdateInvoice writes only the invoice changes made since the invoice was
+// stored: changed header attributes updated in place, added or changed items
+// put and deleted items removed. Items embedded in the legacy header row are
+// moved to the separate rows.
+//
+// Update succeeds only when the stored invoice was not changed by another
+// writer since the caller read it: the update time of inv should equal the
+// stored one.
 func (d *Dynamo) UpdateInvoice(inv invoice.Invoice) error {
-	cond := expression.Name("id").Equal(expression.Value(inv.ID))
-	expr, err := expression.NewBuilder().
-		WithCondition(cond).
-		Build()
+	cur, err := d.findDinvoice(inv.ID)
 	if err != nil {
 		return err
 	}
+	if cur == nil {
+		return fmt.Errorf("invoice %q not found", inv.ID)
+	}
+	version := inv.UpdatedAt
+	if !cur.UpdatedAt.Equal(version) {
+		return &invoice.ConflictError{ID: inv.ID}
+	}
 
 	inv.UpdatedAt = time.Now()
-	err = d.upsertInvoice(inv, expr)
+	next, err := unmarshalDinvoice(inv)
+	if err != nil {
+		return err
+	}
+
+	cs, err := newChangeSet(cur, next)
+	if err != nil {
+		return err
+	}
+
+	expr, err := updateExpr(version, cs)
+	if err != nil {
+		return err
+	}
+
+	if cs.itemsChanged() {
+		err = d.updateWithItems(next, expr, cs)
+	} else {
+		err = d.updateHeader(next, expr)
+	}
+
 	if isConditionalCheckError(err) {
-		return fmt.Errorf("invoice %q not found", inv.ID)
+		// the retried write fails its condition when the timed out or lost
+		// attempt was applied, then the stored version is the written one
+		cur, ferr := d.findDinvoice(inv.ID)
+		switch {
+		case ferr == nil && cur == nil:
+			return fmt.Errorf("invoice %q not found", inv.ID)
+		case ferr == nil && cur.UpdatedAt.Equal(next.UpdatedAt):
+			return nil
+		}
+		return &invoice.ConflictError{ID: inv.ID}
 	}
 
 	return err
 }
 
-// upsertInvoice inserts or updates an invoice depending on provided expression.
-func (d *Dynamo) upsertInvoice(inv invoice.Invoice, expr expression.Expression) error {
-	dinv, err := unmarshalDinvoice(inv)
+// updateHeader updates invoice header row attributes.
+func (d *Dynamo) updateHeader(dInv *dInvoice, expr expression.Expression) error {
+	key, err := headerKey(dInv)
 	if err != nil {
 		return err
 	}
 
-	item, err := dynamodbattribute.MarshalMap(dinv)
+	input := &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(d.table),
+		Key:                       key,
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		ConditionExpression:       expr.Condition(),
+		UpdateExpression:          expr.Update(),
+	}
+
+	_, err = d.client.UpdateItem(input)
+	return err
+}
+
+// updateWithItems atomically updates invoice header row attributes and writes
+// the item rows changes.
+func (d *Dynamo) updateWithItems(dInv *dInvoice, expr expression.Expression, cs *changeSet) error {
+	writes, err := d.updateWrites(dInv, expr, cs)
 	if err != nil {
 		return err
 	}
+	return d.transactWrite(writes)
+}
+
+// updateWrites returns write actions that update invoice header row attributes
+// and write the item rows changes.
+func (d *Dynamo) updateWrites(dInv *dInvoice, expr expression.Expression, cs *changeSet) ([]*dynamodb.TransactWriteItem, error) {
+	key, err := headerKey(dInv)
+	if err != nil {
+		return nil, err
+	}
+
+	writes := []*dynamodb.TransactWriteItem{{
+		Update: &dynamodb.Update{
+			TableName:                 aws.String(d.table),
+			Key:                       key,
+			ExpressionAttributeNames:  expr.Names(),
+			ExpressionAttributeValues: expr.Values(),
+			ConditionExpression:       expr.Condition(),
+			UpdateExpression:          expr.Update(),
+		},
+	}}
+
+	for i := range cs.puts {
+		put, err := d.putItem(&cs.puts[i])
+		if err != nil {
+			return nil, err
+		}
+		writes = append(writes, put)
+	}
+
+	for i := range cs.deletes {
+		del, err := d.deleteItem(&cs.deletes[i])
+		if err != nil {
+			return nil, err
+		}
+		writes = append(writes, del)
+	}
+
+	return writes, nil
+}
+
+// updateExpr builds the header row update of the change set, conditioned on
+// the stored invoice update time still equal to the version.
+func updateExpr(version time.Time, cs *changeSet) (expression.Expression, error) {
+	cond := expression.AttributeExists(expression.Name("pk")).
+		And(expression.Name("updatedAt").Equal(expression.Value(version)))
+	return expression.NewBuilder().
+		WithCondition(cond).
+		WithUpdate(cs.header).
+		Build()
+}
+
+// findDinvoice queries all rows of the invoice item collection and assembles
+// the invoice from them. It returns nil when invoice not found.
+func (d *Dynamo) findDinvoice(id string) (*dInvoice, error) {
+	rows, err := d.queryCollection(id)
+	if err != nil {
+		return nil, err
+	}
+	return unmarshalDinvoice(rows)
+}
 
-	input := &dynamodb.PutItemInput{
+// queryCollection returns all rows of the invoice item collection.
+func (d *Dynamo) queryCollection(id string) ([]map[string]*dynamodb.AttributeValue, error) {
+	keyCond := expression.Key("pk").Equal(expression.Value(dInvoicePartitionKey(id)))
+	expr, err := expression.NewBuilder().
+		WithKeyCondition(keyCond).
+		Build()
+	if err != nil {
+		return nil, err
+	}
+
+	input := &dynamodb.QueryInput{
 		TableName:                 aws.String(d.table),
-		Item:                      item,
+		KeyConditionExpression:    expr.KeyCondition(),
 		ExpressionAttributeNames:  expr.Names(),
 		ExpressionAttributeValues: expr.Values(),
-		ConditionExpression:       expr.Condition(),
+		ConsistentRead:            aws.Bool(true),
+	}
+
+	var rows []map[string]*dynamodb.AttributeValue
+	for {
+		output, err := d.client.Query(input)
+		if err != nil {
+			return nil, err
+		}
+		if output == nil {
+			break
+		}
+
+		rows = append(rows, output.Items...)
+		if len(output.LastEvaluatedKey) == 0 {
+			break
+		}
+		input.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+
+	return rows, nil
+}
+
+func (d *Dynamo) putHeader(dInv *dInvoice, expr expression.Expression) (*dynamodb.TransactWriteItem, error) {
+	item, err := dynamodbattribute.MarshalMap(dInv.header())
+	if err != nil {
+		return nil, err
+	}
+
+	return &dynamodb.TransactWriteItem{
+		Put: &dynamodb.Put{
+			TableName:                 aws.String(d.table),
+			Item:                      item,
+			ExpressionAttributeNames:  expr.Names(),
+			ExpressionAttributeValues: expr.Values(),
+			ConditionExpression:       expr.Condition(),
+		},
+	}, nil
+}
+
+func (d *Dynamo) putItem(di *dItem) (*dynamodb.TransactWriteItem, error) {
+	item, err := dynamodbattribute.MarshalMap(di)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dynamodb.TransactWriteItem{
+		Put: &dynamodb.Put{
+			TableName: aws.String(d.table),
+			Item:      item,
+		},
+	}, nil
+}
+
+func (d *Dynamo) deleteItem(di *dItem) (*dynamodb.TransactWriteItem, error) {
+	key, err := rowKey(di.PK, di.SK)
+	if err != nil {
+		return nil, err
 	}
 
-	_, err = d.client.PutItem(input)
+	return &dynamodb.TransactWriteItem{
+		Delete: &dynamodb.Delete{
+			TableName: aws.String(d.table),
+			Key:       key,
+		},
+	}, nil
+}
+
+// headerKey returns the primary key of the invoice header row.
+func headerKey(dInv *dInvoice) (map[string]*dynamodb.AttributeValue, error) {
+	return rowKey(dInv.PK, dInv.SK)
+}
+
+// rowKey returns the primary key of the item collection row.
+func rowKey(pk, sk string) (map[string]*dynamodb.AttributeValue, error) {
+	return dynamodbattribute.MarshalMap(map[string]string{"pk": pk, "sk": sk})
+}
+
+// transactWrite atomically applies write actions. The first action is expected
+// to be the invoice header write.
+func (d *Dynamo) transactWrite(writes []*dynamodb.TransactWriteItem) error {
+	if len(writes) > dMaxTransactItems {
+		return errTooManyWrites
+	}
+
+	input := &dynamodb.TransactWriteItemsInput{TransactItems: writes}
+	_, err := d.client.TransactWriteItems(input)
 	return err
 }
 
+// batchWrite applies write actions in transactions of up to 100 actions. The
+// actions of different transactions are not applied atomically.
+func (d *Dynamo) batchWrite(writes []*dynamodb.TransactWriteItem) error {
+	for len(writes) > 0 {
+		n := len(writes)
+		if n > dMaxTransactItems {
+			n = dMaxTransactItems
+		}
+		if err := d.transactWrite(writes[:n]); err != nil {
+			return err
+		}
+		writes = writes[n:]
+	}
+	return nil
+}
+
+// isConditionalCheckError returns true when the condition of the invoice
+// header write failed.
 func isConditionalCheckError(err error) bool {
 	var aerr awserr.Error
-	return errors.As(err, &aerr) &&
-		aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+	if !errors.As(err, &aerr) {
+		return false
+	}
+
+	if aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return true
+	}
+
+	var terr *dynamodb.TransactionCanceledException
+	if errors.As(err, &terr) && len(terr.CancellationReasons) > 0 {
+		return aws.StringValue(terr.CancellationReasons[0].Code) == dConditionalCheckFailed
+	}
+
+	return false
 }
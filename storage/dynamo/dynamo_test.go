@@ -3,14 +3,22 @@ package dynamo_test
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
 	"path"
 	"testing"
+	"time"
 
 	"github.com/antklim/go-invoice/invoice"
 	"github.com/antklim/go-invoice/storage/dynamo"
+	"github.com/antklim/go-invoice/storage/storagetest"
+	"github.com/antklim/go-invoice/test/fakes"
 	"github.com/antklim/go-invoice/test/mocks"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
 )
 
 func TestInvoicePK(t *testing.T) {
@@ -38,15 +46,34 @@ func TestInvoiceMarshalUnmarshal(t *testing.T) {
 		}
 	})
 
-	t.Run("dInvoice - get item output unmarshal", func(t *testing.T) {
+	t.Run("dInvoice - item collection rows unmarshal", func(t *testing.T) {
+		inv := invoice.NewInvoice("John Doe")
+		for i := 0; i < 2; i++ {
+			if err := inv.AddItem(invoice.NewItem("pen", 1000, 3)); err != nil {
+				t.Errorf("inv.AddItem() failed: %v", err)
+			}
+		}
+
+		rows := collectionRows(t, inv)
+		dInv, err := dynamo.UnmarshalDinvoice(rows)
+		if err != nil {
+			t.Errorf("UnmarshalDinvoice(%v) failed: %v", rows, err)
+		}
+
+		if got := dInv.InvoiceMarshal(); !inv.Equal(&got) {
+			t.Errorf("invalid invoice %v, want %v", got, inv)
+		}
+	})
+
+	t.Run("dInvoice - legacy rows unmarshal", func(t *testing.T) {
 		{
-			output := (*dynamodb.GetItemOutput)(nil)
-			dInv, err := dynamo.UnmarshalDinvoice(output)
+			rows := []map[string]*dynamodb.AttributeValue(nil)
+			dInv, err := dynamo.UnmarshalDinvoice(rows)
 			if err != nil {
-				t.Errorf("UnmarshalDinvoice(%v) failed: %v", output, err)
+				t.Errorf("UnmarshalDinvoice(%v) failed: %v", rows, err)
 			}
 			if dInv != nil {
-				t.Errorf("UnmarshalDinvoice(%v): %v, want nil", output, dInv)
+				t.Errorf("UnmarshalDinvoice(%v): %v, want nil", rows, dInv)
 			}
 		}
 
@@ -89,9 +116,10 @@ func TestInvoiceMarshalUnmarshal(t *testing.T) {
 						t.Fatalf("failed to Decode test data: %v", err)
 					}
 
-					dInv, err := dynamo.UnmarshalDinvoice(&output)
+					rows := []map[string]*dynamodb.AttributeValue{output.Item}
+					dInv, err := dynamo.UnmarshalDinvoice(rows)
 					if err != nil {
-						t.Errorf("UnmarshalDinvoice(%v) failed: %v", output, err)
+						t.Errorf("UnmarshalDinvoice(%v) failed: %v", rows, err)
 					}
 
 					if dInv.ID != tC.id {
@@ -124,37 +152,38 @@ func TestAddInvoice(t *testing.T) {
 		client := mocks.NewDynamoAPI()
 		strg := dynamo.New(client, "invoices")
 		inv := invoice.NewInvoice("John Doe")
+		for i := 0; i < 2; i++ {
+			if err := inv.AddItem(invoice.NewItem("pen", 1000, 3)); err != nil {
+				t.Errorf("inv.AddItem() failed: %v", err)
+			}
+		}
 
 		if err := strg.AddInvoice(inv); err != nil {
 			t.Errorf("AddInvoice(%v) failed: %v", inv, err)
 		}
 
-		if got, want := client.CalledTimes("PutItem"), 1; got != want {
-			t.Errorf("client.PutItem() called %d times, want %d call(s)", got, want)
+		if got, want := client.CalledTimes("TransactWriteItems"), 1; got != want {
+			t.Errorf("client.TransactWriteItems() called %d times, want %d call(s)", got, want)
 		}
 
-		ncall := 1
-		input := client.NthCall("PutItem", ncall)
-		if input == nil {
-			t.Fatalf("input of PutItem call #%d is nil", ncall)
+		writes := transactWrites(t, client, 1)
+		if got, want := len(writes), len(inv.Items)+1; got != want {
+			t.Fatalf("invalid number of write actions %d, want %d", got, want)
 		}
 
-		if dinput, ok := input.(*dynamodb.PutItemInput); !ok {
-			t.Errorf("type of PutItem input is %T, want *dynamodb.PutItemInput", input)
-		} else {
-			testPutItemInput(t, inv, dinput)
-			testAddItemConditionExression(t, inv.ID, dinput)
-		}
+		testPutHeader(t, inv, writes[0])
+		testAddItemConditionExression(t, inv.ID, writes[0].Put)
+		testPutItems(t, writes[1:], inv.ID, inv.Items)
 	})
 
 	t.Run("handles DynamoDB errors", func(t *testing.T) {
-		client := mocks.NewDynamoAPI(mocks.WithPutItemError(errors.New("DynamoDB PutItem failed")))
+		client := mocks.NewDynamoAPI(mocks.WithTransactWriteItemsError(errors.New("DynamoDB TransactWriteItems failed")))
 		strg := dynamo.New(client, "invoices")
 		inv := invoice.NewInvoice("John Doe")
 
 		if err := strg.AddInvoice(inv); err == nil {
 			t.Errorf("expected AddInvoice(%v) to fail", inv)
-		} else if got, want := err.Error(), `DynamoDB PutItem failed`; got != want {
+		} else if got, want := err.Error(), `DynamoDB TransactWriteItems failed`; got != want {
 			t.Errorf("AddInvoice(%v) = %v, want %v", inv, got, want)
 		}
 	})
@@ -170,73 +199,633 @@ func TestFindInvoice(t *testing.T) {
 			t.Errorf("FindInvoice(%q) failed: %v", invID, err)
 		}
 
-		if got, want := client.CalledTimes("GetItem"), 1; got != want {
-			t.Errorf("client.GetItem() called %d times, want %d call(s)", got, want)
+		if got, want := client.CalledTimes("Query"), 1; got != want {
+			t.Errorf("client.Query() called %d times, want %d call(s)", got, want)
 		}
 
 		ncall := 1
-		input := client.NthCall("GetItem", ncall)
+		input := client.NthCall("Query", ncall)
 		if input == nil {
-			t.Fatalf("input of GetItem call #%d is nil", ncall)
+			t.Fatalf("input of Query call #%d is nil", ncall)
 		}
 
-		if dinput, ok := input.(*dynamodb.GetItemInput); !ok {
-			t.Errorf("type of GetItem input is %T, want *dynamodb.GetItemInput", input)
+		if dinput, ok := input.(*dynamodb.QueryInput); !ok {
+			t.Errorf("type of Query input is %T, want *dynamodb.QueryInput", input)
 		} else {
-			testGetItemInput(t, invID, dinput)
+			testQueryInput(t, invID, dinput)
+		}
+	})
+
+	t.Run("assembles invoice from item collection", func(t *testing.T) {
+		inv := invoice.NewInvoice("John Doe")
+		if err := inv.AddItem(invoice.NewItem("pen", 1000, 3)); err != nil {
+			t.Errorf("inv.AddItem() failed: %v", err)
+		}
+
+		client := mocks.NewDynamoAPI(mocks.WithQueryOutput(&dynamodb.QueryOutput{Items: collectionRows(t, inv)}))
+		strg := dynamo.New(client, "invoices")
+
+		vinv, err := strg.FindInvoice(inv.ID)
+		if err != nil {
+			t.Fatalf("FindInvoice(%q) failed: %v", inv.ID, err)
+		}
+		if !inv.Equal(vinv) {
+			t.Errorf("invalid invoice %v, want %v", vinv, inv)
 		}
 	})
 
 	t.Run("handles DynamoDB errors", func(t *testing.T) {
-		client := mocks.NewDynamoAPI(mocks.WithGetItemError(errors.New("DynamoDB GetItem failed")))
+		client := mocks.NewDynamoAPI(mocks.WithQueryError(errors.New("DynamoDB Query failed")))
 		strg := dynamo.New(client, "invoices")
 		invID := "123"
 
 		if _, err := strg.FindInvoice(invID); err == nil {
 			t.Errorf("expected FindInvoice(%q) to fail", invID)
-		} else if got, want := err.Error(), `DynamoDB GetItem failed`; got != want {
+		} else if got, want := err.Error(), `DynamoDB Query failed`; got != want {
 			t.Errorf("FindInvoice(%q) = %v, want %v", invID, got, want)
 		}
 	})
 }
 
 func TestUpdateInvoice(t *testing.T) {
-	t.Run("builds correct DynamoDB input", func(t *testing.T) {
-		client := mocks.NewDynamoAPI()
-		strg := dynamo.New(client, "invoices")
+	t.Run("updates changed header attributes only", func(t *testing.T) {
 		inv := invoice.NewInvoice("John Doe")
+		inv.Items = []invoice.Item{invoice.NewItem("pen", 1000, 3)}
 
+		client := mocks.NewDynamoAPI(mocks.WithQueryOutput(&dynamodb.QueryOutput{Items: collectionRows(t, inv)}))
+		strg := dynamo.New(client, "invoices")
+
+		stored := inv
+		if err := inv.Cancel(); err != nil {
+			t.Fatalf("inv.Cancel() failed: %v", err)
+		}
 		if err := strg.UpdateInvoice(inv); err != nil {
 			t.Errorf("UpdateInvoice(%v) failed: %v", inv, err)
 		}
 
-		if got, want := client.CalledTimes("PutItem"), 1; got != want {
-			t.Errorf("client.PutItem() called %d times, want %d call(s)", got, want)
+		if got, want := client.CalledTimes("TransactWriteItems"), 0; got != want {
+			t.Errorf("client.TransactWriteItems() called %d times, want %d call(s)", got, want)
+		}
+		if got, want := client.CalledTimes("UpdateItem"), 1; got != want {
+			t.Errorf("client.UpdateItem() called %d times, want %d call(s)", got, want)
 		}
 
 		ncall := 1
-		input := client.NthCall("PutItem", ncall)
+		input := client.NthCall("UpdateItem", ncall)
 		if input == nil {
-			t.Fatalf("input of PutItem call #%d is nil", ncall)
+			t.Fatalf("input of UpdateItem call #%d is nil", ncall)
 		}
 
-		if dinput, ok := input.(*dynamodb.PutItemInput); !ok {
-			t.Errorf("type of PutItem input is %T, want *dynamodb.PutItemInput", input)
-		} else {
-			testPutItemInput(t, inv, dinput)
-			testUpdateItemConditionExression(t, inv.ID, dinput)
+		dinput, ok := input.(*dynamodb.UpdateItemInput)
+		if !ok {
+			t.Fatalf("type of UpdateItem input is %T, want *dynamodb.UpdateItemInput", input)
 		}
+
+		testUpdateHeader(t, stored, &dynamodb.Update{
+			TableName:                 dinput.TableName,
+			Key:                       dinput.Key,
+			ConditionExpression:       dinput.ConditionExpression,
+			UpdateExpression:          dinput.UpdateExpression,
+			ExpressionAttributeNames:  dinput.ExpressionAttributeNames,
+			ExpressionAttributeValues: dinput.ExpressionAttributeValues,
+		}, []string{"status", "updatedAt"}, nil)
 	})
 
-	t.Run("handles DynamoDB errors", func(t *testing.T) {
-		client := mocks.NewDynamoAPI(mocks.WithPutItemError(errors.New("DynamoDB PutItem failed")))
+	t.Run("writes item changes with header update", func(t *testing.T) {
+		inv := invoice.NewInvoice("John Doe")
+		kept, deleted := invoice.NewItem("pen", 1000, 3), invoice.NewItem("book", 2000, 1)
+		inv.Items = []invoice.Item{kept, deleted}
+
+		client := mocks.NewDynamoAPI(mocks.WithQueryOutput(&dynamodb.QueryOutput{Items: collectionRows(t, inv)}))
+		strg := dynamo.New(client, "invoices")
+
+		stored := inv
+		added := invoice.NewItem("ruler", 500, 2)
+		inv.Items = []invoice.Item{kept, added}
+		if err := strg.UpdateInvoice(inv); err != nil {
+			t.Errorf("UpdateInvoice(%v) failed: %v", inv, err)
+		}
+
+		if got, want := client.CalledTimes("TransactWriteItems"), 1; got != want {
+			t.Errorf("client.TransactWriteItems() called %d times, want %d call(s)", got, want)
+		}
+
+		writes := transactWrites(t, client, 1)
+		if got, want := len(writes), 3; got != want {
+			t.Fatalf("invalid number of write actions %d, want %d", got, want)
+		}
+
+		testUpdateHeader(t, stored, writes[0].Update, []string{"updatedAt"}, nil)
+		testPutItems(t, writes[1:2], inv.ID, []invoice.Item{added})
+		testDeleteItem(t, writes[2], inv.ID, deleted.ID)
+	})
+
+	t.Run("moves items embedded in legacy row to item rows", func(t *testing.T) {
+		inv := invoice.NewInvoice("John Doe")
+		inv.Items = []invoice.Item{invoice.NewItem("pen", 1000, 3)}
+
+		dInv, err := dynamo.UnmarshalDinvoice(inv)
+		if err != nil {
+			t.Fatalf("UnmarshalDinvoice(%v) failed: %v", inv, err)
+		}
+		for i := range dInv.Items {
+			dInv.Items[i].PK, dInv.Items[i].SK = "", ""
+		}
+		row, err := dynamodbattribute.MarshalMap(dInv)
+		if err != nil {
+			t.Fatalf("dynamodbattribute.MarshalMap() failed: %v", err)
+		}
+
+		output := &dynamodb.QueryOutput{Items: []map[string]*dynamodb.AttributeValue{row}}
+		client := mocks.NewDynamoAPI(mocks.WithQueryOutput(output))
+		strg := dynamo.New(client, "invoices")
+
+		if err := strg.UpdateInvoice(inv); err != nil {
+			t.Errorf("UpdateInvoice(%v) failed: %v", inv, err)
+		}
+
+		writes := transactWrites(t, client, 1)
+		if got, want := len(writes), 2; got != want {
+			t.Fatalf("invalid number of write actions %d, want %d", got, want)
+		}
+		testUpdateHeader(t, inv, writes[0].Update, []string{"updatedAt"}, []string{"items"})
+		testPutItems(t, writes[1:], inv.ID, inv.Items)
+	})
+
+	t.Run("fails when invoice not found", func(t *testing.T) {
+		client := mocks.NewDynamoAPI()
+		strg := dynamo.New(client, "invoices")
+		inv := invoice.NewInvoice("John Doe")
+
+		if err := strg.UpdateInvoice(inv); err == nil {
+			t.Errorf("expected UpdateInvoice(%v) to fail", inv)
+		} else if got, want := err.Error(), fmt.Sprintf("invoice %q not found", inv.ID); got != want {
+			t.Errorf("UpdateInvoice(%v) = %v, want %v", inv, got, want)
+		}
+
+		if got, want := client.CalledTimes("UpdateItem"), 0; got != want {
+			t.Errorf("client.UpdateItem() called %d times, want %d call(s)", got, want)
+		}
+	})
+
+	t.Run("fails when invoice updated concurrently", func(t *testing.T) {
+		inv := invoice.NewInvoice("John Doe")
+		aerr := awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+		client := mocks.NewDynamoAPI(
+			mocks.WithQueryOutput(&dynamodb.QueryOutput{Items: collectionRows(t, inv)}),
+			mocks.WithUpdateItemError(aerr))
 		strg := dynamo.New(client, "invoices")
+
+		if err := strg.UpdateInvoice(inv); err == nil {
+			t.Errorf("expected UpdateInvoice(%v) to fail", inv)
+		} else if got, want := err.Error(), fmt.Sprintf("invoice %q was updated concurrently", inv.ID); got != want {
+			t.Errorf("UpdateInvoice(%v) = %v, want %v", inv, got, want)
+		}
+	})
+
+	t.Run("handles DynamoDB errors", func(t *testing.T) {
 		inv := invoice.NewInvoice("John Doe")
+		client := mocks.NewDynamoAPI(
+			mocks.WithQueryOutput(&dynamodb.QueryOutput{Items: collectionRows(t, inv)}),
+			mocks.WithUpdateItemError(errors.New("DynamoDB UpdateItem failed")))
+		strg := dynamo.New(client, "invoices")
 
 		if err := strg.UpdateInvoice(inv); err == nil {
 			t.Errorf("expected UpdateInvoice(%v) to fail", inv)
-		} else if got, want := err.Error(), `DynamoDB PutItem failed`; got != want {
+		} else if got, want := err.Error(), `DynamoDB UpdateItem failed`; got != want {
+			t.Errorf("UpdateInvoice(%v) = %v, want %v", inv, got, want)
+		}
+	})
+}
+
+func TestMigrateLegacyTable(t *testing.T) {
+	t.Run("handles DynamoDB errors", func(t *testing.T) {
+		client := mocks.NewDynamoAPI(mocks.WithScanError(errors.New("DynamoDB Scan failed")))
+		strg := dynamo.New(client, "invoices")
+
+		if _, err := strg.MigrateLegacyTable("legacy"); err == nil {
+			t.Error("expected MigrateLegacyTable() to fail")
+		} else if got, want := err.Error(), `scan legacy table "legacy" failed: DynamoDB Scan failed`; got != want {
+			t.Errorf("MigrateLegacyTable() = %v, want %v", got, want)
+		}
+	})
+}
+
+func TestDynamoSemantics(t *testing.T) {
+	newStorage := func() (*dynamo.Dynamo, *fakes.DynamoDB) {
+		client := fakes.NewDynamoDB(
+			fakes.WithTable("invoices", "pk", "sk"),
+			fakes.WithIndex("invoices", "outbox", "outbox", "outboxAt"),
+			fakes.WithTable("legacy", "pk", ""))
+		return dynamo.New(client, "invoices"), client
+	}
+
+	t.Run("round-trips invoice with items", func(t *testing.T) {
+		strg, _ := newStorage()
+		inv := invoice.NewInvoice("John Doe")
+		inv.Items = []invoice.Item{invoice.NewItem("pen", 1000, 3), invoice.NewItem("book", 2000, 1)}
+
+		if err := strg.AddInvoice(inv); err != nil {
+			t.Fatalf("AddInvoice(%v) failed: %v", inv, err)
+		}
+
+		vinv, err := strg.FindInvoice(inv.ID)
+		if err != nil {
+			t.Fatalf("FindInvoice(%q) failed: %v", inv.ID, err)
+		}
+		if !inv.Equal(vinv) {
+			t.Errorf("invalid invoice %v, want %v", vinv, inv)
+		}
+
+		if _, err := vinv.DeleteItem(inv.Items[0].ID); err != nil {
+			t.Fatalf("DeleteItem() failed: %v", err)
+		}
+		if err := vinv.AddItem(invoice.NewItem("ruler", 500, 2)); err != nil {
+			t.Fatalf("AddItem() failed: %v", err)
+		}
+		if err := strg.UpdateInvoice(*vinv); err != nil {
+			t.Fatalf("UpdateInvoice(%v) failed: %v", vinv, err)
+		}
+
+		uinv, err := strg.FindInvoice(inv.ID)
+		if err != nil {
+			t.Fatalf("FindInvoice(%q) failed: %v", inv.ID, err)
+		}
+		if len(uinv.Items) != 2 || uinv.ContainsItem(inv.Items[0].ID) {
+			t.Errorf("invalid invoice items %v", uinv.Items)
+		}
+	})
+
+	t.Run("fails to add existing invoice", func(t *testing.T) {
+		strg, _ := newStorage()
+		inv := invoice.NewInvoice("John Doe")
+
+		if err := strg.AddInvoice(inv); err != nil {
+			t.Fatalf("AddInvoice(%v) failed: %v", inv, err)
+		}
+		if err := strg.AddInvoice(inv); err == nil {
+			t.Errorf("expected second call AddInvoice(%v) to fail", inv)
+		} else if got, want := err.Error(), fmt.Sprintf("invoice %q exists", inv.ID); got != want {
+			t.Errorf("second call AddInvoice(%v) = %v, want %v", inv, got, want)
+		}
+	})
+
+	t.Run("fails to overwrite concurrent update", func(t *testing.T) {
+		strg, client := newStorage()
+		inv := invoice.NewInvoice("John Doe")
+		if err := strg.AddInvoice(inv); err != nil {
+			t.Fatalf("AddInvoice(%v) failed: %v", inv, err)
+		}
+
+		// another writer changes the invoice after it was read by the storage
+		racer := dynamo.New(&racingAPI{DynamoDB: client, race: func() {
+			other := inv
+			other.CustomerName = "John Wick"
+			if err := strg.UpdateInvoice(other); err != nil {
+				t.Fatalf("UpdateInvoice(%v) failed: %v", other, err)
+			}
+		}}, "invoices")
+
+		if err := racer.UpdateInvoice(inv); err == nil {
+			t.Errorf("expected UpdateInvoice(%v) to fail", inv)
+		} else if got, want := err.Error(), fmt.Sprintf("invoice %q was updated concurrently", inv.ID); got != want {
 			t.Errorf("UpdateInvoice(%v) = %v, want %v", inv, got, want)
 		}
 	})
+
+	t.Run("fails to overwrite changes made after the caller read", func(t *testing.T) {
+		strg, _ := newStorage()
+		inv := invoice.NewInvoice("John Doe")
+		if err := strg.AddInvoice(inv); err != nil {
+			t.Fatalf("AddInvoice(%v) failed: %v", inv, err)
+		}
+
+		first, err := strg.FindInvoice(inv.ID)
+		if err != nil {
+			t.Fatalf("FindInvoice(%q) failed: %v", inv.ID, err)
+		}
+		second, err := strg.FindInvoice(inv.ID)
+		if err != nil {
+			t.Fatalf("FindInvoice(%q) failed: %v", inv.ID, err)
+		}
+
+		first.Items = append(first.Items, invoice.NewItem("pen", 100, 1))
+		if err := strg.UpdateInvoice(*first); err != nil {
+			t.Fatalf("UpdateInvoice(%v) failed: %v", first, err)
+		}
+
+		second.CustomerName = "John Wick"
+		if err := strg.UpdateInvoice(*second); err == nil {
+			t.Errorf("expected UpdateInvoice(%v) to fail", second)
+		} else if got, want := err.Error(), fmt.Sprintf("invoice %q was updated concurrently", inv.ID); got != want {
+			t.Errorf("UpdateInvoice(%v) = %v, want %v", second, got, want)
+		}
+
+		vinv, err := strg.FindInvoice(inv.ID)
+		if err != nil {
+			t.Fatalf("FindInvoice(%q) failed: %v", inv.ID, err)
+		}
+		if vinv.CustomerName != "John Doe" || len(vinv.Items) != 1 {
+			t.Errorf("invalid invoice after conflicting update %v", vinv)
+		}
+	})
+
+	t.Run("fails to update concurrently deleted invoice", func(t *testing.T) {
+		strg, client := newStorage()
+		inv := invoice.NewInvoice("John Doe")
+		if err := strg.AddInvoice(inv); err != nil {
+			t.Fatalf("AddInvoice(%v) failed: %v", inv, err)
+		}
+
+		// another writer deletes the invoice after it was read by the storage
+		racer := dynamo.New(&racingAPI{DynamoDB: client, race: func() {
+			_, err := client.DeleteItem(&dynamodb.DeleteItemInput{
+				TableName: aws.String("invoices"),
+				Key: map[string]*dynamodb.AttributeValue{
+					"pk": {S: aws.String(dynamo.InvoicePartitionKey(inv.ID))},
+					"sk": {S: aws.String(dynamo.InvoiceSortKey(inv.ID))},
+				},
+			})
+			if err != nil {
+				t.Fatalf("DeleteItem() failed: %v", err)
+			}
+		}}, "invoices")
+
+		inv.CustomerName = "John Wick"
+		if err := racer.UpdateInvoice(inv); err == nil {
+			t.Errorf("expected UpdateInvoice(%v) to fail", inv)
+		} else if got, want := err.Error(), fmt.Sprintf("invoice %q not found", inv.ID); got != want {
+			t.Errorf("UpdateInvoice(%v) = %v, want %v", inv, got, want)
+		}
+	})
+
+	t.Run("fails transaction on concurrent update", func(t *testing.T) {
+		strg, client := newStorage()
+		inv1 := invoice.NewInvoice("John Doe")
+		inv2 := invoice.NewInvoice("Jane Doe")
+		for _, inv := range []invoice.Invoice{inv1, inv2} {
+			if err := strg.AddInvoice(inv); err != nil {
+				t.Fatalf("AddInvoice(%v) failed: %v", inv, err)
+			}
+		}
+
+		err := strg.RunInTx(func(tx invoice.Storage) error {
+			for _, inv := range []invoice.Invoice{inv1, inv2} {
+				vinv, err := tx.FindInvoice(inv.ID)
+				if err != nil {
+					return err
+				}
+				vinv.Items = append(vinv.Items, invoice.NewItem("pen", 100, 1))
+				if err := tx.UpdateInvoice(*vinv); err != nil {
+					return err
+				}
+			}
+
+			other := inv2
+			other.CustomerName = "Jane Smith"
+			return dynamo.New(client, "invoices").UpdateInvoice(other)
+		})
+		if err == nil {
+			t.Fatal("RunInTx() expected to fail")
+		} else if got, want := err.Error(), fmt.Sprintf("invoice %q was updated concurrently", inv2.ID); got != want {
+			t.Errorf("RunInTx() = %v, want %v", got, want)
+		}
+
+		for _, inv := range []invoice.Invoice{inv1, inv2} {
+			vinv, err := strg.FindInvoice(inv.ID)
+			if err != nil || vinv == nil {
+				t.Fatalf("FindInvoice(%q) = %v, %v", inv.ID, vinv, err)
+			}
+			if len(vinv.Items) != 0 {
+				t.Errorf("invalid invoice items %v, want none", vinv.Items)
+			}
+		}
+	})
+
+	t.Run("fails transaction of too many writes", func(t *testing.T) {
+		strg, _ := newStorage()
+		inv := invoice.NewInvoice("John Doe")
+		for i := 0; i < 100; i++ {
+			inv.Items = append(inv.Items, invoice.NewItem("pen", 100, 1))
+		}
+
+		err := strg.RunInTx(func(tx invoice.Storage) error {
+			return tx.AddInvoice(inv)
+		})
+		if err == nil {
+			t.Fatal("RunInTx() expected to fail")
+		}
+		if vinv, err := strg.FindInvoice(inv.ID); err != nil || vinv != nil {
+			t.Errorf("FindInvoice(%q) = %v, %v, want no invoice", inv.ID, vinv, err)
+		}
+	})
+
+	t.Run("adds invoice of more items than a transaction holds", func(t *testing.T) {
+		strg, client := newStorage()
+		inv := invoice.NewInvoice("John Doe")
+		for i := 0; i < 150; i++ {
+			inv.Items = append(inv.Items, invoice.NewItem("pen", 100, 1))
+		}
+
+		if err := strg.AddInvoice(inv); err != nil {
+			t.Fatalf("AddInvoice(%v) failed: %v", inv, err)
+		}
+		if vinv, err := strg.FindInvoice(inv.ID); err != nil || !inv.Equal(vinv) {
+			t.Errorf("FindInvoice(%q) = %v, %v, want %v", inv.ID, vinv, err, inv)
+		}
+
+		other := inv
+		other.Items = []invoice.Item{invoice.NewItem("book", 1000, 1)}
+		if err := strg.AddInvoice(other); err == nil {
+			t.Errorf("AddInvoice(%v) expected to fail", other)
+		}
+		output, err := client.Query(&dynamodb.QueryInput{
+			TableName:                 aws.String("invoices"),
+			KeyConditionExpression:    aws.String("pk = :pk"),
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":pk": {S: aws.String("INVOICE#" + inv.ID)}},
+		})
+		if err != nil {
+			t.Fatalf("Query() failed: %v", err)
+		}
+		if got, want := len(output.Items), 151; got != want {
+			t.Errorf("invalid number of invoice rows %d, want %d", got, want)
+		}
+	})
+
+	t.Run("migrates legacy invoice of more items than a transaction holds", func(t *testing.T) {
+		fake := fakes.NewDynamoDB(
+			fakes.WithTable("invoices", "pk", "sk"),
+			fakes.WithTable("legacy", "pk", ""))
+		client := mocks.NewFaultyDynamoAPI(fake,
+			mocks.WithFaults("TransactWriteItems", nil, errors.New("connection lost")))
+		strg := dynamo.New(client, "invoices")
+
+		testData, err := os.Open("../../test/fixtures/get-item-issued-invoice.json")
+		if err != nil {
+			t.Fatalf("failed to open test data: %v", err)
+		}
+		var output dynamodb.GetItemOutput
+		err = json.NewDecoder(testData).Decode(&output)
+		testData.Close()
+		if err != nil {
+			t.Fatalf("failed to Decode test data: %v", err)
+		}
+		item := output.Item["items"].L[0]
+		output.Item["items"].L = nil
+		for i := 0; i < 150; i++ {
+			m := make(map[string]*dynamodb.AttributeValue, len(item.M))
+			for k, v := range item.M {
+				m[k] = v
+			}
+			m["id"] = &dynamodb.AttributeValue{S: aws.String(fmt.Sprintf("item-%03d", i))}
+			output.Item["items"].L = append(output.Item["items"].L, &dynamodb.AttributeValue{M: m})
+		}
+		if _, err := fake.PutItem(&dynamodb.PutItemInput{TableName: aws.String("legacy"), Item: output.Item}); err != nil {
+			t.Fatalf("PutItem() failed: %v", err)
+		}
+
+		if _, err := strg.MigrateLegacyTable("legacy"); err == nil {
+			t.Fatal("MigrateLegacyTable() expected to fail")
+		}
+		// restarted migration puts the rest of the item rows
+		if n, err := strg.MigrateLegacyTable("legacy"); err != nil || n != 1 {
+			t.Fatalf("MigrateLegacyTable() = %d, %v, want 1 invoice migrated", n, err)
+		}
+		if n, err := strg.MigrateLegacyTable("legacy"); err != nil || n != 0 {
+			t.Fatalf("MigrateLegacyTable() = %d, %v, want no invoices migrated", n, err)
+		}
+
+		invID := "170bf55e-ca81-4a17-99ad-54f6411d610c"
+		inv, err := strg.FindInvoice(invID)
+		if err != nil {
+			t.Fatalf("FindInvoice(%q) failed: %v", invID, err)
+		}
+		if inv == nil || len(inv.Items) != 150 {
+			t.Errorf("invalid migrated invoice %v", inv)
+		}
+	})
+
+	t.Run("queries outbox events through the index", func(t *testing.T) {
+		client := mocks.NewFaultyDynamoAPI(fakes.NewDynamoDB(fakes.WithTable("invoices", "pk", "sk"),
+			fakes.WithIndex("invoices", "outbox", "outbox", "outboxAt")))
+		strg := dynamo.New(client, "invoices")
+
+		at := time.Now()
+		var want []string
+		for i := 0; i < 3; i++ {
+			inv := invoice.NewInvoice("John Doe")
+			inv.Items = append(inv.Items, invoice.NewItem("pen", 100, 1))
+			e := invoice.Event{ID: fmt.Sprintf("event-%d", i), Type: invoice.EventCreated,
+				At: at.Add(time.Duration(-i) * time.Second), Invoice: inv}
+			err := strg.RunInTx(func(tx invoice.Storage) error {
+				if err := tx.AddInvoice(inv); err != nil {
+					return err
+				}
+				return tx.(invoice.EventWriter).WriteEvent(e)
+			})
+			if err != nil {
+				t.Fatalf("RunInTx() failed: %v", err)
+			}
+			want = append([]string{e.ID}, want...)
+		}
+
+		queries := client.CalledTimes("Query")
+		events, err := strg.OutboxEvents(2)
+		if err != nil {
+			t.Fatalf("OutboxEvents() failed: %v", err)
+		}
+		var got []string
+		for _, e := range events {
+			got = append(got, e.ID)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want[:2]) {
+			t.Errorf("invalid outbox events %v, want %v", got, want[:2])
+		}
+		if n := client.CalledTimes("Scan"); n != 0 {
+			t.Errorf("client.Scan() called %d times, want no calls", n)
+		}
+		if n := client.CalledTimes("Query") - queries; n != 1 {
+			t.Errorf("client.Query() called %d times, want 1 call", n)
+		}
+	})
+
+	t.Run("migrates legacy table", func(t *testing.T) {
+		strg, client := newStorage()
+
+		for _, file := range []string{"get-item-open-invoice.json", "get-item-issued-invoice.json"} {
+			testData, err := os.Open(path.Join("../../test/fixtures", file))
+			if err != nil {
+				t.Fatalf("failed to open test data: %v", err)
+			}
+
+			var output dynamodb.GetItemOutput
+			err = json.NewDecoder(testData).Decode(&output)
+			testData.Close()
+			if err != nil {
+				t.Fatalf("failed to Decode test data: %v", err)
+			}
+
+			input := &dynamodb.PutItemInput{TableName: aws.String("legacy"), Item: output.Item}
+			if _, err := client.PutItem(input); err != nil {
+				t.Fatalf("PutItem() failed: %v", err)
+			}
+		}
+
+		for i := 0; i < 2; i++ { // repeated migration skips migrated invoices
+			n, err := strg.MigrateLegacyTable("legacy")
+			if err != nil {
+				t.Fatalf("MigrateLegacyTable() failed: %v", err)
+			}
+			if want := 2 - 2*i; n != want {
+				t.Errorf("MigrateLegacyTable() migrated %d invoice(s), want %d", n, want)
+			}
+		}
+
+		invID := "170bf55e-ca81-4a17-99ad-54f6411d610c"
+		inv, err := strg.FindInvoice(invID)
+		if err != nil {
+			t.Fatalf("FindInvoice(%q) failed: %v", invID, err)
+		}
+		if inv == nil || inv.Status != invoice.Issued || len(inv.Items) != 1 {
+			t.Errorf("invalid migrated invoice %v", inv)
+		}
+
+		output, err := client.Query(&dynamodb.QueryInput{
+			TableName:                 aws.String("invoices"),
+			KeyConditionExpression:    aws.String("pk = :pk"),
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":pk": {S: aws.String("INVOICE#" + invID)}},
+		})
+		if err != nil {
+			t.Fatalf("Query() failed: %v", err)
+		}
+		if got, want := len(output.Items), 2; got != want {
+			t.Errorf("invalid number of migrated invoice rows %d, want %d", got, want)
+		}
+	})
+}
+
+// racingAPI calls race function after the first Query call, simulating another
+// writer changing data between storage read and write.
+type racingAPI struct {
+	*fakes.DynamoDB
+	race  func()
+	raced bool
+}
+
+func (api *racingAPI) QueryWithContext(ctx aws.Context, input *dynamodb.QueryInput,
+	opts ...request.Option) (*dynamodb.QueryOutput, error) {
+	output, err := api.DynamoDB.QueryWithContext(ctx, input, opts...)
+	if !api.raced {
+		api.raced = true
+		api.race()
+	}
+	return output, err
+}
+
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, storagetest.FactoryFunc(func() invoice.Storage {
+		client := fakes.NewDynamoDB(fakes.WithTable("invoices", "pk", "sk"),
+			fakes.WithIndex("invoices", "outbox", "outbox", "outboxAt"))
+		return dynamo.New(client, "invoices")
+	}))
 }
@@ -1,7 +1,19 @@
 package dynamo
 
+import "time"
+
 type Invoice = dInvoice
 type Item = dItem
 
 var InvoicePartitionKey = dInvoicePartitionKey
+var InvoiceSortKey = dInvoiceSortKey
+var ItemSortKey = dItemSortKey
 var UnmarshalDinvoice = unmarshalDinvoice
+
+// SetRetryClock replaces the clock and the jitter source of the storage
+// retries.
+func SetRetryClock(d *Dynamo, now func() time.Time, sleep func(time.Duration), jitter func(int64) int64) {
+	d.client.now = now
+	d.client.sleep = sleep
+	d.client.jitter = jitter
+}
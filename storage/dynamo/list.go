@@ -0,0 +1,98 @@
+package dynamo
+
+import (
+	"encoding/base64"
+	"encoding/json"
+
+	"github.com/antklim/go-invoice/invoice"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+	"github.com/pkg/errors"
+)
+
+var _ invoice.Lister = (*Dynamo)(nil)
+
+// ListInvoices scans invoice header rows of the table and reads every found
+// invoice collection. The limit is the maximum number of rows scanned, so the
+// page contains fewer invoices than limit when item rows are scanned. The cursor
+// is the encoded key of the last scanned row.
+func (d *Dynamo) ListInvoices(cursor string, limit int) ([]invoice.Invoice, string, error) {
+	filter := expression.Name("sk").BeginsWith(dInvoicePKPrefix + dKeyDelim)
+	expr, err := expression.NewBuilder().WithFilter(filter).Build()
+	if err != nil {
+		return nil, "", err
+	}
+
+	input := &dynamodb.ScanInput{
+		TableName:                 aws.String(d.table),
+		ConsistentRead:            aws.Bool(true),
+		FilterExpression:          expr.Filter(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	}
+	if limit > 0 {
+		input.Limit = aws.Int64(int64(limit))
+	}
+	if cursor != "" {
+		if input.ExclusiveStartKey, err = decodeCursor(cursor); err != nil {
+			return nil, "", err
+		}
+	}
+
+	output, err := d.client.Scan(input)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "list invoices failed")
+	}
+
+	var invs []invoice.Invoice
+	for _, row := range output.Items {
+		var key struct {
+			ID string `dynamodbav:"id"`
+		}
+		if err := dynamodbattribute.UnmarshalMap(row, &key); err != nil {
+			return nil, "", errors.Wrap(err, "list invoices failed")
+		}
+
+		dInv, err := d.findDinvoice(key.ID)
+		if err != nil {
+			return nil, "", err
+		}
+		if dInv != nil {
+			invs = append(invs, dInv.InvoiceMarshal())
+		}
+	}
+
+	var next string
+	if len(output.LastEvaluatedKey) > 0 {
+		if next, err = encodeCursor(output.LastEvaluatedKey); err != nil {
+			return nil, "", err
+		}
+	}
+	return invs, next, nil
+}
+
+func encodeCursor(key map[string]*dynamodb.AttributeValue) (string, error) {
+	var v map[string]string
+	if err := dynamodbattribute.UnmarshalMap(key, &v); err != nil {
+		return "", errors.Wrap(err, "encode cursor failed")
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", errors.Wrap(err, "encode cursor failed")
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeCursor(cursor string) (map[string]*dynamodb.AttributeValue, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid cursor %q", cursor)
+	}
+	var v map[string]string
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, errors.Wrapf(err, "invalid cursor %q", cursor)
+	}
+	return dynamodbattribute.MarshalMap(v)
+}
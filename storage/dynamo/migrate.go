@@ -0,0 +1,88 @@
+package dynamo
+
+import (
+	"github.com/antklim/go-invoice/invoice"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/pkg/errors"
+)
+
+// MigrateLegacyTable copies invoices from the table of the legacy layout, where
+// invoice items embedded in the invoice row, to the storage table. Invoices
+// that already exist in the storage table are skipped, which makes migration
+// resumable. It returns the number of migrated invoices.
+func (d *Dynamo) MigrateLegacyTable(table string) (int, error) {
+	input := &dynamodb.ScanInput{
+		TableName:      aws.String(table),
+		ConsistentRead: aws.Bool(true),
+	}
+
+	var migrated int
+	for {
+		output, err := d.client.Scan(input)
+		if err != nil {
+			return migrated, errors.Wrapf(err, "scan legacy table %q failed", table)
+		}
+		if output == nil {
+			break
+		}
+
+		for _, row := range output.Items {
+			ok, err := d.migrateLegacyRow(row)
+			if err != nil {
+				return migrated, err
+			}
+			if ok {
+				migrated++
+			}
+		}
+
+		if len(output.LastEvaluatedKey) == 0 {
+			break
+		}
+		input.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+
+	return migrated, nil
+}
+
+// migrateLegacyRow stores an invoice from the legacy row. It returns false when
+// the invoice already exists in the storage table. Item rows of the invoice
+// whose migration failed after the header row was written are put again.
+func (d *Dynamo) migrateLegacyRow(row map[string]*dynamodb.AttributeValue) (bool, error) {
+	dInv, err := unmarshalDinvoice([]map[string]*dynamodb.AttributeValue{row})
+	if err != nil {
+		return false, err
+	}
+	if dInv == nil {
+		return false, nil
+	}
+
+	found, err := d.findDinvoice(dInv.ID)
+	if err != nil {
+		return false, err
+	}
+	if found != nil {
+		if !found.UpdatedAt.Equal(dInv.UpdatedAt) || len(found.Items) >= len(dInv.Items) {
+			return false, nil
+		}
+		if err := d.putItems(dInv.InvoiceMarshal()); err != nil {
+			return false, errors.Wrapf(err, "migrate invoice %q failed", dInv.ID)
+		}
+		return true, nil
+	}
+
+	if err := d.AddInvoice(dInv.InvoiceMarshal()); err != nil {
+		return false, errors.Wrapf(err, "migrate invoice %q failed", dInv.ID)
+	}
+	return true, nil
+}
+
+// putItems puts the item rows of the invoice in batches.
+func (d *Dynamo) putItems(inv invoice.Invoice) error {
+	writes, err := d.addWrites(inv)
+	if err != nil {
+		return err
+	}
+	return d.batchWrite(writes[1:]) // the header row is the first write
+}
@@ -0,0 +1,32 @@
+package dynamo
+
+type options struct {
+	retryPolicy RetryPolicy
+}
+
+var defaultOptions = options{
+	retryPolicy: DefaultRetryPolicy,
+}
+
+type Option interface {
+	apply(*options)
+}
+
+type funcOption struct {
+	f func(*options)
+}
+
+func (f *funcOption) apply(o *options) {
+	f.f(o)
+}
+
+func newFuncOption(f func(*options)) Option {
+	return &funcOption{f: f}
+}
+
+// WithRetryPolicy sets the policy of DynamoDB calls retries.
+func WithRetryPolicy(v RetryPolicy) Option {
+	return newFuncOption(func(o *options) {
+		o.retryPolicy = v
+	})
+}
@@ -0,0 +1,164 @@
+package dynamo
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/antklim/go-invoice/invoice"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+	"github.com/pkg/errors"
+)
+
+const (
+	dEventSKPrefix = "EVENT"
+
+	// dEventTimeFormat is the fixed width time format of the event sort key,
+	// so the keys order events by time.
+	dEventTimeFormat = "2006-01-02T15:04:05.000000000Z"
+
+	// dOutboxIndex is the sparse global secondary index of the event rows.
+	// Only event rows have its key attributes, so the relay queries pending
+	// events without reading invoice rows.
+	dOutboxIndex = "outbox"
+	dOutboxKey   = 1
+)
+
+var _ invoice.Outbox = (*Dynamo)(nil)
+var _ invoice.EventWriter = (*dynamoTx)(nil)
+
+// dEvent is an outbox event row. Event rows are stored in the item collection
+// of the event invoice, so they are written by the same transaction as the
+// invoice change. The sort key orders events of the invoice by time. Outbox
+// and OutboxAt are the outbox index keys ordering events of all invoices by
+// time.
+type dEvent struct {
+	PK       string `dynamodbav:"pk"`
+	SK       string `dynamodbav:"sk"`
+	Outbox   int    `dynamodbav:"outbox"`
+	OutboxAt string `dynamodbav:"outboxAt"`
+	Event    string `dynamodbav:"event"` // JSON encoded event
+}
+
+func eventUnmarshal(e invoice.Event) (*dEvent, error) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	return &dEvent{
+		PK:       dInvoicePartitionKey(e.Invoice.ID),
+		SK:       dEventSortKey(e),
+		Outbox:   dOutboxKey,
+		OutboxAt: dEventTime(e),
+		Event:    string(data),
+	}, nil
+}
+
+// dEventSortKey builds event row sort key based on event time and id.
+func dEventSortKey(e invoice.Event) string {
+	return fmt.Sprintf("%s%s%s", dEventSKPrefix, dKeyDelim, dEventTime(e))
+}
+
+// dEventTime builds the time ordered event key of the event time and id.
+func dEventTime(e invoice.Event) string {
+	return fmt.Sprintf("%s%s%s", e.At.UTC().Format(dEventTimeFormat), dKeyDelim, e.ID)
+}
+
+// WriteEvent stages the event row written on commit.
+func (tx *dynamoTx) WriteEvent(e invoice.Event) error {
+	e.Invoice = e.Invoice.Clone()
+	tx.events = append(tx.events, e)
+	return nil
+}
+
+// OutboxEvents queries event rows through the outbox index in time order.
+// The index is eventually consistent: an event written just now may be
+// returned by the next call, and a deleted event returned again, which the
+// at least once delivery of the relay allows.
+func (d *Dynamo) OutboxEvents(limit int) ([]invoice.Event, error) {
+	keyCond := expression.Key("outbox").Equal(expression.Value(dOutboxKey))
+	expr, err := expression.NewBuilder().WithKeyCondition(keyCond).Build()
+	if err != nil {
+		return nil, err
+	}
+
+	input := &dynamodb.QueryInput{
+		TableName:                 aws.String(d.table),
+		IndexName:                 aws.String(dOutboxIndex),
+		KeyConditionExpression:    expr.KeyCondition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	}
+	if limit > 0 {
+		input.Limit = aws.Int64(int64(limit))
+	}
+
+	var events []invoice.Event
+	for limit <= 0 || len(events) < limit {
+		output, err := d.client.Query(input)
+		if err != nil {
+			return nil, errors.Wrap(err, "query outbox failed")
+		}
+
+		for _, row := range output.Items {
+			var de dEvent
+			if err := dynamodbattribute.UnmarshalMap(row, &de); err != nil {
+				return nil, errors.Wrap(err, "query outbox failed")
+			}
+			var e invoice.Event
+			if err := json.Unmarshal([]byte(de.Event), &e); err != nil {
+				return nil, errors.Wrapf(err, "outbox event %q invalid", de.SK)
+			}
+			events = append(events, e)
+		}
+
+		if len(output.LastEvaluatedKey) == 0 {
+			break
+		}
+		input.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+
+	if limit > 0 && len(events) > limit {
+		events = events[:limit]
+	}
+	return events, nil
+}
+
+// DeleteOutboxEvents deletes event rows in transactions of up to 100 rows.
+func (d *Dynamo) DeleteOutboxEvents(events []invoice.Event) error {
+	var writes []*dynamodb.TransactWriteItem
+	for _, e := range events {
+		key, err := rowKey(dInvoicePartitionKey(e.Invoice.ID), dEventSortKey(e))
+		if err != nil {
+			return err
+		}
+		writes = append(writes, &dynamodb.TransactWriteItem{
+			Delete: &dynamodb.Delete{
+				TableName: aws.String(d.table),
+				Key:       key,
+			},
+		})
+	}
+
+	return errors.Wrap(d.batchWrite(writes), "delete outbox events failed")
+}
+
+func (d *Dynamo) putEvent(e invoice.Event) (*dynamodb.TransactWriteItem, error) {
+	de, err := eventUnmarshal(e)
+	if err != nil {
+		return nil, err
+	}
+	item, err := dynamodbattribute.MarshalMap(de)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dynamodb.TransactWriteItem{
+		Put: &dynamodb.Put{
+			TableName: aws.String(d.table),
+			Item:      item,
+		},
+	}, nil
+}
@@ -0,0 +1,265 @@
+package dynamo
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/google/uuid"
+)
+
+// RetryPolicy configures retries of the DynamoDB calls failed with transient
+// errors, such as throttling or network errors.
+//
+// The delay before the retry n (starting from 1) is a random duration between
+// zero and min(MaxDelay, BaseDelay * 2^(n-1)) ("full jitter"). Retries stop
+// when MaxAttempts calls made or when the next retry would start after the
+// call Deadline.
+//
+// Every attempt is cancelled after AttemptTimeout, and the timed out attempt is
+// retried. The call is cancelled when the Deadline is reached, whatever
+// attempt is running.
+type RetryPolicy struct {
+	MaxAttempts    int           // maximum number of attempts, including the first one
+	BaseDelay      time.Duration // delay before the first retry
+	MaxDelay       time.Duration // upper bound of the delay between attempts, zero means no bound
+	AttemptTimeout time.Duration // maximum time of an attempt, zero means no timeout
+	Deadline       time.Duration // maximum time spent on a call including retries, zero means no deadline
+}
+
+// DefaultRetryPolicy is used when no retry policy configured.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    5,                     // nolint:gomnd
+	BaseDelay:      50 * time.Millisecond, // nolint:gomnd
+	MaxDelay:       2 * time.Second,       // nolint:gomnd
+	AttemptTimeout: 3 * time.Second,       // nolint:gomnd
+	Deadline:       10 * time.Second,      // nolint:gomnd
+}
+
+// NoRetryPolicy disables retries.
+var NoRetryPolicy = RetryPolicy{MaxAttempts: 1}
+
+// delay returns the delay before the retry n, n starts from 1.
+func (p RetryPolicy) delay(n int, rnd func(int64) int64) time.Duration {
+	ceiling := p.BaseDelay << (n - 1)
+	if ceiling>>(n-1) != p.BaseDelay { // overflow
+		ceiling = math.MaxInt64 - 1
+	}
+	if p.MaxDelay > 0 && ceiling > p.MaxDelay {
+		ceiling = p.MaxDelay
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(rnd(int64(ceiling) + 1))
+}
+
+// retryableCodes are the codes of DynamoDB and AWS SDK errors that are safe to
+// retry.
+var retryableCodes = map[string]struct{}{
+	dynamodb.ErrCodeProvisionedThroughputExceededException: {},
+	dynamodb.ErrCodeRequestLimitExceeded:                   {},
+	dynamodb.ErrCodeInternalServerError:                    {},
+	dynamodb.ErrCodeTransactionConflictException:           {},
+	dynamodb.ErrCodeTransactionInProgressException:         {},
+	"ThrottlingException":                                  {},
+	"ServiceUnavailable":                                   {},
+	request.ErrCodeRequestError:                            {},
+	request.ErrCodeResponseTimeout:                         {},
+}
+
+// retryableCancellationReasons are the transaction cancellation reasons that
+// make the whole transaction safe to retry.
+var retryableCancellationReasons = map[string]struct{}{
+	"ThrottlingError":                    {},
+	"TransactionConflict":                {},
+	"ProvisionedThroughputExceeded":      {},
+	dynamodb.ErrCodeRequestLimitExceeded: {},
+	dynamodb.ErrCodeInternalServerError:  {},
+}
+
+// isRetryable returns true when the error is transient and the call can be
+// retried. Cancelled transaction retryable only when every failed action was
+// cancelled for a transient reason; failed conditions are final.
+func isRetryable(err error) bool {
+	var aerr awserr.Error
+	if !errors.As(err, &aerr) {
+		return false
+	}
+
+	if _, ok := retryableCodes[aerr.Code()]; ok {
+		return true
+	}
+
+	var terr *dynamodb.TransactionCanceledException
+	if !errors.As(err, &terr) {
+		return false
+	}
+
+	retryable := false
+	for _, r := range terr.CancellationReasons {
+		code := aws.StringValue(r.Code)
+		if code == "" || code == "None" {
+			continue
+		}
+		if _, ok := retryableCancellationReasons[code]; !ok {
+			return false
+		}
+		retryable = true
+	}
+	return retryable
+}
+
+// RetryStats contains counters of the DynamoDB calls made by the storage.
+type RetryStats struct {
+	Calls     int64 // calls made by storage, retries not included
+	Retries   int64 // retries made
+	Exhausted int64 // calls failed with transient error after all attempts made
+}
+
+type retryCounters struct {
+	calls     int64
+	retries   int64
+	exhausted int64
+}
+
+func (c *retryCounters) stats() RetryStats {
+	return RetryStats{
+		Calls:     atomic.LoadInt64(&c.calls),
+		Retries:   atomic.LoadInt64(&c.retries),
+		Exhausted: atomic.LoadInt64(&c.exhausted),
+	}
+}
+
+// retryAPI is the DynamoDB API decorator that retries calls failed with
+// transient errors according to the retry policy.
+type retryAPI struct {
+	api      API
+	policy   RetryPolicy
+	counters retryCounters
+	sleep    func(time.Duration)
+	now      func() time.Time
+	jitter   func(n int64) int64 // returns random delay in [0, n)
+
+	mu  sync.Mutex // guards rnd
+	rnd *rand.Rand
+}
+
+func newRetryAPI(api API, policy RetryPolicy) *retryAPI {
+	r := &retryAPI{
+		api:    api,
+		policy: policy,
+		sleep:  time.Sleep,
+		now:    time.Now,
+		rnd:    rand.New(rand.NewSource(time.Now().UnixNano())), // nolint:gosec
+	}
+	r.jitter = r.random
+	return r
+}
+
+func (r *retryAPI) Query(input *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+	var output *dynamodb.QueryOutput
+	err := r.do(func(ctx aws.Context) (err error) {
+		output, err = r.api.QueryWithContext(ctx, input)
+		return err
+	})
+	return output, err
+}
+
+func (r *retryAPI) Scan(input *dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+	var output *dynamodb.ScanOutput
+	err := r.do(func(ctx aws.Context) (err error) {
+		output, err = r.api.ScanWithContext(ctx, input)
+		return err
+	})
+	return output, err
+}
+
+// TransactWriteItems sets the client request token, so the transaction retried
+// after a network error is not applied twice.
+func (r *retryAPI) TransactWriteItems(input *dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+	if input.ClientRequestToken == nil {
+		input.ClientRequestToken = aws.String(uuid.NewString())
+	}
+
+	var output *dynamodb.TransactWriteItemsOutput
+	err := r.do(func(ctx aws.Context) (err error) {
+		output, err = r.api.TransactWriteItemsWithContext(ctx, input)
+		return err
+	})
+	return output, err
+}
+
+func (r *retryAPI) UpdateItem(input *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+	var output *dynamodb.UpdateItemOutput
+	err := r.do(func(ctx aws.Context) (err error) {
+		output, err = r.api.UpdateItemWithContext(ctx, input)
+		return err
+	})
+	return output, err
+}
+
+// do calls f and retries it while it fails with transient errors, attempts
+// left and deadline not reached. It returns the error of the last attempt.
+func (r *retryAPI) do(f func(aws.Context) error) error {
+	atomic.AddInt64(&r.counters.calls, 1)
+	start := r.now()
+
+	ctx, cancel := context.Background(), context.CancelFunc(func() {})
+	if r.policy.Deadline > 0 {
+		ctx, cancel = context.WithTimeout(ctx, r.policy.Deadline)
+	}
+	defer cancel()
+
+	for attempt := 1; ; attempt++ {
+		err := r.attempt(ctx, f)
+		if err == nil || !isRetryable(err) {
+			return err
+		}
+
+		if attempt >= r.policy.MaxAttempts {
+			atomic.AddInt64(&r.counters.exhausted, 1)
+			return err
+		}
+
+		d := r.policy.delay(attempt, r.jitter)
+		if r.policy.Deadline > 0 && r.now().Add(d).Sub(start) >= r.policy.Deadline {
+			atomic.AddInt64(&r.counters.exhausted, 1)
+			return err
+		}
+
+		atomic.AddInt64(&r.counters.retries, 1)
+		r.sleep(d)
+	}
+}
+
+// attempt calls f with the attempt timeout. The attempt timed out before the
+// call deadline fails with the retryable response timeout error.
+func (r *retryAPI) attempt(ctx context.Context, f func(aws.Context) error) error {
+	if r.policy.AttemptTimeout <= 0 {
+		return f(ctx)
+	}
+
+	actx, cancel := context.WithTimeout(ctx, r.policy.AttemptTimeout)
+	defer cancel()
+
+	err := f(actx)
+	if err != nil && actx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+		return awserr.New(request.ErrCodeResponseTimeout, "attempt timed out", err)
+	}
+	return err
+}
+
+func (r *retryAPI) random(n int64) int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rnd.Int63n(n)
+}
@@ -0,0 +1,240 @@
+package dynamo_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/antklim/go-invoice/invoice"
+	"github.com/antklim/go-invoice/storage/dynamo"
+	"github.com/antklim/go-invoice/test/fakes"
+	"github.com/antklim/go-invoice/test/mocks"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func TestRetries(t *testing.T) {
+	throttled := func() error {
+		return awserr.New(dynamodb.ErrCodeProvisionedThroughputExceededException, "throughput exceeded", nil)
+	}
+	fastPolicy := dynamo.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+
+	newStorage := func(policy dynamo.RetryPolicy, opts ...mocks.FaultyDynamoAPIOption) (*dynamo.Dynamo, *mocks.FaultyDynamoAPI) {
+		client := mocks.NewFaultyDynamoAPI(fakes.NewDynamoDB(fakes.WithTable("invoices", "pk", "sk"),
+			fakes.WithIndex("invoices", "outbox", "outbox", "outboxAt")), opts...)
+		return dynamo.New(client, "invoices", dynamo.WithRetryPolicy(policy)), client
+	}
+
+	t.Run("retries transient errors", func(t *testing.T) {
+		strg, client := newStorage(fastPolicy,
+			mocks.WithFaults("TransactWriteItems", throttled(), awserr.New(request.ErrCodeRequestError, "connection reset", nil)))
+		inv := invoice.NewInvoice("John Doe")
+
+		if err := strg.AddInvoice(inv); err != nil {
+			t.Fatalf("AddInvoice(%v) failed: %v", inv, err)
+		}
+
+		if got, want := client.CalledTimes("TransactWriteItems"), 3; got != want {
+			t.Errorf("client.TransactWriteItems() called %d times, want %d call(s)", got, want)
+		}
+
+		want := dynamo.RetryStats{Calls: 1, Retries: 2}
+		if got := strg.RetryStats(); got != want {
+			t.Errorf("invalid retry stats %+v, want %+v", got, want)
+		}
+
+		if vinv, err := strg.FindInvoice(inv.ID); err != nil || vinv == nil {
+			t.Errorf("FindInvoice(%q) = %v, %v, want stored invoice", inv.ID, vinv, err)
+		}
+	})
+
+	t.Run("retries transactions cancelled for transient reasons", func(t *testing.T) {
+		cancelled := &dynamodb.TransactionCanceledException{
+			Message_: aws.String("Transaction cancelled"),
+			CancellationReasons: []*dynamodb.CancellationReason{
+				{Code: aws.String("TransactionConflict")},
+				{Code: aws.String("None")},
+			},
+		}
+		strg, client := newStorage(fastPolicy, mocks.WithFaults("TransactWriteItems", cancelled))
+		inv := invoice.NewInvoice("John Doe")
+
+		if err := strg.AddInvoice(inv); err != nil {
+			t.Fatalf("AddInvoice(%v) failed: %v", inv, err)
+		}
+		if got, want := client.CalledTimes("TransactWriteItems"), 2; got != want {
+			t.Errorf("client.TransactWriteItems() called %d times, want %d call(s)", got, want)
+		}
+	})
+
+	t.Run("does not retry permanent errors", func(t *testing.T) {
+		strg, client := newStorage(fastPolicy)
+		inv := invoice.NewInvoice("John Doe")
+
+		if err := strg.AddInvoice(inv); err != nil {
+			t.Fatalf("AddInvoice(%v) failed: %v", inv, err)
+		}
+		if err := strg.AddInvoice(inv); err == nil {
+			t.Fatalf("expected second call AddInvoice(%v) to fail", inv)
+		}
+
+		if got, want := client.CalledTimes("TransactWriteItems"), 2; got != want {
+			t.Errorf("client.TransactWriteItems() called %d times, want %d call(s)", got, want)
+		}
+		if got := strg.RetryStats().Retries; got != 0 {
+			t.Errorf("invalid number of retries %d, want 0", got)
+		}
+	})
+
+	t.Run("gives up when attempts exhausted", func(t *testing.T) {
+		strg, client := newStorage(fastPolicy, mocks.WithFaults("Query", throttled(), throttled(), throttled()))
+		invID := "123"
+
+		if _, err := strg.FindInvoice(invID); err == nil {
+			t.Fatalf("expected FindInvoice(%q) to fail", invID)
+		} else if got, want := err.Error(), throttled().Error(); got != want {
+			t.Errorf("FindInvoice(%q) = %v, want %v", invID, got, want)
+		}
+
+		if got, want := client.CalledTimes("Query"), 3; got != want {
+			t.Errorf("client.Query() called %d times, want %d call(s)", got, want)
+		}
+
+		want := dynamo.RetryStats{Calls: 1, Retries: 2, Exhausted: 1}
+		if got := strg.RetryStats(); got != want {
+			t.Errorf("invalid retry stats %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("gives up when call deadline reached", func(t *testing.T) {
+		policy := dynamo.RetryPolicy{
+			MaxAttempts: 10,
+			BaseDelay:   20 * time.Millisecond,
+			MaxDelay:    80 * time.Millisecond,
+			Deadline:    100 * time.Millisecond,
+		}
+		strg, client := newStorage(policy, mocks.WithFaults("Query",
+			throttled(), throttled(), throttled(), throttled(), throttled()))
+
+		// fake clock advanced by sleeps, jitter always picks the longest delay:
+		// 20ms, 40ms, then 80ms retry would start after the deadline
+		now := time.Now()
+		var slept []time.Duration
+		dynamo.SetRetryClock(strg,
+			func() time.Time { return now },
+			func(d time.Duration) {
+				slept = append(slept, d)
+				now = now.Add(d)
+			},
+			func(n int64) int64 { return n - 1 })
+
+		if _, err := strg.FindInvoice("123"); err == nil {
+			t.Fatal("expected FindInvoice() to fail")
+		}
+
+		if got, want := client.CalledTimes("Query"), 3; got != want {
+			t.Errorf("client.Query() called %d times, want %d call(s)", got, want)
+		}
+		want := []time.Duration{20 * time.Millisecond, 40 * time.Millisecond}
+		if len(slept) != len(want) || slept[0] != want[0] || slept[1] != want[1] {
+			t.Errorf("invalid delays between attempts %v, want %v", slept, want)
+		}
+		if got, want := strg.RetryStats(), (dynamo.RetryStats{Calls: 1, Retries: 2, Exhausted: 1}); got != want {
+			t.Errorf("invalid retry stats %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("retries timed out attempts", func(t *testing.T) {
+		policy := fastPolicy
+		policy.AttemptTimeout = 20 * time.Millisecond
+		strg, client := newStorage(policy, mocks.WithLatencies("Query", time.Minute))
+
+		start := time.Now()
+		if _, err := strg.FindInvoice("123"); err != nil {
+			t.Fatalf("FindInvoice() failed: %v", err)
+		}
+		if elapsed := time.Since(start); elapsed > 10*time.Second {
+			t.Errorf("FindInvoice() took %v, want the slow attempt to be cancelled", elapsed)
+		}
+		if got, want := client.CalledTimes("Query"), 2; got != want {
+			t.Errorf("client.Query() called %d times, want %d call(s)", got, want)
+		}
+	})
+
+	t.Run("cancels the attempt when call deadline reached", func(t *testing.T) {
+		policy := fastPolicy
+		policy.AttemptTimeout = time.Minute
+		policy.Deadline = 20 * time.Millisecond
+		strg, client := newStorage(policy, mocks.WithLatencies("Query", time.Minute))
+
+		if _, err := strg.FindInvoice("123"); err == nil {
+			t.Fatal("expected FindInvoice() to fail")
+		}
+		if got, want := client.CalledTimes("Query"), 1; got != want {
+			t.Errorf("client.Query() called %d times, want %d call(s)", got, want)
+		}
+	})
+
+	t.Run("does not bound delays when max delay is zero", func(t *testing.T) {
+		policy := dynamo.RetryPolicy{MaxAttempts: 4, BaseDelay: 10 * time.Millisecond}
+		strg, _ := newStorage(policy, mocks.WithFaults("Query", throttled(), throttled(), throttled()))
+
+		var slept []time.Duration
+		dynamo.SetRetryClock(strg, time.Now,
+			func(d time.Duration) { slept = append(slept, d) },
+			func(n int64) int64 { return n - 1 })
+
+		if _, err := strg.FindInvoice("123"); err != nil {
+			t.Fatalf("FindInvoice() failed: %v", err)
+		}
+		want := []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 40 * time.Millisecond}
+		if fmt.Sprint(slept) != fmt.Sprint(want) {
+			t.Errorf("invalid delays between attempts %v, want %v", slept, want)
+		}
+	})
+
+	t.Run("succeeds when retried update was applied by lost attempt", func(t *testing.T) {
+		strg, client := newStorage(fastPolicy,
+			mocks.WithLostResponses("UpdateItem", awserr.New(request.ErrCodeRequestError, "connection reset", nil)))
+		inv := invoice.NewInvoice("John Doe")
+		if err := strg.AddInvoice(inv); err != nil {
+			t.Fatalf("AddInvoice(%v) failed: %v", inv, err)
+		}
+
+		inv.CustomerName = "Jane Doe"
+		if err := strg.UpdateInvoice(inv); err != nil {
+			t.Fatalf("UpdateInvoice(%v) failed: %v", inv, err)
+		}
+		if got, want := client.CalledTimes("UpdateItem"), 2; got != want {
+			t.Errorf("client.UpdateItem() called %d times, want %d call(s)", got, want)
+		}
+		if vinv, err := strg.FindInvoice(inv.ID); err != nil || vinv.CustomerName != "Jane Doe" {
+			t.Errorf("FindInvoice(%q) = %v, %v, want updated invoice", inv.ID, vinv, err)
+		}
+	})
+
+	t.Run("uses the same client request token for transaction retries", func(t *testing.T) {
+		mock := mocks.NewDynamoAPI(mocks.WithTransactWriteItemsError(throttled()))
+		strg := dynamo.New(mock, "invoices", dynamo.WithRetryPolicy(fastPolicy))
+		inv := invoice.NewInvoice("John Doe")
+
+		if err := strg.AddInvoice(inv); err == nil {
+			t.Fatalf("expected AddInvoice(%v) to fail", inv)
+		}
+
+		var token string
+		for n := 1; n <= fastPolicy.MaxAttempts; n++ {
+			input, ok := mock.NthCall("TransactWriteItems", n).(*dynamodb.TransactWriteItemsInput)
+			if !ok {
+				t.Fatalf("invalid input of TransactWriteItems call #%d", n)
+			}
+			got := aws.StringValue(input.ClientRequestToken)
+			if got == "" || (token != "" && got != token) {
+				t.Errorf("invalid client request token %q of call #%d, want %q", got, n, token)
+			}
+			token = got
+		}
+	})
+}
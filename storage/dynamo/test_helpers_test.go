@@ -2,10 +2,13 @@ package dynamo_test
 
 import (
 	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/antklim/go-invoice/invoice"
 	"github.com/antklim/go-invoice/storage/dynamo"
+	"github.com/antklim/go-invoice/test/mocks"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
@@ -60,9 +63,35 @@ func testInvoiceItems(t *testing.T, dItems []dynamo.Item, items []invoice.Item)
 	}
 }
 
-func testPutItemInput(t *testing.T, inv invoice.Invoice, input *dynamodb.PutItemInput) {
+// transactWrites returns write actions of nth TransactWriteItems call.
+func transactWrites(t *testing.T, client *mocks.DynamoAPI, n int) []*dynamodb.TransactWriteItem {
+	t.Helper()
+
+	input := client.NthCall("TransactWriteItems", n)
+	if input == nil {
+		t.Fatalf("input of TransactWriteItems call #%d is nil", n)
+	}
+
+	dinput, ok := input.(*dynamodb.TransactWriteItemsInput)
+	if !ok {
+		t.Fatalf("type of TransactWriteItems input is %T, want *dynamodb.TransactWriteItemsInput", input)
+	}
+	return dinput.TransactItems
+}
+
+// testPutHeader tests that the write action puts invoice header row.
+func testPutHeader(t *testing.T, inv invoice.Invoice, write *dynamodb.TransactWriteItem) {
+	if write.Put == nil {
+		t.Fatalf("invalid header write %v, want Put", write)
+	}
+	input := write.Put
+
 	if got, want := aws.StringValue(input.TableName), "invoices"; got != want {
-		t.Errorf("invalid PutItemInput table %q, want %q", got, want)
+		t.Errorf("invalid Put table %q, want %q", got, want)
+	}
+
+	if _, ok := input.Item["items"]; ok {
+		t.Error("invoice header row should not embed items")
 	}
 
 	var dinv dynamo.Invoice
@@ -70,8 +99,12 @@ func testPutItemInput(t *testing.T, inv invoice.Invoice, input *dynamodb.PutItem
 		t.Fatalf("dynamodbattribute.UnmarshalMap() failed: %v", err)
 	}
 
-	if dinv.PK == "" {
-		t.Error("dInvoice.PK should not be empty")
+	if want := dynamo.InvoicePartitionKey(inv.ID); dinv.PK != want {
+		t.Errorf("invalid dInvoice.PK %q, want %q", dinv.PK, want)
+	}
+
+	if want := dynamo.InvoiceSortKey(inv.ID); dinv.SK != want {
+		t.Errorf("invalid dInvoice.SK %q, want %q", dinv.SK, want)
 	}
 
 	if dinv.ID != inv.ID {
@@ -92,8 +125,6 @@ func testPutItemInput(t *testing.T, inv invoice.Invoice, input *dynamodb.PutItem
 		t.Errorf("invalid dInvoice.Status %d, want %d", dinv.Status, inv.Status)
 	}
 
-	testInvoiceItems(t, dinv.Items, inv.Items)
-
 	if !dinv.CreatedAt.Equal(inv.CreatedAt) {
 		t.Errorf("invalid dInvoice.CreatedAt %v, want %v", dinv.CreatedAt, inv.CreatedAt)
 	}
@@ -103,48 +134,209 @@ func testPutItemInput(t *testing.T, inv invoice.Invoice, input *dynamodb.PutItem
 	}
 }
 
-func testAddItemConditionExression(t *testing.T, id string, input *dynamodb.PutItemInput) {
+// testPutItems tests that the write actions put item rows of the provided
+// items.
+func testPutItems(t *testing.T, writes []*dynamodb.TransactWriteItem, invID string, items []invoice.Item) {
+	dItems := make([]dynamo.Item, 0, len(writes))
+	for _, write := range writes {
+		if write.Put == nil {
+			t.Fatalf("invalid item write %v, want Put", write)
+		}
+
+		var ditem dynamo.Item
+		if err := dynamodbattribute.UnmarshalMap(write.Put.Item, &ditem); err != nil {
+			t.Fatalf("dynamodbattribute.UnmarshalMap() failed: %v", err)
+		}
+
+		if want := dynamo.InvoicePartitionKey(invID); ditem.PK != want {
+			t.Errorf("invalid dItem.PK %q, want %q", ditem.PK, want)
+		}
+		if want := dynamo.ItemSortKey(ditem.ID); ditem.SK != want {
+			t.Errorf("invalid dItem.SK %q, want %q", ditem.SK, want)
+		}
+
+		dItems = append(dItems, ditem)
+	}
+
+	testInvoiceItems(t, dItems, items)
+}
+
+func testAddItemConditionExression(t *testing.T, id string, input *dynamodb.Put) {
 	if got, want := aws.StringValue(input.ConditionExpression), "#0 <> :0"; got != want {
-		t.Errorf("PutItem condition expression %q, want %q", got, want)
+		t.Errorf("Put condition expression %q, want %q", got, want)
 	}
 
-	testPutItemExpressionAttribute(t, "0", "id", id, input)
+	testPutExpressionAttribute(t, "0", "id", id, input)
 }
 
-func testUpdateItemConditionExression(t *testing.T, id string, input *dynamodb.PutItemInput) {
-	if got, want := aws.StringValue(input.ConditionExpression), "#0 = :0"; got != want {
-		t.Errorf("PutItem condition expression %q, want %q", got, want)
+// testUpdateHeader tests that the header update is conditioned by the stored
+// invoice updatedAt value, sets only attributes set and removes only
+// attributes removed.
+func testUpdateHeader(t *testing.T, stored invoice.Invoice, input *dynamodb.Update, set, removed []string) {
+	if input == nil {
+		t.Fatal("invalid header write, want Update")
+	}
+
+	if got, want := aws.StringValue(input.TableName), "invoices"; got != want {
+		t.Errorf("invalid Update table %q, want %q", got, want)
+	}
+
+	var key struct {
+		PK string `dynamodbav:"pk"`
+		SK string `dynamodbav:"sk"`
+	}
+	if err := dynamodbattribute.UnmarshalMap(input.Key, &key); err != nil {
+		t.Fatalf("Update key unmarshal failed: %v", err)
+	}
+	if want := dynamo.InvoicePartitionKey(stored.ID); key.PK != want {
+		t.Errorf("Update key pk %q, want %q", key.PK, want)
+	}
+	if want := dynamo.InvoiceSortKey(stored.ID); key.SK != want {
+		t.Errorf("Update key sk %q, want %q", key.SK, want)
+	}
+
+	cond := aws.StringValue(input.ConditionExpression)
+	if want := "(attribute_exists (#0)) AND (#1 = :0)"; cond != want {
+		t.Errorf("Update condition expression %q, want %q", cond, want)
+	}
+	if got := aws.StringValue(input.ExpressionAttributeNames["#1"]); got != "updatedAt" {
+		t.Errorf("Update condition expression: #1 attribute name %q, want %q", got, "updatedAt")
+	}
+	var updatedAt time.Time
+	if err := dynamodbattribute.Unmarshal(input.ExpressionAttributeValues[":0"], &updatedAt); err != nil {
+		t.Fatalf("Update condition expression: unmarshal :0 attribute value failed: %v", err)
+	}
+	if !updatedAt.Equal(stored.UpdatedAt) {
+		t.Errorf("Update condition expression: :0 attribute value %v, want %v", updatedAt, stored.UpdatedAt)
+	}
+
+	gotSet, gotRemoved := updateAttributes(aws.StringValue(input.UpdateExpression), input.ExpressionAttributeNames)
+	if !equalStrings(gotSet, set) {
+		t.Errorf("Update sets attributes %v, want %v", gotSet, set)
 	}
-	testPutItemExpressionAttribute(t, "0", "id", id, input)
+	if !equalStrings(gotRemoved, removed) {
+		t.Errorf("Update removes attributes %v, want %v", gotRemoved, removed)
+	}
+}
+
+// updateAttributes returns sorted names of attributes set and removed by the
+// update expression.
+func updateAttributes(expr string, names map[string]*string) (set, removed []string) {
+	for _, clause := range strings.Split(strings.TrimSpace(expr), "\n") {
+		switch {
+		case strings.HasPrefix(clause, "SET "):
+			for _, action := range strings.Split(strings.TrimPrefix(clause, "SET "), ",") {
+				name := strings.TrimSpace(strings.SplitN(action, "=", 2)[0])
+				set = append(set, aws.StringValue(names[name]))
+			}
+		case strings.HasPrefix(clause, "REMOVE "):
+			for _, name := range strings.Split(strings.TrimPrefix(clause, "REMOVE "), ",") {
+				removed = append(removed, aws.StringValue(names[strings.TrimSpace(name)]))
+			}
+		}
+	}
+
+	sort.Strings(set)
+	sort.Strings(removed)
+	return set, removed
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
 }
 
-// testPutItemExpressionAttribute tests that expression attribute with the index
+// testDeleteItem tests that the write action deletes the item row.
+func testDeleteItem(t *testing.T, write *dynamodb.TransactWriteItem, invID, itemID string) {
+	if write.Delete == nil {
+		t.Fatalf("invalid item write %v, want Delete", write)
+	}
+
+	var key struct {
+		PK string `dynamodbav:"pk"`
+		SK string `dynamodbav:"sk"`
+	}
+	if err := dynamodbattribute.UnmarshalMap(write.Delete.Key, &key); err != nil {
+		t.Fatalf("dynamodbattribute.UnmarshalMap() failed: %v", err)
+	}
+	if want := dynamo.InvoicePartitionKey(invID); key.PK != want {
+		t.Errorf("invalid deleted item key pk %q, want %q", key.PK, want)
+	}
+	if want := dynamo.ItemSortKey(itemID); key.SK != want {
+		t.Errorf("invalid deleted item key sk %q, want %q", key.SK, want)
+	}
+}
+
+// testPutExpressionAttribute tests that expression attribute with the index
 // idx mapped to the expected field name and value val.
-func testPutItemExpressionAttribute(t *testing.T, idx, name, val string, input *dynamodb.PutItemInput) {
+func testPutExpressionAttribute(t *testing.T, idx, name, val string, input *dynamodb.Put) {
 	if got := aws.StringValue(input.ExpressionAttributeNames["#"+idx]); got != name {
-		t.Errorf("PutItem condition expression: #%s attribute name %q, want %q", idx, got, name)
+		t.Errorf("Put condition expression: #%s attribute name %q, want %q", idx, got, name)
 	}
 
 	var actual string
 	if err := dynamodbattribute.Unmarshal(input.ExpressionAttributeValues[":"+idx], &actual); err != nil {
-		t.Fatalf("PutItem condition expression: unmarshal :%s attribute value failed: %v", idx, err)
+		t.Fatalf("Put condition expression: unmarshal :%s attribute value failed: %v", idx, err)
 	}
 	if actual != val {
-		t.Errorf("PutItem condition expression: :%s attribute value %q, want %q", idx, actual, val)
+		t.Errorf("Put condition expression: :%s attribute value %q, want %q", idx, actual, val)
 	}
 }
 
-func testGetItemInput(t *testing.T, id string, input *dynamodb.GetItemInput) {
+func testQueryInput(t *testing.T, id string, input *dynamodb.QueryInput) {
 	if got, want := aws.StringValue(input.TableName), "invoices"; got != want {
-		t.Errorf("invalid GetItemInput table %q, want %q", got, want)
+		t.Errorf("invalid QueryInput table %q, want %q", got, want)
+	}
+
+	if got, want := aws.StringValue(input.KeyConditionExpression), "#0 = :0"; got != want {
+		t.Errorf("QueryInput key condition expression %q, want %q", got, want)
 	}
 
-	var pk struct{ PK string }
-	if err := dynamodbattribute.UnmarshalMap(input.Key, &pk); err != nil {
-		t.Fatalf("GetItemInput key unmarshal failed: %v", err)
+	if got, want := aws.StringValue(input.ExpressionAttributeNames["#0"]), "pk"; got != want {
+		t.Errorf("QueryInput key condition: #0 attribute name %q, want %q", got, want)
 	}
 
-	if want := "INVOICE#" + id; pk.PK != want {
-		t.Errorf("GetItemInput key value %q, want %q", pk.PK, want)
+	var pk string
+	if err := dynamodbattribute.Unmarshal(input.ExpressionAttributeValues[":0"], &pk); err != nil {
+		t.Fatalf("QueryInput key condition: unmarshal :0 attribute value failed: %v", err)
 	}
+
+	if want := "INVOICE#" + id; pk != want {
+		t.Errorf("QueryInput key value %q, want %q", pk, want)
+	}
+}
+
+// collectionRows builds rows of the invoice item collection.
+func collectionRows(t *testing.T, inv invoice.Invoice) []map[string]*dynamodb.AttributeValue {
+	t.Helper()
+
+	dInv, err := dynamo.UnmarshalDinvoice(inv)
+	if err != nil {
+		t.Fatalf("UnmarshalDinvoice(%v) failed: %v", inv, err)
+	}
+
+	items := dInv.Items
+	dInv.Items = nil
+	header, err := dynamodbattribute.MarshalMap(dInv)
+	if err != nil {
+		t.Fatalf("dynamodbattribute.MarshalMap() failed: %v", err)
+	}
+
+	rows := []map[string]*dynamodb.AttributeValue{header}
+	for _, item := range items {
+		row, err := dynamodbattribute.MarshalMap(item)
+		if err != nil {
+			t.Fatalf("dynamodbattribute.MarshalMap() failed: %v", err)
+		}
+		rows = append(rows, row)
+	}
+
+	return rows
 }
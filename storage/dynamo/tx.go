@@ -0,0 +1,198 @@
+package dynamo
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/antklim/go-invoice/invoice"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// dStaged is the invoice change staged by transaction.
+type dStaged struct {
+	add bool
+	inv invoice.Invoice
+}
+
+// dynamoTx is the storage of transaction. It stages invoices changes and reads
+// invoices through them. Staged changes are written by a single
+// TransactWriteItems call on commit.
+type dynamoTx struct {
+	d       *Dynamo
+	changes map[string]*dStaged
+	order   []string
+	reads   map[string]*dInvoice // stored invoices when first read
+	events  []invoice.Event
+}
+
+var _ invoice.Storage = (*dynamoTx)(nil)
+
+// RunInTx runs f in transaction. All changes of the transaction, with their
+// item rows and event rows, are written with a single TransactWriteItems call,
+// so the transaction cannot write more than 100 rows. Updated invoices are written
+// only when they were not changed since the transaction read them.
+func (d *Dynamo) RunInTx(f func(tx invoice.Storage) error) error {
+	tx := &dynamoTx{
+		d:       d,
+		changes: make(map[string]*dStaged),
+		reads:   make(map[string]*dInvoice),
+	}
+	if err := f(tx); err != nil {
+		return err
+	}
+	return tx.commit()
+}
+
+func (tx *dynamoTx) AddInvoice(inv invoice.Invoice) error {
+	if _, ok := tx.changes[inv.ID]; ok {
+		return fmt.Errorf("invoice %q exists", inv.ID)
+	}
+	cur, err := tx.read(inv.ID)
+	if err != nil {
+		return err
+	}
+	if cur != nil {
+		return fmt.Errorf("invoice %q exists", inv.ID)
+	}
+
+	tx.stage(&dStaged{add: true, inv: inv.Clone()})
+	return nil
+}
+
+func (tx *dynamoTx) FindInvoice(id string) (*invoice.Invoice, error) {
+	if ch, ok := tx.changes[id]; ok {
+		c := ch.inv.Clone()
+		return &c, nil
+	}
+
+	cur, err := tx.read(id)
+	if err != nil || cur == nil {
+		return nil, err
+	}
+	inv := cur.InvoiceMarshal()
+	return &inv, nil
+}
+
+func (tx *dynamoTx) UpdateInvoice(inv invoice.Invoice) error {
+	if ch, ok := tx.changes[inv.ID]; ok {
+		ch.inv = inv.Clone()
+		return nil
+	}
+
+	cur, err := tx.read(inv.ID)
+	if err != nil {
+		return err
+	}
+	if cur == nil {
+		return fmt.Errorf("invoice %q not found", inv.ID)
+	}
+
+	tx.stage(&dStaged{inv: inv.Clone()})
+	return nil
+}
+
+// read finds the stored invoice. The invoice found by the first read is kept,
+// later changes are written conditioned on it.
+func (tx *dynamoTx) read(id string) (*dInvoice, error) {
+	if cur, ok := tx.reads[id]; ok {
+		return cur, nil
+	}
+
+	cur, err := tx.d.findDinvoice(id)
+	if err != nil {
+		return nil, err
+	}
+	if cur != nil {
+		tx.reads[id] = cur
+	}
+	return cur, nil
+}
+
+func (tx *dynamoTx) stage(ch *dStaged) {
+	tx.changes[ch.inv.ID] = ch
+	tx.order = append(tx.order, ch.inv.ID)
+}
+
+// commit writes staged changes. A failed condition of the invoice header write
+// is reported as the error of that invoice.
+func (tx *dynamoTx) commit() error {
+	if len(tx.order) == 0 && len(tx.events) == 0 {
+		return nil
+	}
+
+	var (
+		writes []*dynamodb.TransactWriteItem
+		owners []*dStaged // change of every write action
+	)
+	now := time.Now()
+	for _, id := range tx.order {
+		ch := tx.changes[id]
+		w, err := tx.writes(ch, now)
+		if err != nil {
+			return err
+		}
+		writes = append(writes, w...)
+		for range w {
+			owners = append(owners, ch)
+		}
+	}
+	for _, e := range tx.events {
+		w, err := tx.d.putEvent(e)
+		if err != nil {
+			return err
+		}
+		writes = append(writes, w)
+		owners = append(owners, nil) // event rows are written unconditionally
+	}
+
+	err := tx.d.transactWrite(writes)
+	if ch := conditionFailed(err, owners); ch != nil {
+		if ch.add {
+			return fmt.Errorf("invoice %q exists", ch.inv.ID)
+		}
+		return &invoice.ConflictError{ID: ch.inv.ID}
+	}
+	return err
+}
+
+func (tx *dynamoTx) writes(ch *dStaged, now time.Time) ([]*dynamodb.TransactWriteItem, error) {
+	if ch.add {
+		return tx.d.addWrites(ch.inv)
+	}
+
+	inv := ch.inv
+	inv.UpdatedAt = now
+	next, err := unmarshalDinvoice(inv)
+	if err != nil {
+		return nil, err
+	}
+
+	cur := tx.reads[inv.ID]
+	cs, err := newChangeSet(cur, next)
+	if err != nil {
+		return nil, err
+	}
+
+	expr, err := updateExpr(cur.UpdatedAt, cs)
+	if err != nil {
+		return nil, err
+	}
+	return tx.d.updateWrites(next, expr, cs)
+}
+
+// conditionFailed returns the change which write condition failed.
+func conditionFailed(err error, owners []*dStaged) *dStaged {
+	var terr *dynamodb.TransactionCanceledException
+	if !errors.As(err, &terr) {
+		return nil
+	}
+
+	for i, r := range terr.CancellationReasons {
+		if i < len(owners) && aws.StringValue(r.Code) == dConditionalCheckFailed {
+			return owners[i]
+		}
+	}
+	return nil
+}
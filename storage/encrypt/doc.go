@@ -0,0 +1,12 @@
+// Package encrypt contains invoice storage decorator that encrypts customer
+// personal data at rest.
+//
+// Every personal data field is encrypted with its own random AES-256-GCM data
+// key. The data key is wrapped by the KeyProvider key and stored together with
+// the encrypted field value and the wrapping key ID:
+//
+//	enc:v1:<key ID>:<wrapped data key>:<nonce and ciphertext>
+//
+// The encrypted value is bound to the invoice ID and the field, so it cannot
+// be moved to another invoice or field.
+package encrypt
@@ -0,0 +1,326 @@
+package encrypt
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/antklim/go-invoice/invoice"
+	"github.com/pkg/errors"
+)
+
+// Encrypted field value format.
+const (
+	valuePrefix = "enc:v1:"
+	valueDelim  = ":"
+)
+
+var encoding = base64.RawStdEncoding
+
+// field is the invoice personal data field.
+type field struct {
+	name  string
+	value func(*invoice.Invoice) *string
+}
+
+// piiFields are the invoice fields encrypted at rest.
+var piiFields = []field{
+	{name: "customer_name", value: func(inv *invoice.Invoice) *string { return &inv.CustomerName }},
+}
+
+// Encrypt is the invoice storage decorator that encrypts invoice personal data
+// fields before they are written to the storage, and decrypts them when
+// invoices are read. Values stored in plain text, for example before the
+// encryption was enabled, are read as is and encrypted on rotation.
+type Encrypt struct {
+	strg invoice.Storage
+	keys KeyProvider
+}
+
+var _ invoice.Storage = (*Encrypt)(nil)
+var _ invoice.Lister = (*Encrypt)(nil)
+var _ invoice.Transactor = (*Encrypt)(nil)
+var _ invoice.Outbox = (*Encrypt)(nil)
+var _ invoice.EventWriter = (*Encrypt)(nil)
+
+// New creates encrypting decorator of the storage.
+func New(strg invoice.Storage, keys KeyProvider) *Encrypt {
+	return &Encrypt{strg: strg, keys: keys}
+}
+
+func (e *Encrypt) AddInvoice(inv invoice.Invoice) error {
+	if err := e.encrypt(&inv); err != nil {
+		return err
+	}
+	return e.strg.AddInvoice(inv)
+}
+
+func (e *Encrypt) FindInvoice(id string) (*invoice.Invoice, error) {
+	inv, err := e.strg.FindInvoice(id)
+	if err != nil || inv == nil {
+		return inv, err
+	}
+
+	if err := e.decrypt(inv); err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+func (e *Encrypt) UpdateInvoice(inv invoice.Invoice) error {
+	if err := e.encrypt(&inv); err != nil {
+		return err
+	}
+	return e.strg.UpdateInvoice(inv)
+}
+
+// ListInvoices lists decrypted invoices of the storage. It fails when the
+// storage cannot list invoices.
+func (e *Encrypt) ListInvoices(cursor string, limit int) ([]invoice.Invoice, string, error) {
+	l, ok := e.strg.(invoice.Lister)
+	if !ok {
+		return nil, "", errors.New("storage does not support listing invoices")
+	}
+
+	invs, next, err := l.ListInvoices(cursor, limit)
+	if err != nil {
+		return nil, "", err
+	}
+	for i := range invs {
+		if err := e.decrypt(&invs[i]); err != nil {
+			return nil, "", err
+		}
+	}
+	return invs, next, nil
+}
+
+// RunInTx runs f in the storage transaction. Invoices are encrypted and
+// decrypted in the transaction the same way as by the storage. It fails when
+// the storage does not support transactions.
+func (e *Encrypt) RunInTx(f func(tx invoice.Storage) error) error {
+	t, ok := e.strg.(invoice.Transactor)
+	if !ok {
+		return errors.New("storage does not support transactions")
+	}
+
+	return t.RunInTx(func(tx invoice.Storage) error {
+		return f(New(tx, e.keys))
+	})
+}
+
+// WriteEvent writes the event with the encrypted invoice to the outbox of the
+// transaction. It fails when the storage is not the transaction of the storage
+// with the outbox.
+func (e *Encrypt) WriteEvent(event invoice.Event) error {
+	w, ok := e.strg.(invoice.EventWriter)
+	if !ok {
+		return errors.New("storage does not support outbox")
+	}
+
+	event.Invoice = event.Invoice.Clone()
+	if err := e.encrypt(&event.Invoice); err != nil {
+		return err
+	}
+	return w.WriteEvent(event)
+}
+
+// OutboxEvents returns the events of the storage outbox with decrypted
+// invoices. It fails when the storage has no outbox.
+func (e *Encrypt) OutboxEvents(limit int) ([]invoice.Event, error) {
+	o, ok := e.strg.(invoice.Outbox)
+	if !ok {
+		return nil, errors.New("storage does not support outbox")
+	}
+
+	events, err := o.OutboxEvents(limit)
+	if err != nil {
+		return nil, err
+	}
+	for i := range events {
+		if err := e.decrypt(&events[i].Invoice); err != nil {
+			return nil, err
+		}
+	}
+	return events, nil
+}
+
+// DeleteOutboxEvents deletes the events from the storage outbox. It fails when
+// the storage has no outbox.
+func (e *Encrypt) DeleteOutboxEvents(events []invoice.Event) error {
+	o, ok := e.strg.(invoice.Outbox)
+	if !ok {
+		return errors.New("storage does not support outbox")
+	}
+	return o.DeleteOutboxEvents(events)
+}
+
+// Rotate re-encrypts invoices which fields are stored in plain text or
+// encrypted with a key other than the current one. It returns the number of
+// re-encrypted invoices. The storage should implement invoice.Lister.
+func (e *Encrypt) Rotate() (int, error) {
+	l, ok := e.strg.(invoice.Lister)
+	if !ok {
+		return 0, errors.New("storage does not support listing invoices")
+	}
+
+	current := e.keys.CurrentKeyID()
+	var n int
+	cursor := ""
+	for {
+		invs, next, err := l.ListInvoices(cursor, 100) // nolint:gomnd
+		if err != nil {
+			return n, errors.Wrap(err, "list invoices failed")
+		}
+
+		for i := range invs {
+			inv := invs[i]
+			if !e.stale(&inv, current) {
+				continue
+			}
+			if err := e.decrypt(&inv); err != nil {
+				return n, err
+			}
+			if err := e.UpdateInvoice(inv); err != nil {
+				return n, errors.Wrapf(err, "re-encrypt invoice %q failed", inv.ID)
+			}
+			n++
+		}
+
+		if next == "" {
+			return n, nil
+		}
+		cursor = next
+	}
+}
+
+// Close closes the storage when it is closeable.
+func (e *Encrypt) Close() error {
+	if closer, ok := e.strg.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// stale returns true when any invoice field is not encrypted with the key.
+func (e *Encrypt) stale(inv *invoice.Invoice, keyID string) bool {
+	for _, f := range piiFields {
+		v := *f.value(inv)
+		if v == "" {
+			continue
+		}
+		id, _, _, err := parseValue(v)
+		if err != nil || id != keyID {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *Encrypt) encrypt(inv *invoice.Invoice) error {
+	keyID := e.keys.CurrentKeyID()
+	for _, f := range piiFields {
+		v := f.value(inv)
+		if *v == "" {
+			continue
+		}
+
+		enc, err := e.encryptValue(keyID, *v, additionalData(inv.ID, f.name))
+		if err != nil {
+			return errors.Wrapf(err, "encrypt invoice %q %s failed", inv.ID, f.name)
+		}
+		*v = enc
+	}
+	return nil
+}
+
+func (e *Encrypt) decrypt(inv *invoice.Invoice) error {
+	for _, f := range piiFields {
+		v := f.value(inv)
+		if !strings.HasPrefix(*v, valuePrefix) {
+			continue
+		}
+
+		dec, err := e.decryptValue(*v, additionalData(inv.ID, f.name))
+		if err != nil {
+			return errors.Wrapf(err, "decrypt invoice %q %s failed", inv.ID, f.name)
+		}
+		*v = dec
+	}
+	return nil
+}
+
+func (e *Encrypt) encryptValue(keyID, plaintext string, additional []byte) (string, error) {
+	dataKey := make([]byte, KeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return "", errors.Wrap(err, "generate data key failed")
+	}
+
+	wrapped, err := e.keys.WrapKey(keyID, dataKey)
+	if err != nil {
+		return "", errors.Wrap(err, "wrap data key failed")
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := seal(aead, []byte(plaintext), additional)
+	if err != nil {
+		return "", err
+	}
+
+	return valuePrefix + strings.Join([]string{
+		keyID,
+		encoding.EncodeToString(wrapped),
+		encoding.EncodeToString(ciphertext),
+	}, valueDelim), nil
+}
+
+func (e *Encrypt) decryptValue(value string, additional []byte) (string, error) {
+	keyID, wrapped, ciphertext, err := parseValue(value)
+	if err != nil {
+		return "", err
+	}
+
+	dataKey, err := e.keys.UnwrapKey(keyID, wrapped)
+	if err != nil {
+		return "", errors.Wrap(err, "unwrap data key failed")
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(aead, ciphertext, additional)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// parseValue splits encrypted value to the key ID, the wrapped data key and
+// the ciphertext.
+func parseValue(value string) (keyID string, wrapped, ciphertext []byte, err error) {
+	if !strings.HasPrefix(value, valuePrefix) {
+		return "", nil, nil, errors.New("value is not encrypted")
+	}
+
+	parts := strings.Split(strings.TrimPrefix(value, valuePrefix), valueDelim)
+	if len(parts) != 3 { // nolint:gomnd
+		return "", nil, nil, fmt.Errorf("encrypted value has %d parts, want 3", len(parts))
+	}
+	if wrapped, err = encoding.DecodeString(parts[1]); err != nil {
+		return "", nil, nil, errors.Wrap(err, "wrapped data key invalid")
+	}
+	if ciphertext, err = encoding.DecodeString(parts[2]); err != nil {
+		return "", nil, nil, errors.Wrap(err, "ciphertext invalid")
+	}
+	return parts[0], wrapped, ciphertext, nil
+}
+
+// additionalData binds the encrypted value to the invoice and the field.
+func additionalData(invID, field string) []byte {
+	return []byte(invID + valueDelim + field)
+}
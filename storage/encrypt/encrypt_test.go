@@ -0,0 +1,157 @@
+package encrypt_test
+
+import (
+	"crypto/rand"
+	"strings"
+	"testing"
+
+	"github.com/antklim/go-invoice/invoice"
+	"github.com/antklim/go-invoice/storage/encrypt"
+	"github.com/antklim/go-invoice/storage/memory"
+	"github.com/antklim/go-invoice/storage/storagetest"
+)
+
+func newKey(t *testing.T) []byte {
+	t.Helper()
+
+	key := make([]byte, encrypt.KeySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatalf("generate key failed: %v", err)
+	}
+	return key
+}
+
+func newKeys(t *testing.T, current string, keys map[string][]byte) *encrypt.LocalKeyProvider {
+	t.Helper()
+
+	p, err := encrypt.NewLocalKeyProvider(current, keys)
+	if err != nil {
+		t.Fatalf("NewLocalKeyProvider() failed: %v", err)
+	}
+	return p
+}
+
+func findInvoice(t *testing.T, strg invoice.Storage, id string) *invoice.Invoice {
+	t.Helper()
+
+	inv, err := strg.FindInvoice(id)
+	if err != nil {
+		t.Fatalf("FindInvoice(%q) failed: %v", id, err)
+	}
+	if inv == nil {
+		t.Fatalf("FindInvoice(%q) invoice expected, got nil", id)
+	}
+	return inv
+}
+
+func TestEncrypt(t *testing.T) {
+	strg := memory.New()
+	e := encrypt.New(strg, newKeys(t, "k1", map[string][]byte{"k1": newKey(t)}))
+	inv := invoice.NewInvoice("John Doe")
+
+	if err := e.AddInvoice(inv); err != nil {
+		t.Fatalf("AddInvoice(%v) failed: %v", inv, err)
+	}
+
+	stored := findInvoice(t, strg, inv.ID)
+	if strings.Contains(stored.CustomerName, "John") || !strings.HasPrefix(stored.CustomerName, "enc:v1:k1:") {
+		t.Errorf("invalid stored invoice.CustomerName %q, want it encrypted with key k1", stored.CustomerName)
+	}
+	if vinv := findInvoice(t, e, inv.ID); !vinv.Equal(&inv) {
+		t.Errorf("FindInvoice(%q) = %v, want %v", inv.ID, vinv, inv)
+	}
+}
+
+func TestReadPlainText(t *testing.T) {
+	strg := memory.New()
+	inv := invoice.NewInvoice("John Doe")
+	if err := strg.AddInvoice(inv); err != nil {
+		t.Fatalf("AddInvoice(%v) failed: %v", inv, err)
+	}
+
+	e := encrypt.New(strg, newKeys(t, "k1", map[string][]byte{"k1": newKey(t)}))
+	if vinv := findInvoice(t, e, inv.ID); vinv.CustomerName != inv.CustomerName {
+		t.Errorf("invalid invoice.CustomerName %q, want %q", vinv.CustomerName, inv.CustomerName)
+	}
+}
+
+func TestDecryptErrors(t *testing.T) {
+	key := newKey(t)
+	strg := memory.New()
+	e := encrypt.New(strg, newKeys(t, "k1", map[string][]byte{"k1": key}))
+
+	a, b := invoice.NewInvoice("John Doe"), invoice.NewInvoice("Jane Doe")
+	for _, inv := range []invoice.Invoice{a, b} {
+		if err := e.AddInvoice(inv); err != nil {
+			t.Fatalf("AddInvoice(%v) failed: %v", inv, err)
+		}
+	}
+
+	t.Run("value moved to another invoice", func(t *testing.T) {
+		stored := findInvoice(t, strg, b.ID)
+		stored.CustomerName = findInvoice(t, strg, a.ID).CustomerName
+		if err := strg.UpdateInvoice(*stored); err != nil {
+			t.Fatalf("UpdateInvoice(%v) failed: %v", stored, err)
+		}
+
+		if _, err := e.FindInvoice(b.ID); err == nil {
+			t.Errorf("FindInvoice(%q) expected to fail", b.ID)
+		}
+	})
+
+	t.Run("unknown key", func(t *testing.T) {
+		other := encrypt.New(strg, newKeys(t, "k2", map[string][]byte{"k2": key}))
+		if _, err := other.FindInvoice(a.ID); err == nil {
+			t.Errorf("FindInvoice(%q) expected to fail", a.ID)
+		}
+	})
+}
+
+func TestRotate(t *testing.T) {
+	k1, k2 := newKey(t), newKey(t)
+	strg := memory.New()
+
+	legacy := invoice.NewInvoice("John Doe")
+	if err := strg.AddInvoice(legacy); err != nil {
+		t.Fatalf("AddInvoice(%v) failed: %v", legacy, err)
+	}
+	old := invoice.NewInvoice("Jane Doe")
+	if err := encrypt.New(strg, newKeys(t, "k1", map[string][]byte{"k1": k1})).AddInvoice(old); err != nil {
+		t.Fatalf("AddInvoice(%v) failed: %v", old, err)
+	}
+
+	e := encrypt.New(strg, newKeys(t, "k2", map[string][]byte{"k1": k1, "k2": k2}))
+	current := invoice.NewInvoice("Bob Smith")
+	if err := e.AddInvoice(current); err != nil {
+		t.Fatalf("AddInvoice(%v) failed: %v", current, err)
+	}
+
+	n, err := e.Rotate()
+	if err != nil {
+		t.Fatalf("Rotate() failed: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("Rotate() re-encrypted %d invoices, want 2", n)
+	}
+
+	for _, inv := range []invoice.Invoice{legacy, old, current} {
+		if stored := findInvoice(t, strg, inv.ID); !strings.HasPrefix(stored.CustomerName, "enc:v1:k2:") {
+			t.Errorf("invalid stored invoice.CustomerName %q, want it encrypted with key k2", stored.CustomerName)
+		}
+	}
+
+	// old key is not needed after rotation
+	rotated := encrypt.New(strg, newKeys(t, "k2", map[string][]byte{"k2": k2}))
+	for _, inv := range []invoice.Invoice{legacy, old, current} {
+		if vinv := findInvoice(t, rotated, inv.ID); vinv.CustomerName != inv.CustomerName {
+			t.Errorf("invalid invoice.CustomerName %q, want %q", vinv.CustomerName, inv.CustomerName)
+		}
+	}
+}
+
+func TestConformance(t *testing.T) {
+	keys := newKeys(t, "k1", map[string][]byte{"k1": newKey(t)})
+	storagetest.Run(t, storagetest.FactoryFunc(func() invoice.Storage {
+		return encrypt.New(memory.New(), keys)
+	}))
+}
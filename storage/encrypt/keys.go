@@ -0,0 +1,144 @@
+package encrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// KeySize is the size of the keys in bytes, keys are AES-256 keys.
+const KeySize = 32
+
+// KeyProvider wraps and unwraps data keys with the keys identified by IDs.
+// It can be implemented by a key management service client.
+type KeyProvider interface {
+	// CurrentKeyID returns the ID of the key used to wrap new data keys.
+	CurrentKeyID() string
+	WrapKey(keyID string, dataKey []byte) ([]byte, error)
+	UnwrapKey(keyID string, wrapped []byte) ([]byte, error)
+}
+
+// LocalKeyProvider wraps data keys with AES-GCM using keys kept in memory.
+type LocalKeyProvider struct {
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+var _ KeyProvider = (*LocalKeyProvider)(nil)
+
+// NewLocalKeyProvider creates key provider of the keys by their IDs. The
+// current key wraps new data keys, other keys are used to unwrap data keys
+// wrapped before they were rotated.
+func NewLocalKeyProvider(current string, keys map[string][]byte) (*LocalKeyProvider, error) {
+	if _, ok := keys[current]; !ok {
+		return nil, fmt.Errorf("current key %q not found", current)
+	}
+
+	p := &LocalKeyProvider{current: current, keys: make(map[string]cipher.AEAD, len(keys))}
+	for id, key := range keys {
+		if id == "" || strings.Contains(id, valueDelim) {
+			return nil, fmt.Errorf("invalid key ID %q", id)
+		}
+		if len(key) != KeySize {
+			return nil, fmt.Errorf("key %q is %d bytes, want %d", id, len(key), KeySize)
+		}
+
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, errors.Wrapf(err, "key %q invalid", id)
+		}
+		p.keys[id] = aead
+	}
+	return p, nil
+}
+
+// keyFile is the format of the key file.
+type keyFile struct {
+	Current string            `json:"current"`
+	Keys    map[string]string `json:"keys"` // base64 encoded keys by ID
+}
+
+// LoadKeyFile creates key provider of the keys in JSON file:
+//
+//	{"current": "2021-10", "keys": {"2021-09": "<base64 key>", "2021-10": "<base64 key>"}}
+//
+// To rotate keys, add a new key to the file and make it current. Old keys
+// should be kept until all invoices are re-encrypted.
+func LoadKeyFile(path string) (*LocalKeyProvider, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "read key file failed")
+	}
+
+	var f keyFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, errors.Wrapf(err, "key file %q invalid", path)
+	}
+
+	keys := make(map[string][]byte, len(f.Keys))
+	for id, v := range f.Keys {
+		if keys[id], err = base64.StdEncoding.DecodeString(v); err != nil {
+			return nil, errors.Wrapf(err, "key file %q key %q invalid", path, id)
+		}
+	}
+
+	p, err := NewLocalKeyProvider(f.Current, keys)
+	return p, errors.Wrapf(err, "key file %q invalid", path)
+}
+
+func (p *LocalKeyProvider) CurrentKeyID() string {
+	return p.current
+}
+
+// WrapKey encrypts the data key. The key ID is authenticated with the data
+// key, so the wrapped key cannot be unwrapped as wrapped by another key.
+func (p *LocalKeyProvider) WrapKey(keyID string, dataKey []byte) ([]byte, error) {
+	aead, ok := p.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", keyID)
+	}
+	return seal(aead, dataKey, []byte(keyID))
+}
+
+func (p *LocalKeyProvider) UnwrapKey(keyID string, wrapped []byte) ([]byte, error) {
+	aead, ok := p.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", keyID)
+	}
+	return open(aead, wrapped, []byte(keyID))
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts the plaintext with a random nonce, the nonce is prepended to
+// the ciphertext.
+func seal(aead cipher.AEAD, plaintext, additional []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, errors.Wrap(err, "generate nonce failed")
+	}
+	return aead.Seal(nonce, nonce, plaintext, additional), nil
+}
+
+func open(aead cipher.AEAD, ciphertext, additional []byte) ([]byte, error) {
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, additional)
+	return plaintext, errors.Wrap(err, "decrypt failed")
+}
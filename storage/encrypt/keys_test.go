@@ -0,0 +1,96 @@
+package encrypt_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/antklim/go-invoice/storage/encrypt"
+)
+
+func TestLoadKeyFile(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(make([]byte, encrypt.KeySize))
+	testCases := []struct {
+		desc    string
+		content string
+		wantErr bool
+	}{
+		{
+			desc:    "valid keys",
+			content: `{"current": "k2", "keys": {"k1": "` + key + `", "k2": "` + key + `"}}`,
+		},
+		{
+			desc:    "missing current key",
+			content: `{"current": "k3", "keys": {"k1": "` + key + `"}}`,
+			wantErr: true,
+		},
+		{
+			desc:    "short key",
+			content: `{"current": "k1", "keys": {"k1": "c2hvcnQ="}}`,
+			wantErr: true,
+		},
+		{
+			desc:    "invalid key ID",
+			content: `{"current": "k:1", "keys": {"k:1": "` + key + `"}}`,
+			wantErr: true,
+		},
+		{
+			desc:    "malformed file",
+			content: `{"current": "k1"`,
+			wantErr: true,
+		},
+	}
+
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "keys.json")
+			if err := os.WriteFile(path, []byte(tC.content), 0600); err != nil {
+				t.Fatalf("write key file failed: %v", err)
+			}
+
+			p, err := encrypt.LoadKeyFile(path)
+			if tC.wantErr {
+				if err == nil {
+					t.Errorf("LoadKeyFile() expected to fail")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadKeyFile() failed: %v", err)
+			}
+			if got := p.CurrentKeyID(); got != "k2" {
+				t.Errorf("invalid current key ID %q, want k2", got)
+			}
+		})
+	}
+}
+
+func TestWrapKey(t *testing.T) {
+	p := newKeys(t, "k1", map[string][]byte{"k1": newKey(t), "k2": newKey(t)})
+	dataKey := newKey(t)
+
+	wrapped, err := p.WrapKey("k1", dataKey)
+	if err != nil {
+		t.Fatalf("WrapKey() failed: %v", err)
+	}
+	if bytes.Contains(wrapped, dataKey) {
+		t.Error("wrapped key contains data key")
+	}
+
+	got, err := p.UnwrapKey("k1", wrapped)
+	if err != nil {
+		t.Fatalf("UnwrapKey() failed: %v", err)
+	}
+	if !bytes.Equal(got, dataKey) {
+		t.Error("unwrapped key differs from data key")
+	}
+
+	if _, err := p.UnwrapKey("k2", wrapped); err == nil {
+		t.Error("UnwrapKey() with another key expected to fail")
+	}
+	if _, err := p.WrapKey("k3", dataKey); err == nil {
+		t.Error("WrapKey() with unknown key expected to fail")
+	}
+}
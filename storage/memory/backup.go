@@ -0,0 +1,408 @@
+package memory
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/antklim/go-invoice/invoice"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// Backup files names.
+const (
+	InvoicesFile = "invoices.csv"
+	ItemsFile    = "items.csv"
+)
+
+const (
+	backupFormat  = "go-invoice"
+	backupVersion = "6"
+	timeLayout    = time.RFC3339Nano
+)
+
+// Every backup file starts with the version record: format name, file kind and
+// format version. It is followed by the columns record and data records.
+// Version 2 appended split and merge references columns, version 1 backups
+// are restored without references. Version 3 appended the scheduled issue time
+// column of invoices, version 4 appended the dunning level and time columns
+// and version 5 appended the JSON delivery column. Version 6 appended the
+// backup ID to the version record, both files of the backup have the same ID.
+// Items columns are the same since version 2.
+var (
+	invoicesColumns = map[string][]string{
+		"1": {"id", "customer_name", "issue_date", "status", "created_at", "updated_at"},
+		"2": {"id", "customer_name", "issue_date", "status", "created_at", "updated_at", "origins", "successors"},
+		"3": {"id", "customer_name", "issue_date", "status", "created_at", "updated_at", "origins", "successors",
+			"issue_at"},
+		"4": {"id", "customer_name", "issue_date", "status", "created_at", "updated_at", "origins", "successors",
+			"issue_at", "dunning_level", "dunning_at"},
+		"5": {"id", "customer_name", "issue_date", "status", "created_at", "updated_at", "origins", "successors",
+			"issue_at", "dunning_level", "dunning_at", "delivery"},
+		"6": {"id", "customer_name", "issue_date", "status", "created_at", "updated_at", "origins", "successors",
+			"issue_at", "dunning_level", "dunning_at", "delivery"},
+	}
+	itemsColumns = map[string][]string{
+		"1": {"invoice_id", "id", "product_name", "price", "qty", "created_at"},
+		"2": {"invoice_id", "id", "product_name", "price", "qty", "created_at", "origin"},
+		"3": {"invoice_id", "id", "product_name", "price", "qty", "created_at", "origin"},
+		"4": {"invoice_id", "id", "product_name", "price", "qty", "created_at", "origin"},
+		"5": {"invoice_id", "id", "product_name", "price", "qty", "created_at", "origin"},
+		"6": {"invoice_id", "id", "product_name", "price", "qty", "created_at", "origin"},
+	}
+)
+
+// Backup writes all invoices to the invoices.csv and items.csv files in the
+// directory. The directory created when it does not exist. Both files are
+// written to temporary files first and renamed only when written, so a failed
+// write does not corrupt the previous backup. The files are renamed one by one:
+// a crash between the renames leaves the new invoices file with the previous
+// items file, and Restore rejects such pair by the backup ID.
+func (memo *Memory) Backup(dir string) error {
+	memo.RLock()
+	invs := make([]invoice.Invoice, 0, len(memo.records))
+	for _, inv := range memo.records {
+		invs = append(invs, inv)
+	}
+	memo.RUnlock()
+
+	sort.Slice(invs, func(i, j int) bool {
+		if !invs[i].CreatedAt.Equal(invs[j].CreatedAt) {
+			return invs[i].CreatedAt.Before(invs[j].CreatedAt)
+		}
+		return invs[i].ID < invs[j].ID
+	})
+
+	if err := os.MkdirAll(dir, 0700); err != nil { // nolint:gomnd
+		return errors.Wrapf(err, "create backup directory %q failed", dir)
+	}
+
+	var invRecords, itemRecords [][]string
+	for _, inv := range invs {
+		rec, err := invoiceRecord(inv)
+		if err != nil {
+			return err
+		}
+		invRecords = append(invRecords, rec)
+		for _, item := range inv.Items {
+			itemRecords = append(itemRecords, itemRecord(inv.ID, item))
+		}
+	}
+
+	id := uuid.NewString()
+	invTmp, err := writeBackupFile(dir, InvoicesFile, "invoices", id, invoicesColumns[backupVersion], invRecords)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(invTmp) // nolint:errcheck
+
+	itemsTmp, err := writeBackupFile(dir, ItemsFile, "items", id, itemsColumns[backupVersion], itemRecords)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(itemsTmp) // nolint:errcheck
+
+	if err := os.Rename(invTmp, filepath.Join(dir, InvoicesFile)); err != nil {
+		return errors.Wrapf(err, "write backup file %q failed", filepath.Join(dir, InvoicesFile))
+	}
+	return errors.Wrapf(os.Rename(itemsTmp, filepath.Join(dir, ItemsFile)),
+		"write backup file %q failed", filepath.Join(dir, ItemsFile))
+}
+
+// Restore replaces all invoices with the invoices read from the invoices.csv
+// and items.csv files in the directory. Files are validated completely before
+// any invoice replaced, so the storage is not changed when restore fails.
+// Files of different backups are not restored. The
+// durable storage takes the snapshot of restored invoices before they replace
+// current invoices.
+func (memo *Memory) Restore(dir string) error {
+	records := make(map[string]invoice.Invoice)
+
+	invID, err := readBackupFile(dir, InvoicesFile, "invoices", invoicesColumns, func(rec []string) error {
+		inv, err := parseInvoiceRecord(rec)
+		if err != nil {
+			return err
+		}
+		if _, ok := records[inv.ID]; ok {
+			return fmt.Errorf("duplicate invoice %q", inv.ID)
+		}
+		records[inv.ID] = inv
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	itemsID, err := readBackupFile(dir, ItemsFile, "items", itemsColumns, func(rec []string) error {
+		invID, item, err := parseItemRecord(rec)
+		if err != nil {
+			return err
+		}
+		inv, ok := records[invID]
+		if !ok {
+			return fmt.Errorf("item %q of unknown invoice %q", item.ID, invID)
+		}
+		if inv.ContainsItem(item.ID) {
+			return fmt.Errorf("duplicate item %q of invoice %q", item.ID, invID)
+		}
+		inv.Items = append(inv.Items, item)
+		records[invID] = inv
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if invID != itemsID {
+		return fmt.Errorf("backup files %q and %q are from different backups",
+			filepath.Join(dir, InvoicesFile), filepath.Join(dir, ItemsFile))
+	}
+
+	memo.Lock()
+	defer memo.Unlock()
+
+	if memo.wal != nil {
+		if err := memo.wal.snapshot(records, memo.outbox); err != nil {
+			return err
+		}
+	}
+	memo.records = records
+
+	return nil
+}
+
+// writeBackupFile writes the backup file of the backup id to a temporary file
+// in the directory and returns its name.
+func writeBackupFile(dir, name, kind, id string, columns []string, records [][]string) (string, error) {
+	path := filepath.Join(dir, name)
+	f, err := os.CreateTemp(dir, name+".*.tmp")
+	if err != nil {
+		return "", errors.Wrapf(err, "create backup file %q failed", path)
+	}
+
+	w := csv.NewWriter(f)
+	_ = w.Write([]string{backupFormat, kind, backupVersion, id})
+	_ = w.Write(columns)
+	_ = w.WriteAll(records)
+	err = w.Error()
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name()) // nolint:errcheck
+		return "", errors.Wrapf(err, "write backup file %q failed", path)
+	}
+
+	return f.Name(), nil
+}
+
+// readBackupFile validates version and columns records of the backup file and
+// calls f for every data record. Columns are selected by the file version. It
+// returns the backup ID, empty for versions before 6. Returned errors reference
+// the file and the record number.
+func readBackupFile(dir, name, kind string, versions map[string][]string, f func([]string) error) (string, error) {
+	path := filepath.Join(dir, name)
+	file, err := os.Open(path)
+	if err != nil {
+		return "", errors.Wrapf(err, "open backup file %q failed", path)
+	}
+	defer file.Close()
+
+	r := csv.NewReader(file)
+	r.FieldsPerRecord = -1
+
+	version, err := r.Read()
+	if err != nil {
+		return "", backupFileError(path, 1, errors.Wrap(err, "read version failed"))
+	}
+	if len(version) < 3 || len(version) > 4 || version[0] != backupFormat || version[1] != kind { // nolint:gomnd
+		return "", backupFileError(path, 1, fmt.Errorf("not a backup of %s", kind))
+	}
+	columns, ok := versions[version[2]]
+	if !ok {
+		return "", backupFileError(path, 1, fmt.Errorf("unsupported version %q, want up to %q", version[2], backupVersion))
+	}
+	var id string
+	if len(version) > 3 { // nolint:gomnd
+		id = version[3]
+	}
+
+	header, err := r.Read()
+	if err != nil {
+		return "", backupFileError(path, 2, errors.Wrap(err, "read columns failed")) // nolint:gomnd
+	}
+	if !equalColumns(header, columns) {
+		return "", backupFileError(path, 2, fmt.Errorf("invalid columns %v, want %v", header, columns)) // nolint:gomnd
+	}
+
+	for n := 3; ; n++ {
+		rec, err := r.Read()
+		if err == io.EOF {
+			return id, nil
+		}
+		if err != nil {
+			return "", backupFileError(path, n, err)
+		}
+		if len(rec) != len(columns) {
+			return "", backupFileError(path, n, fmt.Errorf("got %d fields, want %d", len(rec), len(columns)))
+		}
+		if err := f(rec); err != nil {
+			return "", backupFileError(path, n, err)
+		}
+	}
+}
+
+func backupFileError(path string, record int, err error) error {
+	return errors.Wrapf(err, "backup file %q record %d", path, record)
+}
+
+func equalColumns(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func invoiceRecord(inv invoice.Invoice) ([]string, error) {
+	var date, issueAt, dunningAt, delivery string
+	if inv.Date != nil {
+		date = inv.Date.Format(timeLayout)
+	}
+	if inv.IssueAt != nil {
+		issueAt = inv.IssueAt.Format(timeLayout)
+	}
+	if inv.DunningAt != nil {
+		dunningAt = inv.DunningAt.Format(timeLayout)
+	}
+	if inv.Delivery != nil {
+		data, err := json.Marshal(inv.Delivery)
+		if err != nil {
+			return nil, errors.Wrapf(err, "encode invoice %q delivery failed", inv.ID)
+		}
+		delivery = string(data)
+	}
+
+	return []string{
+		inv.ID,
+		inv.CustomerName,
+		date,
+		strconv.Itoa(int(inv.Status)),
+		inv.CreatedAt.Format(timeLayout),
+		inv.UpdatedAt.Format(timeLayout),
+		strings.Join(inv.Origins, " "),
+		strings.Join(inv.Successors, " "),
+		issueAt,
+		strconv.Itoa(inv.DunningLevel),
+		dunningAt,
+		delivery,
+	}, nil
+}
+
+func parseInvoiceRecord(rec []string) (invoice.Invoice, error) {
+	inv := invoice.Invoice{ID: rec[0], CustomerName: rec[1]}
+	if inv.ID == "" {
+		return inv, errors.New("blank invoice id")
+	}
+
+	if rec[2] != "" {
+		date, err := time.Parse(timeLayout, rec[2])
+		if err != nil {
+			return inv, errors.Wrapf(err, "invalid invoice %q issue_date", inv.ID)
+		}
+		inv.Date = &date
+	}
+
+	status, err := strconv.Atoi(rec[3])
+	if err != nil || invoice.Status(status).String() == "" {
+		return inv, fmt.Errorf("invalid invoice %q status %q", inv.ID, rec[3])
+	}
+	inv.Status = invoice.Status(status)
+
+	if inv.CreatedAt, err = time.Parse(timeLayout, rec[4]); err != nil {
+		return inv, errors.Wrapf(err, "invalid invoice %q created_at", inv.ID)
+	}
+	if inv.UpdatedAt, err = time.Parse(timeLayout, rec[5]); err != nil {
+		return inv, errors.Wrapf(err, "invalid invoice %q updated_at", inv.ID)
+	}
+	if len(rec) > 6 { // nolint:gomnd
+		inv.Origins, inv.Successors = strings.Fields(rec[6]), strings.Fields(rec[7])
+	}
+	if len(rec) > 8 && rec[8] != "" { // nolint:gomnd
+		at, err := time.Parse(timeLayout, rec[8])
+		if err != nil {
+			return inv, errors.Wrapf(err, "invalid invoice %q issue_at", inv.ID)
+		}
+		inv.IssueAt = &at
+	}
+	if len(rec) > 9 { // nolint:gomnd
+		if inv.DunningLevel, err = strconv.Atoi(rec[9]); err != nil || inv.DunningLevel < 0 {
+			return inv, fmt.Errorf("invalid invoice %q dunning_level %q", inv.ID, rec[9])
+		}
+		if rec[10] != "" {
+			at, err := time.Parse(timeLayout, rec[10])
+			if err != nil {
+				return inv, errors.Wrapf(err, "invalid invoice %q dunning_at", inv.ID)
+			}
+			inv.DunningAt = &at
+		}
+	}
+	if len(rec) > 11 && rec[11] != "" { // nolint:gomnd
+		inv.Delivery = &invoice.Delivery{}
+		if err := json.Unmarshal([]byte(rec[11]), inv.Delivery); err != nil {
+			return inv, errors.Wrapf(err, "invalid invoice %q delivery", inv.ID)
+		}
+	}
+
+	return inv, nil
+}
+
+func itemRecord(invID string, item invoice.Item) []string {
+	return []string{
+		invID,
+		item.ID,
+		item.ProductName,
+		strconv.Itoa(item.Price),
+		strconv.Itoa(item.Qty),
+		item.CreatedAt.Format(timeLayout),
+		item.Origin,
+	}
+}
+
+func parseItemRecord(rec []string) (string, invoice.Item, error) {
+	invID := rec[0]
+	item := invoice.Item{ID: rec[1], ProductName: rec[2]}
+	if item.ID == "" {
+		return invID, item, errors.New("blank item id")
+	}
+
+	var err error
+	if item.Price, err = strconv.Atoi(rec[3]); err != nil {
+		return invID, item, fmt.Errorf("invalid item %q price %q", item.ID, rec[3])
+	}
+	if item.Qty, err = strconv.Atoi(rec[4]); err != nil {
+		return invID, item, fmt.Errorf("invalid item %q qty %q", item.ID, rec[4])
+	}
+	if item.CreatedAt, err = time.Parse(timeLayout, rec[5]); err != nil {
+		return invID, item, errors.Wrapf(err, "invalid item %q created_at", item.ID)
+	}
+	if len(rec) > 6 { // nolint:gomnd
+		item.Origin = rec[6]
+	}
+
+	return invID, item, nil
+}
@@ -0,0 +1,247 @@
+package memory_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/antklim/go-invoice/invoice"
+	"github.com/antklim/go-invoice/storage/memory"
+)
+
+const (
+	invoicesHeader = "go-invoice,invoices,1\nid,customer_name,issue_date,status,created_at,updated_at\n"
+	itemsHeader    = "go-invoice,items,1\ninvoice_id,id,product_name,price,qty,created_at\n"
+	validInvoice   = "inv-1,John Doe,,0,2021-09-01T10:00:00Z,2021-09-01T10:00:00Z\n"
+)
+
+func writeBackup(t *testing.T, invoices, items string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, memory.InvoicesFile), []byte(invoices), 0600); err != nil {
+		t.Fatalf("write invoices file failed: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, memory.ItemsFile), []byte(items), 0600); err != nil {
+		t.Fatalf("write items file failed: %v", err)
+	}
+	return dir
+}
+
+func TestBackupRestore(t *testing.T) {
+	strg := memory.New()
+
+	issued := invoice.NewInvoice("Doe, \"John\"")
+	issued.Items = append(issued.Items,
+		invoice.NewItem("pen", 100, 2),
+		invoice.NewItem("multi\nline", 250, 1))
+	if err := issued.Issue(); err != nil {
+		t.Fatalf("Issue() failed: %v", err)
+	}
+	open := invoice.NewInvoice("Jane Doe")
+	open.Origins = []string{issued.ID}
+	open.Items = append(open.Items, invoice.NewItem("ruler", 50, 1))
+	open.Items[0].Origin = issued.ID
+	issueAt := time.Now().Add(time.Hour)
+	open.IssueAt = &issueAt
+	dunningAt := time.Now()
+	issued.DunningLevel, issued.DunningAt = 2, &dunningAt
+	issued.Delivery = &invoice.Delivery{
+		Status:   invoice.DeliverySent,
+		Attempts: []invoice.DeliveryAttempt{{At: dunningAt, Err: "timeout"}, {At: dunningAt.Add(time.Minute)}},
+	}
+	issued.Successors = []string{open.ID, "inv-2"}
+
+	for _, inv := range []invoice.Invoice{issued, open} {
+		if err := strg.AddInvoice(inv); err != nil {
+			t.Fatalf("AddInvoice(%v) failed: %v", inv, err)
+		}
+	}
+
+	dir := filepath.Join(t.TempDir(), "backup")
+	if err := strg.Backup(dir); err != nil {
+		t.Fatalf("Backup(%q) failed: %v", dir, err)
+	}
+
+	restored := memory.New()
+	if err := restored.AddInvoice(invoice.NewInvoice("replaced")); err != nil {
+		t.Fatalf("AddInvoice() failed: %v", err)
+	}
+	if err := restored.Restore(dir); err != nil {
+		t.Fatalf("Restore(%q) failed: %v", dir, err)
+	}
+
+	for _, inv := range []invoice.Invoice{issued, open} {
+		inv := inv
+		vinv, err := restored.FindInvoice(inv.ID)
+		if err != nil {
+			t.Fatalf("FindInvoice(%q) failed: %v", inv.ID, err)
+		}
+		if vinv == nil {
+			t.Fatalf("FindInvoice(%q) invoice expected, got nil", inv.ID)
+		}
+		if !vinv.Equal(&inv) {
+			t.Errorf("restored invoice %v, want %v", vinv, inv)
+		}
+	}
+
+	if err := restored.Backup(dir); err != nil {
+		t.Fatalf("repeated Backup(%q) failed: %v", dir, err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir(%q) failed: %v", dir, err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("backup directory contains %d files, want 2", len(entries))
+	}
+}
+
+func TestRestoreErrors(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		invoices string
+		items    string
+		err      string
+	}{
+		{
+			desc:     "unsupported version",
+			invoices: "go-invoice,invoices,7\n",
+			items:    itemsHeader,
+			err:      `record 1: unsupported version "7", want up to "6"`,
+		},
+		{
+			desc:     "not a backup file",
+			invoices: "id,customer_name\n",
+			items:    itemsHeader,
+			err:      "record 1: not a backup of invoices",
+		},
+		{
+			desc:     "swapped files",
+			invoices: itemsHeader,
+			items:    invoicesHeader,
+			err:      "record 1: not a backup of invoices",
+		},
+		{
+			desc:     "invalid columns",
+			invoices: "go-invoice,invoices,1\nid,customer,issue_date,status,created_at,updated_at\n",
+			items:    itemsHeader,
+			err:      "record 2: invalid columns",
+		},
+		{
+			desc:     "missing fields",
+			invoices: invoicesHeader + "inv-1,John Doe,,0\n",
+			items:    itemsHeader,
+			err:      "record 3: got 4 fields, want 6",
+		},
+		{
+			desc:     "invalid status",
+			invoices: invoicesHeader + "inv-1,John Doe,,9,2021-09-01T10:00:00Z,2021-09-01T10:00:00Z\n",
+			items:    itemsHeader,
+			err:      `record 3: invalid invoice "inv-1" status "9"`,
+		},
+		{
+			desc:     "invalid time",
+			invoices: invoicesHeader + "inv-1,John Doe,yesterday,1,2021-09-01T10:00:00Z,2021-09-01T10:00:00Z\n",
+			items:    itemsHeader,
+			err:      `record 3: invalid invoice "inv-1" issue_date`,
+		},
+		{
+			desc:     "duplicate invoice",
+			invoices: invoicesHeader + validInvoice + validInvoice,
+			items:    itemsHeader,
+			err:      `record 4: duplicate invoice "inv-1"`,
+		},
+		{
+			desc:     "invalid price",
+			invoices: invoicesHeader + validInvoice,
+			items:    itemsHeader + "inv-1,item-1,pen,one,1,2021-09-01T10:00:00Z\n",
+			err:      `record 3: invalid item "item-1" price "one"`,
+		},
+		{
+			desc:     "unknown invoice",
+			invoices: invoicesHeader + validInvoice,
+			items:    itemsHeader + "inv-2,item-1,pen,100,1,2021-09-01T10:00:00Z\n",
+			err:      `record 3: item "item-1" of unknown invoice "inv-2"`,
+		},
+	}
+
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			dir := writeBackup(t, tC.invoices, tC.items)
+
+			strg := memory.New()
+			inv := invoice.NewInvoice("John Doe")
+			if err := strg.AddInvoice(inv); err != nil {
+				t.Fatalf("AddInvoice(%v) failed: %v", inv, err)
+			}
+
+			err := strg.Restore(dir)
+			if err == nil {
+				t.Fatalf("Restore(%q) expected to fail", dir)
+			}
+			if !strings.Contains(err.Error(), tC.err) {
+				t.Errorf("Restore(%q) error = %q, want it to contain %q", dir, err, tC.err)
+			}
+
+			vinv, err := strg.FindInvoice(inv.ID)
+			if err != nil {
+				t.Fatalf("FindInvoice(%q) failed: %v", inv.ID, err)
+			}
+			if vinv == nil {
+				t.Errorf("FindInvoice(%q) invoice expected to remain after failed restore", inv.ID)
+			}
+		})
+	}
+}
+
+func TestRestoreMixedBackups(t *testing.T) {
+	strg := memory.New()
+	inv := invoice.NewInvoice("John Doe")
+	inv.Items = append(inv.Items, invoice.NewItem("pen", 100, 1))
+	if err := strg.AddInvoice(inv); err != nil {
+		t.Fatalf("AddInvoice(%v) failed: %v", inv, err)
+	}
+
+	dir := t.TempDir()
+	itemsPath := filepath.Join(dir, memory.ItemsFile)
+	if err := strg.Backup(dir); err != nil {
+		t.Fatalf("Backup(%q) failed: %v", dir, err)
+	}
+	items, err := os.ReadFile(itemsPath)
+	if err != nil {
+		t.Fatalf("ReadFile(%q) failed: %v", itemsPath, err)
+	}
+
+	// the next backup crashed after the invoices file was renamed
+	if err := strg.Backup(dir); err != nil {
+		t.Fatalf("Backup(%q) failed: %v", dir, err)
+	}
+	if err := os.WriteFile(itemsPath, items, 0600); err != nil {
+		t.Fatalf("WriteFile(%q) failed: %v", itemsPath, err)
+	}
+
+	err = memory.New().Restore(dir)
+	if err == nil {
+		t.Fatalf("Restore(%q) of files of different backups expected to fail", dir)
+	}
+	if !strings.Contains(err.Error(), "are from different backups") {
+		t.Errorf("Restore(%q) error = %q, want it to contain %q", dir, err, "are from different backups")
+	}
+}
+
+func TestRestoreMissingBackup(t *testing.T) {
+	strg := memory.New()
+	dir := t.TempDir()
+
+	err := strg.Restore(dir)
+	if err == nil {
+		t.Fatalf("Restore(%q) expected to fail", dir)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Restore(%q) error = %v, want not exist error", dir, err)
+	}
+}
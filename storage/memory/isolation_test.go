@@ -0,0 +1,201 @@
+package memory_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/antklim/go-invoice/invoice"
+	"github.com/antklim/go-invoice/storage/memory"
+)
+
+var isolationStorages = []struct {
+	name string
+	new  func() invoice.Storage
+}{
+	{name: "memory", new: func() invoice.Storage { return memory.New() }},
+	{name: "sharded", new: func() invoice.Storage { return memory.NewSharded(4) }},
+}
+
+// newIssuedInvoice returns invoice with issue date and unsorted items.
+func newIssuedInvoice() invoice.Invoice {
+	inv := invoice.NewInvoice("John Doe")
+	inv.Items = []invoice.Item{
+		invoice.NewItem("pen", 150, 2),
+		invoice.NewItem("apple", 75, 10),
+		invoice.NewItem("pineapple", 499, 1),
+	}
+	inv.Items[0].ID, inv.Items[1].ID, inv.Items[2].ID = "c", "b", "a"
+	date := time.Date(2021, time.September, 1, 0, 0, 0, 0, time.UTC)
+	inv.Date = &date
+	return inv
+}
+
+// mutate changes invoice issue date and items in place.
+func mutate(inv *invoice.Invoice) {
+	*inv.Date = inv.Date.Add(time.Hour)
+	inv.Items[0].Qty++
+	inv.Items[0], inv.Items[1] = inv.Items[1], inv.Items[0]
+	_, _ = inv.DeleteItem(inv.Items[len(inv.Items)-1].ID)
+}
+
+func assertStored(t *testing.T, strg invoice.Storage, want invoice.Invoice) {
+	t.Helper()
+
+	vinv, err := strg.FindInvoice(want.ID)
+	if err != nil {
+		t.Fatalf("FindInvoice(%q) failed: %v", want.ID, err)
+	}
+	if vinv == nil {
+		t.Fatalf("FindInvoice(%q) invoice expected, got nil", want.ID)
+	}
+	if !vinv.Date.Equal(*want.Date) || len(vinv.Items) != len(want.Items) {
+		t.Fatalf("stored invoice changed: %v, want %v", vinv, want)
+	}
+	for i := range want.Items {
+		if vinv.Items[i] != want.Items[i] {
+			t.Errorf("stored invoice item %d changed: %v, want %v", i, vinv.Items[i], want.Items[i])
+		}
+	}
+}
+
+func TestIsolation(t *testing.T) {
+	for _, s := range isolationStorages {
+		t.Run(s.name, func(t *testing.T) {
+			t.Run("added invoice changed by caller", func(t *testing.T) {
+				strg := s.new()
+				inv := newIssuedInvoice()
+				want := inv.Clone()
+				if err := strg.AddInvoice(inv); err != nil {
+					t.Fatalf("AddInvoice(%v) failed: %v", inv, err)
+				}
+
+				mutate(&inv)
+				assertStored(t, strg, want)
+			})
+
+			t.Run("updated invoice changed by caller", func(t *testing.T) {
+				strg := s.new()
+				inv := newIssuedInvoice()
+				if err := strg.AddInvoice(inv); err != nil {
+					t.Fatalf("AddInvoice(%v) failed: %v", inv, err)
+				}
+				inv.CustomerName = "Jane Doe"
+				want := inv.Clone()
+				if err := strg.UpdateInvoice(inv); err != nil {
+					t.Fatalf("UpdateInvoice(%v) failed: %v", inv, err)
+				}
+
+				mutate(&inv)
+				assertStored(t, strg, want)
+			})
+
+			t.Run("found invoice changed by caller", func(t *testing.T) {
+				strg := s.new()
+				inv := newIssuedInvoice()
+				if err := strg.AddInvoice(inv); err != nil {
+					t.Fatalf("AddInvoice(%v) failed: %v", inv, err)
+				}
+
+				vinv, err := strg.FindInvoice(inv.ID)
+				if err != nil {
+					t.Fatalf("FindInvoice(%q) failed: %v", inv.ID, err)
+				}
+				mutate(vinv)
+				assertStored(t, strg, inv)
+			})
+
+			t.Run("listed invoice changed by caller", func(t *testing.T) {
+				strg := s.new()
+				inv := newIssuedInvoice()
+				if err := strg.AddInvoice(inv); err != nil {
+					t.Fatalf("AddInvoice(%v) failed: %v", inv, err)
+				}
+
+				invs, _, err := strg.(invoice.Lister).ListInvoices("", 0)
+				if err != nil {
+					t.Fatalf("ListInvoices() failed: %v", err)
+				}
+				mutate(&invs[0])
+				assertStored(t, strg, inv)
+			})
+		})
+	}
+}
+
+// TestConcurrentIsolation mutates found invoices while other goroutines read
+// and update the same invoice. Run with the race detector, it fails when the
+// storage shares issue date or items with callers.
+func TestConcurrentIsolation(t *testing.T) {
+	for _, s := range isolationStorages {
+		t.Run(s.name, func(t *testing.T) {
+			strg := s.new()
+			inv := newIssuedInvoice()
+			if err := strg.AddInvoice(inv); err != nil {
+				t.Fatalf("AddInvoice(%v) failed: %v", inv, err)
+			}
+
+			var wg sync.WaitGroup
+			for w := 0; w < 8; w++ {
+				wg.Add(1)
+				go func(w int) {
+					defer wg.Done()
+					for i := 0; i < 100; i++ {
+						vinv, err := strg.FindInvoice(inv.ID)
+						if err != nil || vinv == nil || len(vinv.Items) != len(inv.Items) {
+							t.Errorf("FindInvoice(%q) = %v, %v, want %d items", inv.ID, vinv, err, len(inv.Items))
+							return
+						}
+
+						upd := vinv.Clone()
+						mutate(vinv)
+						if w%2 == 0 {
+							if err := strg.UpdateInvoice(upd); err != nil {
+								t.Errorf("UpdateInvoice(%v) failed: %v", upd, err)
+								return
+							}
+							mutate(&upd)
+						}
+					}
+				}(w)
+			}
+			wg.Wait()
+		})
+	}
+}
+
+// TestEqualIsolation compares found invoices with each other and with the added
+// invoice from many goroutines. Run with the race detector, it fails when Equal
+// reorders items of the compared invoices.
+func TestEqualIsolation(t *testing.T) {
+	strg := memory.New()
+	inv := newIssuedInvoice()
+	if err := strg.AddInvoice(inv); err != nil {
+		t.Fatalf("AddInvoice(%v) failed: %v", inv, err)
+	}
+	vinv, err := strg.FindInvoice(inv.ID)
+	if err != nil || vinv == nil {
+		t.Fatalf("FindInvoice(%q) = %v, %v", inv.ID, vinv, err)
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				if !inv.Equal(vinv) || !vinv.Equal(&inv) {
+					t.Error("found invoice expected to equal added invoice")
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, items := range [][]invoice.Item{inv.Items, vinv.Items} {
+		if got := items[0].ID + items[1].ID + items[2].ID; got != "cba" {
+			t.Errorf("invalid item order %q, want %q", got, "cba")
+		}
+	}
+}
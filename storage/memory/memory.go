@@ -2,23 +2,63 @@ package memory
 
 import (
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/antklim/go-invoice/invoice"
 )
 
+// Memory stores copies of invoices: invoices passed to and returned by the
+// storage never share issue date or items with the stored ones.
 type Memory struct {
-	sync.RWMutex // guards records
+	sync.RWMutex // guards records, outbox and wal
 	records      map[string]invoice.Invoice
+	outbox       []invoice.Event // in the order written
+	wal          *wal            // nil when storage is not durable
 }
 
 var _ invoice.Storage = (*Memory)(nil)
+var _ invoice.Lister = (*Memory)(nil)
+var _ invoice.Transactor = (*Memory)(nil)
+var _ invoice.Outbox = (*Memory)(nil)
 
 func New() *Memory {
 	return &Memory{records: make(map[string]invoice.Invoice)}
 }
 
+// Open creates durable storage in the directory. Every invoice change appended
+// to the write-ahead log before it is applied, and the log is compacted to the
+// snapshot of all invoices periodically. Open recovers invoices from the
+// snapshot and the log, the storage should be closed with Close.
+func Open(dir string, opts ...Option) (*Memory, error) {
+	mopts := defaultOptions
+	for _, o := range opts {
+		o.apply(&mopts)
+	}
+
+	memo := New()
+	w, err := openWAL(dir, mopts, memo.records, &memo.outbox)
+	if err != nil {
+		return nil, err
+	}
+	memo.wal = w
+	return memo, nil
+}
+
+// Close closes the write-ahead log of the durable storage.
+func (memo *Memory) Close() error {
+	memo.Lock()
+	defer memo.Unlock()
+
+	if memo.wal == nil {
+		return nil
+	}
+	err := memo.wal.close()
+	memo.wal = nil
+	return err
+}
+
 func (memo *Memory) AddInvoice(inv invoice.Invoice) error {
 	memo.Lock()
 	defer memo.Unlock()
@@ -26,7 +66,11 @@ func (memo *Memory) AddInvoice(inv invoice.Invoice) error {
 	if _, ok := memo.records[inv.ID]; ok {
 		return fmt.Errorf("invoice %q exists", inv.ID)
 	}
-	memo.records[inv.ID] = inv
+	if err := memo.log(opAdd, inv); err != nil {
+		return err
+	}
+	memo.records[inv.ID] = inv.Clone()
+	memo.compact()
 
 	return nil
 }
@@ -40,7 +84,8 @@ func (memo *Memory) FindInvoice(id string) (*invoice.Invoice, error) {
 		return nil, nil
 	}
 
-	return &inv, nil
+	c := inv.Clone()
+	return &c, nil
 }
 
 func (memo *Memory) UpdateInvoice(inv invoice.Invoice) error {
@@ -52,7 +97,59 @@ func (memo *Memory) UpdateInvoice(inv invoice.Invoice) error {
 	}
 
 	inv.UpdatedAt = time.Now()
-	memo.records[inv.ID] = inv
+	if err := memo.log(opUpdate, inv); err != nil {
+		return err
+	}
+	memo.records[inv.ID] = inv.Clone()
+	memo.compact()
 
 	return nil
 }
+
+// ListInvoices lists invoices ordered by ID. The cursor is the ID of the last
+// invoice of the previous page.
+func (memo *Memory) ListInvoices(cursor string, limit int) ([]invoice.Invoice, string, error) {
+	memo.RLock()
+	defer memo.RUnlock()
+
+	var ids []string
+	for id := range memo.records {
+		if id > cursor {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	var next string
+	if limit > 0 && len(ids) > limit {
+		ids = ids[:limit]
+		next = ids[limit-1]
+	}
+
+	invs := make([]invoice.Invoice, len(ids))
+	for i, id := range ids {
+		rec := memo.records[id]
+		invs[i] = rec.Clone()
+	}
+	return invs, next, nil
+}
+
+// compact takes the snapshot of the durable storage when the write-ahead log
+// has grown to the snapshot threshold. Snapshot errors are not returned: the
+// change is already in the log, and the snapshot is retried on the next write.
+// Caller should hold the write lock.
+func (memo *Memory) compact() {
+	if memo.wal == nil || !memo.wal.needsSnapshot() {
+		return
+	}
+	_ = memo.wal.snapshot(memo.records, memo.outbox)
+}
+
+// log appends the change to the write-ahead log of the durable storage. Caller
+// should hold the write lock.
+func (memo *Memory) log(op string, inv invoice.Invoice) error {
+	if memo.wal == nil {
+		return nil
+	}
+	return memo.wal.append(walRecord{Op: op, Invoice: inv})
+}
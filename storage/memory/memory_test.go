@@ -6,6 +6,7 @@ import (
 
 	"github.com/antklim/go-invoice/invoice"
 	"github.com/antklim/go-invoice/storage/memory"
+	"github.com/antklim/go-invoice/storage/storagetest"
 )
 
 func TestFindInvoice(t *testing.T) {
@@ -60,3 +61,9 @@ func TestUpdateInvoice(t *testing.T) {
 			inv.UpdatedAt.Format(time.RFC3339))
 	}
 }
+
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, storagetest.FactoryFunc(func() invoice.Storage {
+		return memory.New()
+	}))
+}
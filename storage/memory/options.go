@@ -0,0 +1,54 @@
+package memory
+
+import "time"
+
+type options struct {
+	syncPolicy        SyncPolicy
+	syncInterval      time.Duration
+	snapshotThreshold int
+}
+
+var defaultOptions = options{
+	syncPolicy:        SyncAlways,
+	syncInterval:      time.Second,
+	snapshotThreshold: 1000, // nolint:gomnd
+}
+
+type Option interface {
+	apply(*options)
+}
+
+type funcOption struct {
+	f func(*options)
+}
+
+func (f *funcOption) apply(o *options) {
+	f.f(o)
+}
+
+func newFuncOption(f func(*options)) Option {
+	return &funcOption{f: f}
+}
+
+// WithSyncPolicy sets when the write-ahead log is synced to the disk.
+func WithSyncPolicy(v SyncPolicy) Option {
+	return newFuncOption(func(o *options) {
+		o.syncPolicy = v
+	})
+}
+
+// WithSyncInterval sets the interval of the write-ahead log syncs. It is used
+// only with SyncInterval policy.
+func WithSyncInterval(v time.Duration) Option {
+	return newFuncOption(func(o *options) {
+		o.syncInterval = v
+	})
+}
+
+// WithSnapshotThreshold sets the number of the write-ahead log records after
+// which the snapshot of all invoices written and the log truncated.
+func WithSnapshotThreshold(v int) Option {
+	return newFuncOption(func(o *options) {
+		o.snapshotThreshold = v
+	})
+}
@@ -0,0 +1,80 @@
+package memory
+
+import "github.com/antklim/go-invoice/invoice"
+
+// OutboxEvents returns up to limit events of the outbox in the order they
+// were written, all events when limit is not positive.
+func (memo *Memory) OutboxEvents(limit int) ([]invoice.Event, error) {
+	memo.RLock()
+	defer memo.RUnlock()
+
+	n := len(memo.outbox)
+	if limit > 0 && limit < n {
+		n = limit
+	}
+	events := make([]invoice.Event, n)
+	for i := range events {
+		events[i] = memo.outbox[i]
+		events[i].Invoice = events[i].Invoice.Clone()
+	}
+	return events, nil
+}
+
+// DeleteOutboxEvents deletes the events from the outbox. The deletion is
+// appended to the write-ahead log of the durable storage.
+func (memo *Memory) DeleteOutboxEvents(events []invoice.Event) error {
+	ids := make([]string, len(events))
+	for i, e := range events {
+		ids[i] = e.ID
+	}
+
+	memo.Lock()
+	defer memo.Unlock()
+
+	if memo.wal != nil {
+		if err := memo.wal.append(walRecord{Op: opAck, Acked: ids}); err != nil {
+			return err
+		}
+	}
+	memo.outbox = deleteEvents(memo.outbox, ids)
+	memo.compact()
+	return nil
+}
+
+// appendEvents appends the events which IDs are not in the events yet.
+func appendEvents(events, added []invoice.Event) []invoice.Event {
+	if len(added) == 0 {
+		return events
+	}
+
+	ids := make(map[string]bool, len(events))
+	for _, e := range events {
+		ids[e.ID] = true
+	}
+	for _, e := range added {
+		if !ids[e.ID] {
+			ids[e.ID] = true
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+// deleteEvents returns the events without the events of the IDs.
+func deleteEvents(events []invoice.Event, ids []string) []invoice.Event {
+	if len(ids) == 0 {
+		return events
+	}
+
+	deleted := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		deleted[id] = true
+	}
+	kept := events[:0]
+	for _, e := range events {
+		if !deleted[e.ID] {
+			kept = append(kept, e)
+		}
+	}
+	return kept
+}
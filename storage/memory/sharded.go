@@ -0,0 +1,141 @@
+package memory
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/antklim/go-invoice/invoice"
+)
+
+// DefaultShards is the default number of the sharded storage shards.
+const DefaultShards = 32
+
+type shard struct {
+	sync.RWMutex // guards records
+	records      map[string]invoice.Invoice
+}
+
+// Sharded is the in-memory storage that spreads invoices over shards by the
+// hash of invoice ID. Every shard has its own lock, so operations on invoices
+// of different shards do not block each other. It has the same semantics as
+// Memory, including copying of invoices, but is not durable.
+type Sharded struct {
+	shards []*shard
+}
+
+var _ invoice.Storage = (*Sharded)(nil)
+var _ invoice.Lister = (*Sharded)(nil)
+var _ invoice.Transactor = (*Sharded)(nil)
+
+// NewSharded creates storage of n shards. DefaultShards used when n is not
+// positive.
+func NewSharded(n int) *Sharded {
+	if n <= 0 {
+		n = DefaultShards
+	}
+
+	s := &Sharded{shards: make([]*shard, n)}
+	for i := range s.shards {
+		s.shards[i] = &shard{records: make(map[string]invoice.Invoice)}
+	}
+	return s
+}
+
+func (s *Sharded) AddInvoice(inv invoice.Invoice) error {
+	sh := s.shard(inv.ID)
+	sh.Lock()
+	defer sh.Unlock()
+
+	if _, ok := sh.records[inv.ID]; ok {
+		return fmt.Errorf("invoice %q exists", inv.ID)
+	}
+	sh.records[inv.ID] = inv.Clone()
+
+	return nil
+}
+
+func (s *Sharded) FindInvoice(id string) (*invoice.Invoice, error) {
+	sh := s.shard(id)
+	sh.RLock()
+	defer sh.RUnlock()
+
+	inv, ok := sh.records[id]
+	if !ok {
+		return nil, nil
+	}
+
+	c := inv.Clone()
+	return &c, nil
+}
+
+func (s *Sharded) UpdateInvoice(inv invoice.Invoice) error {
+	sh := s.shard(inv.ID)
+	sh.Lock()
+	defer sh.Unlock()
+
+	if _, ok := sh.records[inv.ID]; !ok {
+		return fmt.Errorf("invoice %q not found", inv.ID)
+	}
+
+	inv.UpdatedAt = time.Now()
+	sh.records[inv.ID] = inv.Clone()
+
+	return nil
+}
+
+// ListInvoices lists invoices ordered by ID. The cursor is the ID of the last
+// invoice of the previous page. Shards are read one by one, so the page is not
+// a consistent snapshot of all shards. Only the invoices of the page are
+// cloned.
+func (s *Sharded) ListInvoices(cursor string, limit int) ([]invoice.Invoice, string, error) {
+	var ids []string
+	for _, sh := range s.shards {
+		sh.RLock()
+		for id := range sh.records {
+			if id > cursor {
+				ids = append(ids, id)
+			}
+		}
+		sh.RUnlock()
+	}
+	sort.Strings(ids)
+
+	var next string
+	if limit > 0 && len(ids) > limit {
+		ids = ids[:limit]
+		next = ids[limit-1]
+	}
+
+	invs := make([]invoice.Invoice, 0, len(ids))
+	for _, id := range ids {
+		sh := s.shard(id)
+		sh.RLock()
+		if inv, ok := sh.records[id]; ok {
+			invs = append(invs, inv.Clone())
+		}
+		sh.RUnlock()
+	}
+	return invs, next, nil
+}
+
+// shard returns the shard of the invoice ID.
+func (s *Sharded) shard(id string) *shard {
+	return s.shards[s.index(id)]
+}
+
+// index returns the shard index of the invoice ID by its FNV-1a hash.
+func (s *Sharded) index(id string) int {
+	const (
+		offset32 = 2166136261
+		prime32  = 16777619
+	)
+
+	h := uint32(offset32)
+	for i := 0; i < len(id); i++ {
+		h ^= uint32(id[i])
+		h *= prime32
+	}
+	return int(h % uint32(len(s.shards)))
+}
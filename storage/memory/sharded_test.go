@@ -0,0 +1,75 @@
+package memory_test
+
+import (
+	"fmt"
+	"math/rand"
+	"sync/atomic"
+	"testing"
+
+	"github.com/antklim/go-invoice/invoice"
+	"github.com/antklim/go-invoice/storage/memory"
+	"github.com/antklim/go-invoice/storage/storagetest"
+)
+
+func TestShardedConformance(t *testing.T) {
+	for _, n := range []int{1, 0} {
+		t.Run(fmt.Sprintf("%d shards", n), func(t *testing.T) {
+			storagetest.Run(t, storagetest.FactoryFunc(func() invoice.Storage {
+				return memory.NewSharded(n)
+			}))
+		})
+	}
+}
+
+// benchmarkMixed runs parallel workload of finds and updates of the invoices,
+// writes is the percentage of updates.
+func benchmarkMixed(b *testing.B, strg invoice.Storage, writes int) {
+	const invoices = 1000
+
+	ids := make([]string, invoices)
+	for i := range ids {
+		inv := invoice.NewInvoice(fmt.Sprintf("customer %d", i))
+		if err := strg.AddInvoice(inv); err != nil {
+			b.Fatalf("AddInvoice(%v) failed: %v", inv, err)
+		}
+		ids[i] = inv.ID
+	}
+
+	var seed int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		rnd := rand.New(rand.NewSource(atomic.AddInt64(&seed, 1))) // nolint:gosec
+		for pb.Next() {
+			id := ids[rnd.Intn(invoices)]
+			inv, err := strg.FindInvoice(id)
+			if err != nil || inv == nil {
+				b.Errorf("FindInvoice(%q) = %v, %v", id, inv, err)
+				return
+			}
+			if rnd.Intn(100) < writes { // nolint:gomnd
+				if err := strg.UpdateInvoice(*inv); err != nil {
+					b.Errorf("UpdateInvoice(%v) failed: %v", inv, err)
+					return
+				}
+			}
+		}
+	})
+}
+
+func BenchmarkMixed(b *testing.B) {
+	storages := []struct {
+		name string
+		new  func() invoice.Storage
+	}{
+		{name: "memory", new: func() invoice.Storage { return memory.New() }},
+		{name: "sharded", new: func() invoice.Storage { return memory.NewSharded(memory.DefaultShards) }},
+	}
+
+	for _, writes := range []int{10, 50} {
+		for _, s := range storages {
+			b.Run(fmt.Sprintf("%s/%d%%writes", s.name, writes), func(b *testing.B) {
+				benchmarkMixed(b, s.new(), writes)
+			})
+		}
+	}
+}
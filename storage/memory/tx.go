@@ -0,0 +1,209 @@
+package memory
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/antklim/go-invoice/invoice"
+)
+
+// staged is the invoice change staged by transaction.
+type staged struct {
+	op      string // opAdd or opUpdate
+	inv     invoice.Invoice
+	version time.Time // update time of the stored invoice read by transaction
+}
+
+// journal is the storage of transaction. It stages invoices changes in order
+// and reads invoices through the staged changes. Staged changes are committed
+// by the storage.
+type journal struct {
+	find    func(id string) (*invoice.Invoice, error) // reads stored invoice
+	changes map[string]*staged
+	order   []string
+	reads   map[string]time.Time // update time of stored invoices when first read
+	events  []invoice.Event
+}
+
+var _ invoice.Storage = (*journal)(nil)
+var _ invoice.EventWriter = (*journal)(nil)
+
+func newJournal(find func(id string) (*invoice.Invoice, error)) *journal {
+	return &journal{
+		find:    find,
+		changes: make(map[string]*staged),
+		reads:   make(map[string]time.Time),
+	}
+}
+
+func (j *journal) AddInvoice(inv invoice.Invoice) error {
+	if _, ok := j.changes[inv.ID]; ok {
+		return fmt.Errorf("invoice %q exists", inv.ID)
+	}
+	cur, err := j.read(inv.ID)
+	if err != nil {
+		return err
+	}
+	if cur != nil {
+		return fmt.Errorf("invoice %q exists", inv.ID)
+	}
+
+	j.stage(&staged{op: opAdd, inv: inv.Clone()})
+	return nil
+}
+
+func (j *journal) FindInvoice(id string) (*invoice.Invoice, error) {
+	if ch, ok := j.changes[id]; ok {
+		c := ch.inv.Clone()
+		return &c, nil
+	}
+	return j.read(id)
+}
+
+func (j *journal) UpdateInvoice(inv invoice.Invoice) error {
+	if ch, ok := j.changes[inv.ID]; ok {
+		ch.inv = inv.Clone()
+		return nil
+	}
+
+	version, ok := j.reads[inv.ID]
+	if !ok {
+		cur, err := j.read(inv.ID)
+		if err != nil {
+			return err
+		}
+		if cur == nil {
+			return fmt.Errorf("invoice %q not found", inv.ID)
+		}
+		version = cur.UpdatedAt
+	}
+
+	j.stage(&staged{op: opUpdate, inv: inv.Clone(), version: version})
+	return nil
+}
+
+// WriteEvent stages the event written to the outbox on commit.
+func (j *journal) WriteEvent(e invoice.Event) error {
+	e.Invoice = e.Invoice.Clone()
+	j.events = append(j.events, e)
+	return nil
+}
+
+// read finds the stored invoice and remembers its update time.
+func (j *journal) read(id string) (*invoice.Invoice, error) {
+	inv, err := j.find(id)
+	if err != nil || inv == nil {
+		return inv, err
+	}
+	if _, ok := j.reads[id]; !ok {
+		j.reads[id] = inv.UpdatedAt
+	}
+	return inv, nil
+}
+
+func (j *journal) stage(ch *staged) {
+	j.changes[ch.inv.ID] = ch
+	j.order = append(j.order, ch.inv.ID)
+}
+
+// commitSet validates staged changes against the stored invoices, found by
+// lookup, and returns invoices to store in the staging order. Updated invoices
+// get the update time of the commit.
+func (j *journal) commitSet(lookup func(id string) (invoice.Invoice, bool)) ([]invoice.Invoice, error) {
+	now := time.Now()
+	invs := make([]invoice.Invoice, len(j.order))
+	for i, id := range j.order {
+		ch := j.changes[id]
+		cur, ok := lookup(id)
+		switch {
+		case ch.op == opAdd && ok:
+			return nil, fmt.Errorf("invoice %q exists", id)
+		case ch.op == opUpdate && !ok:
+			return nil, fmt.Errorf("invoice %q not found", id)
+		case ch.op == opUpdate && !cur.UpdatedAt.Equal(ch.version):
+			return nil, &invoice.ConflictError{ID: id}
+		}
+
+		invs[i] = ch.inv
+		if ch.op == opUpdate {
+			invs[i].UpdatedAt = now
+		}
+	}
+	return invs, nil
+}
+
+// RunInTx runs f in transaction. Changes and events of the transaction are
+// appended to the write-ahead log as one record, so they are recovered all or
+// none.
+func (memo *Memory) RunInTx(f func(tx invoice.Storage) error) error {
+	j := newJournal(memo.FindInvoice)
+	if err := f(j); err != nil {
+		return err
+	}
+	if len(j.order) == 0 && len(j.events) == 0 {
+		return nil
+	}
+
+	memo.Lock()
+	defer memo.Unlock()
+
+	invs, err := j.commitSet(func(id string) (invoice.Invoice, bool) {
+		inv, ok := memo.records[id]
+		return inv, ok
+	})
+	if err != nil {
+		return err
+	}
+	if memo.wal != nil {
+		if err := memo.wal.append(walRecord{Op: opTx, Invoices: invs, Events: j.events}); err != nil {
+			return err
+		}
+	}
+	for _, inv := range invs {
+		memo.records[inv.ID] = inv
+	}
+	memo.outbox = append(memo.outbox, j.events...)
+	memo.compact()
+
+	return nil
+}
+
+// RunInTx runs f in transaction. Shards of the changed invoices are locked in
+// order for the commit. Events cannot be written, the storage has no outbox.
+func (s *Sharded) RunInTx(f func(tx invoice.Storage) error) error {
+	j := newJournal(s.FindInvoice)
+	if err := f(j); err != nil {
+		return err
+	}
+	if len(j.events) > 0 {
+		return errors.New("storage does not support outbox")
+	}
+	if len(j.order) == 0 {
+		return nil
+	}
+
+	locked := make([]bool, len(s.shards))
+	for _, id := range j.order {
+		locked[s.index(id)] = true
+	}
+	for i, ok := range locked {
+		if ok {
+			s.shards[i].Lock()
+			defer s.shards[i].Unlock()
+		}
+	}
+
+	invs, err := j.commitSet(func(id string) (invoice.Invoice, bool) {
+		inv, ok := s.shard(id).records[id]
+		return inv, ok
+	})
+	if err != nil {
+		return err
+	}
+	for _, inv := range invs {
+		s.shard(inv.ID).records[inv.ID] = inv
+	}
+
+	return nil
+}
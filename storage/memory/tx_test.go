@@ -0,0 +1,84 @@
+package memory_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/antklim/go-invoice/invoice"
+)
+
+func TestRunInTxConflict(t *testing.T) {
+	for _, s := range isolationStorages {
+		t.Run(s.name, func(t *testing.T) {
+			strg := s.new()
+			inv1 := invoice.NewInvoice("John Doe")
+			inv2 := invoice.NewInvoice("Jane Doe")
+			for _, inv := range []invoice.Invoice{inv1, inv2} {
+				if err := strg.AddInvoice(inv); err != nil {
+					t.Fatalf("AddInvoice(%v) failed: %v", inv, err)
+				}
+			}
+
+			err := strg.(invoice.Transactor).RunInTx(func(tx invoice.Storage) error {
+				for _, id := range []string{inv1.ID, inv2.ID} {
+					vinv, err := tx.FindInvoice(id)
+					if err != nil {
+						return err
+					}
+					vinv.CustomerName = "Bob Doe"
+					if err := tx.UpdateInvoice(*vinv); err != nil {
+						return err
+					}
+				}
+
+				upd := inv2
+				upd.CustomerName = "Jane Smith"
+				return strg.UpdateInvoice(upd)
+			})
+			if err == nil {
+				t.Fatal("RunInTx() expected to fail")
+			} else if got, want := err.Error(), fmt.Sprintf("invoice %q was updated concurrently", inv2.ID); got != want {
+				t.Errorf("RunInTx() = %v, want %v", got, want)
+			} else if conflict := (*invoice.ConflictError)(nil); !errors.As(err, &conflict) {
+				t.Errorf("RunInTx() = %T, want *invoice.ConflictError", err)
+			}
+
+			for id, want := range map[string]string{inv1.ID: "John Doe", inv2.ID: "Jane Smith"} {
+				vinv, err := strg.FindInvoice(id)
+				if err != nil || vinv == nil {
+					t.Fatalf("FindInvoice(%q) = %v, %v", id, vinv, err)
+				}
+				if vinv.CustomerName != want {
+					t.Errorf("invalid invoice.CustomerName %q, want %q", vinv.CustomerName, want)
+				}
+			}
+		})
+	}
+}
+
+func TestOpenRecoversTransaction(t *testing.T) {
+	dir := t.TempDir()
+	strg := openStorage(t, dir)
+	inv1 := invoice.NewInvoice("John Doe")
+	if err := strg.AddInvoice(inv1); err != nil {
+		t.Fatalf("AddInvoice(%v) failed: %v", inv1, err)
+	}
+
+	inv1.Items = append(inv1.Items, invoice.NewItem("pen", 100, 2))
+	inv2 := invoice.NewInvoice("Jane Doe")
+	err := strg.RunInTx(func(tx invoice.Storage) error {
+		if err := tx.UpdateInvoice(inv1); err != nil {
+			return err
+		}
+		return tx.AddInvoice(inv2)
+	})
+	if err != nil {
+		t.Fatalf("RunInTx() failed: %v", err)
+	}
+	closeStorage(t, strg)
+
+	strg = openStorage(t, dir)
+	defer closeStorage(t, strg)
+	assertInvoices(t, strg, inv1, inv2)
+}
@@ -0,0 +1,418 @@
+package memory
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/antklim/go-invoice/invoice"
+	"github.com/pkg/errors"
+)
+
+// SyncPolicy defines when the write-ahead log is synced to the disk.
+type SyncPolicy int
+
+// Supported sync policies.
+const (
+	SyncAlways   SyncPolicy = iota // sync after every write, no acknowledged write lost
+	SyncInterval                   // sync periodically, writes of the last interval can be lost
+	SyncNever                      // leave syncs to the operating system
+)
+
+var syncPolicyName = map[SyncPolicy]string{
+	SyncAlways:   "always",
+	SyncInterval: "interval",
+	SyncNever:    "never",
+}
+
+func (p SyncPolicy) String() string { return syncPolicyName[p] }
+
+// ParseSyncPolicy returns the sync policy by its name.
+func ParseSyncPolicy(s string) (SyncPolicy, error) {
+	for p, name := range syncPolicyName {
+		if name == s {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown sync policy %q", s)
+}
+
+// Durable storage files names.
+const (
+	LogFile      = "invoices.wal"
+	SnapshotFile = "snapshot.wal"
+)
+
+// Log record operations.
+const (
+	opAdd    = "add"
+	opUpdate = "update"
+	opPut    = "put" // snapshot record
+	opTx     = "tx"  // invoices changed and events written by transaction
+	opAck    = "ack" // events deleted from the outbox
+)
+
+// Every log record is stored as the payload length and the payload CRC-32C
+// checksum, both little-endian uint32, followed by the payload.
+const (
+	recordHeaderSize = 8
+	maxRecordSize    = 64 << 20
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+type walRecord struct {
+	Op       string            `json:"op"`
+	Invoice  invoice.Invoice   `json:"invoice"`
+	Invoices []invoice.Invoice `json:"invoices,omitempty"` // transaction invoices
+	Events   []invoice.Event   `json:"events,omitempty"`   // events written to the outbox
+	Acked    []string          `json:"acked,omitempty"`    // IDs of events deleted from the outbox
+}
+
+// invoices returns invoices changed by the record.
+func (rec walRecord) invoices() []invoice.Invoice {
+	switch rec.Op {
+	case opTx:
+		return rec.Invoices
+	case opAck:
+		return nil
+	}
+	return []invoice.Invoice{rec.Invoice}
+}
+
+// subject describes the record in errors.
+func (rec walRecord) subject() string {
+	switch rec.Op {
+	case opTx:
+		return fmt.Sprintf("transaction of %d invoices", len(rec.Invoices))
+	case opAck:
+		return fmt.Sprintf("deletion of %d events", len(rec.Acked))
+	}
+	return fmt.Sprintf("invoice %q", rec.Invoice.ID)
+}
+
+func encodeRecord(rec walRecord) ([]byte, error) {
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, recordHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(buf[4:8], crc32.Checksum(payload, crcTable))
+	copy(buf[recordHeaderSize:], payload)
+	return buf, nil
+}
+
+// readRecords calls f for every record of r. It stops on the torn record, the
+// incomplete or damaged one at the end of r, and returns the size of valid
+// records read and true when such torn record found. Damaged records followed
+// by more data are not torn writes, they fail the read.
+func readRecords(r io.Reader, f func(walRecord) error) (int64, bool, error) {
+	br := bufio.NewReader(r)
+	header := make([]byte, recordHeaderSize)
+	var size int64
+
+	for {
+		if _, err := io.ReadFull(br, header); err == io.EOF {
+			return size, false, nil
+		} else if err == io.ErrUnexpectedEOF {
+			return size, true, nil
+		} else if err != nil {
+			return size, false, err
+		}
+
+		n := binary.LittleEndian.Uint32(header[0:4])
+		if n > maxRecordSize {
+			return tornTail(br, size)
+		}
+
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(br, payload); err == io.EOF || err == io.ErrUnexpectedEOF {
+			return size, true, nil
+		} else if err != nil {
+			return size, false, err
+		}
+		if crc32.Checksum(payload, crcTable) != binary.LittleEndian.Uint32(header[4:8]) {
+			return tornTail(br, size)
+		}
+
+		var rec walRecord
+		if err := json.Unmarshal(payload, &rec); err != nil {
+			return size, false, errors.Wrapf(err, "record at offset %d unmarshal failed", size)
+		}
+		if err := f(rec); err != nil {
+			return size, false, err
+		}
+		size += int64(recordHeaderSize + n)
+	}
+}
+
+// tornTail reports the damaged record at the offset as torn when it is the
+// last data of r, otherwise the record is corrupted.
+func tornTail(br *bufio.Reader, offset int64) (int64, bool, error) {
+	if _, err := br.Peek(1); err == io.EOF {
+		return offset, true, nil
+	} else if err != nil {
+		return offset, false, err
+	}
+	return offset, false, errors.Errorf("record at offset %d is corrupted", offset)
+}
+
+// wal is the write-ahead log of the invoices changes. Appends are made by the
+// storage while it holds the write lock.
+type wal struct {
+	dir     string
+	opts    options
+	f       *os.File
+	size    int64 // size of the valid log records
+	records int   // number of records since the last snapshot
+	err     error // set when the log cannot be repaired after a failed append
+
+	dirty int32 // set when there are appended records not synced yet
+	done  chan struct{}
+	wg    sync.WaitGroup
+}
+
+// openWAL loads the snapshot and replays the log of the directory into the
+// records and the outbox. Torn records at the end of the log, left by
+// interrupted writes, are truncated. Damaged records in the middle of the log
+// fail the open and the log is left as is.
+func openWAL(dir string, opts options, records map[string]invoice.Invoice, outbox *[]invoice.Event) (*wal, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil { // nolint:gomnd
+		return nil, errors.Wrapf(err, "create directory %q failed", dir)
+	}
+
+	apply := func(rec walRecord) error {
+		for _, inv := range rec.invoices() {
+			records[inv.ID] = inv
+		}
+		*outbox = appendEvents(*outbox, rec.Events)
+		*outbox = deleteEvents(*outbox, rec.Acked)
+		return nil
+	}
+
+	if err := loadSnapshot(filepath.Join(dir, SnapshotFile), apply); err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(dir, LogFile)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600) // nolint:gomnd
+	if err != nil {
+		return nil, errors.Wrapf(err, "open log %q failed", path)
+	}
+
+	w := &wal{dir: dir, opts: opts, f: f, done: make(chan struct{})}
+	size, torn, err := readRecords(f, func(rec walRecord) error {
+		w.records++
+		return apply(rec)
+	})
+	if err != nil {
+		f.Close()
+		return nil, errors.Wrapf(err, "replay log %q failed", path)
+	}
+	if torn {
+		if err := f.Truncate(size); err != nil {
+			f.Close()
+			return nil, errors.Wrapf(err, "truncate torn log %q failed", path)
+		}
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return nil, errors.Wrapf(err, "truncate torn log %q failed", path)
+		}
+	}
+	if _, err := f.Seek(size, io.SeekStart); err != nil {
+		f.Close()
+		return nil, errors.Wrapf(err, "open log %q failed", path)
+	}
+	w.size = size
+
+	if opts.syncPolicy == SyncInterval {
+		w.wg.Add(1)
+		go w.syncLoop()
+	}
+
+	return w, nil
+}
+
+func loadSnapshot(path string, apply func(walRecord) error) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "open snapshot %q failed", path)
+	}
+	defer f.Close()
+
+	// snapshot replaced atomically, it is never torn
+	_, torn, err := readRecords(f, apply)
+	if err != nil {
+		return errors.Wrapf(err, "load snapshot %q failed", path)
+	}
+	if torn {
+		return fmt.Errorf("snapshot %q damaged", path)
+	}
+	return nil
+}
+
+// append writes the record to the log and syncs it according to the sync
+// policy. When the write fails the log is truncated to the last valid record,
+// so the record is not replayed.
+func (w *wal) append(rec walRecord) error {
+	if w.err != nil {
+		return w.err
+	}
+
+	buf, err := encodeRecord(rec)
+	if err != nil {
+		return errors.Wrapf(err, "%s marshal failed", rec.subject())
+	}
+
+	_, err = w.f.Write(buf)
+	if err == nil && w.opts.syncPolicy == SyncAlways {
+		err = w.f.Sync()
+	}
+	if err != nil {
+		w.rollback()
+		return errors.Wrapf(err, "append log record of %s failed", rec.subject())
+	}
+
+	w.size += int64(len(buf))
+	w.records++
+	atomic.StoreInt32(&w.dirty, 1)
+	return nil
+}
+
+func (w *wal) rollback() {
+	if err := w.f.Truncate(w.size); err != nil {
+		w.err = errors.Wrap(err, "log truncate after failed append failed")
+		return
+	}
+	if _, err := w.f.Seek(w.size, io.SeekStart); err != nil {
+		w.err = errors.Wrap(err, "log truncate after failed append failed")
+	}
+}
+
+// needsSnapshot returns true when the log has grown to the snapshot threshold.
+func (w *wal) needsSnapshot() bool {
+	return w.opts.snapshotThreshold > 0 && w.records >= w.opts.snapshotThreshold
+}
+
+// snapshot writes all records and the outbox to the snapshot file and
+// truncates the log.
+// Snapshot written to a temporary file and renamed, so the previous snapshot
+// and the log stay valid until the new snapshot is durable. Records replayed
+// from the log over the snapshot are idempotent, events already in the outbox
+// are not appended again, so the crash before the log truncated does not
+// change the recovered records and outbox.
+func (w *wal) snapshot(records map[string]invoice.Invoice, outbox []invoice.Event) error {
+	if w.err != nil {
+		return w.err
+	}
+
+	path := filepath.Join(w.dir, SnapshotFile)
+	tmp, err := os.CreateTemp(w.dir, SnapshotFile+".*.tmp")
+	if err != nil {
+		return errors.Wrapf(err, "create snapshot %q failed", path)
+	}
+	defer os.Remove(tmp.Name()) // nolint:errcheck
+
+	bw := bufio.NewWriter(tmp)
+	for _, inv := range records {
+		buf, err := encodeRecord(walRecord{Op: opPut, Invoice: inv})
+		if err != nil {
+			tmp.Close()
+			return errors.Wrapf(err, "invoice %q marshal failed", inv.ID)
+		}
+		if _, err := bw.Write(buf); err != nil {
+			tmp.Close()
+			return errors.Wrapf(err, "write snapshot %q failed", path)
+		}
+	}
+	if len(outbox) > 0 {
+		buf, err := encodeRecord(walRecord{Op: opTx, Events: outbox})
+		if err != nil {
+			tmp.Close()
+			return errors.Wrap(err, "outbox marshal failed")
+		}
+		if _, err := bw.Write(buf); err != nil {
+			tmp.Close()
+			return errors.Wrapf(err, "write snapshot %q failed", path)
+		}
+	}
+	err = bw.Flush()
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err == nil {
+		err = syncDir(w.dir)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "write snapshot %q failed", path)
+	}
+
+	if err := w.f.Truncate(0); err != nil {
+		w.err = errors.Wrap(err, "log truncate after snapshot failed")
+		return w.err
+	}
+	if _, err := w.f.Seek(0, io.SeekStart); err != nil {
+		w.err = errors.Wrap(err, "log truncate after snapshot failed")
+		return w.err
+	}
+	w.size = 0
+	w.records = 0
+	return nil
+}
+
+func (w *wal) syncLoop() {
+	defer w.wg.Done()
+
+	t := time.NewTicker(w.opts.syncInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if atomic.CompareAndSwapInt32(&w.dirty, 1, 0) {
+				_ = w.f.Sync()
+			}
+		case <-w.done:
+			return
+		}
+	}
+}
+
+// close stops the periodic syncs, syncs and closes the log.
+func (w *wal) close() error {
+	close(w.done)
+	w.wg.Wait()
+
+	err := w.f.Sync()
+	if cerr := w.f.Close(); err == nil {
+		err = cerr
+	}
+	return errors.Wrap(err, "close log failed")
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
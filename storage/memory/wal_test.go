@@ -0,0 +1,404 @@
+package memory_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/antklim/go-invoice/invoice"
+	"github.com/antklim/go-invoice/storage/memory"
+	"github.com/antklim/go-invoice/storage/storagetest"
+)
+
+func openStorage(t *testing.T, dir string, opts ...memory.Option) *memory.Memory {
+	t.Helper()
+
+	strg, err := memory.Open(dir, opts...)
+	if err != nil {
+		t.Fatalf("Open(%q) failed: %v", dir, err)
+	}
+	return strg
+}
+
+func closeStorage(t *testing.T, strg *memory.Memory) {
+	t.Helper()
+
+	if err := strg.Close(); err != nil {
+		t.Fatalf("Close() failed: %v", err)
+	}
+}
+
+func assertInvoices(t *testing.T, strg *memory.Memory, invs ...invoice.Invoice) {
+	t.Helper()
+
+	for _, inv := range invs {
+		inv := inv
+		vinv, err := strg.FindInvoice(inv.ID)
+		if err != nil {
+			t.Fatalf("FindInvoice(%q) failed: %v", inv.ID, err)
+		}
+		if vinv == nil {
+			t.Fatalf("FindInvoice(%q) invoice expected, got nil", inv.ID)
+		}
+		if vinv.CustomerName != inv.CustomerName || len(vinv.Items) != len(inv.Items) {
+			t.Errorf("recovered invoice %v, want %v", vinv, inv)
+		}
+	}
+}
+
+func fileSize(t *testing.T, path string) int64 {
+	t.Helper()
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat(%q) failed: %v", path, err)
+	}
+	return fi.Size()
+}
+
+func TestOpenRecoversInvoices(t *testing.T) {
+	policies := []memory.SyncPolicy{memory.SyncAlways, memory.SyncInterval, memory.SyncNever}
+	for _, policy := range policies {
+		t.Run(policy.String(), func(t *testing.T) {
+			dir := t.TempDir()
+			opts := []memory.Option{
+				memory.WithSyncPolicy(policy),
+				memory.WithSyncInterval(time.Millisecond),
+			}
+
+			strg := openStorage(t, dir, opts...)
+			inv1 := invoice.NewInvoice("John Doe")
+			inv2 := invoice.NewInvoice("Jane Doe")
+			for _, inv := range []invoice.Invoice{inv1, inv2} {
+				if err := strg.AddInvoice(inv); err != nil {
+					t.Fatalf("AddInvoice(%v) failed: %v", inv, err)
+				}
+			}
+			inv1.CustomerName = "John Smith"
+			inv1.Items = append(inv1.Items, invoice.NewItem("pen", 100, 2))
+			if err := strg.UpdateInvoice(inv1); err != nil {
+				t.Fatalf("UpdateInvoice(%v) failed: %v", inv1, err)
+			}
+			closeStorage(t, strg)
+
+			strg = openStorage(t, dir, opts...)
+			defer closeStorage(t, strg)
+			assertInvoices(t, strg, inv1, inv2)
+
+			if err := strg.AddInvoice(inv2); err == nil {
+				t.Errorf("AddInvoice(%v) of recovered invoice expected to fail", inv2)
+			}
+		})
+	}
+}
+
+func TestOpenTruncatesTornRecords(t *testing.T) {
+	testCases := []struct {
+		desc string
+		tail func(record []byte) []byte
+	}{
+		{
+			desc: "partial header",
+			tail: func(record []byte) []byte { return record[:5] },
+		},
+		{
+			desc: "partial payload",
+			tail: func(record []byte) []byte { return record[:len(record)-3] },
+		},
+		{
+			desc: "damaged payload",
+			tail: func(record []byte) []byte {
+				damaged := append([]byte(nil), record...)
+				damaged[len(damaged)-2] ^= 0xff
+				return damaged
+			},
+		},
+		{
+			desc: "garbage length",
+			tail: func([]byte) []byte { return []byte{0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0} },
+		},
+	}
+
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			dir := t.TempDir()
+			logPath := filepath.Join(dir, memory.LogFile)
+
+			strg := openStorage(t, dir)
+			inv1 := invoice.NewInvoice("John Doe")
+			if err := strg.AddInvoice(inv1); err != nil {
+				t.Fatalf("AddInvoice(%v) failed: %v", inv1, err)
+			}
+			validSize := fileSize(t, logPath)
+
+			inv2 := invoice.NewInvoice("Jane Doe")
+			if err := strg.AddInvoice(inv2); err != nil {
+				t.Fatalf("AddInvoice(%v) failed: %v", inv2, err)
+			}
+			closeStorage(t, strg)
+
+			// replace the last record with the torn one, as if the process
+			// crashed while it was written
+			data, err := os.ReadFile(logPath)
+			if err != nil {
+				t.Fatalf("ReadFile(%q) failed: %v", logPath, err)
+			}
+			data = append(data[:validSize:validSize], tC.tail(data[validSize:])...)
+			if err := os.WriteFile(logPath, data, 0600); err != nil {
+				t.Fatalf("WriteFile(%q) failed: %v", logPath, err)
+			}
+
+			strg = openStorage(t, dir)
+			assertInvoices(t, strg, inv1)
+			if vinv, _ := strg.FindInvoice(inv2.ID); vinv != nil {
+				t.Errorf("FindInvoice(%q) torn invoice recovered", inv2.ID)
+			}
+			if size := fileSize(t, logPath); size != validSize {
+				t.Errorf("log size after recovery %d, want %d", size, validSize)
+			}
+
+			// records appended after recovery are not lost behind the torn record
+			if err := strg.AddInvoice(inv2); err != nil {
+				t.Fatalf("AddInvoice(%v) failed: %v", inv2, err)
+			}
+			closeStorage(t, strg)
+
+			strg = openStorage(t, dir)
+			defer closeStorage(t, strg)
+			assertInvoices(t, strg, inv1, inv2)
+		})
+	}
+}
+
+func TestOpenFailsOnCorruptedRecords(t *testing.T) {
+	testCases := []struct {
+		desc    string
+		corrupt func(record []byte)
+	}{
+		{
+			desc:    "damaged payload",
+			corrupt: func(record []byte) { record[len(record)-2] ^= 0xff },
+		},
+		{
+			desc:    "garbage length",
+			corrupt: func(record []byte) { copy(record, []byte{0xff, 0xff, 0xff, 0xff}) },
+		},
+	}
+
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			dir := t.TempDir()
+			logPath := filepath.Join(dir, memory.LogFile)
+
+			strg := openStorage(t, dir)
+			var offsets []int64
+			for _, name := range []string{"John Doe", "Jane Doe", "Bob Doe"} {
+				offsets = append(offsets, fileSize(t, logPath))
+				inv := invoice.NewInvoice(name)
+				if err := strg.AddInvoice(inv); err != nil {
+					t.Fatalf("AddInvoice(%v) failed: %v", inv, err)
+				}
+			}
+			closeStorage(t, strg)
+
+			data, err := os.ReadFile(logPath)
+			if err != nil {
+				t.Fatalf("ReadFile(%q) failed: %v", logPath, err)
+			}
+			tC.corrupt(data[offsets[1]:offsets[2]])
+			if err := os.WriteFile(logPath, data, 0600); err != nil {
+				t.Fatalf("WriteFile(%q) failed: %v", logPath, err)
+			}
+
+			if strg, err := memory.Open(dir); err == nil {
+				strg.Close()
+				t.Fatalf("Open(%q) of corrupted log expected to fail", dir)
+			}
+			if size := fileSize(t, logPath); size != int64(len(data)) {
+				t.Errorf("log size after failed open %d, want %d", size, len(data))
+			}
+		})
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, memory.LogFile)
+	threshold := 3
+
+	strg := openStorage(t, dir, memory.WithSnapshotThreshold(threshold))
+	var invs []invoice.Invoice
+	for i := 0; i < threshold+1; i++ {
+		inv := invoice.NewInvoice("John Doe")
+		if err := strg.AddInvoice(inv); err != nil {
+			t.Fatalf("AddInvoice(%v) failed: %v", inv, err)
+		}
+		invs = append(invs, inv)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, memory.SnapshotFile)); err != nil {
+		t.Fatalf("snapshot expected: %v", err)
+	}
+	logSize := fileSize(t, logPath)
+	if logSize == 0 {
+		t.Errorf("log expected to contain the record written after the snapshot")
+	}
+
+	invs[0].CustomerName = "Jane Doe"
+	if err := strg.UpdateInvoice(invs[0]); err != nil {
+		t.Fatalf("UpdateInvoice(%v) failed: %v", invs[0], err)
+	}
+	closeStorage(t, strg)
+
+	strg = openStorage(t, dir, memory.WithSnapshotThreshold(threshold))
+	defer closeStorage(t, strg)
+	assertInvoices(t, strg, invs...)
+}
+
+func TestOpenRecoversOutbox(t *testing.T) {
+	dir := t.TempDir()
+	threshold := 3
+
+	strg := openStorage(t, dir, memory.WithSnapshotThreshold(threshold))
+	var events []invoice.Event
+	for i := 0; i < threshold+1; i++ {
+		inv := invoice.NewInvoice("John Doe")
+		e := invoice.Event{ID: inv.ID, Type: invoice.EventCreated, At: time.Now(), Invoice: inv}
+		err := strg.RunInTx(func(tx invoice.Storage) error {
+			if err := tx.AddInvoice(inv); err != nil {
+				return err
+			}
+			return tx.(invoice.EventWriter).WriteEvent(e)
+		})
+		if err != nil {
+			t.Fatalf("RunInTx() failed: %v", err)
+		}
+		events = append(events, e)
+	}
+	if err := strg.DeleteOutboxEvents(events[:2]); err != nil {
+		t.Fatalf("DeleteOutboxEvents() failed: %v", err)
+	}
+	closeStorage(t, strg)
+
+	strg = openStorage(t, dir, memory.WithSnapshotThreshold(threshold))
+	defer closeStorage(t, strg)
+	got, err := strg.OutboxEvents(0)
+	if err != nil {
+		t.Fatalf("OutboxEvents() failed: %v", err)
+	}
+	if len(got) != 2 || got[0].ID != events[2].ID || got[1].ID != events[3].ID {
+		t.Errorf("invalid recovered outbox events %v, want %v", got, events[2:])
+	}
+}
+
+func TestOpenDoesNotDuplicateSnapshotEvents(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, memory.LogFile)
+
+	writeEvent := func(strg *memory.Memory) invoice.Event {
+		t.Helper()
+
+		inv := invoice.NewInvoice("John Doe")
+		e := invoice.Event{ID: inv.ID, Type: invoice.EventCreated, At: time.Now(), Invoice: inv}
+		err := strg.RunInTx(func(tx invoice.Storage) error {
+			if err := tx.AddInvoice(inv); err != nil {
+				return err
+			}
+			return tx.(invoice.EventWriter).WriteEvent(e)
+		})
+		if err != nil {
+			t.Fatalf("RunInTx() failed: %v", err)
+		}
+		return e
+	}
+
+	strg := openStorage(t, dir)
+	events := []invoice.Event{writeEvent(strg), writeEvent(strg)}
+	closeStorage(t, strg)
+	log, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("ReadFile(%q) failed: %v", logPath, err)
+	}
+
+	// the next write takes the snapshot of all events and truncates the log
+	strg = openStorage(t, dir, memory.WithSnapshotThreshold(1))
+	events = append(events, writeEvent(strg))
+	closeStorage(t, strg)
+
+	// restore the log, as if the process crashed before it was truncated
+	if err := os.WriteFile(logPath, log, 0600); err != nil {
+		t.Fatalf("WriteFile(%q) failed: %v", logPath, err)
+	}
+
+	strg = openStorage(t, dir)
+	defer closeStorage(t, strg)
+	got, err := strg.OutboxEvents(0)
+	if err != nil {
+		t.Fatalf("OutboxEvents() failed: %v", err)
+	}
+	if len(got) != len(events) {
+		t.Fatalf("invalid number of recovered outbox events %d, want %d", len(got), len(events))
+	}
+	for i := range events {
+		if got[i].ID != events[i].ID {
+			t.Errorf("invalid recovered outbox event #%d %q, want %q", i, got[i].ID, events[i].ID)
+		}
+	}
+}
+
+func TestRestoreDurable(t *testing.T) {
+	dir := t.TempDir()
+	backupDir := t.TempDir()
+
+	backup := memory.New()
+	inv := invoice.NewInvoice("John Doe")
+	if err := backup.AddInvoice(inv); err != nil {
+		t.Fatalf("AddInvoice(%v) failed: %v", inv, err)
+	}
+	if err := backup.Backup(backupDir); err != nil {
+		t.Fatalf("Backup(%q) failed: %v", backupDir, err)
+	}
+
+	strg := openStorage(t, dir)
+	replaced := invoice.NewInvoice("Jane Doe")
+	if err := strg.AddInvoice(replaced); err != nil {
+		t.Fatalf("AddInvoice(%v) failed: %v", replaced, err)
+	}
+	if err := strg.Restore(backupDir); err != nil {
+		t.Fatalf("Restore(%q) failed: %v", backupDir, err)
+	}
+	closeStorage(t, strg)
+
+	strg = openStorage(t, dir)
+	defer closeStorage(t, strg)
+	assertInvoices(t, strg, inv)
+	if vinv, _ := strg.FindInvoice(replaced.ID); vinv != nil {
+		t.Errorf("FindInvoice(%q) replaced invoice recovered", replaced.ID)
+	}
+}
+
+func TestParseSyncPolicy(t *testing.T) {
+	for _, p := range []memory.SyncPolicy{memory.SyncAlways, memory.SyncInterval, memory.SyncNever} {
+		v, err := memory.ParseSyncPolicy(p.String())
+		if err != nil {
+			t.Errorf("ParseSyncPolicy(%q) failed: %v", p, err)
+		}
+		if v != p {
+			t.Errorf("ParseSyncPolicy(%q) = %v, want %v", p, v, p)
+		}
+	}
+
+	if _, err := memory.ParseSyncPolicy("sometimes"); err == nil {
+		t.Errorf("ParseSyncPolicy(%q) expected to fail", "sometimes")
+	}
+}
+
+func TestDurableConformance(t *testing.T) {
+	strg := openStorage(t, t.TempDir(), memory.WithSnapshotThreshold(10))
+	defer closeStorage(t, strg)
+
+	storagetest.Run(t, storagetest.FactoryFunc(func() invoice.Storage {
+		return strg
+	}))
+}
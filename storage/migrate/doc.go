@@ -0,0 +1,2 @@
+// Package migrate copies invoices between invoice storages.
+package migrate
@@ -0,0 +1,247 @@
+package migrate
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/antklim/go-invoice/invoice"
+	"github.com/pkg/errors"
+)
+
+// ConflictPolicy defines what to do with invoice that already exists in the
+// target storage.
+type ConflictPolicy int
+
+// Supported conflict policies.
+const (
+	ConflictFail      ConflictPolicy = iota // stop the migration
+	ConflictSkip                            // keep the target invoice
+	ConflictOverwrite                       // replace the target invoice with the source one
+)
+
+var conflictPolicyName = map[ConflictPolicy]string{
+	ConflictFail:      "fail",
+	ConflictSkip:      "skip",
+	ConflictOverwrite: "overwrite",
+}
+
+func (p ConflictPolicy) String() string { return conflictPolicyName[p] }
+
+// ParseConflictPolicy returns the conflict policy by its name.
+func ParseConflictPolicy(s string) (ConflictPolicy, error) {
+	for p, name := range conflictPolicyName {
+		if name == s {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown conflict policy %q", s)
+}
+
+// Report describes the migration results. Counters cover only invoices
+// processed by this run, invoices copied by previous runs of the resumed
+// migration are not counted.
+type Report struct {
+	Resumed     bool     // migration resumed from the checkpoint
+	Read        int      // invoices read from the source storage
+	Added       int      // invoices added to the target storage
+	Existing    int      // invoices already equal in the target storage
+	Conflicts   int      // invoices that differ in the target storage
+	Overwritten int      // conflicting invoices overwritten
+	Skipped     int      // conflicting invoices skipped
+	Verified    int      // invoices equal in both storages
+	Missing     []string // IDs of invoices not found in the target storage
+	Mismatched  []string // IDs of invoices that differ in the target storage
+}
+
+// Run copies all invoices from the source storage to the target storage and
+// verifies the copies. The source storage should implement invoice.Lister.
+//
+// Verification compares every source invoice with the target one. Update
+// time is not compared, because storages stamp it when invoice is overwritten.
+// Skipped conflicting invoices are compared too, and reported as mismatched
+// when they differ. Verification is not run in dry-run.
+//
+// Target invoices equal to the source ones are already migrated, for example
+// by the interrupted run after its last checkpoint, they are not conflicts.
+//
+// In dry-run conflicts do not stop the migration with the fail policy, they
+// are only counted.
+func Run(src, dst invoice.Storage, opts ...Option) (Report, error) {
+	mopts := defaultOptions
+	for _, o := range opts {
+		o.apply(&mopts)
+	}
+
+	var r Report
+	lister, ok := src.(invoice.Lister)
+	if !ok {
+		return r, errors.New("source storage does not support listing invoices")
+	}
+
+	cursor, err := readCheckpoint(mopts.checkpoint)
+	if err != nil {
+		return r, err
+	}
+	r.Resumed = cursor != ""
+
+	if err := copyInvoices(lister, dst, cursor, mopts, &r); err != nil {
+		return r, err
+	}
+	if mopts.dryRun {
+		return r, nil
+	}
+	if mopts.checkpoint != "" {
+		if err := os.Remove(mopts.checkpoint); err != nil && !os.IsNotExist(err) {
+			return r, errors.Wrap(err, "remove checkpoint failed")
+		}
+	}
+
+	return r, verify(lister, dst, mopts.batchSize, &r)
+}
+
+func copyInvoices(src invoice.Lister, dst invoice.Storage, cursor string, o options, r *Report) error {
+	for {
+		invs, next, err := src.ListInvoices(cursor, o.batchSize)
+		if err != nil {
+			return errors.Wrap(err, "list source invoices failed")
+		}
+
+		for _, inv := range invs {
+			r.Read++
+			if err := copyInvoice(dst, inv, o, r); err != nil {
+				return err
+			}
+		}
+
+		if next == "" {
+			return nil
+		}
+		cursor = next
+		if !o.dryRun {
+			if err := writeCheckpoint(o.checkpoint, cursor); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func copyInvoice(dst invoice.Storage, inv invoice.Invoice, o options, r *Report) error {
+	cur, err := dst.FindInvoice(inv.ID)
+	if err != nil {
+		return errors.Wrapf(err, "find target invoice %q failed", inv.ID)
+	}
+
+	if cur == nil {
+		r.Added++
+		if o.dryRun {
+			return nil
+		}
+		return errors.Wrapf(dst.AddInvoice(inv), "add invoice %q failed", inv.ID)
+	}
+	if equal(inv, *cur) {
+		r.Existing++
+		return nil
+	}
+
+	r.Conflicts++
+	switch o.onConflict {
+	case ConflictSkip:
+		r.Skipped++
+		return nil
+	case ConflictOverwrite:
+		r.Overwritten++
+		if o.dryRun {
+			return nil
+		}
+		// the target invoice is replaced as of its version that was read
+		inv.UpdatedAt = cur.UpdatedAt
+		return errors.Wrapf(dst.UpdateInvoice(inv), "overwrite invoice %q failed", inv.ID)
+	default:
+		if o.dryRun {
+			return nil
+		}
+		return fmt.Errorf("invoice %q exists in target storage", inv.ID)
+	}
+}
+
+func verify(src invoice.Lister, dst invoice.Storage, batchSize int, r *Report) error {
+	cursor := ""
+	for {
+		invs, next, err := src.ListInvoices(cursor, batchSize)
+		if err != nil {
+			return errors.Wrap(err, "list source invoices failed")
+		}
+
+		for i := range invs {
+			inv := invs[i]
+			vinv, err := dst.FindInvoice(inv.ID)
+			if err != nil {
+				return errors.Wrapf(err, "find target invoice %q failed", inv.ID)
+			}
+
+			switch {
+			case vinv == nil:
+				r.Missing = append(r.Missing, inv.ID)
+			case !equal(inv, *vinv):
+				r.Mismatched = append(r.Mismatched, inv.ID)
+			default:
+				r.Verified++
+			}
+		}
+
+		if next == "" {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// equal compares invoices ignoring update time.
+func equal(inv, other invoice.Invoice) bool {
+	other.UpdatedAt = inv.UpdatedAt
+	return inv.Equal(&other)
+}
+
+func readCheckpoint(path string) (string, error) {
+	if path == "" {
+		return "", nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "read checkpoint failed")
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// writeCheckpoint replaces the checkpoint file with the cursor. The file is
+// written to a temporary file first, so the checkpoint is never torn.
+func writeCheckpoint(path, cursor string) error {
+	if path == "" {
+		return nil
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return errors.Wrap(err, "write checkpoint failed")
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.WriteString(cursor + "\n"); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "write checkpoint failed")
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "write checkpoint failed")
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "write checkpoint failed")
+	}
+	return errors.Wrap(os.Rename(tmp.Name(), path), "write checkpoint failed")
+}
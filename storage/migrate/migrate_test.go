@@ -0,0 +1,231 @@
+package migrate_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/antklim/go-invoice/invoice"
+	"github.com/antklim/go-invoice/storage/memory"
+	"github.com/antklim/go-invoice/storage/migrate"
+)
+
+func newSource(t *testing.T, n int) (*memory.Memory, []invoice.Invoice) {
+	t.Helper()
+
+	src := memory.New()
+	var invs []invoice.Invoice
+	for i := 0; i < n; i++ {
+		inv := invoice.NewInvoice("John Doe")
+		inv.Items = append(inv.Items, invoice.NewItem("pen", 100, i+1))
+		if err := src.AddInvoice(inv); err != nil {
+			t.Fatalf("AddInvoice(%v) failed: %v", inv, err)
+		}
+		invs = append(invs, inv)
+	}
+	return src, invs
+}
+
+func findInvoice(t *testing.T, strg invoice.Storage, id string) *invoice.Invoice {
+	t.Helper()
+
+	inv, err := strg.FindInvoice(id)
+	if err != nil {
+		t.Fatalf("FindInvoice(%q) failed: %v", id, err)
+	}
+	return inv
+}
+
+func TestRun(t *testing.T) {
+	src, invs := newSource(t, 5)
+	dst := memory.New()
+
+	r, err := migrate.Run(src, dst, migrate.WithBatchSize(2))
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+
+	want := migrate.Report{Read: 5, Added: 5, Verified: 5}
+	if r.Read != want.Read || r.Added != want.Added || r.Verified != want.Verified ||
+		r.Resumed || len(r.Missing) != 0 || len(r.Mismatched) != 0 {
+		t.Errorf("invalid report %+v, want %+v", r, want)
+	}
+	for _, inv := range invs {
+		inv := inv
+		if vinv := findInvoice(t, dst, inv.ID); vinv == nil || !vinv.Equal(&inv) {
+			t.Errorf("FindInvoice(%q) = %v, want %v", inv.ID, vinv, inv)
+		}
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	src, invs := newSource(t, 3)
+	dst := memory.New()
+	conflict := invs[0]
+	conflict.CustomerName = "Jane Doe"
+	if err := dst.AddInvoice(conflict); err != nil {
+		t.Fatalf("AddInvoice(%v) failed: %v", conflict, err)
+	}
+	checkpoint := filepath.Join(t.TempDir(), "checkpoint")
+
+	r, err := migrate.Run(src, dst,
+		migrate.WithDryRun(true),
+		migrate.WithBatchSize(1),
+		migrate.WithCheckpoint(checkpoint),
+		migrate.WithConflictPolicy(migrate.ConflictOverwrite))
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+
+	if r.Read != 3 || r.Added != 2 || r.Conflicts != 1 || r.Overwritten != 1 || r.Verified != 0 {
+		t.Errorf("invalid report %+v, want 3 read, 2 added and 1 conflict overwritten", r)
+	}
+	for _, inv := range invs[1:] {
+		if vinv := findInvoice(t, dst, inv.ID); vinv != nil {
+			t.Errorf("FindInvoice(%q) no invoice expected in dry-run, got %v", inv.ID, vinv)
+		}
+	}
+	if _, err := os.Stat(checkpoint); !os.IsNotExist(err) {
+		t.Errorf("checkpoint written in dry-run: %v", err)
+	}
+}
+
+func TestDryRunCountsConflicts(t *testing.T) {
+	src, invs := newSource(t, 3)
+	dst := memory.New()
+	for _, inv := range invs[:2] {
+		inv.CustomerName = "Jane Doe"
+		if err := dst.AddInvoice(inv); err != nil {
+			t.Fatalf("AddInvoice(%v) failed: %v", inv, err)
+		}
+	}
+
+	r, err := migrate.Run(src, dst, migrate.WithDryRun(true))
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+	if r.Added != 1 || r.Conflicts != 2 {
+		t.Errorf("invalid report %+v, want 1 added and 2 conflicts", r)
+	}
+}
+
+func TestConflictPolicy(t *testing.T) {
+	setup := func(t *testing.T) (*memory.Memory, *memory.Memory, invoice.Invoice) {
+		src, invs := newSource(t, 3)
+		dst := memory.New()
+		conflict := invs[1]
+		conflict.CustomerName = "Jane Doe"
+		if err := dst.AddInvoice(conflict); err != nil {
+			t.Fatalf("AddInvoice(%v) failed: %v", conflict, err)
+		}
+		return src, dst, conflict
+	}
+
+	t.Run("fail", func(t *testing.T) {
+		src, dst, conflict := setup(t)
+
+		_, err := migrate.Run(src, dst)
+		if err == nil {
+			t.Fatal("Run() expected to fail on conflict")
+		}
+		if vinv := findInvoice(t, dst, conflict.ID); vinv.CustomerName != conflict.CustomerName {
+			t.Errorf("conflicting invoice changed: %v", vinv)
+		}
+	})
+
+	t.Run("skip", func(t *testing.T) {
+		src, dst, conflict := setup(t)
+
+		r, err := migrate.Run(src, dst, migrate.WithConflictPolicy(migrate.ConflictSkip))
+		if err != nil {
+			t.Fatalf("Run() failed: %v", err)
+		}
+		if r.Added != 2 || r.Skipped != 1 || r.Verified != 2 {
+			t.Errorf("invalid report %+v, want 2 added, 1 skipped and 2 verified", r)
+		}
+		if len(r.Mismatched) != 1 || r.Mismatched[0] != conflict.ID {
+			t.Errorf("invalid mismatched invoices %v, want [%s]", r.Mismatched, conflict.ID)
+		}
+		if vinv := findInvoice(t, dst, conflict.ID); vinv.CustomerName != conflict.CustomerName {
+			t.Errorf("skipped invoice changed: %v", vinv)
+		}
+	})
+
+	t.Run("overwrite", func(t *testing.T) {
+		src, dst, conflict := setup(t)
+
+		r, err := migrate.Run(src, dst, migrate.WithConflictPolicy(migrate.ConflictOverwrite))
+		if err != nil {
+			t.Fatalf("Run() failed: %v", err)
+		}
+		if r.Added != 2 || r.Overwritten != 1 || r.Verified != 3 {
+			t.Errorf("invalid report %+v, want 2 added, 1 overwritten and 3 verified", r)
+		}
+		if vinv := findInvoice(t, dst, conflict.ID); vinv.CustomerName != "John Doe" {
+			t.Errorf("invalid overwritten invoice.CustomerName %q, want %q", vinv.CustomerName, "John Doe")
+		}
+	})
+}
+
+// failingStorage fails AddInvoice after the number of successful calls.
+type failingStorage struct {
+	invoice.Storage
+	adds int
+}
+
+func (s *failingStorage) AddInvoice(inv invoice.Invoice) error {
+	if s.adds == 0 {
+		return errors.New("storage unavailable")
+	}
+	s.adds--
+	return s.Storage.AddInvoice(inv)
+}
+
+func TestResume(t *testing.T) {
+	src, invs := newSource(t, 5)
+	dst := memory.New()
+	checkpoint := filepath.Join(t.TempDir(), "checkpoint")
+	opts := []migrate.Option{migrate.WithBatchSize(2), migrate.WithCheckpoint(checkpoint)}
+
+	// the first batch copied, the second batch fails on its second invoice
+	if _, err := migrate.Run(src, &failingStorage{Storage: dst, adds: 3}, opts...); err == nil {
+		t.Fatal("Run() expected to fail")
+	}
+	if _, err := os.Stat(checkpoint); err != nil {
+		t.Fatalf("checkpoint expected: %v", err)
+	}
+
+	// the second batch is copied again, its first invoice copied before the
+	// crash is not a conflict
+	r, err := migrate.Run(src, dst, opts...)
+	if err != nil {
+		t.Fatalf("resumed Run() failed: %v", err)
+	}
+	if !r.Resumed || r.Read != 3 || r.Added != 2 || r.Existing != 1 || r.Conflicts != 0 || r.Verified != len(invs) {
+		t.Errorf("invalid report %+v, want resumed, 3 read, 2 added, 1 existing and %d verified", r, len(invs))
+	}
+	if _, err := os.Stat(checkpoint); !os.IsNotExist(err) {
+		t.Errorf("checkpoint expected to be removed: %v", err)
+	}
+}
+
+func TestRunNotListableSource(t *testing.T) {
+	src := struct{ invoice.Storage }{memory.New()}
+	if _, err := migrate.Run(src, memory.New()); err == nil {
+		t.Error("Run() expected to fail when source storage cannot list invoices")
+	}
+}
+
+func TestParseConflictPolicy(t *testing.T) {
+	for _, p := range []migrate.ConflictPolicy{migrate.ConflictFail, migrate.ConflictSkip, migrate.ConflictOverwrite} {
+		got, err := migrate.ParseConflictPolicy(p.String())
+		if err != nil || got != p {
+			t.Errorf("ParseConflictPolicy(%q) = %v, %v, want %v", p.String(), got, err, p)
+		}
+	}
+
+	if _, err := migrate.ParseConflictPolicy("replace"); err == nil {
+		t.Error("ParseConflictPolicy(\"replace\") expected to fail")
+	}
+}
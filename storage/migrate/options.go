@@ -0,0 +1,62 @@
+package migrate
+
+type options struct {
+	batchSize  int
+	checkpoint string
+	dryRun     bool
+	onConflict ConflictPolicy
+}
+
+var defaultOptions = options{
+	batchSize:  100, // nolint:gomnd
+	onConflict: ConflictFail,
+}
+
+type Option interface {
+	apply(*options)
+}
+
+type funcOption struct {
+	f func(*options)
+}
+
+func (f *funcOption) apply(o *options) {
+	f.f(o)
+}
+
+func newFuncOption(f func(*options)) Option {
+	return &funcOption{f: f}
+}
+
+// WithBatchSize sets the number of invoices listed from the source storage at
+// once. The checkpoint is saved after every batch.
+func WithBatchSize(v int) Option {
+	return newFuncOption(func(o *options) {
+		o.batchSize = v
+	})
+}
+
+// WithCheckpoint sets the file where the migration progress is saved. The
+// migration resumes from the saved progress when the file exists. The file is
+// removed when all invoices are copied.
+func WithCheckpoint(path string) Option {
+	return newFuncOption(func(o *options) {
+		o.checkpoint = path
+	})
+}
+
+// WithDryRun enables dry-run. In dry-run invoices are read from both storages
+// and conflicts are counted, but nothing is written.
+func WithDryRun(v bool) Option {
+	return newFuncOption(func(o *options) {
+		o.dryRun = v
+	})
+}
+
+// WithConflictPolicy sets what to do with invoices that already exist in the
+// target storage.
+func WithConflictPolicy(v ConflictPolicy) Option {
+	return newFuncOption(func(o *options) {
+		o.onConflict = v
+	})
+}
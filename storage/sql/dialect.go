@@ -0,0 +1,73 @@
+package sql
+
+import "strings"
+
+// Dialect describes SQL specifics of the database.
+type Dialect interface {
+	// Placeholder returns the bind parameter placeholder of the n-th query
+	// argument, n starts from 1.
+	Placeholder(n int) string
+
+	// Migrations returns the schema migrations in the order they should be
+	// applied. The migration version is its index in the list plus one.
+	// Applied migrations must never be changed, schema changes are added as
+	// new migrations to the end of the list.
+	Migrations() []string
+}
+
+type sqlite struct{}
+
+// SQLite is the dialect of SQLite database.
+var SQLite Dialect = sqlite{}
+
+func (sqlite) Placeholder(int) string { return "?" }
+
+func (sqlite) Migrations() []string {
+	return []string{
+		`CREATE TABLE invoices (
+			id            TEXT PRIMARY KEY,
+			customer_name TEXT NOT NULL,
+			issue_date    TEXT,
+			status        INTEGER NOT NULL,
+			created_at    TEXT NOT NULL,
+			updated_at    TEXT NOT NULL
+		)`,
+		`CREATE TABLE items (
+			invoice_id   TEXT NOT NULL REFERENCES invoices (id) ON DELETE CASCADE,
+			id           TEXT NOT NULL,
+			position     INTEGER NOT NULL,
+			product_name TEXT NOT NULL,
+			price        INTEGER NOT NULL,
+			qty          INTEGER NOT NULL,
+			created_at   TEXT NOT NULL,
+			PRIMARY KEY (invoice_id, id)
+		)`,
+		`ALTER TABLE invoices ADD COLUMN origins TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE invoices ADD COLUMN successors TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE items ADD COLUMN origin TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE invoices ADD COLUMN issue_at TEXT`,
+		`ALTER TABLE invoices ADD COLUMN dunning_level INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE invoices ADD COLUMN dunning_at TEXT`,
+		`ALTER TABLE invoices ADD COLUMN delivery TEXT`,
+	}
+}
+
+// rebind replaces the "?" placeholders of the query with the dialect
+// placeholders.
+func rebind(d Dialect, query string) string {
+	if d.Placeholder(1) == "?" {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r != '?' {
+			b.WriteRune(r)
+			continue
+		}
+		n++
+		b.WriteString(d.Placeholder(n))
+	}
+	return b.String()
+}
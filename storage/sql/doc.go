@@ -0,0 +1,4 @@
+// Package sql contains storage implementation on top of database/sql.
+// Invoices and invoice items stored in the normalized tables, SQL dialect
+// specifics are described by Dialect.
+package sql
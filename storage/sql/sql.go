@@ -0,0 +1,415 @@
+package sql
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/antklim/go-invoice/invoice"
+	"github.com/pkg/errors"
+)
+
+// timeLayout is the layout of the timestamps stored in the text columns. It
+// keeps nanoseconds and time zone offset, so stored time read back equal.
+const timeLayout = time.RFC3339Nano
+
+type SQL struct {
+	db      *sql.DB
+	dialect Dialect
+}
+
+var _ invoice.Storage = (*SQL)(nil)
+var _ invoice.Lister = (*SQL)(nil)
+var _ invoice.Transactor = (*SQL)(nil)
+
+// New creates storage on top of the database. The database schema should be
+// migrated with Migrate before the storage used.
+func New(db *sql.DB, dialect Dialect) *SQL {
+	return &SQL{db: db, dialect: dialect}
+}
+
+// Close closes the database.
+func (s *SQL) Close() error {
+	return s.db.Close()
+}
+
+// Migrate applies the dialect schema migrations that were not applied yet.
+// Every migration applied in its own transaction, together with the record
+// of the applied version.
+func (s *SQL) Migrate() error {
+	if _, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		applied_at TEXT NOT NULL
+	)`); err != nil {
+		return errors.Wrap(err, "create schema migrations table failed")
+	}
+
+	version, err := s.SchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	migrations := s.dialect.Migrations()
+	for v := version + 1; v <= len(migrations); v++ {
+		err := s.inTx(func(tx *sql.Tx) error {
+			if _, err := tx.Exec(migrations[v-1]); err != nil {
+				return err
+			}
+			_, err := tx.Exec(s.rebind("INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)"),
+				v, time.Now().UTC().Format(timeLayout))
+			return err
+		})
+		if err != nil {
+			return errors.Wrapf(err, "apply schema migration %d failed", v)
+		}
+	}
+
+	return nil
+}
+
+// SchemaVersion returns the version of the last applied schema migration.
+func (s *SQL) SchemaVersion() (int, error) {
+	var version sql.NullInt64
+	if err := s.db.QueryRow("SELECT MAX(version) FROM schema_migrations").Scan(&version); err != nil {
+		return 0, errors.Wrap(err, "read schema version failed")
+	}
+	return int(version.Int64), nil
+}
+
+func (s *SQL) AddInvoice(inv invoice.Invoice) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		return s.addInvoice(tx, inv)
+	})
+}
+
+func (s *SQL) FindInvoice(id string) (*invoice.Invoice, error) {
+	var inv *invoice.Invoice
+	err := s.inTx(func(tx *sql.Tx) error {
+		var err error
+		inv, err = s.findInvoice(tx, id)
+		return err
+	})
+	return inv, err
+}
+
+func (s *SQL) UpdateInvoice(inv invoice.Invoice) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		return s.updateInvoice(tx, inv, nil)
+	})
+}
+
+// ListInvoices lists invoices ordered by ID. The cursor is the ID of the last
+// invoice of the previous page.
+func (s *SQL) ListInvoices(cursor string, limit int) ([]invoice.Invoice, string, error) {
+	var (
+		invs []invoice.Invoice
+		next string
+	)
+	err := s.inTx(func(tx *sql.Tx) error {
+		ids, err := s.invoiceIDs(tx, cursor, limit)
+		if err != nil {
+			return err
+		}
+		if limit > 0 && len(ids) > limit {
+			ids = ids[:limit]
+			next = ids[limit-1]
+		}
+
+		for _, id := range ids {
+			inv, err := s.findInvoice(tx, id)
+			if err != nil {
+				return err
+			}
+			if inv != nil {
+				invs = append(invs, *inv)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return invs, next, nil
+}
+
+// invoiceIDs returns up to limit+1 invoice IDs after the cursor, so that the
+// caller knows whether there are more invoices.
+func (s *SQL) invoiceIDs(tx *sql.Tx, cursor string, limit int) ([]string, error) {
+	query := "SELECT id FROM invoices WHERE id > ? ORDER BY id"
+	args := []interface{}{cursor}
+	if limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, limit+1)
+	}
+
+	rows, err := tx.Query(s.rebind(query), args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "list invoices failed")
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, errors.Wrap(err, "list invoices failed")
+		}
+		ids = append(ids, id)
+	}
+	return ids, errors.Wrap(rows.Err(), "list invoices failed")
+}
+
+func (s *SQL) addInvoice(tx *sql.Tx, inv invoice.Invoice) error {
+	exists, err := s.invoiceExists(tx, inv.ID)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("invoice %q exists", inv.ID)
+	}
+
+	delivery, err := formatDelivery(inv.Delivery)
+	if err != nil {
+		return errors.Wrapf(err, "encode invoice %q delivery failed", inv.ID)
+	}
+
+	if _, err := tx.Exec(s.rebind(`INSERT INTO invoices
+		(id, customer_name, issue_date, issue_at, status, origins, successors, dunning_level, dunning_at,
+		delivery, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`),
+		inv.ID, inv.CustomerName, formatDate(inv.Date), formatDate(inv.IssueAt), int(inv.Status),
+		formatIDs(inv.Origins), formatIDs(inv.Successors), inv.DunningLevel, formatDate(inv.DunningAt),
+		delivery, inv.CreatedAt.Format(timeLayout), inv.UpdatedAt.Format(timeLayout)); err != nil {
+		return errors.Wrapf(err, "insert invoice %q failed", inv.ID)
+	}
+
+	return s.insertItems(tx, inv)
+}
+
+// updateInvoice updates the invoice. When version is set, the invoice is
+// updated only if its update time still equals the version.
+func (s *SQL) updateInvoice(tx *sql.Tx, inv invoice.Invoice, version *time.Time) error {
+	delivery, err := formatDelivery(inv.Delivery)
+	if err != nil {
+		return errors.Wrapf(err, "encode invoice %q delivery failed", inv.ID)
+	}
+
+	query := `UPDATE invoices
+		SET customer_name = ?, issue_date = ?, issue_at = ?, status = ?, origins = ?, successors = ?,
+		dunning_level = ?, dunning_at = ?, delivery = ?, updated_at = ?
+		WHERE id = ?`
+	inv.UpdatedAt = time.Now()
+	args := []interface{}{inv.CustomerName, formatDate(inv.Date), formatDate(inv.IssueAt), int(inv.Status),
+		formatIDs(inv.Origins), formatIDs(inv.Successors), inv.DunningLevel, formatDate(inv.DunningAt),
+		delivery, inv.UpdatedAt.Format(timeLayout), inv.ID}
+	if version != nil {
+		query += " AND updated_at = ?"
+		args = append(args, version.Format(timeLayout))
+	}
+
+	res, err := tx.Exec(s.rebind(query), args...)
+	if err != nil {
+		return errors.Wrapf(err, "update invoice %q failed", inv.ID)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrapf(err, "update invoice %q failed", inv.ID)
+	}
+	if n == 0 {
+		exists, err := s.invoiceExists(tx, inv.ID)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return &invoice.ConflictError{ID: inv.ID}
+		}
+		return fmt.Errorf("invoice %q not found", inv.ID)
+	}
+
+	if _, err := tx.Exec(s.rebind("DELETE FROM items WHERE invoice_id = ?"), inv.ID); err != nil {
+		return errors.Wrapf(err, "delete invoice %q items failed", inv.ID)
+	}
+	return s.insertItems(tx, inv)
+}
+
+func (s *SQL) invoiceExists(tx *sql.Tx, id string) (bool, error) {
+	var n int
+	err := tx.QueryRow(s.rebind("SELECT COUNT(*) FROM invoices WHERE id = ?"), id).Scan(&n)
+	if err != nil {
+		return false, errors.Wrapf(err, "find invoice %q failed", id)
+	}
+	return n > 0, nil
+}
+
+func (s *SQL) findInvoice(tx *sql.Tx, id string) (*invoice.Invoice, error) {
+	var (
+		inv                  invoice.Invoice
+		date, issueAt        sql.NullString
+		dunningAt, delivery  sql.NullString
+		status               int
+		origins, successors  string
+		createdAt, updatedAt string
+	)
+	err := tx.QueryRow(s.rebind(`SELECT id, customer_name, issue_date, issue_at, status, origins, successors,
+		dunning_level, dunning_at, delivery, created_at, updated_at
+		FROM invoices WHERE id = ?`), id).
+		Scan(&inv.ID, &inv.CustomerName, &date, &issueAt, &status, &origins, &successors,
+			&inv.DunningLevel, &dunningAt, &delivery, &createdAt, &updatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "find invoice %q failed", id)
+	}
+
+	inv.Status = invoice.Status(status)
+	inv.Origins, inv.Successors = parseIDs(origins), parseIDs(successors)
+	if inv.Date, err = parseDate(date); err != nil {
+		return nil, errors.Wrapf(err, "invoice %q issue date invalid", id)
+	}
+	if inv.IssueAt, err = parseDate(issueAt); err != nil {
+		return nil, errors.Wrapf(err, "invoice %q issue at invalid", id)
+	}
+	if inv.DunningAt, err = parseDate(dunningAt); err != nil {
+		return nil, errors.Wrapf(err, "invoice %q dunning at invalid", id)
+	}
+	if inv.Delivery, err = parseDelivery(delivery); err != nil {
+		return nil, errors.Wrapf(err, "invoice %q delivery invalid", id)
+	}
+	if inv.CreatedAt, err = time.Parse(timeLayout, createdAt); err != nil {
+		return nil, errors.Wrapf(err, "invoice %q created at invalid", id)
+	}
+	if inv.UpdatedAt, err = time.Parse(timeLayout, updatedAt); err != nil {
+		return nil, errors.Wrapf(err, "invoice %q updated at invalid", id)
+	}
+
+	if inv.Items, err = s.findItems(tx, id); err != nil {
+		return nil, err
+	}
+	return &inv, nil
+}
+
+func (s *SQL) findItems(tx *sql.Tx, invID string) ([]invoice.Item, error) {
+	rows, err := tx.Query(s.rebind(`SELECT id, product_name, price, qty, origin, created_at
+		FROM items WHERE invoice_id = ? ORDER BY position`), invID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "find invoice %q items failed", invID)
+	}
+	defer rows.Close()
+
+	var items []invoice.Item
+	for rows.Next() {
+		var (
+			item      invoice.Item
+			createdAt string
+		)
+		if err := rows.Scan(&item.ID, &item.ProductName, &item.Price, &item.Qty, &item.Origin, &createdAt); err != nil {
+			return nil, errors.Wrapf(err, "read invoice %q items failed", invID)
+		}
+		if item.CreatedAt, err = time.Parse(timeLayout, createdAt); err != nil {
+			return nil, errors.Wrapf(err, "invoice %q item %q created at invalid", invID, item.ID)
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrapf(err, "read invoice %q items failed", invID)
+	}
+
+	return items, nil
+}
+
+func (s *SQL) insertItems(tx *sql.Tx, inv invoice.Invoice) error {
+	if len(inv.Items) == 0 {
+		return nil
+	}
+
+	stmt, err := tx.Prepare(s.rebind(`INSERT INTO items
+		(invoice_id, id, position, product_name, price, qty, origin, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`))
+	if err != nil {
+		return errors.Wrapf(err, "insert invoice %q items failed", inv.ID)
+	}
+	defer stmt.Close()
+
+	for i, item := range inv.Items {
+		if _, err := stmt.Exec(inv.ID, item.ID, i, item.ProductName, item.Price, item.Qty,
+			item.Origin, item.CreatedAt.Format(timeLayout)); err != nil {
+			return errors.Wrapf(err, "insert invoice %q item %q failed", inv.ID, item.ID)
+		}
+	}
+
+	return nil
+}
+
+// inTx runs f in the transaction. The transaction committed when f succeeds,
+// and rolled back otherwise.
+func (s *SQL) inTx(f func(*sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "begin transaction failed")
+	}
+
+	if err := f(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return errors.Wrap(tx.Commit(), "commit transaction failed")
+}
+
+func (s *SQL) rebind(query string) string {
+	return rebind(s.dialect, query)
+}
+
+func formatDate(date *time.Time) sql.NullString {
+	if date == nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: date.Format(timeLayout), Valid: true}
+}
+
+func parseDate(date sql.NullString) (*time.Time, error) {
+	if !date.Valid {
+		return nil, nil
+	}
+	t, err := time.Parse(timeLayout, date.String)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// formatDelivery encodes the invoice delivery to the JSON text column value.
+func formatDelivery(d *invoice.Delivery) (sql.NullString, error) {
+	if d == nil {
+		return sql.NullString{}, nil
+	}
+	data, err := json.Marshal(d)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	return sql.NullString{String: string(data), Valid: true}, nil
+}
+
+func parseDelivery(s sql.NullString) (*invoice.Delivery, error) {
+	if !s.Valid {
+		return nil, nil
+	}
+	var d invoice.Delivery
+	if err := json.Unmarshal([]byte(s.String), &d); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// formatIDs joins invoice IDs to the text column value.
+func formatIDs(ids []string) string {
+	return strings.Join(ids, " ")
+}
+
+func parseIDs(ids string) []string {
+	return strings.Fields(ids)
+}
@@ -0,0 +1,186 @@
+package sql_test
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/antklim/go-invoice/invoice"
+	sqlstorage "github.com/antklim/go-invoice/storage/sql"
+	"github.com/antklim/go-invoice/storage/storagetest"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func newStorage(t *testing.T) (*sqlstorage.SQL, *sql.DB) {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("sql.Open() failed: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	strg := sqlstorage.New(db, sqlstorage.SQLite)
+	if err := strg.Migrate(); err != nil {
+		t.Fatalf("Migrate() failed: %v", err)
+	}
+	return strg, db
+}
+
+func TestMigrate(t *testing.T) {
+	strg, _ := newStorage(t)
+	want := len(sqlstorage.SQLite.Migrations())
+
+	v, err := strg.SchemaVersion()
+	if err != nil {
+		t.Fatalf("SchemaVersion() failed: %v", err)
+	}
+	if v != want {
+		t.Errorf("SchemaVersion() = %d, want %d", v, want)
+	}
+
+	if err := strg.Migrate(); err != nil {
+		t.Fatalf("repeated Migrate() failed: %v", err)
+	}
+	v, err = strg.SchemaVersion()
+	if err != nil {
+		t.Fatalf("SchemaVersion() failed: %v", err)
+	}
+	if v != want {
+		t.Errorf("SchemaVersion() after repeated migration = %d, want %d", v, want)
+	}
+}
+
+func TestClose(t *testing.T) {
+	strg, _ := newStorage(t)
+	if err := strg.Close(); err != nil {
+		t.Fatalf("Close() failed: %v", err)
+	}
+	if _, err := strg.FindInvoice("123"); err == nil {
+		t.Error("FindInvoice() of closed storage expected to fail")
+	}
+}
+
+func TestAddInvoice(t *testing.T) {
+	strg, _ := newStorage(t)
+	inv := invoice.NewInvoice("John Doe")
+
+	if err := strg.AddInvoice(inv); err != nil {
+		t.Fatalf("AddInvoice(%v) failed: %v", inv, err)
+	}
+
+	err := strg.AddInvoice(inv)
+	if err == nil {
+		t.Fatalf("repeated AddInvoice(%v) expected to fail", inv)
+	}
+	want := "invoice \"" + inv.ID + "\" exists"
+	if err.Error() != want {
+		t.Errorf("repeated AddInvoice(%v) error = %q, want %q", inv, err, want)
+	}
+}
+
+func TestFindInvoice(t *testing.T) {
+	strg, _ := newStorage(t)
+	inv := invoice.NewInvoice("John Doe")
+	date := time.Date(2021, time.September, 1, 10, 30, 0, 123, time.FixedZone("AEST", 10*60*60))
+	inv.Date = &date
+	inv.Status = invoice.Issued
+	for _, name := range []string{"pen", "apple", "pineapple"} {
+		inv.Items = append(inv.Items, invoice.NewItem(name, 100, 2))
+	}
+
+	vinv, err := strg.FindInvoice(inv.ID)
+	if err != nil {
+		t.Errorf("FindInvoice(%q) failed: %v", inv.ID, err)
+	}
+	if vinv != nil {
+		t.Errorf("FindInvoice(%q) no invoice expected, got %v", inv.ID, vinv)
+	}
+
+	if err := strg.AddInvoice(inv); err != nil {
+		t.Fatalf("AddInvoice(%v) failed: %v", inv, err)
+	}
+
+	wantItems := append([]invoice.Item(nil), inv.Items...)
+
+	vinv, err = strg.FindInvoice(inv.ID)
+	if err != nil {
+		t.Fatalf("FindInvoice(%q) failed: %v", inv.ID, err)
+	}
+	if vinv == nil {
+		t.Fatalf("FindInvoice(%q) invoice expected, got nil", inv.ID)
+	}
+	for i, item := range vinv.Items {
+		if item.ID != wantItems[i].ID {
+			t.Errorf("invoice.Items[%d].ID = %q, want %q", i, item.ID, wantItems[i].ID)
+		}
+	}
+	if !vinv.Equal(&inv) {
+		t.Errorf("FindInvoice(%q) = %v, want %v", inv.ID, vinv, inv)
+	}
+}
+
+func TestUpdateInvoice(t *testing.T) {
+	t.Run("updates invoice and items", func(t *testing.T) {
+		strg, db := newStorage(t)
+		inv := invoice.NewInvoice("John Doe")
+		inv.Items = append(inv.Items, invoice.NewItem("pen", 100, 2))
+
+		if err := strg.AddInvoice(inv); err != nil {
+			t.Fatalf("AddInvoice(%v) failed: %v", inv, err)
+		}
+
+		newCustomer := "new customer"
+		inv.CustomerName = newCustomer
+		inv.Items = nil
+		if err := strg.UpdateInvoice(inv); err != nil {
+			t.Fatalf("UpdateInvoice(%v) failed: %v", inv, err)
+		}
+
+		vinv, err := strg.FindInvoice(inv.ID)
+		if err != nil {
+			t.Fatalf("FindInvoice(%q) failed: %v", inv.ID, err)
+		}
+		if vinv.CustomerName != newCustomer {
+			t.Errorf("invalid updated invoice.CustomerName %q, want %q", vinv.CustomerName, newCustomer)
+		}
+		if len(vinv.Items) != 0 {
+			t.Errorf("invalid updated invoice.Items %v, want no items", vinv.Items)
+		}
+		if !vinv.UpdatedAt.After(inv.UpdatedAt) {
+			t.Errorf("invalid udated invoice.UpdatedAt %s, want after %s",
+				vinv.UpdatedAt.Format(time.RFC3339),
+				inv.UpdatedAt.Format(time.RFC3339))
+		}
+
+		var n int
+		if err := db.QueryRow("SELECT COUNT(*) FROM items").Scan(&n); err != nil {
+			t.Fatalf("count items failed: %v", err)
+		}
+		if n != 0 {
+			t.Errorf("items table has %d rows, want 0", n)
+		}
+	})
+
+	t.Run("fails when invoice not found", func(t *testing.T) {
+		strg, _ := newStorage(t)
+		inv := invoice.NewInvoice("John Doe")
+
+		err := strg.UpdateInvoice(inv)
+		if err == nil {
+			t.Fatalf("UpdateInvoice(%v) expected to fail", inv)
+		}
+		want := "invoice \"" + inv.ID + "\" not found"
+		if err.Error() != want {
+			t.Errorf("UpdateInvoice(%v) error = %q, want %q", inv, err, want)
+		}
+	})
+}
+
+func TestConformance(t *testing.T) {
+	strg, _ := newStorage(t)
+	storagetest.Run(t, storagetest.FactoryFunc(func() invoice.Storage {
+		return strg
+	}))
+}
@@ -0,0 +1,54 @@
+package sql
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/antklim/go-invoice/invoice"
+)
+
+// sqlTx is the storage of the database transaction.
+type sqlTx struct {
+	s     *SQL
+	tx    *sql.Tx
+	reads map[string]time.Time // update time of invoices when first read
+}
+
+var _ invoice.Storage = (*sqlTx)(nil)
+
+// RunInTx runs f in the database transaction. Invoices updated by f after they
+// were read in the transaction are updated only when their update time has not
+// changed, so the transaction fails rather than overwrites concurrent changes
+// on databases with weaker isolation levels.
+func (s *SQL) RunInTx(f func(tx invoice.Storage) error) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		return f(&sqlTx{s: s, tx: tx, reads: make(map[string]time.Time)})
+	})
+}
+
+func (t *sqlTx) AddInvoice(inv invoice.Invoice) error {
+	return t.s.addInvoice(t.tx, inv)
+}
+
+func (t *sqlTx) FindInvoice(id string) (*invoice.Invoice, error) {
+	inv, err := t.s.findInvoice(t.tx, id)
+	if err != nil || inv == nil {
+		return inv, err
+	}
+	if _, ok := t.reads[id]; !ok {
+		t.reads[id] = inv.UpdatedAt
+	}
+	return inv, nil
+}
+
+func (t *sqlTx) UpdateInvoice(inv invoice.Invoice) error {
+	var version *time.Time
+	if v, ok := t.reads[inv.ID]; ok {
+		version = &v
+	}
+	if err := t.s.updateInvoice(t.tx, inv, version); err != nil {
+		return err
+	}
+	delete(t.reads, inv.ID) // own writes are not conflicts
+	return nil
+}
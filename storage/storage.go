@@ -1,12 +1,20 @@
 package storage
 
 import (
+	"database/sql"
+	"strings"
+	"time"
+
 	"github.com/antklim/go-invoice/invoice"
+	"github.com/antklim/go-invoice/storage/bolt"
 	"github.com/antklim/go-invoice/storage/dynamo"
 	"github.com/antklim/go-invoice/storage/memory"
+	sqlstorage "github.com/antklim/go-invoice/storage/sql"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
+	_ "github.com/mattn/go-sqlite3" // registers sqlite3 database/sql driver
+	"go.etcd.io/bbolt"
 )
 
 type Memory struct{}
@@ -17,6 +25,45 @@ func (Memory) MakeStorage() invoice.Storage {
 
 var _ invoice.StorageFactory = new(Memory)
 
+type ShardedMemory struct {
+	shards int
+}
+
+// NewShardedMemory creates factory of the in-memory storage of n shards, the
+// default number of shards used when n is not positive.
+func NewShardedMemory(n int) *ShardedMemory {
+	return &ShardedMemory{shards: n}
+}
+
+func (s *ShardedMemory) MakeStorage() invoice.Storage {
+	return memory.NewSharded(s.shards)
+}
+
+var _ invoice.StorageFactory = (*ShardedMemory)(nil)
+
+type DurableMemory struct {
+	dir  string
+	opts []memory.Option
+}
+
+// NewDurableMemory creates factory of the in-memory storage persisted to the
+// write-ahead log and snapshots in the directory.
+func NewDurableMemory(dir string, opts ...memory.Option) *DurableMemory {
+	return &DurableMemory{dir: dir, opts: opts}
+}
+
+// MakeStorage recovers invoices from the directory. It panics when recovery
+// fails.
+func (s *DurableMemory) MakeStorage() invoice.Storage {
+	strg, err := memory.Open(s.dir, s.opts...)
+	if err != nil {
+		panic("memory: " + err.Error())
+	}
+	return strg
+}
+
+var _ invoice.StorageFactory = (*DurableMemory)(nil)
+
 type Dynamo struct {
 	table string
 	opts  dynamoOptions
@@ -35,25 +82,107 @@ func NewDynamo(table string, opts ...DynamoOption) *Dynamo {
 }
 
 func (s *Dynamo) MakeStorage() invoice.Storage {
+	retryPolicy := dynamo.WithRetryPolicy(s.opts.retryPolicy)
+	if s.opts.client != nil {
+		return dynamo.New(s.opts.client, s.table, retryPolicy)
+	}
+
+	// storage retries transient errors, SDK retries disabled to not multiply
+	// the number of attempts
 	cfg := &aws.Config{Region: aws.String(s.opts.region)}
+	cfg.WithMaxRetries(0)
 	if s.opts.endpoint != "" {
 		cfg.WithEndpoint(s.opts.endpoint)
 	}
 
 	sess := session.Must(session.NewSession(cfg))
 	client := dynamodb.New(sess)
-	return dynamo.New(client, s.table)
+	return dynamo.New(client, s.table, retryPolicy)
 }
 
 var _ invoice.StorageFactory = (*Dynamo)(nil)
 
+type SQLite struct {
+	dsn string
+}
+
+// NewSQLite creates factory of the storage in SQLite database. The DSN is the
+// database file name or URI, see github.com/mattn/go-sqlite3 for the details.
+func NewSQLite(dsn string) *SQLite {
+	return &SQLite{dsn: dsn}
+}
+
+// MakeStorage opens the database with foreign keys enforced and applies the
+// schema migrations. It panics when the database cannot be opened or migrated.
+func (s *SQLite) MakeStorage() invoice.Storage {
+	db, err := sql.Open("sqlite3", withForeignKeys(s.dsn))
+	if err != nil {
+		panic("sqlite: open database failed: " + err.Error())
+	}
+	// SQLite allows a single writer, one connection serializes writes instead
+	// of failing them with "database is locked" errors. It also keeps the
+	// ":memory:" database alive, every connection opens its own in-memory
+	// database.
+	db.SetMaxOpenConns(1)
+	db.SetConnMaxLifetime(0)
+
+	strg := sqlstorage.New(db, sqlstorage.SQLite)
+	if err := strg.Migrate(); err != nil {
+		panic("sqlite: " + err.Error())
+	}
+	return strg
+}
+
+var _ invoice.StorageFactory = (*SQLite)(nil)
+
+// withForeignKeys adds the parameter enabling foreign keys to the DSN. SQLite
+// does not enforce foreign keys, and so cascade deletes, unless it is enabled
+// for every connection.
+func withForeignKeys(dsn string) string {
+	if strings.Contains(dsn, "?") {
+		return dsn + "&_foreign_keys=on"
+	}
+	return dsn + "?_foreign_keys=on"
+}
+
+type Bolt struct {
+	path string
+}
+
+// NewBolt creates factory of the storage in bbolt database file. The file is
+// created when it does not exist.
+func NewBolt(path string) *Bolt {
+	return &Bolt{path: path}
+}
+
+// MakeStorage opens the database file. The file is locked while it is open,
+// MakeStorage panics when the file cannot be locked within a second, for
+// example when it is used by another process.
+func (s *Bolt) MakeStorage() invoice.Storage {
+	db, err := bbolt.Open(s.path, 0600, &bbolt.Options{Timeout: time.Second}) // nolint:gomnd
+	if err != nil {
+		panic("bolt: open database failed: " + err.Error())
+	}
+
+	strg, err := bolt.New(db)
+	if err != nil {
+		panic("bolt: " + err.Error())
+	}
+	return strg
+}
+
+var _ invoice.StorageFactory = (*Bolt)(nil)
+
 type dynamoOptions struct {
-	endpoint string
-	region   string
+	client      dynamo.API
+	endpoint    string
+	region      string
+	retryPolicy dynamo.RetryPolicy
 }
 
 var defaultDynamoOptions = dynamoOptions{
-	region: "ap-southeast-2",
+	region:      "ap-southeast-2",
+	retryPolicy: dynamo.DefaultRetryPolicy,
 }
 
 type DynamoOption interface {
@@ -83,3 +212,19 @@ func WithRegion(v string) DynamoOption {
 		o.region = v
 	})
 }
+
+// WithClient sets DynamoDB API client used by storage. When client provided
+// endpoint and region options are ignored.
+func WithClient(v dynamo.API) DynamoOption {
+	return newFuncDynamoOption(func(o *dynamoOptions) {
+		o.client = v
+	})
+}
+
+// WithRetryPolicy sets the policy of retrying DynamoDB calls failed with
+// transient errors.
+func WithRetryPolicy(v dynamo.RetryPolicy) DynamoOption {
+	return newFuncDynamoOption(func(o *dynamoOptions) {
+		o.retryPolicy = v
+	})
+}
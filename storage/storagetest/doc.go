@@ -0,0 +1,11 @@
+// Package storagetest contains the conformance test suite of invoice storage
+// implementations.
+//
+// Every storage implementation should pass the suite:
+//
+//	func TestConformance(t *testing.T) {
+//		storagetest.Run(t, storagetest.FactoryFunc(func() invoice.Storage {
+//			return memory.New()
+//		}))
+//	}
+package storagetest
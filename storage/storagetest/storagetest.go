@@ -0,0 +1,624 @@
+package storagetest
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/antklim/go-invoice/invoice"
+	"github.com/google/uuid"
+)
+
+// FactoryFunc is an adapter to use a function as storage factory.
+type FactoryFunc func() invoice.Storage
+
+func (f FactoryFunc) MakeStorage() invoice.Storage {
+	return f()
+}
+
+var _ invoice.StorageFactory = FactoryFunc(nil)
+
+// concurrency is the number of goroutines used by concurrency tests.
+const concurrency = 8
+
+// Run runs the conformance test suite against the storage made by the
+// factory. The storage made once and shared by all tests, every test uses
+// invoices with new IDs, so the storage does not have to be empty.
+func Run(t *testing.T, f invoice.StorageFactory) {
+	t.Helper()
+	strg := f.MakeStorage()
+
+	t.Run("AddInvoice", func(t *testing.T) { testAddInvoice(t, strg) })
+	t.Run("FindInvoice", func(t *testing.T) { testFindInvoice(t, strg) })
+	t.Run("UpdateInvoice", func(t *testing.T) { testUpdateInvoice(t, strg) })
+	t.Run("Concurrency", func(t *testing.T) { testConcurrency(t, strg) })
+	if l, ok := strg.(invoice.Lister); ok {
+		t.Run("ListInvoices", func(t *testing.T) { testListInvoices(t, strg, l) })
+	}
+	if tr, ok := strg.(invoice.Transactor); ok {
+		t.Run("RunInTx", func(t *testing.T) { testRunInTx(t, strg, tr) })
+	}
+	if o, ok := strg.(invoice.Outbox); ok {
+		t.Run("Outbox", func(t *testing.T) { testOutbox(t, strg, o) })
+	}
+}
+
+func testAddInvoice(t *testing.T, strg invoice.Storage) {
+	t.Run("stores invoice as is", func(t *testing.T) {
+		inv := invoice.NewInvoice("John Doe")
+		inv.CreatedAt = inv.CreatedAt.Add(-time.Hour)
+		mustAdd(t, strg, inv)
+
+		vinv := mustFind(t, strg, inv.ID)
+		if !vinv.Equal(&inv) {
+			t.Errorf("FindInvoice(%q) = %v, want %v", inv.ID, vinv, inv)
+		}
+	})
+
+	t.Run("fails when repeat adding existing invoice", func(t *testing.T) {
+		inv := invoice.NewInvoice("John Doe")
+		mustAdd(t, strg, inv)
+
+		if err := strg.AddInvoice(inv); err == nil {
+			t.Errorf("expected second call AddInvoice(%v) to fail", inv)
+		} else if got, want := err.Error(), fmt.Sprintf("invoice %q exists", inv.ID); got != want {
+			t.Errorf("second call AddInvoice(%v) = %v, want %v", inv, got, want)
+		}
+	})
+}
+
+func testFindInvoice(t *testing.T, strg invoice.Storage) {
+	t.Run("returns nil invoice when no invoices found", func(t *testing.T) {
+		invID := uuid.NewString()
+		inv, err := strg.FindInvoice(invID)
+		if err != nil {
+			t.Errorf("FindInvoice(%q) failed: %v", invID, err)
+		}
+		if inv != nil {
+			t.Errorf("FindInvoice(%q) no invoice expected, got %v", invID, inv)
+		}
+	})
+
+	t.Run("returns invoice without issue date", func(t *testing.T) {
+		inv := invoice.NewInvoice("John Doe")
+		mustAdd(t, strg, inv)
+
+		vinv := mustFind(t, strg, inv.ID)
+		if vinv.Date != nil {
+			t.Errorf("invalid invoice.Date %v, want nil", vinv.Date)
+		}
+	})
+
+	t.Run("returns invoice with issue date", func(t *testing.T) {
+		inv := invoice.NewInvoice("John Doe")
+		date := time.Date(2021, time.September, 1, 10, 30, 15, 123456789, time.FixedZone("AEST", 10*60*60))
+		inv.Date = &date
+		inv.Status = invoice.Issued
+		mustAdd(t, strg, inv)
+
+		vinv := mustFind(t, strg, inv.ID)
+		if vinv.Date == nil || !vinv.Date.Equal(date) {
+			t.Errorf("invalid invoice.Date %v, want %v", vinv.Date, date)
+		}
+		if vinv.Status != invoice.Issued {
+			t.Errorf("invalid invoice.Status %q, want %q", vinv.Status, invoice.Issued)
+		}
+	})
+
+	t.Run("returns invoice items", func(t *testing.T) {
+		inv := invoice.NewInvoice("John Doe")
+		inv.Items = []invoice.Item{
+			invoice.NewItem("pen", 150, 2),
+			invoice.NewItem("apple", 75, 10),
+			invoice.NewItem("pineapple", 499, 1),
+		}
+		mustAdd(t, strg, inv)
+
+		vinv := mustFind(t, strg, inv.ID)
+		if !vinv.Equal(&inv) {
+			t.Errorf("FindInvoice(%q) = %v, want %v", inv.ID, vinv, inv)
+		}
+	})
+}
+
+func testUpdateInvoice(t *testing.T, strg invoice.Storage) {
+	t.Run("stores changes", func(t *testing.T) {
+		inv := invoice.NewInvoice("John Doe")
+		inv.Items = []invoice.Item{
+			invoice.NewItem("pen", 150, 2),
+			invoice.NewItem("apple", 75, 10),
+		}
+		mustAdd(t, strg, inv)
+
+		upd := *mustFind(t, strg, inv.ID)
+		upd.CustomerName = "Jane Doe"
+		upd.Items = []invoice.Item{inv.Items[1], invoice.NewItem("pineapple", 499, 1)}
+		upd.Items[0].Qty = 5
+		mustUpdate(t, strg, upd)
+
+		vinv := mustFind(t, strg, inv.ID)
+		upd.UpdatedAt = vinv.UpdatedAt
+		if !vinv.Equal(&upd) {
+			t.Errorf("FindInvoice(%q) = %v, want %v", inv.ID, vinv, upd)
+		}
+	})
+
+	t.Run("stores split and merge references", func(t *testing.T) {
+		inv := invoice.NewInvoice("John Doe")
+		inv.Items = []invoice.Item{invoice.NewItem("pen", 150, 2)}
+		mustAdd(t, strg, inv)
+
+		upd := *mustFind(t, strg, inv.ID)
+		upd.Origins = []string{uuid.NewString(), uuid.NewString()}
+		upd.Successors = []string{uuid.NewString()}
+		upd.Items[0].Origin = upd.Origins[0]
+		mustUpdate(t, strg, upd)
+
+		vinv := mustFind(t, strg, inv.ID)
+		upd.UpdatedAt = vinv.UpdatedAt
+		if !vinv.Equal(&upd) {
+			t.Errorf("FindInvoice(%q) = %v, want %v", inv.ID, vinv, upd)
+		}
+
+		upd = *vinv
+		upd.Origins, upd.Successors = nil, nil
+		mustUpdate(t, strg, upd)
+
+		vinv = mustFind(t, strg, inv.ID)
+		if len(vinv.Origins) != 0 || len(vinv.Successors) != 0 {
+			t.Errorf("invalid invoice references %v, %v, want none", vinv.Origins, vinv.Successors)
+		}
+	})
+
+	t.Run("stores scheduled issue time", func(t *testing.T) {
+		inv := invoice.NewInvoice("John Doe")
+		at := time.Date(2021, time.October, 1, 9, 0, 0, 123456789, time.UTC)
+		inv.IssueAt = &at
+		mustAdd(t, strg, inv)
+
+		vinv := mustFind(t, strg, inv.ID)
+		if vinv.IssueAt == nil || !vinv.IssueAt.Equal(at) {
+			t.Errorf("invalid invoice.IssueAt %v, want %v", vinv.IssueAt, at)
+		}
+
+		upd := *vinv
+		if err := upd.Issue(); err != nil {
+			t.Fatalf("Issue() failed: %v", err)
+		}
+		mustUpdate(t, strg, upd)
+
+		vinv = mustFind(t, strg, inv.ID)
+		if vinv.IssueAt != nil {
+			t.Errorf("invalid invoice.IssueAt %v, want nil", vinv.IssueAt)
+		}
+	})
+
+	t.Run("stores dunning state", func(t *testing.T) {
+		inv := invoice.NewInvoice("John Doe")
+		mustAdd(t, strg, inv)
+
+		upd := *mustFind(t, strg, inv.ID)
+		at := time.Date(2021, time.October, 1, 9, 0, 0, 123456789, time.UTC)
+		upd.DunningLevel, upd.DunningAt = 2, &at
+		mustUpdate(t, strg, upd)
+
+		vinv := mustFind(t, strg, inv.ID)
+		if vinv.DunningLevel != 2 || vinv.DunningAt == nil || !vinv.DunningAt.Equal(at) {
+			t.Errorf("invalid invoice dunning level %d at %v, want level 2 at %v", vinv.DunningLevel, vinv.DunningAt, at)
+		}
+	})
+
+	t.Run("stores delivery", func(t *testing.T) {
+		inv := invoice.NewInvoice("John Doe")
+		mustAdd(t, strg, inv)
+
+		upd := *mustFind(t, strg, inv.ID)
+		at := time.Date(2021, time.October, 1, 9, 0, 0, 123456789, time.UTC)
+		next := at.Add(time.Minute)
+		upd.Delivery = &invoice.Delivery{
+			Status:   invoice.DeliveryPending,
+			Attempts: []invoice.DeliveryAttempt{{At: at, Err: "connection refused"}},
+			NextAt:   &next,
+		}
+		mustUpdate(t, strg, upd)
+
+		vinv := mustFind(t, strg, inv.ID)
+		if !vinv.Delivery.Equal(upd.Delivery) {
+			t.Errorf("invalid invoice.Delivery %v, want %v", vinv.Delivery, upd.Delivery)
+		}
+	})
+
+	t.Run("stores status and issue date changes", func(t *testing.T) {
+		inv := invoice.NewInvoice("John Doe")
+		mustAdd(t, strg, inv)
+
+		upd := *mustFind(t, strg, inv.ID)
+		if err := upd.Issue(); err != nil {
+			t.Fatalf("Issue() failed: %v", err)
+		}
+		mustUpdate(t, strg, upd)
+
+		vinv := mustFind(t, strg, inv.ID)
+		if vinv.Status != invoice.Issued {
+			t.Errorf("invalid invoice.Status %q, want %q", vinv.Status, invoice.Issued)
+		}
+		if vinv.Date == nil || !vinv.Date.Equal(*upd.Date) {
+			t.Errorf("invalid invoice.Date %v, want %v", vinv.Date, upd.Date)
+		}
+	})
+
+	t.Run("stamps invoice update time", func(t *testing.T) {
+		inv := invoice.NewInvoice("John Doe")
+		mustAdd(t, strg, inv)
+
+		upd := *mustFind(t, strg, inv.ID)
+		upd.CustomerName = "Jane Doe"
+		mustUpdate(t, strg, upd)
+
+		vinv := mustFind(t, strg, inv.ID)
+		if !vinv.UpdatedAt.After(inv.UpdatedAt) {
+			t.Errorf("invalid invoice.UpdatedAt %v, want it to be after %v", vinv.UpdatedAt, inv.UpdatedAt)
+		}
+		if !vinv.CreatedAt.Equal(inv.CreatedAt) {
+			t.Errorf("invalid invoice.CreatedAt %v, want %v", vinv.CreatedAt, inv.CreatedAt)
+		}
+	})
+
+	t.Run("fails when updating non-existing invoice", func(t *testing.T) {
+		inv := invoice.NewInvoice("John Doe")
+		if err := strg.UpdateInvoice(inv); err == nil {
+			t.Errorf("expected UpdateInvoice(%v) to fail", inv)
+		} else if got, want := err.Error(), fmt.Sprintf("invoice %q not found", inv.ID); got != want {
+			t.Errorf("UpdateInvoice(%v) = %v, want %v", inv, got, want)
+		}
+
+		if vinv, err := strg.FindInvoice(inv.ID); err != nil || vinv != nil {
+			t.Errorf("FindInvoice(%q) = %v, %v, want no invoice", inv.ID, vinv, err)
+		}
+	})
+}
+
+func testListInvoices(t *testing.T, strg invoice.Storage, l invoice.Lister) {
+	want := make(map[string]invoice.Invoice)
+	for i := 0; i < 5; i++ {
+		inv := invoice.NewInvoice("John Doe")
+		inv.Items = []invoice.Item{invoice.NewItem("pen", 150, i+1)}
+		mustAdd(t, strg, inv)
+		want[inv.ID] = inv
+	}
+
+	// the storage is shared with other tests, so every page is listed and only
+	// the invoices added by this test are checked
+	seen := make(map[string]bool)
+	cursor := ""
+	for page := 0; ; page++ {
+		if page > 1000 {
+			t.Fatalf("ListInvoices() did not reach the last page")
+		}
+
+		invs, next, err := l.ListInvoices(cursor, 2)
+		if err != nil {
+			t.Fatalf("ListInvoices(%q, 2) failed: %v", cursor, err)
+		}
+		if len(invs) > 2 {
+			t.Fatalf("ListInvoices(%q, 2) returned %d invoices, want at most 2", cursor, len(invs))
+		}
+
+		for i := range invs {
+			inv := invs[i]
+			if seen[inv.ID] {
+				t.Errorf("invoice %q listed twice", inv.ID)
+			}
+			seen[inv.ID] = true
+
+			if w, ok := want[inv.ID]; ok && !inv.Equal(&w) {
+				t.Errorf("listed invoice %v, want %v", inv, w)
+			}
+		}
+
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+
+	for id := range want {
+		if !seen[id] {
+			t.Errorf("invoice %q not listed", id)
+		}
+	}
+}
+
+// testConcurrency adds, finds and updates different invoices concurrently.
+func testRunInTx(t *testing.T, strg invoice.Storage, tr invoice.Transactor) {
+	t.Run("commits all changes", func(t *testing.T) {
+		inv := invoice.NewInvoice("John Doe")
+		mustAdd(t, strg, inv)
+		added := invoice.NewInvoice("Jane Doe")
+		added.Items = []invoice.Item{invoice.NewItem("pen", 150, 2)}
+
+		err := tr.RunInTx(func(tx invoice.Storage) error {
+			if err := tx.AddInvoice(added); err != nil {
+				return err
+			}
+			vinv, err := tx.FindInvoice(inv.ID)
+			if err != nil {
+				return err
+			}
+			vinv.CustomerName = "Bob Doe"
+			return tx.UpdateInvoice(*vinv)
+		})
+		if err != nil {
+			t.Fatalf("RunInTx() failed: %v", err)
+		}
+
+		if vinv := mustFind(t, strg, added.ID); !vinv.Equal(&added) {
+			t.Errorf("FindInvoice(%q) = %v, want %v", added.ID, vinv, added)
+		}
+		if vinv := mustFind(t, strg, inv.ID); vinv.CustomerName != "Bob Doe" {
+			t.Errorf("invalid invoice.CustomerName %q, want Bob Doe", vinv.CustomerName)
+		}
+	})
+
+	t.Run("reads own changes", func(t *testing.T) {
+		inv := invoice.NewInvoice("John Doe")
+
+		err := tr.RunInTx(func(tx invoice.Storage) error {
+			if err := tx.AddInvoice(inv); err != nil {
+				return err
+			}
+			upd := inv
+			upd.Items = []invoice.Item{invoice.NewItem("pen", 150, 2)}
+			if err := tx.UpdateInvoice(upd); err != nil {
+				return err
+			}
+
+			vinv, err := tx.FindInvoice(inv.ID)
+			if err != nil {
+				return err
+			}
+			if vinv == nil || len(vinv.Items) != 1 {
+				return fmt.Errorf("FindInvoice(%q) = %v, want invoice with 1 item", inv.ID, vinv)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("RunInTx() failed: %v", err)
+		}
+
+		if vinv := mustFind(t, strg, inv.ID); len(vinv.Items) != 1 {
+			t.Errorf("invalid invoice.Items %v, want 1 item", vinv.Items)
+		}
+	})
+
+	t.Run("writes nothing when fails", func(t *testing.T) {
+		inv := invoice.NewInvoice("John Doe")
+		mustAdd(t, strg, inv)
+		added := invoice.NewInvoice("Jane Doe")
+		missing := invoice.NewInvoice("Bob Doe")
+
+		err := tr.RunInTx(func(tx invoice.Storage) error {
+			if err := tx.AddInvoice(added); err != nil {
+				return err
+			}
+			upd := inv
+			upd.CustomerName = "Bob Doe"
+			if err := tx.UpdateInvoice(upd); err != nil {
+				return err
+			}
+			return tx.UpdateInvoice(missing)
+		})
+		if err == nil {
+			t.Fatal("RunInTx() expected to fail")
+		} else if got, want := err.Error(), fmt.Sprintf("invoice %q not found", missing.ID); got != want {
+			t.Errorf("RunInTx() = %v, want %v", got, want)
+		}
+
+		if vinv, err := strg.FindInvoice(added.ID); err != nil || vinv != nil {
+			t.Errorf("FindInvoice(%q) = %v, %v, want no invoice", added.ID, vinv, err)
+		}
+		if vinv := mustFind(t, strg, inv.ID); vinv.CustomerName != inv.CustomerName {
+			t.Errorf("invalid invoice.CustomerName %q, want %q", vinv.CustomerName, inv.CustomerName)
+		}
+	})
+
+	t.Run("fails when adding existing invoice", func(t *testing.T) {
+		inv := invoice.NewInvoice("John Doe")
+		mustAdd(t, strg, inv)
+
+		err := tr.RunInTx(func(tx invoice.Storage) error {
+			return tx.AddInvoice(inv)
+		})
+		if err == nil {
+			t.Fatal("RunInTx() expected to fail")
+		} else if got, want := err.Error(), fmt.Sprintf("invoice %q exists", inv.ID); got != want {
+			t.Errorf("RunInTx() = %v, want %v", got, want)
+		}
+	})
+}
+
+func testOutbox(t *testing.T, strg invoice.Storage, o invoice.Outbox) {
+	newEvent := func(typ string, inv invoice.Invoice, at time.Time) invoice.Event {
+		return invoice.Event{ID: uuid.NewString(), Type: typ, At: at, Invoice: inv}
+	}
+
+	t.Run("stores events with transaction changes", func(t *testing.T) {
+		inv := invoice.NewInvoice("John Doe")
+		inv.Items = []invoice.Item{invoice.NewItem("pen", 150, 2)}
+		created := newEvent(invoice.EventCreated, inv, time.Now())
+		err := o.RunInTx(func(tx invoice.Storage) error {
+			if err := tx.AddInvoice(inv); err != nil {
+				return err
+			}
+			return writeEvent(tx, created)
+		})
+		if err != nil {
+			t.Fatalf("RunInTx() failed: %v", err)
+		}
+
+		issued := inv
+		issued.Status = invoice.Issued
+		issuedEvent := newEvent(invoice.EventType(invoice.Issued), issued, created.At.Add(time.Second))
+		issuedEvent.From = invoice.Open.String()
+		err = o.RunInTx(func(tx invoice.Storage) error {
+			if err := tx.UpdateInvoice(issued); err != nil {
+				return err
+			}
+			return writeEvent(tx, issuedEvent)
+		})
+		if err != nil {
+			t.Fatalf("RunInTx() failed: %v", err)
+		}
+
+		events := mustOutboxEvents(t, o, inv.ID)
+		if len(events) != 2 {
+			t.Fatalf("invalid outbox events %v, want 2 events", events)
+		}
+		for i, want := range []invoice.Event{created, issuedEvent} {
+			got := events[i]
+			if got.ID != want.ID || got.Type != want.Type || got.From != want.From || !got.At.Equal(want.At) ||
+				!got.Invoice.Equal(&want.Invoice) {
+				t.Errorf("invalid outbox event %d %v, want %v", i, got, want)
+			}
+		}
+		if vinv := mustFind(t, strg, inv.ID); vinv.Status != invoice.Issued || len(vinv.Items) != 1 {
+			t.Errorf("invalid invoice %v", vinv)
+		}
+
+		if err := o.DeleteOutboxEvents(events[:1]); err != nil {
+			t.Fatalf("DeleteOutboxEvents() failed: %v", err)
+		}
+		if events := mustOutboxEvents(t, o, inv.ID); len(events) != 1 || events[0].ID != issuedEvent.ID {
+			t.Errorf("invalid outbox events %v, want issued event", events)
+		}
+		if err := o.DeleteOutboxEvents(events); err != nil {
+			t.Fatalf("DeleteOutboxEvents() failed: %v", err)
+		}
+		if events := mustOutboxEvents(t, o, inv.ID); len(events) != 0 {
+			t.Errorf("invalid outbox events %v, want none", events)
+		}
+	})
+
+	t.Run("writes no events when fails", func(t *testing.T) {
+		inv := invoice.NewInvoice("John Doe")
+		err := o.RunInTx(func(tx invoice.Storage) error {
+			if err := tx.AddInvoice(inv); err != nil {
+				return err
+			}
+			if err := writeEvent(tx, newEvent(invoice.EventCreated, inv, time.Now())); err != nil {
+				return err
+			}
+			return errors.New("failed")
+		})
+		if err == nil {
+			t.Fatal("RunInTx() expected to fail")
+		}
+		if events := mustOutboxEvents(t, o, inv.ID); len(events) != 0 {
+			t.Errorf("invalid outbox events %v, want none", events)
+		}
+	})
+}
+
+func writeEvent(tx invoice.Storage, e invoice.Event) error {
+	w, ok := tx.(invoice.EventWriter)
+	if !ok {
+		return errors.New("transaction cannot write events")
+	}
+	return w.WriteEvent(e)
+}
+
+// mustOutboxEvents returns the outbox events of the invoice.
+func mustOutboxEvents(t *testing.T, o invoice.Outbox, id string) []invoice.Event {
+	t.Helper()
+
+	all, err := o.OutboxEvents(0)
+	if err != nil {
+		t.Fatalf("OutboxEvents() failed: %v", err)
+	}
+	var events []invoice.Event
+	for _, e := range all {
+		if e.Invoice.ID == id {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+func testConcurrency(t *testing.T, strg invoice.Storage) {
+	var wg sync.WaitGroup
+	errs := make(chan error, concurrency)
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- addFindUpdate(strg, fmt.Sprintf("customer %d", i))
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func addFindUpdate(strg invoice.Storage, customer string) error {
+	inv := invoice.NewInvoice(customer)
+	if err := strg.AddInvoice(inv); err != nil {
+		return fmt.Errorf("AddInvoice(%v) failed: %v", inv, err)
+	}
+
+	for i := 0; i < 3; i++ {
+		vinv, err := strg.FindInvoice(inv.ID)
+		if err != nil {
+			return fmt.Errorf("FindInvoice(%q) failed: %v", inv.ID, err)
+		}
+		if vinv == nil {
+			return fmt.Errorf("FindInvoice(%q) invoice expected, got nil", inv.ID)
+		}
+		if len(vinv.Items) != i || vinv.CustomerName != customer {
+			return fmt.Errorf("FindInvoice(%q) = %v, want %d items of %q", inv.ID, vinv, i, customer)
+		}
+
+		vinv.Items = append(vinv.Items, invoice.NewItem("pen", 100, 1))
+		if err := strg.UpdateInvoice(*vinv); err != nil {
+			return fmt.Errorf("UpdateInvoice(%v) failed: %v", vinv, err)
+		}
+	}
+
+	return nil
+}
+
+func mustAdd(t *testing.T, strg invoice.Storage, inv invoice.Invoice) {
+	t.Helper()
+
+	if err := strg.AddInvoice(inv); err != nil {
+		t.Fatalf("AddInvoice(%v) failed: %v", inv, err)
+	}
+}
+
+func mustFind(t *testing.T, strg invoice.Storage, id string) *invoice.Invoice {
+	t.Helper()
+
+	inv, err := strg.FindInvoice(id)
+	if err != nil {
+		t.Fatalf("FindInvoice(%q) failed: %v", id, err)
+	}
+	if inv == nil {
+		t.Fatalf("FindInvoice(%q) invoice expected, got nil", id)
+	}
+	return inv
+}
+
+func mustUpdate(t *testing.T, strg invoice.Storage, inv invoice.Invoice) {
+	t.Helper()
+
+	if err := strg.UpdateInvoice(inv); err != nil {
+		t.Fatalf("UpdateInvoice(%v) failed: %v", inv, err)
+	}
+}
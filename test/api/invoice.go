@@ -123,6 +123,24 @@ func WithIssueaDate(date *time.Time) InvoiceOption {
 	})
 }
 
+func WithIssueAt(at *time.Time) InvoiceOption {
+	return newFuncInvoiceOption(func(inv *invoice.Invoice) {
+		inv.IssueAt = at
+	})
+}
+
+func WithDunningLevel(level int) InvoiceOption {
+	return newFuncInvoiceOption(func(inv *invoice.Invoice) {
+		inv.DunningLevel = level
+	})
+}
+
+func WithDelivery(d *invoice.Delivery) InvoiceOption {
+	return newFuncInvoiceOption(func(inv *invoice.Invoice) {
+		inv.Delivery = d
+	})
+}
+
 func WithStatus(status invoice.Status) InvoiceOption {
 	return newFuncInvoiceOption(func(inv *invoice.Invoice) {
 		inv.Status = status
@@ -0,0 +1,3 @@
+// Package fakes contains working in-memory implementations of the external
+// services APIs.
+package fakes
@@ -0,0 +1,775 @@
+package fakes
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/antklim/go-invoice/storage/dynamo"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+const (
+	errCodeValidation       = "ValidationException"
+	errCodeResourceNotFound = dynamodb.ErrCodeResourceNotFoundException
+
+	reasonNone                   = "None"
+	reasonConditionalCheckFailed = "ConditionalCheckFailed"
+)
+
+// table is a DynamoDB table. Items indexed by the encoded primary key.
+type table struct {
+	hashKey  string
+	rangeKey string // empty for tables with the simple primary key
+	items    map[string]attrs
+	indexes  map[string]*table // global secondary indexes, definitions only
+	base     *table            // table of the index view, nil for tables
+}
+
+// DynamoDB is an in-memory implementation of the DynamoDB API. It stores items
+// and evaluates condition, key condition, filter, projection and update
+// expressions.
+type DynamoDB struct {
+	sync.Mutex // guards tables
+	tables     map[string]*table
+}
+
+var _ dynamo.API = (*DynamoDB)(nil)
+
+// NewDynamoDB creates an instance of the DynamoDB fake. Tables should be
+// created with the WithTable option.
+//
+// For example:
+//
+//	// DynamoDB with invoices table of the item collection layout.
+//	fakes.NewDynamoDB(fakes.WithTable("invoices", "pk", "sk"),
+//		fakes.WithIndex("invoices", "outbox", "outbox", "outboxAt"))
+func NewDynamoDB(opts ...DynamoDBOption) *DynamoDB {
+	db := &DynamoDB{tables: make(map[string]*table)}
+
+	for _, o := range opts {
+		o.apply(db)
+	}
+
+	return db
+}
+
+// QueryWithContext is Query failed when the context is done.
+func (db *DynamoDB) QueryWithContext(ctx aws.Context, input *dynamodb.QueryInput,
+	_ ...request.Option) (*dynamodb.QueryOutput, error) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+	return db.Query(input)
+}
+
+// ScanWithContext is Scan failed when the context is done.
+func (db *DynamoDB) ScanWithContext(ctx aws.Context, input *dynamodb.ScanInput,
+	_ ...request.Option) (*dynamodb.ScanOutput, error) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+	return db.Scan(input)
+}
+
+// TransactWriteItemsWithContext is TransactWriteItems failed when the context
+// is done.
+func (db *DynamoDB) TransactWriteItemsWithContext(ctx aws.Context, input *dynamodb.TransactWriteItemsInput,
+	_ ...request.Option) (*dynamodb.TransactWriteItemsOutput, error) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+	return db.TransactWriteItems(input)
+}
+
+// UpdateItemWithContext is UpdateItem failed when the context is done.
+func (db *DynamoDB) UpdateItemWithContext(ctx aws.Context, input *dynamodb.UpdateItemInput,
+	_ ...request.Option) (*dynamodb.UpdateItemOutput, error) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+	return db.UpdateItem(input)
+}
+
+// contextError returns the error of the SDK request cancelled by the context.
+func contextError(ctx aws.Context) error {
+	if err := ctx.Err(); err != nil {
+		return awserr.New(request.CanceledErrorCode, "request context canceled", err)
+	}
+	return nil
+}
+
+func (db *DynamoDB) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	db.Lock()
+	defer db.Unlock()
+
+	t, err := db.table(input.TableName)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := t.encodeKey(input.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	projection, err := parseProjection(input.ProjectionExpression, input.ExpressionAttributeNames)
+	if err != nil {
+		return nil, validationError(err)
+	}
+
+	item, ok := t.items[key]
+	if !ok {
+		return &dynamodb.GetItemOutput{}, nil
+	}
+	return &dynamodb.GetItemOutput{Item: project(item, projection)}, nil
+}
+
+func (db *DynamoDB) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	db.Lock()
+	defer db.Unlock()
+
+	w := &dynamodb.Put{
+		TableName:                 input.TableName,
+		Item:                      input.Item,
+		ConditionExpression:       input.ConditionExpression,
+		ExpressionAttributeNames:  input.ExpressionAttributeNames,
+		ExpressionAttributeValues: input.ExpressionAttributeValues,
+	}
+
+	apply, err := db.preparePut(w)
+	if err != nil {
+		return nil, err
+	}
+	apply()
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (db *DynamoDB) DeleteItem(input *dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error) {
+	db.Lock()
+	defer db.Unlock()
+
+	w := &dynamodb.Delete{
+		TableName:                 input.TableName,
+		Key:                       input.Key,
+		ConditionExpression:       input.ConditionExpression,
+		ExpressionAttributeNames:  input.ExpressionAttributeNames,
+		ExpressionAttributeValues: input.ExpressionAttributeValues,
+	}
+
+	apply, err := db.prepareDelete(w)
+	if err != nil {
+		return nil, err
+	}
+	apply()
+	return &dynamodb.DeleteItemOutput{}, nil
+}
+
+func (db *DynamoDB) UpdateItem(input *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+	db.Lock()
+	defer db.Unlock()
+
+	w := &dynamodb.Update{
+		TableName:                 input.TableName,
+		Key:                       input.Key,
+		ConditionExpression:       input.ConditionExpression,
+		UpdateExpression:          input.UpdateExpression,
+		ExpressionAttributeNames:  input.ExpressionAttributeNames,
+		ExpressionAttributeValues: input.ExpressionAttributeValues,
+	}
+
+	apply, err := db.prepareUpdate(w)
+	if err != nil {
+		return nil, err
+	}
+	apply()
+	return &dynamodb.UpdateItemOutput{}, nil
+}
+
+func (db *DynamoDB) Query(input *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+	db.Lock()
+	defer db.Unlock()
+
+	t, err := db.table(input.TableName)
+	if err != nil {
+		return nil, err
+	}
+
+	if input.IndexName != nil {
+		if aws.BoolValue(input.ConsistentRead) {
+			return nil, validationError(fmt.Errorf("consistent reads are not supported on global secondary indexes"))
+		}
+		if t, err = t.index(aws.StringValue(input.IndexName)); err != nil {
+			return nil, err
+		}
+	}
+
+	keyCond, err := parseCondition(input.KeyConditionExpression, input.ExpressionAttributeNames,
+		input.ExpressionAttributeValues)
+	if err != nil {
+		return nil, validationError(err)
+	}
+	if keyCond == nil {
+		return nil, validationError(fmt.Errorf("key condition expression is required"))
+	}
+
+	page, err := t.read(readInput{
+		keyCond:           keyCond,
+		filter:            input.FilterExpression,
+		projection:        input.ProjectionExpression,
+		names:             input.ExpressionAttributeNames,
+		values:            input.ExpressionAttributeValues,
+		exclusiveStartKey: input.ExclusiveStartKey,
+		limit:             input.Limit,
+		backward:          input.ScanIndexForward != nil && !*input.ScanIndexForward,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &dynamodb.QueryOutput{
+		Items:            page.items,
+		Count:            aws.Int64(int64(len(page.items))),
+		ScannedCount:     aws.Int64(int64(page.scanned)),
+		LastEvaluatedKey: page.lastKey,
+	}, nil
+}
+
+func (db *DynamoDB) Scan(input *dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+	db.Lock()
+	defer db.Unlock()
+
+	t, err := db.table(input.TableName)
+	if err != nil {
+		return nil, err
+	}
+
+	page, err := t.read(readInput{
+		filter:            input.FilterExpression,
+		projection:        input.ProjectionExpression,
+		names:             input.ExpressionAttributeNames,
+		values:            input.ExpressionAttributeValues,
+		exclusiveStartKey: input.ExclusiveStartKey,
+		limit:             input.Limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &dynamodb.ScanOutput{
+		Items:            page.items,
+		Count:            aws.Int64(int64(len(page.items))),
+		ScannedCount:     aws.Int64(int64(page.scanned)),
+		LastEvaluatedKey: page.lastKey,
+	}, nil
+}
+
+// TransactWriteItems atomically applies all the write actions. When any action
+// condition fails none of the actions applied and TransactionCanceledException
+// returned with the cancellation reason of every action.
+func (db *DynamoDB) TransactWriteItems(input *dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+	db.Lock()
+	defer db.Unlock()
+
+	if len(input.TransactItems) == 0 || len(input.TransactItems) > 100 { // nolint:gomnd
+		return nil, validationError(fmt.Errorf("transaction should contain from 1 to 100 actions"))
+	}
+
+	var (
+		applies []func()
+		reasons = make([]*dynamodb.CancellationReason, 0, len(input.TransactItems))
+		failed  bool
+		touched = make(map[string]struct{}, len(input.TransactItems))
+	)
+
+	for _, w := range input.TransactItems {
+		target, err := db.transactTarget(w)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := touched[target]; ok {
+			return nil, validationError(fmt.Errorf("transaction cannot include multiple operations on one item"))
+		}
+		touched[target] = struct{}{}
+
+		apply, err := db.prepareTransactWrite(w)
+		if isConditionalCheckError(err) {
+			failed = true
+			reasons = append(reasons, &dynamodb.CancellationReason{
+				Code:    aws.String(reasonConditionalCheckFailed),
+				Message: aws.String("The conditional request failed"),
+			})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		applies = append(applies, apply)
+		reasons = append(reasons, &dynamodb.CancellationReason{Code: aws.String(reasonNone)})
+	}
+
+	if failed {
+		codes := make([]string, 0, len(reasons))
+		for _, r := range reasons {
+			codes = append(codes, aws.StringValue(r.Code))
+		}
+		return nil, &dynamodb.TransactionCanceledException{
+			Message_: aws.String(fmt.Sprintf(
+				"Transaction cancelled, please refer cancellation reasons for specific reasons [%s]",
+				strings.Join(codes, ", "))),
+			CancellationReasons: reasons,
+		}
+	}
+
+	for _, apply := range applies {
+		apply()
+	}
+	return &dynamodb.TransactWriteItemsOutput{}, nil
+}
+
+// transactTarget returns the table and the encoded key of the item targeted by
+// the write action.
+func (db *DynamoDB) transactTarget(w *dynamodb.TransactWriteItem) (string, error) {
+	var (
+		tableName *string
+		key       attrs
+	)
+
+	switch {
+	case w.Put != nil:
+		tableName, key = w.Put.TableName, w.Put.Item
+	case w.Update != nil:
+		tableName, key = w.Update.TableName, w.Update.Key
+	case w.Delete != nil:
+		tableName, key = w.Delete.TableName, w.Delete.Key
+	case w.ConditionCheck != nil:
+		tableName, key = w.ConditionCheck.TableName, w.ConditionCheck.Key
+	default:
+		return "", validationError(fmt.Errorf("transaction action should define one operation"))
+	}
+
+	t, err := db.table(tableName)
+	if err != nil {
+		return "", err
+	}
+	k, err := t.encodeKey(key)
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(tableName) + "\x00" + k, nil
+}
+
+func (db *DynamoDB) prepareTransactWrite(w *dynamodb.TransactWriteItem) (func(), error) {
+	switch {
+	case w.Put != nil:
+		return db.preparePut(w.Put)
+	case w.Update != nil:
+		return db.prepareUpdate(w.Update)
+	case w.Delete != nil:
+		return db.prepareDelete(w.Delete)
+	default:
+		c := w.ConditionCheck
+		t, err := db.table(c.TableName)
+		if err != nil {
+			return nil, err
+		}
+		_, cur, err := t.lookup(c.Key)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkCondition(c.ConditionExpression, c.ExpressionAttributeNames,
+			c.ExpressionAttributeValues, cur); err != nil {
+			return nil, err
+		}
+		return func() {}, nil
+	}
+}
+
+// preparePut validates the put and checks its condition. It returns a function
+// that stores the item.
+func (db *DynamoDB) preparePut(w *dynamodb.Put) (func(), error) {
+	t, err := db.table(w.TableName)
+	if err != nil {
+		return nil, err
+	}
+
+	key, cur, err := t.lookup(w.Item)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkCondition(w.ConditionExpression, w.ExpressionAttributeNames,
+		w.ExpressionAttributeValues, cur); err != nil {
+		return nil, err
+	}
+
+	item := copyAttrs(w.Item)
+	return func() { t.items[key] = item }, nil
+}
+
+// prepareUpdate validates the update, checks its condition and evaluates the
+// update expression. It returns a function that stores the updated item.
+func (db *DynamoDB) prepareUpdate(w *dynamodb.Update) (func(), error) {
+	t, err := db.table(w.TableName)
+	if err != nil {
+		return nil, err
+	}
+
+	key, cur, err := t.lookup(w.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkCondition(w.ConditionExpression, w.ExpressionAttributeNames,
+		w.ExpressionAttributeValues, cur); err != nil {
+		return nil, err
+	}
+
+	actions, err := parseUpdate(w.UpdateExpression, w.ExpressionAttributeNames, w.ExpressionAttributeValues)
+	if err != nil {
+		return nil, validationError(err)
+	}
+
+	for _, a := range actions {
+		if a.path.name == t.hashKey || a.path.name == t.rangeKey {
+			return nil, validationError(fmt.Errorf("cannot update attribute %s, it is part of the key", a.path.name))
+		}
+	}
+
+	base := cur
+	if base == nil {
+		base = copyAttrs(w.Key)
+	}
+	updated, err := applyUpdate(base, actions)
+	if err != nil {
+		return nil, validationError(err)
+	}
+
+	return func() { t.items[key] = updated }, nil
+}
+
+// prepareDelete validates the delete and checks its condition. It returns a
+// function that deletes the item.
+func (db *DynamoDB) prepareDelete(w *dynamodb.Delete) (func(), error) {
+	t, err := db.table(w.TableName)
+	if err != nil {
+		return nil, err
+	}
+
+	key, cur, err := t.lookup(w.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkCondition(w.ConditionExpression, w.ExpressionAttributeNames,
+		w.ExpressionAttributeValues, cur); err != nil {
+		return nil, err
+	}
+
+	return func() { delete(t.items, key) }, nil
+}
+
+func (db *DynamoDB) table(name *string) (*table, error) {
+	t, ok := db.tables[aws.StringValue(name)]
+	if !ok {
+		return nil, awserr.New(errCodeResourceNotFound, "Requested resource not found", nil)
+	}
+	return t, nil
+}
+
+// index returns a view of the items that have the key attributes of the
+// global secondary index. Items without them are not in the (sparse) index.
+func (t *table) index(name string) (*table, error) {
+	def, ok := t.indexes[name]
+	if !ok {
+		return nil, validationError(fmt.Errorf("the table does not have the specified index: %s", name))
+	}
+
+	view := &table{
+		hashKey:  def.hashKey,
+		rangeKey: def.rangeKey,
+		items:    make(map[string]attrs),
+		base:     t,
+	}
+	for key, item := range t.items {
+		if _, err := view.encodeKey(item); err != nil {
+			continue
+		}
+		view.items[key] = item
+	}
+	return view, nil
+}
+
+// lookup returns the encoded primary key and the stored item identified by
+// the key attributes of item. The stored item is nil when not found.
+func (t *table) lookup(item attrs) (string, attrs, error) {
+	key, err := t.encodeKey(item)
+	if err != nil {
+		return "", nil, err
+	}
+	return key, t.items[key], nil
+}
+
+// encodeKey builds the index key of the item from its primary key attributes.
+func (t *table) encodeKey(item attrs) (string, error) {
+	hash, err := keyPart(item, t.hashKey)
+	if err != nil {
+		return "", err
+	}
+	if t.rangeKey == "" {
+		return hash, nil
+	}
+
+	rng, err := keyPart(item, t.rangeKey)
+	if err != nil {
+		return "", err
+	}
+	return hash + "\x00" + rng, nil
+}
+
+func keyPart(item attrs, name string) (string, error) {
+	av := item[name]
+	switch {
+	case av == nil:
+		return "", validationError(fmt.Errorf("missing the key %s in the item", name))
+	case av.S != nil:
+		return "S" + *av.S, nil
+	case av.N != nil:
+		return "N" + *av.N, nil
+	case av.B != nil:
+		return "B" + string(av.B), nil
+	}
+	return "", validationError(fmt.Errorf("invalid type of the key %s", name))
+}
+
+type readInput struct {
+	keyCond           condition
+	filter            *string
+	projection        *string
+	names             map[string]*string
+	values            attrs
+	exclusiveStartKey attrs
+	limit             *int64
+	backward          bool
+}
+
+type readPage struct {
+	items   []attrs
+	scanned int
+	lastKey attrs
+}
+
+// read returns a page of items ordered by primary key that satisfy the key
+// condition and the filter. Limit restricts the number of evaluated items.
+func (t *table) read(in readInput) (*readPage, error) {
+	filter, err := parseCondition(in.filter, in.names, in.values)
+	if err != nil {
+		return nil, validationError(err)
+	}
+
+	projection, err := parseProjection(in.projection, in.names)
+	if err != nil {
+		return nil, validationError(err)
+	}
+
+	var matched []attrs
+	for _, item := range t.items {
+		if in.keyCond != nil {
+			ok, err := in.keyCond.eval(item)
+			if err != nil {
+				return nil, validationError(err)
+			}
+			if !ok {
+				continue
+			}
+		}
+		matched = append(matched, item)
+	}
+	t.sort(matched, in.backward)
+
+	start := 0
+	if len(in.exclusiveStartKey) > 0 {
+		startKey, err := t.pageKey(in.exclusiveStartKey)
+		if err != nil {
+			return nil, err
+		}
+		for i, item := range matched {
+			if key, _ := t.pageKey(item); key == startKey {
+				start = i + 1
+				break
+			}
+		}
+	}
+	matched = matched[start:]
+
+	page := &readPage{}
+	if in.limit != nil && int(*in.limit) < len(matched) {
+		matched = matched[:*in.limit]
+		page.lastKey = t.key(matched[len(matched)-1])
+	}
+	page.scanned = len(matched)
+
+	for _, item := range matched {
+		if filter != nil {
+			ok, err := filter.eval(item)
+			if err != nil {
+				return nil, validationError(err)
+			}
+			if !ok {
+				continue
+			}
+		}
+		page.items = append(page.items, project(item, projection))
+	}
+
+	return page, nil
+}
+
+// pageKey encodes the key identifying the item position in a page. Index keys
+// are not unique, so the table primary key is added for the index view.
+func (t *table) pageKey(item attrs) (string, error) {
+	key, err := t.encodeKey(item)
+	if err != nil || t.base == nil {
+		return key, err
+	}
+
+	baseKey, err := t.base.encodeKey(item)
+	if err != nil {
+		return "", err
+	}
+	return key + "\x01" + baseKey, nil
+}
+
+// sort orders items by the hash key and then by the range key. Index view
+// items with the same index key are ordered by the table primary key.
+func (t *table) sort(items []attrs, backward bool) {
+	sort.Slice(items, func(i, j int) bool {
+		if backward {
+			return t.less(items[j], items[i])
+		}
+		return t.less(items[i], items[j])
+	})
+}
+
+func (t *table) less(a, b attrs) bool {
+	if ha, hb := a[t.hashKey], b[t.hashKey]; !equalValues(ha, hb) {
+		return compareValues("<", ha, hb)
+	}
+	if ra, rb := a[t.rangeKey], b[t.rangeKey]; t.rangeKey != "" && !equalValues(ra, rb) {
+		return compareValues("<", ra, rb)
+	}
+	if t.base == nil {
+		return false
+	}
+	return t.base.less(a, b)
+}
+
+// key returns the primary key attributes of the item. Keys of the index view
+// items include the table primary key as well.
+func (t *table) key(item attrs) attrs {
+	key := attrs{t.hashKey: copyValue(item[t.hashKey])}
+	if t.rangeKey != "" {
+		key[t.rangeKey] = copyValue(item[t.rangeKey])
+	}
+	if t.base != nil {
+		for k, v := range t.base.key(item) {
+			key[k] = v
+		}
+	}
+	return key
+}
+
+// project returns a copy of the item with only the projected attributes. All
+// attributes copied when projection is empty.
+func project(item attrs, projection []string) attrs {
+	if len(projection) == 0 {
+		return copyAttrs(item)
+	}
+
+	projected := make(attrs, len(projection))
+	for _, name := range projection {
+		if v, ok := item[name]; ok {
+			projected[name] = copyValue(v)
+		}
+	}
+	return projected
+}
+
+// checkCondition evaluates the condition expression against the stored item.
+// It returns ConditionalCheckFailedException when condition not satisfied.
+func checkCondition(expr *string, names map[string]*string, values, item attrs) error {
+	cond, err := parseCondition(expr, names, values)
+	if err != nil {
+		return validationError(err)
+	}
+	if cond == nil {
+		return nil
+	}
+
+	if item == nil {
+		item = attrs{}
+	}
+	ok, err := cond.eval(item)
+	if err != nil {
+		return validationError(err)
+	}
+	if !ok {
+		return awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+	}
+	return nil
+}
+
+func isConditionalCheckError(err error) bool {
+	aerr, ok := err.(awserr.Error) // nolint:errorlint
+	return ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+}
+
+func validationError(err error) error {
+	return awserr.New(errCodeValidation, err.Error(), nil)
+}
+
+type DynamoDBOption interface {
+	apply(*DynamoDB)
+}
+
+type funcDynamoDBOption struct {
+	f func(*DynamoDB)
+}
+
+func (fdo *funcDynamoDBOption) apply(db *DynamoDB) {
+	fdo.f(db)
+}
+
+func newFuncDynamoDBOption(f func(*DynamoDB)) DynamoDBOption {
+	return &funcDynamoDBOption{f: f}
+}
+
+// WithTable creates a table with the provided primary key attributes. The
+// range key is empty for the table with the simple primary key.
+func WithTable(name, hashKey, rangeKey string) DynamoDBOption {
+	return newFuncDynamoDBOption(func(db *DynamoDB) {
+		db.tables[name] = &table{
+			hashKey:  hashKey,
+			rangeKey: rangeKey,
+			items:    make(map[string]attrs),
+			indexes:  make(map[string]*table),
+		}
+	})
+}
+
+// WithIndex adds the global secondary index with the provided key attributes
+// to the table created by WithTable. Only items having the index key
+// attributes are queried through the index.
+func WithIndex(tableName, index, hashKey, rangeKey string) DynamoDBOption {
+	return newFuncDynamoDBOption(func(db *DynamoDB) {
+		db.tables[tableName].indexes[index] = &table{
+			hashKey:  hashKey,
+			rangeKey: rangeKey,
+		}
+	})
+}
@@ -0,0 +1,1077 @@
+package fakes
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// This file implements parsing and evaluation of DynamoDB condition, key
+// condition, projection and update expressions. Only the top level attribute
+// paths supported.
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokName  // #name placeholder
+	tokValue // :value placeholder
+	tokOp    // comparators and arithmetic operators
+	tokLParen
+	tokRParen
+	tokComma
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+func tokenize(expr string) ([]token, error) {
+	var tokens []token
+	rs := []rune(expr)
+
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, token{kind: tokLParen, text: "("})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{kind: tokRParen, text: ")"})
+			i++
+		case r == ',':
+			tokens = append(tokens, token{kind: tokComma, text: ","})
+			i++
+		case r == '=' || r == '+' || r == '-':
+			tokens = append(tokens, token{kind: tokOp, text: string(r)})
+			i++
+		case r == '<' || r == '>':
+			op := string(r)
+			if i+1 < len(rs) && (rs[i+1] == '=' || (r == '<' && rs[i+1] == '>')) {
+				op += string(rs[i+1])
+			}
+			tokens = append(tokens, token{kind: tokOp, text: op})
+			i += len(op)
+		case r == '#' || r == ':' || isIdentRune(r):
+			j := i + 1
+			for j < len(rs) && isIdentRune(rs[j]) {
+				j++
+			}
+			kind := tokIdent
+			if r == '#' {
+				kind = tokName
+			} else if r == ':' {
+				kind = tokValue
+			}
+			tokens = append(tokens, token{kind: kind, text: string(rs[i:j])})
+			i = j
+		default:
+			return nil, fmt.Errorf("invalid expression: unexpected character %q", r)
+		}
+	}
+
+	return append(tokens, token{kind: tokEOF}), nil
+}
+
+func isIdentRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// attrs is an item representation: attribute name to value.
+type attrs = map[string]*dynamodb.AttributeValue
+
+type operand interface {
+	// value returns nil when operand refers a missing attribute.
+	value(item attrs) (*dynamodb.AttributeValue, error)
+}
+
+type pathOperand struct{ name string }
+
+func (p pathOperand) value(item attrs) (*dynamodb.AttributeValue, error) {
+	return item[p.name], nil
+}
+
+type valueOperand struct{ av *dynamodb.AttributeValue }
+
+func (v valueOperand) value(attrs) (*dynamodb.AttributeValue, error) {
+	return v.av, nil
+}
+
+type sizeOperand struct{ path pathOperand }
+
+func (s sizeOperand) value(item attrs) (*dynamodb.AttributeValue, error) {
+	av := item[s.path.name]
+	if av == nil {
+		return nil, nil
+	}
+
+	var n int
+	switch {
+	case av.S != nil:
+		n = len(*av.S)
+	case av.B != nil:
+		n = len(av.B)
+	case av.SS != nil:
+		n = len(av.SS)
+	case av.NS != nil:
+		n = len(av.NS)
+	case av.BS != nil:
+		n = len(av.BS)
+	case av.L != nil:
+		n = len(av.L)
+	case av.M != nil:
+		n = len(av.M)
+	default:
+		return nil, fmt.Errorf("invalid expression: size() of unsupported attribute type %s", av)
+	}
+	return numberValue(float64(n)), nil
+}
+
+// arithOperand is an operand of SET action: a value, a sum or a difference of
+// two values, if_not_exists() or list_append().
+type arithOperand struct {
+	op          string
+	left, right operand
+}
+
+func (a arithOperand) value(item attrs) (*dynamodb.AttributeValue, error) {
+	l, err := a.left.value(item)
+	if err != nil {
+		return nil, err
+	}
+	r, err := a.right.value(item)
+	if err != nil {
+		return nil, err
+	}
+	if l == nil || r == nil || l.N == nil || r.N == nil {
+		return nil, fmt.Errorf("invalid update expression: %q operands should be existing numbers", a.op)
+	}
+
+	lf, rf := parseNumber(*l.N), parseNumber(*r.N)
+	if a.op == "-" {
+		return numberValue(lf - rf), nil
+	}
+	return numberValue(lf + rf), nil
+}
+
+type ifNotExistsOperand struct {
+	path pathOperand
+	def  operand
+}
+
+func (o ifNotExistsOperand) value(item attrs) (*dynamodb.AttributeValue, error) {
+	if av := item[o.path.name]; av != nil {
+		return av, nil
+	}
+	return o.def.value(item)
+}
+
+type listAppendOperand struct{ left, right operand }
+
+func (o listAppendOperand) value(item attrs) (*dynamodb.AttributeValue, error) {
+	l, err := o.left.value(item)
+	if err != nil {
+		return nil, err
+	}
+	r, err := o.right.value(item)
+	if err != nil {
+		return nil, err
+	}
+	if l == nil || r == nil || l.L == nil || r.L == nil {
+		return nil, fmt.Errorf("invalid update expression: list_append() operands should be lists")
+	}
+
+	list := make([]*dynamodb.AttributeValue, 0, len(l.L)+len(r.L))
+	list = append(list, l.L...)
+	list = append(list, r.L...)
+	return &dynamodb.AttributeValue{L: list}, nil
+}
+
+type condition interface {
+	eval(item attrs) (bool, error)
+}
+
+type andCond struct{ left, right condition }
+
+func (c andCond) eval(item attrs) (bool, error) {
+	ok, err := c.left.eval(item)
+	if err != nil || !ok {
+		return false, err
+	}
+	return c.right.eval(item)
+}
+
+type orCond struct{ left, right condition }
+
+func (c orCond) eval(item attrs) (bool, error) {
+	ok, err := c.left.eval(item)
+	if err != nil || ok {
+		return ok, err
+	}
+	return c.right.eval(item)
+}
+
+type notCond struct{ cond condition }
+
+func (c notCond) eval(item attrs) (bool, error) {
+	ok, err := c.cond.eval(item)
+	return !ok, err
+}
+
+type compareCond struct {
+	op          string
+	left, right operand
+}
+
+func (c compareCond) eval(item attrs) (bool, error) {
+	l, err := c.left.value(item)
+	if err != nil {
+		return false, err
+	}
+	r, err := c.right.value(item)
+	if err != nil {
+		return false, err
+	}
+	return compareValues(c.op, l, r), nil
+}
+
+type betweenCond struct{ x, lo, hi operand }
+
+func (c betweenCond) eval(item attrs) (bool, error) {
+	x, err := c.x.value(item)
+	if err != nil {
+		return false, err
+	}
+	lo, err := c.lo.value(item)
+	if err != nil {
+		return false, err
+	}
+	hi, err := c.hi.value(item)
+	if err != nil {
+		return false, err
+	}
+	return compareValues(">=", x, lo) && compareValues("<=", x, hi), nil
+}
+
+type inCond struct {
+	x    operand
+	list []operand
+}
+
+func (c inCond) eval(item attrs) (bool, error) {
+	x, err := c.x.value(item)
+	if err != nil {
+		return false, err
+	}
+	for _, o := range c.list {
+		v, err := o.value(item)
+		if err != nil {
+			return false, err
+		}
+		if compareValues("=", x, v) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+type funcCond struct {
+	name string
+	args []operand
+}
+
+func (c funcCond) eval(item attrs) (bool, error) {
+	values := make([]*dynamodb.AttributeValue, 0, len(c.args))
+	for _, arg := range c.args {
+		v, err := arg.value(item)
+		if err != nil {
+			return false, err
+		}
+		values = append(values, v)
+	}
+
+	switch c.name {
+	case "attribute_exists":
+		return values[0] != nil, nil
+	case "attribute_not_exists":
+		return values[0] == nil, nil
+	case "attribute_type":
+		return values[0] != nil && values[1] != nil && values[1].S != nil &&
+			attributeType(values[0]) == *values[1].S, nil
+	case "begins_with":
+		v, prefix := values[0], values[1]
+		if v == nil || prefix == nil {
+			return false, nil
+		}
+		if v.S != nil && prefix.S != nil {
+			return strings.HasPrefix(*v.S, *prefix.S), nil
+		}
+		if v.B != nil && prefix.B != nil {
+			return strings.HasPrefix(string(v.B), string(prefix.B)), nil
+		}
+		return false, nil
+	case "contains":
+		return containsValue(values[0], values[1]), nil
+	}
+
+	return false, fmt.Errorf("invalid expression: unknown function %q", c.name)
+}
+
+// condFuncArity lists supported condition functions and number of arguments.
+var condFuncArity = map[string]int{
+	"attribute_exists":     1,
+	"attribute_not_exists": 1,
+	"attribute_type":       2,
+	"begins_with":          2,
+	"contains":             2,
+}
+
+type updateAction struct {
+	kind string // SET, REMOVE, ADD or DELETE
+	path pathOperand
+	val  operand
+}
+
+// exprParser parses expression tokens. Attribute names and values placeholders
+// resolved during parsing.
+type exprParser struct {
+	tokens []token
+	pos    int
+	names  map[string]*string
+	values attrs
+}
+
+func newExprParser(expr string, names map[string]*string, values attrs) (*exprParser, error) {
+	tokens, err := tokenize(expr)
+	if err != nil {
+		return nil, err
+	}
+	return &exprParser{tokens: tokens, names: names, values: values}, nil
+}
+
+func (p *exprParser) peek() token { return p.tokens[p.pos] }
+
+func (p *exprParser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *exprParser) isKeyword(kw string) bool {
+	t := p.peek()
+	return t.kind == tokIdent && strings.EqualFold(t.text, kw)
+}
+
+func (p *exprParser) expect(kind tokenKind, text string) error {
+	t := p.next()
+	if t.kind != kind {
+		return fmt.Errorf("invalid expression: expected %q, got %q", text, t.text)
+	}
+	return nil
+}
+
+func (p *exprParser) expectEOF() error {
+	if t := p.peek(); t.kind != tokEOF {
+		return fmt.Errorf("invalid expression: unexpected token %q", t.text)
+	}
+	return nil
+}
+
+func (p *exprParser) parseCondition() (condition, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("OR") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orCond{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseAnd() (condition, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("AND") {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = andCond{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseNot() (condition, error) {
+	if p.isKeyword("NOT") {
+		p.next()
+		c, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notCond{cond: c}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *exprParser) parsePrimary() (condition, error) {
+	if p.peek().kind == tokLParen {
+		p.next()
+		c, err := p.parseCondition()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(tokRParen, ")"); err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+
+	if t := p.peek(); t.kind == tokIdent {
+		if arity, ok := condFuncArity[t.text]; ok {
+			return p.parseFuncCond(t.text, arity)
+		}
+	}
+
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	switch t := p.next(); {
+	case t.kind == tokOp && t.text != "+" && t.text != "-":
+		right, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return compareCond{op: t.text, left: left, right: right}, nil
+	case t.kind == tokIdent && strings.EqualFold(t.text, "BETWEEN"):
+		lo, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		if !p.isKeyword("AND") {
+			return nil, fmt.Errorf("invalid expression: BETWEEN without AND")
+		}
+		p.next()
+		hi, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return betweenCond{x: left, lo: lo, hi: hi}, nil
+	case t.kind == tokIdent && strings.EqualFold(t.text, "IN"):
+		list, err := p.parseOperandList()
+		if err != nil {
+			return nil, err
+		}
+		return inCond{x: left, list: list}, nil
+	default:
+		return nil, fmt.Errorf("invalid expression: unexpected token %q", t.text)
+	}
+}
+
+func (p *exprParser) parseFuncCond(name string, arity int) (condition, error) {
+	p.next()
+	args, err := p.parseOperandList()
+	if err != nil {
+		return nil, err
+	}
+	if len(args) != arity {
+		return nil, fmt.Errorf("invalid expression: %s() expects %d argument(s), got %d", name, arity, len(args))
+	}
+	return funcCond{name: name, args: args}, nil
+}
+
+// parseOperandList parses a parenthesized comma separated list of operands.
+func (p *exprParser) parseOperandList() ([]operand, error) {
+	if err := p.expect(tokLParen, "("); err != nil {
+		return nil, err
+	}
+
+	var list []operand
+	for {
+		o, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, o)
+
+		t := p.next()
+		if t.kind == tokRParen {
+			return list, nil
+		}
+		if t.kind != tokComma {
+			return nil, fmt.Errorf("invalid expression: expected \",\" or \")\", got %q", t.text)
+		}
+	}
+}
+
+func (p *exprParser) parseOperand() (operand, error) {
+	t := p.peek()
+	if t.kind == tokIdent && t.text == "size" {
+		p.next()
+		args, err := p.parseOperandList()
+		if err != nil {
+			return nil, err
+		}
+		path, ok := args[0].(pathOperand)
+		if len(args) != 1 || !ok {
+			return nil, fmt.Errorf("invalid expression: size() expects an attribute path")
+		}
+		return sizeOperand{path: path}, nil
+	}
+
+	if t.kind == tokValue {
+		p.next()
+		av, ok := p.values[t.text]
+		if !ok {
+			return nil, fmt.Errorf("invalid expression: undefined attribute value %s", t.text)
+		}
+		return valueOperand{av: av}, nil
+	}
+
+	return p.parsePath()
+}
+
+func (p *exprParser) parsePath() (pathOperand, error) {
+	t := p.next()
+	switch t.kind {
+	case tokName:
+		name, ok := p.names[t.text]
+		if !ok || name == nil {
+			return pathOperand{}, fmt.Errorf("invalid expression: undefined attribute name %s", t.text)
+		}
+		return pathOperand{name: *name}, nil
+	case tokIdent:
+		return pathOperand{name: t.text}, nil
+	default:
+		return pathOperand{}, fmt.Errorf("invalid expression: expected attribute path, got %q", t.text)
+	}
+}
+
+func (p *exprParser) parseProjection() ([]string, error) {
+	var names []string
+	for {
+		path, err := p.parsePath()
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, path.name)
+
+		if p.peek().kind != tokComma {
+			return names, p.expectEOF()
+		}
+		p.next()
+	}
+}
+
+func (p *exprParser) parseUpdate() ([]updateAction, error) {
+	var actions []updateAction
+	for p.peek().kind != tokEOF {
+		clause := p.next()
+		if clause.kind != tokIdent {
+			return nil, fmt.Errorf("invalid update expression: unexpected token %q", clause.text)
+		}
+
+		kind := strings.ToUpper(clause.text)
+		for {
+			action, err := p.parseUpdateAction(kind)
+			if err != nil {
+				return nil, err
+			}
+			actions = append(actions, action)
+
+			if p.peek().kind != tokComma {
+				break
+			}
+			p.next()
+		}
+	}
+	return actions, nil
+}
+
+func (p *exprParser) parseUpdateAction(kind string) (updateAction, error) {
+	path, err := p.parsePath()
+	if err != nil {
+		return updateAction{}, err
+	}
+
+	switch kind {
+	case "REMOVE":
+		return updateAction{kind: kind, path: path}, nil
+	case "SET":
+		if err := p.expect(tokOp, "="); err != nil {
+			return updateAction{}, err
+		}
+		val, err := p.parseSetValue()
+		if err != nil {
+			return updateAction{}, err
+		}
+		return updateAction{kind: kind, path: path, val: val}, nil
+	case "ADD", "DELETE":
+		val, err := p.parseOperand()
+		if err != nil {
+			return updateAction{}, err
+		}
+		return updateAction{kind: kind, path: path, val: val}, nil
+	}
+
+	return updateAction{}, fmt.Errorf("invalid update expression: unknown clause %q", kind)
+}
+
+func (p *exprParser) parseSetValue() (operand, error) {
+	left, err := p.parseSetTerm()
+	if err != nil {
+		return nil, err
+	}
+
+	if t := p.peek(); t.kind == tokOp && (t.text == "+" || t.text == "-") {
+		p.next()
+		right, err := p.parseSetTerm()
+		if err != nil {
+			return nil, err
+		}
+		return arithOperand{op: t.text, left: left, right: right}, nil
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseSetTerm() (operand, error) {
+	t := p.peek()
+	if t.kind != tokIdent || (t.text != "if_not_exists" && t.text != "list_append") {
+		return p.parseOperand()
+	}
+
+	p.next()
+	args, err := p.parseOperandList()
+	if err != nil {
+		return nil, err
+	}
+	if len(args) != 2 { // nolint:gomnd
+		return nil, fmt.Errorf("invalid update expression: %s() expects 2 arguments", t.text)
+	}
+
+	if t.text == "list_append" {
+		return listAppendOperand{left: args[0], right: args[1]}, nil
+	}
+
+	path, ok := args[0].(pathOperand)
+	if !ok {
+		return nil, fmt.Errorf("invalid update expression: if_not_exists() expects an attribute path")
+	}
+	return ifNotExistsOperand{path: path, def: args[1]}, nil
+}
+
+func parseCondition(expr *string, names map[string]*string, values attrs) (condition, error) {
+	if expr == nil || *expr == "" {
+		return nil, nil
+	}
+
+	p, err := newExprParser(*expr, names, values)
+	if err != nil {
+		return nil, err
+	}
+	c, err := p.parseCondition()
+	if err != nil {
+		return nil, err
+	}
+	return c, p.expectEOF()
+}
+
+func parseProjection(expr *string, names map[string]*string) ([]string, error) {
+	if expr == nil || *expr == "" {
+		return nil, nil
+	}
+
+	p, err := newExprParser(*expr, names, nil)
+	if err != nil {
+		return nil, err
+	}
+	return p.parseProjection()
+}
+
+func parseUpdate(expr *string, names map[string]*string, values attrs) ([]updateAction, error) {
+	if expr == nil || *expr == "" {
+		return nil, nil
+	}
+
+	p, err := newExprParser(*expr, names, values)
+	if err != nil {
+		return nil, err
+	}
+	return p.parseUpdate()
+}
+
+// applyUpdate applies update actions to the item. Operands evaluated against
+// the item state before update.
+func applyUpdate(item attrs, actions []updateAction) (attrs, error) {
+	updated := copyAttrs(item)
+	for _, a := range actions {
+		switch a.kind {
+		case "REMOVE":
+			delete(updated, a.path.name)
+		case "SET":
+			v, err := a.val.value(item)
+			if err != nil {
+				return nil, err
+			}
+			if v == nil {
+				return nil, fmt.Errorf("invalid update expression: SET %s to a missing attribute", a.path.name)
+			}
+			updated[a.path.name] = copyValue(v)
+		case "ADD":
+			v, err := addValue(item[a.path.name], a.val, item)
+			if err != nil {
+				return nil, err
+			}
+			updated[a.path.name] = v
+		case "DELETE":
+			v, err := deleteValue(item[a.path.name], a.val, item)
+			if err != nil {
+				return nil, err
+			}
+			if v == nil {
+				delete(updated, a.path.name)
+			} else {
+				updated[a.path.name] = v
+			}
+		}
+	}
+	return updated, nil
+}
+
+func addValue(cur *dynamodb.AttributeValue, o operand, item attrs) (*dynamodb.AttributeValue, error) {
+	v, err := o.value(item)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case v.N != nil:
+		if cur == nil {
+			return copyValue(v), nil
+		}
+		if cur.N == nil {
+			return nil, fmt.Errorf("invalid update expression: ADD number to non number attribute")
+		}
+		return numberValue(parseNumber(*cur.N) + parseNumber(*v.N)), nil
+	case v.SS != nil:
+		if cur == nil {
+			return copyValue(v), nil
+		}
+		return &dynamodb.AttributeValue{SS: unionStrings(cur.SS, v.SS)}, nil
+	case v.NS != nil:
+		if cur == nil {
+			return copyValue(v), nil
+		}
+		return &dynamodb.AttributeValue{NS: unionStrings(cur.NS, v.NS)}, nil
+	}
+
+	return nil, fmt.Errorf("invalid update expression: ADD supports numbers and sets only")
+}
+
+func deleteValue(cur *dynamodb.AttributeValue, o operand, item attrs) (*dynamodb.AttributeValue, error) {
+	v, err := o.value(item)
+	if err != nil {
+		return nil, err
+	}
+	if cur == nil {
+		return nil, nil
+	}
+
+	var rest []*string
+	switch {
+	case v.SS != nil && cur.SS != nil:
+		rest = subtractStrings(cur.SS, v.SS)
+		if len(rest) > 0 {
+			return &dynamodb.AttributeValue{SS: rest}, nil
+		}
+	case v.NS != nil && cur.NS != nil:
+		rest = subtractStrings(cur.NS, v.NS)
+		if len(rest) > 0 {
+			return &dynamodb.AttributeValue{NS: rest}, nil
+		}
+	default:
+		return nil, fmt.Errorf("invalid update expression: DELETE supports sets only")
+	}
+	return nil, nil
+}
+
+func unionStrings(a, b []*string) []*string {
+	seen := make(map[string]struct{}, len(a))
+	out := make([]*string, 0, len(a)+len(b))
+	for _, s := range append(append([]*string{}, a...), b...) {
+		if _, ok := seen[*s]; ok {
+			continue
+		}
+		seen[*s] = struct{}{}
+		v := *s
+		out = append(out, &v)
+	}
+	return out
+}
+
+func subtractStrings(a, b []*string) []*string {
+	drop := make(map[string]struct{}, len(b))
+	for _, s := range b {
+		drop[*s] = struct{}{}
+	}
+	var out []*string
+	for _, s := range a {
+		if _, ok := drop[*s]; !ok {
+			v := *s
+			out = append(out, &v)
+		}
+	}
+	return out
+}
+
+// compareValues compares attribute values. Missing attributes only satisfy
+// the "<>" comparison. Ordering comparisons supported for strings, numbers and
+// binaries of the same type.
+func compareValues(op string, l, r *dynamodb.AttributeValue) bool {
+	if l == nil || r == nil {
+		return op == "<>"
+	}
+
+	switch op {
+	case "=":
+		return equalValues(l, r)
+	case "<>":
+		return !equalValues(l, r)
+	}
+
+	var c int
+	switch {
+	case l.S != nil && r.S != nil:
+		c = strings.Compare(*l.S, *r.S)
+	case l.N != nil && r.N != nil:
+		lf, rf := parseNumber(*l.N), parseNumber(*r.N)
+		switch {
+		case lf < rf:
+			c = -1
+		case lf > rf:
+			c = 1
+		}
+	case l.B != nil && r.B != nil:
+		c = strings.Compare(string(l.B), string(r.B))
+	default:
+		return false
+	}
+
+	switch op {
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	}
+	return false
+}
+
+func equalValues(l, r *dynamodb.AttributeValue) bool {
+	if l == nil || r == nil {
+		return l == r
+	}
+
+	switch {
+	case l.S != nil:
+		return r.S != nil && *l.S == *r.S
+	case l.N != nil:
+		return r.N != nil && parseNumber(*l.N) == parseNumber(*r.N)
+	case l.B != nil:
+		return r.B != nil && string(l.B) == string(r.B)
+	case l.BOOL != nil:
+		return r.BOOL != nil && *l.BOOL == *r.BOOL
+	case l.NULL != nil:
+		return r.NULL != nil && *l.NULL == *r.NULL
+	case l.SS != nil:
+		return r.SS != nil && equalStringSets(l.SS, r.SS)
+	case l.NS != nil:
+		return r.NS != nil && equalStringSets(l.NS, r.NS)
+	case l.BS != nil:
+		return r.BS != nil && equalBinarySets(l.BS, r.BS)
+	case l.L != nil:
+		if r.L == nil || len(l.L) != len(r.L) {
+			return false
+		}
+		for i := range l.L {
+			if !equalValues(l.L[i], r.L[i]) {
+				return false
+			}
+		}
+		return true
+	case l.M != nil:
+		if r.M == nil || len(l.M) != len(r.M) {
+			return false
+		}
+		for k, v := range l.M {
+			if !equalValues(v, r.M[k]) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func equalStringSets(a, b []*string) bool {
+	return len(a) == len(b) && len(subtractStrings(a, b)) == 0
+}
+
+func equalBinarySets(a, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	set := make(map[string]struct{}, len(a))
+	for _, v := range a {
+		set[string(v)] = struct{}{}
+	}
+	for _, v := range b {
+		if _, ok := set[string(v)]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func containsValue(v, x *dynamodb.AttributeValue) bool {
+	if v == nil || x == nil {
+		return false
+	}
+
+	switch {
+	case v.S != nil && x.S != nil:
+		return strings.Contains(*v.S, *x.S)
+	case v.SS != nil && x.S != nil:
+		return len(subtractStrings([]*string{x.S}, v.SS)) == 0
+	case v.NS != nil && x.N != nil:
+		for _, n := range v.NS {
+			if parseNumber(*n) == parseNumber(*x.N) {
+				return true
+			}
+		}
+	case v.L != nil:
+		for _, e := range v.L {
+			if equalValues(e, x) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func attributeType(v *dynamodb.AttributeValue) string {
+	switch {
+	case v.S != nil:
+		return "S"
+	case v.N != nil:
+		return "N"
+	case v.B != nil:
+		return "B"
+	case v.BOOL != nil:
+		return "BOOL"
+	case v.NULL != nil:
+		return "NULL"
+	case v.SS != nil:
+		return "SS"
+	case v.NS != nil:
+		return "NS"
+	case v.BS != nil:
+		return "BS"
+	case v.L != nil:
+		return "L"
+	case v.M != nil:
+		return "M"
+	}
+	return ""
+}
+
+func parseNumber(s string) float64 {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0
+	}
+	return f
+}
+
+func numberValue(f float64) *dynamodb.AttributeValue {
+	s := strconv.FormatFloat(f, 'f', -1, 64)
+	return &dynamodb.AttributeValue{N: &s}
+}
+
+func copyAttrs(item attrs) attrs {
+	if item == nil {
+		return attrs{}
+	}
+	c := make(attrs, len(item))
+	for k, v := range item {
+		c[k] = copyValue(v)
+	}
+	return c
+}
+
+func copyValue(v *dynamodb.AttributeValue) *dynamodb.AttributeValue {
+	if v == nil {
+		return nil
+	}
+
+	c := &dynamodb.AttributeValue{}
+	if v.S != nil {
+		s := *v.S
+		c.S = &s
+	}
+	if v.N != nil {
+		n := *v.N
+		c.N = &n
+	}
+	if v.B != nil {
+		c.B = append([]byte{}, v.B...)
+	}
+	if v.BOOL != nil {
+		b := *v.BOOL
+		c.BOOL = &b
+	}
+	if v.NULL != nil {
+		n := *v.NULL
+		c.NULL = &n
+	}
+	if v.SS != nil {
+		c.SS = unionStrings(nil, v.SS)
+	}
+	if v.NS != nil {
+		c.NS = unionStrings(nil, v.NS)
+	}
+	for _, b := range v.BS {
+		c.BS = append(c.BS, append([]byte{}, b...))
+	}
+	if v.L != nil {
+		c.L = make([]*dynamodb.AttributeValue, 0, len(v.L))
+		for _, e := range v.L {
+			c.L = append(c.L, copyValue(e))
+		}
+	}
+	if v.M != nil {
+		c.M = copyAttrs(v.M)
+	}
+	return c
+}
@@ -0,0 +1,168 @@
+package fakes
+
+import (
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+)
+
+// SMTPMessage is the message received by the SMTP server.
+type SMTPMessage struct {
+	From string
+	To   []string
+	Data string // message with CRLF line endings and dot-stuffing removed
+}
+
+// SMTPServer is the in-process SMTP server that keeps received messages in
+// memory. It supports the commands required to send mail without
+// authentication.
+type SMTPServer struct {
+	ln net.Listener
+	wg sync.WaitGroup
+
+	mu       sync.Mutex
+	messages []SMTPMessage
+	reject   int
+}
+
+// NewSMTPServer starts the server on a random local port.
+func NewSMTPServer() (*SMTPServer, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &SMTPServer{ln: ln}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// Addr returns the host:port the server listens on.
+func (s *SMTPServer) Addr() string {
+	return s.ln.Addr().String()
+}
+
+// Messages returns the received messages.
+func (s *SMTPServer) Messages() []SMTPMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]SMTPMessage(nil), s.messages...)
+}
+
+// Reject makes the server reject the next n messages with a transient error.
+func (s *SMTPServer) Reject(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reject = n
+}
+
+// Close stops the server and waits for open connections to be closed.
+func (s *SMTPServer) Close() error {
+	err := s.ln.Close()
+	s.wg.Wait()
+	return err
+}
+
+func (s *SMTPServer) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			s.handle(textproto.NewConn(conn))
+		}()
+	}
+}
+
+func (s *SMTPServer) handle(c *textproto.Conn) {
+	reply := func(code int, msg string) bool {
+		return c.PrintfLine("%d %s", code, msg) == nil
+	}
+	if !reply(220, "localhost fake ESMTP") {
+		return
+	}
+
+	var msg SMTPMessage
+	for {
+		line, err := c.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg := line, ""
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			verb, arg = line[:i], line[i+1:]
+		}
+
+		ok := true
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			ok = c.PrintfLine("250-localhost") == nil && reply(250, "8BITMIME")
+		case "HELO", "NOOP":
+			ok = reply(250, "OK")
+		case "RSET":
+			msg = SMTPMessage{}
+			ok = reply(250, "OK")
+		case "MAIL":
+			msg = SMTPMessage{From: address(arg, "FROM:")}
+			ok = reply(250, "OK")
+		case "RCPT":
+			msg.To = append(msg.To, address(arg, "TO:"))
+			ok = reply(250, "OK")
+		case "DATA":
+			if len(msg.To) == 0 {
+				ok = reply(503, "no recipients")
+				break
+			}
+			if !reply(354, "end data with <CR><LF>.<CR><LF>") {
+				return
+			}
+			data, err := c.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			msg.Data = strings.ReplaceAll(string(data), "\n", "\r\n")
+			ok = s.receive(msg, reply)
+			msg = SMTPMessage{}
+		case "QUIT":
+			reply(221, "bye")
+			return
+		default:
+			ok = reply(502, "command not implemented")
+		}
+		if !ok {
+			return
+		}
+	}
+}
+
+// receive keeps the message unless it should be rejected.
+func (s *SMTPServer) receive(msg SMTPMessage, reply func(int, string) bool) bool {
+	s.mu.Lock()
+	if s.reject > 0 {
+		s.reject--
+		s.mu.Unlock()
+		return reply(451, "try again later")
+	}
+	s.messages = append(s.messages, msg)
+	s.mu.Unlock()
+	return reply(250, "OK")
+}
+
+// address returns the address of the MAIL or RCPT argument, like
+// "FROM:<a@example.com> BODY=8BITMIME".
+func address(arg, prefix string) string {
+	if len(arg) >= len(prefix) && strings.EqualFold(arg[:len(prefix)], prefix) {
+		arg = arg[len(prefix):]
+	}
+	if i := strings.IndexByte(arg, ' '); i >= 0 {
+		arg = arg[:i]
+	}
+	return strings.Trim(arg, "<>")
+}
@@ -4,19 +4,25 @@ import (
 	"sync"
 
 	"github.com/antklim/go-invoice/storage/dynamo"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 )
 
 type dynamoOp int
 
 const (
-	getItem dynamoOp = iota
-	putItem
+	query dynamoOp = iota
+	scan
+	transactWriteItems
+	updateItem
 )
 
 var dynamoOps = map[string]dynamoOp{
-	"GetItem": getItem,
-	"PutItem": putItem,
+	"Query":              query,
+	"Scan":               scan,
+	"TransactWriteItems": transactWriteItems,
+	"UpdateItem":         updateItem,
 }
 
 func dynamoOpFrom(op string) dynamoOp {
@@ -28,7 +34,8 @@ func dynamoOpFrom(op string) dynamoOp {
 }
 
 type DynamoAPI struct {
-	errors map[dynamoOp]error
+	errors      map[dynamoOp]error
+	queryOutput *dynamodb.QueryOutput
 
 	sync.RWMutex // guards calls
 	callsTimes   map[dynamoOp]int
@@ -51,33 +58,56 @@ func NewDynamoAPI(opts ...DynamoAPIOption) *DynamoAPI {
 
 var _ dynamo.API = (*DynamoAPI)(nil)
 
-func (api *DynamoAPI) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+func (api *DynamoAPI) QueryWithContext(_ aws.Context, input *dynamodb.QueryInput,
+	_ ...request.Option) (*dynamodb.QueryOutput, error) {
 	api.Lock()
 	defer api.Unlock()
-	api.recordGetItemCall(input)
+	api.recordCall(query, input)
 
-	return nil, api.errors[getItem]
+	if err := api.errors[query]; err != nil {
+		return nil, err
+	}
+	return api.queryOutput, nil
+}
+
+func (api *DynamoAPI) ScanWithContext(_ aws.Context, input *dynamodb.ScanInput,
+	_ ...request.Option) (*dynamodb.ScanOutput, error) {
+	api.Lock()
+	defer api.Unlock()
+	api.recordCall(scan, input)
+
+	return nil, api.errors[scan]
+}
+
+func (api *DynamoAPI) TransactWriteItemsWithContext(_ aws.Context, input *dynamodb.TransactWriteItemsInput,
+	_ ...request.Option) (*dynamodb.TransactWriteItemsOutput, error) {
+	api.Lock()
+	defer api.Unlock()
+	api.recordCall(transactWriteItems, input)
+
+	return nil, api.errors[transactWriteItems]
 }
 
-func (api *DynamoAPI) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+func (api *DynamoAPI) UpdateItemWithContext(_ aws.Context, input *dynamodb.UpdateItemInput,
+	_ ...request.Option) (*dynamodb.UpdateItemOutput, error) {
 	api.Lock()
 	defer api.Unlock()
-	api.recordPutItemCall(input)
+	api.recordCall(updateItem, input)
 
-	return nil, api.errors[putItem]
+	return nil, api.errors[updateItem]
 }
 
 // CalledTimes returns amount of times the DynamoDB operation was called. It
 // returns -1 when unknown operation provided.
 func (api *DynamoAPI) CalledTimes(op string) int {
-	api.RLock()
-	times, ok := api.callsTimes[dynamoOpFrom(op)]
-	api.RUnlock()
-
-	if !ok {
+	dop := dynamoOpFrom(op)
+	if dop == -1 {
 		return -1
 	}
-	return times
+
+	api.RLock()
+	defer api.RUnlock()
+	return api.callsTimes[dop]
 }
 
 // NthCall returns input of nth operation to DynamoDB. Counter n starts from 1.
@@ -99,14 +129,9 @@ func (api *DynamoAPI) NthCall(op string, n int) interface{} {
 	return calls[n-1]
 }
 
-func (api *DynamoAPI) recordPutItemCall(input *dynamodb.PutItemInput) {
-	api.callsTimes[putItem]++
-	api.callsArgs[putItem] = append(api.callsArgs[putItem], input)
-}
-
-func (api *DynamoAPI) recordGetItemCall(input *dynamodb.GetItemInput) {
-	api.callsTimes[getItem]++
-	api.callsArgs[getItem] = append(api.callsArgs[getItem], input)
+func (api *DynamoAPI) recordCall(op dynamoOp, input interface{}) {
+	api.callsTimes[op]++
+	api.callsArgs[op] = append(api.callsArgs[op], input)
 }
 
 type DynamoAPIOption interface {
@@ -125,14 +150,33 @@ func newFuncDynamoAPIOption(f func(*DynamoAPI)) DynamoAPIOption {
 	return &funcDynamoAPIOption{f: f}
 }
 
-func WithGetItemError(err error) DynamoAPIOption {
+func WithQueryError(err error) DynamoAPIOption {
+	return newFuncDynamoAPIOption(func(api *DynamoAPI) {
+		api.errors[query] = err
+	})
+}
+
+func WithScanError(err error) DynamoAPIOption {
+	return newFuncDynamoAPIOption(func(api *DynamoAPI) {
+		api.errors[scan] = err
+	})
+}
+
+func WithTransactWriteItemsError(err error) DynamoAPIOption {
+	return newFuncDynamoAPIOption(func(api *DynamoAPI) {
+		api.errors[transactWriteItems] = err
+	})
+}
+
+func WithUpdateItemError(err error) DynamoAPIOption {
 	return newFuncDynamoAPIOption(func(api *DynamoAPI) {
-		api.errors[getItem] = err
+		api.errors[updateItem] = err
 	})
 }
 
-func WithPutItemError(err error) DynamoAPIOption {
+// WithQueryOutput sets the output returned by every Query call.
+func WithQueryOutput(output *dynamodb.QueryOutput) DynamoAPIOption {
 	return newFuncDynamoAPIOption(func(api *DynamoAPI) {
-		api.errors[putItem] = err
+		api.queryOutput = output
 	})
 }
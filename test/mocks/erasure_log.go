@@ -0,0 +1,27 @@
+package mocks
+
+import (
+	"github.com/antklim/go-invoice/invoice"
+)
+
+// ErasureLog records logged erasures in memory.
+type ErasureLog struct {
+	Erasures []invoice.Erasure
+	err      error
+}
+
+// NewErasureLog creates erasure log mock. Logging fails with err when it is
+// not nil.
+func NewErasureLog(err error) *ErasureLog {
+	return &ErasureLog{err: err}
+}
+
+func (l *ErasureLog) LogErasure(e invoice.Erasure) error {
+	if l.err != nil {
+		return l.err
+	}
+	l.Erasures = append(l.Erasures, e)
+	return nil
+}
+
+var _ invoice.ErasureLog = (*ErasureLog)(nil)
@@ -0,0 +1,190 @@
+package mocks
+
+import (
+	"sync"
+	"time"
+
+	"github.com/antklim/go-invoice/storage/dynamo"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// FaultyDynamoAPI wraps DynamoDB API and fails calls with injected errors.
+// Injected errors of the operation returned one per call in the order they
+// were injected. When operation has no injected errors left the call passed to
+// the wrapped API. Injected latencies delay the calls the same way, the
+// delayed call fails as the SDK one when its context is done. Injected lost
+// responses are returned after the call is applied by the wrapped API.
+type FaultyDynamoAPI struct {
+	api dynamo.API
+
+	sync.Mutex // guards faults, latencies, lost and callsTimes
+	faults     map[dynamoOp][]error
+	latencies  map[dynamoOp][]time.Duration
+	lost       map[dynamoOp][]error
+	callsTimes map[dynamoOp]int
+}
+
+func NewFaultyDynamoAPI(api dynamo.API, opts ...FaultyDynamoAPIOption) *FaultyDynamoAPI {
+	fapi := &FaultyDynamoAPI{
+		api:        api,
+		faults:     make(map[dynamoOp][]error),
+		latencies:  make(map[dynamoOp][]time.Duration),
+		lost:       make(map[dynamoOp][]error),
+		callsTimes: make(map[dynamoOp]int),
+	}
+
+	for _, o := range opts {
+		o.apply(fapi)
+	}
+
+	return fapi
+}
+
+var _ dynamo.API = (*FaultyDynamoAPI)(nil)
+
+func (api *FaultyDynamoAPI) QueryWithContext(ctx aws.Context, input *dynamodb.QueryInput,
+	opts ...request.Option) (*dynamodb.QueryOutput, error) {
+	if err := api.fault(ctx, query); err != nil {
+		return nil, err
+	}
+	output, err := api.api.QueryWithContext(ctx, input, opts...)
+	if err == nil {
+		err = api.lostResponse(query)
+	}
+	return output, err
+}
+
+func (api *FaultyDynamoAPI) ScanWithContext(ctx aws.Context, input *dynamodb.ScanInput,
+	opts ...request.Option) (*dynamodb.ScanOutput, error) {
+	if err := api.fault(ctx, scan); err != nil {
+		return nil, err
+	}
+	output, err := api.api.ScanWithContext(ctx, input, opts...)
+	if err == nil {
+		err = api.lostResponse(scan)
+	}
+	return output, err
+}
+
+func (api *FaultyDynamoAPI) TransactWriteItemsWithContext(ctx aws.Context, input *dynamodb.TransactWriteItemsInput,
+	opts ...request.Option) (*dynamodb.TransactWriteItemsOutput, error) {
+	if err := api.fault(ctx, transactWriteItems); err != nil {
+		return nil, err
+	}
+	output, err := api.api.TransactWriteItemsWithContext(ctx, input, opts...)
+	if err == nil {
+		err = api.lostResponse(transactWriteItems)
+	}
+	return output, err
+}
+
+func (api *FaultyDynamoAPI) UpdateItemWithContext(ctx aws.Context, input *dynamodb.UpdateItemInput,
+	opts ...request.Option) (*dynamodb.UpdateItemOutput, error) {
+	if err := api.fault(ctx, updateItem); err != nil {
+		return nil, err
+	}
+	output, err := api.api.UpdateItemWithContext(ctx, input, opts...)
+	if err == nil {
+		err = api.lostResponse(updateItem)
+	}
+	return output, err
+}
+
+// CalledTimes returns amount of times the DynamoDB operation was called,
+// including failed calls. It returns -1 when unknown operation provided.
+func (api *FaultyDynamoAPI) CalledTimes(op string) int {
+	dop := dynamoOpFrom(op)
+	if dop == -1 {
+		return -1
+	}
+
+	api.Lock()
+	defer api.Unlock()
+	return api.callsTimes[dop]
+}
+
+// fault records the operation call, waits the next injected latency of the
+// operation and returns the next injected error of the operation or nil.
+func (api *FaultyDynamoAPI) fault(ctx aws.Context, op dynamoOp) error {
+	api.Lock()
+	api.callsTimes[op]++
+	var latency time.Duration
+	if latencies := api.latencies[op]; len(latencies) > 0 {
+		latency, api.latencies[op] = latencies[0], latencies[1:]
+	}
+	var err error
+	if faults := api.faults[op]; len(faults) > 0 {
+		err, api.faults[op] = faults[0], faults[1:]
+	}
+	api.Unlock()
+
+	if latency > 0 {
+		timer := time.NewTimer(latency)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return awserr.New(request.CanceledErrorCode, "request context canceled", ctx.Err())
+		}
+	}
+	return err
+}
+
+// lostResponse returns the next injected lost response error of the operation
+// or nil.
+func (api *FaultyDynamoAPI) lostResponse(op dynamoOp) error {
+	api.Lock()
+	defer api.Unlock()
+
+	lost := api.lost[op]
+	if len(lost) == 0 {
+		return nil
+	}
+	api.lost[op] = lost[1:]
+	return lost[0]
+}
+
+type FaultyDynamoAPIOption interface {
+	apply(*FaultyDynamoAPI)
+}
+
+type funcFaultyDynamoAPIOption struct {
+	f func(*FaultyDynamoAPI)
+}
+
+func (fdo *funcFaultyDynamoAPIOption) apply(api *FaultyDynamoAPI) {
+	fdo.f(api)
+}
+
+func newFuncFaultyDynamoAPIOption(f func(*FaultyDynamoAPI)) FaultyDynamoAPIOption {
+	return &funcFaultyDynamoAPIOption{f: f}
+}
+
+// WithFaults injects errors returned by the consecutive calls of the
+// operation.
+func WithFaults(op string, errs ...error) FaultyDynamoAPIOption {
+	return newFuncFaultyDynamoAPIOption(func(api *FaultyDynamoAPI) {
+		dop := dynamoOpFrom(op)
+		api.faults[dop] = append(api.faults[dop], errs...)
+	})
+}
+
+// WithLatencies injects delays of the consecutive calls of the operation.
+func WithLatencies(op string, latencies ...time.Duration) FaultyDynamoAPIOption {
+	return newFuncFaultyDynamoAPIOption(func(api *FaultyDynamoAPI) {
+		dop := dynamoOpFrom(op)
+		api.latencies[dop] = append(api.latencies[dop], latencies...)
+	})
+}
+
+// WithLostResponses injects errors returned by the consecutive calls of the
+// operation after the wrapped API applied them, as when the response is lost.
+func WithLostResponses(op string, errs ...error) FaultyDynamoAPIOption {
+	return newFuncFaultyDynamoAPIOption(func(api *FaultyDynamoAPI) {
+		dop := dynamoOpFrom(op)
+		api.lost[dop] = append(api.lost[dop], errs...)
+	})
+}
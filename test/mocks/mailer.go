@@ -0,0 +1,38 @@
+package mocks
+
+import (
+	"sync"
+
+	"github.com/antklim/go-invoice/invoice"
+)
+
+// Mailer records sent emails in memory.
+type Mailer struct {
+	sync.Mutex
+	Emails []invoice.Email
+	err    error
+}
+
+// NewMailer creates mailer mock. Sending fails with err when it is not nil.
+func NewMailer(err error) *Mailer {
+	return &Mailer{err: err}
+}
+
+// Fail sets the error sending fails with, nil makes sending succeed.
+func (m *Mailer) Fail(err error) {
+	m.Lock()
+	defer m.Unlock()
+	m.err = err
+}
+
+func (m *Mailer) Send(e invoice.Email) error {
+	m.Lock()
+	defer m.Unlock()
+	if m.err != nil {
+		return m.err
+	}
+	m.Emails = append(m.Emails, e)
+	return nil
+}
+
+var _ invoice.Mailer = (*Mailer)(nil)
@@ -0,0 +1,31 @@
+package mocks
+
+import (
+	"sync"
+
+	"github.com/antklim/go-invoice/invoice"
+)
+
+// Notifier records sent notices in memory.
+type Notifier struct {
+	sync.Mutex
+	Notices []invoice.Notice
+	err     error
+}
+
+// NewNotifier creates notifier mock. Sending fails with err when it is not nil.
+func NewNotifier(err error) *Notifier {
+	return &Notifier{err: err}
+}
+
+func (n *Notifier) Notify(notice invoice.Notice) error {
+	if n.err != nil {
+		return n.err
+	}
+	n.Lock()
+	defer n.Unlock()
+	n.Notices = append(n.Notices, notice)
+	return nil
+}
+
+var _ invoice.Notifier = (*Notifier)(nil)
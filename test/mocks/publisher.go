@@ -0,0 +1,46 @@
+package mocks
+
+import (
+	"sync"
+
+	"github.com/antklim/go-invoice/invoice"
+)
+
+// Publisher records published events in memory.
+type Publisher struct {
+	sync.Mutex
+	Events []invoice.Event
+	err    error
+}
+
+// NewPublisher creates publisher mock. Publishing fails with err when it is
+// not nil.
+func NewPublisher(err error) *Publisher {
+	return &Publisher{err: err}
+}
+
+func (p *Publisher) Publish(e invoice.Event) error {
+	p.Lock()
+	defer p.Unlock()
+	if p.err != nil {
+		return p.err
+	}
+	p.Events = append(p.Events, e)
+	return nil
+}
+
+// Fail makes publishing fail with err, or succeed when err is nil.
+func (p *Publisher) Fail(err error) {
+	p.Lock()
+	defer p.Unlock()
+	p.err = err
+}
+
+// Reset forgets the published events.
+func (p *Publisher) Reset() {
+	p.Lock()
+	defer p.Unlock()
+	p.Events = nil
+}
+
+var _ invoice.Publisher = (*Publisher)(nil)
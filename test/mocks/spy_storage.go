@@ -0,0 +1,61 @@
+package mocks
+
+import (
+	"sync"
+
+	"github.com/antklim/go-invoice/invoice"
+)
+
+var storageOps = map[string]memoryOp{
+	"AddInvoice":    addInvoice,
+	"FindInvoice":   findInvoice,
+	"UpdateInvoice": updateInvoice,
+}
+
+// SpyStorage is the storage decorator that counts storage calls.
+type SpyStorage struct {
+	strg invoice.Storage
+
+	sync.RWMutex // guards callsTimes
+	callsTimes   map[memoryOp]int
+}
+
+var _ invoice.Storage = (*SpyStorage)(nil)
+
+func NewSpyStorage(strg invoice.Storage) *SpyStorage {
+	return &SpyStorage{strg: strg, callsTimes: make(map[memoryOp]int)}
+}
+
+func (spy *SpyStorage) AddInvoice(inv invoice.Invoice) error {
+	spy.recordCall(addInvoice)
+	return spy.strg.AddInvoice(inv)
+}
+
+func (spy *SpyStorage) FindInvoice(id string) (*invoice.Invoice, error) {
+	spy.recordCall(findInvoice)
+	return spy.strg.FindInvoice(id)
+}
+
+func (spy *SpyStorage) UpdateInvoice(inv invoice.Invoice) error {
+	spy.recordCall(updateInvoice)
+	return spy.strg.UpdateInvoice(inv)
+}
+
+// CalledTimes returns the number of calls of the storage method, or -1 when
+// the method is unknown.
+func (spy *SpyStorage) CalledTimes(op string) int {
+	sop, ok := storageOps[op]
+	if !ok {
+		return -1
+	}
+
+	spy.RLock()
+	defer spy.RUnlock()
+	return spy.callsTimes[sop]
+}
+
+func (spy *SpyStorage) recordCall(op memoryOp) {
+	spy.Lock()
+	defer spy.Unlock()
+	spy.callsTimes[op]++
+}
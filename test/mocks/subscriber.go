@@ -0,0 +1,36 @@
+package mocks
+
+import (
+	"sync"
+
+	"github.com/antklim/go-invoice/invoice"
+)
+
+// Subscriber records the bus events in memory.
+type Subscriber struct {
+	sync.Mutex
+	Events []invoice.BusEvent
+}
+
+// Handle records the event, it is the bus handler.
+func (s *Subscriber) Handle(e invoice.BusEvent) {
+	s.Lock()
+	defer s.Unlock()
+	s.Events = append(s.Events, e)
+}
+
+// EventsOf returns the recorded events of the invoice.
+func (s *Subscriber) EventsOf(id string) []invoice.BusEvent {
+	s.Lock()
+	defer s.Unlock()
+
+	var events []invoice.BusEvent
+	for _, e := range s.Events {
+		if e.InvoiceID() == id {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+var _ invoice.Handler = (*Subscriber)(nil).Handle
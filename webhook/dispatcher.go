@@ -0,0 +1,476 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/antklim/go-invoice/invoice"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// AllEvents is the endpoint events filter of all event types.
+const AllEvents = "*"
+
+const (
+	secretSize    = 32
+	maxReadBodyKB = 64
+)
+
+// Dispatcher delivers invoice events to the registered endpoints. Delivery is
+// at least once: the event is retried until the endpoint responds with 2xx
+// status, so endpoints should skip duplicates by the event ID. Deliveries of
+// the endpoint are not ordered.
+type Dispatcher struct {
+	mu     sync.Mutex
+	state  state
+	sendMu sync.Mutex // one delivery pass at a time
+	opts   options
+
+	started bool
+	stopped bool
+	wake    chan struct{}
+	stop    chan struct{}
+	ctx     context.Context // canceled when requests in flight should return
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+var _ invoice.Publisher = (*Dispatcher)(nil)
+
+// New creates the dispatcher, the endpoints and deliveries are read from the
+// state file when it set.
+func New(opts ...Option) (*Dispatcher, error) {
+	dopts := defaultOptions
+	for _, o := range opts {
+		o.apply(&dopts)
+	}
+
+	var s state
+	if dopts.stateFile != "" {
+		var err error
+		if s, err = loadState(dopts.stateFile); err != nil {
+			return nil, err
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Dispatcher{
+		state:  s,
+		opts:   dopts,
+		wake:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		ctx:    ctx,
+		cancel: cancel,
+	}, nil
+}
+
+// AddEndpoint registers the endpoint receiving the events of the types, see
+// invoice.EventTypes. The endpoint receives all events when no types given or
+// one of them is AllEvents. The random secret is generated when secret is
+// empty.
+func (d *Dispatcher) AddEndpoint(rawURL, secret string, events []string) (Endpoint, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return Endpoint{}, fmt.Errorf("invalid endpoint URL %q", rawURL)
+	}
+	filter, err := parseEvents(events)
+	if err != nil {
+		return Endpoint{}, err
+	}
+	if secret == "" {
+		if secret, err = newSecret(); err != nil {
+			return Endpoint{}, err
+		}
+	}
+
+	ep := Endpoint{
+		ID:        uuid.NewString(),
+		URL:       rawURL,
+		Secret:    secret,
+		Events:    filter,
+		CreatedAt: d.opts.clock.Now().UTC(),
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.state.Endpoints = append(d.state.Endpoints, ep)
+	return ep, d.save()
+}
+
+// RemoveEndpoint removes the endpoint and its pending deliveries. Dead letters
+// of the endpoint are kept.
+func (d *Dispatcher) RemoveEndpoint(id string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	i := d.endpointIndex(id)
+	if i < 0 {
+		return fmt.Errorf("endpoint %q not found", id)
+	}
+	d.state.Endpoints = append(d.state.Endpoints[:i], d.state.Endpoints[i+1:]...)
+
+	pending := d.state.Pending[:0]
+	for _, dl := range d.state.Pending {
+		if dl.EndpointID != id {
+			pending = append(pending, dl)
+		}
+	}
+	d.state.Pending = pending
+	return d.save()
+}
+
+// Endpoints returns the registered endpoints in the order of registration.
+func (d *Dispatcher) Endpoints() []Endpoint {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]Endpoint(nil), d.state.Endpoints...)
+}
+
+// Pending returns the deliveries waiting to be sent or retried.
+func (d *Dispatcher) Pending() []Delivery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]Delivery(nil), d.state.Pending...)
+}
+
+// DeadLetters returns the deliveries failed all attempts.
+func (d *Dispatcher) DeadLetters() []Delivery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]Delivery(nil), d.state.Dead...)
+}
+
+// Publish queues the event delivery to every endpoint accepting the event
+// type. It does not wait for deliveries, they are sent by the started
+// dispatcher or DeliverDue. The event published again is not queued while its
+// delivery is pending or dead.
+func (d *Dispatcher) Publish(e invoice.Event) error {
+	payload, err := NewPayload(e).marshal()
+	if err != nil {
+		return errors.Wrapf(err, "event %q marshal failed", e.ID)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.opts.clock.Now().UTC()
+	queued := false
+	for i := range d.state.Endpoints {
+		ep := &d.state.Endpoints[i]
+		if !ep.accepts(e.Type) || d.queued(ep.ID, e.ID) {
+			continue
+		}
+		d.state.Pending = append(d.state.Pending, Delivery{
+			ID:         uuid.NewString(),
+			EndpointID: ep.ID,
+			EventID:    e.ID,
+			EventType:  e.Type,
+			Payload:    payload,
+			NextAt:     now,
+			CreatedAt:  now,
+		})
+		queued = true
+	}
+	if !queued {
+		return nil
+	}
+	if err := d.save(); err != nil {
+		return err
+	}
+	d.notify()
+	return nil
+}
+
+// Redeliver moves the dead letter back to the pending deliveries, it is sent
+// again with all attempts.
+func (d *Dispatcher) Redeliver(id string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	i := -1
+	for j := range d.state.Dead {
+		if d.state.Dead[j].ID == id {
+			i = j
+			break
+		}
+	}
+	if i < 0 {
+		return fmt.Errorf("dead letter %q not found", id)
+	}
+	dl := d.state.Dead[i]
+	if d.endpointIndex(dl.EndpointID) < 0 {
+		return fmt.Errorf("endpoint %q not found", dl.EndpointID)
+	}
+
+	dl.Attempts, dl.LastError, dl.NextAt = 0, "", d.opts.clock.Now().UTC()
+	d.state.Dead = append(d.state.Dead[:i], d.state.Dead[i+1:]...)
+	d.state.Pending = append(d.state.Pending, dl)
+	if err := d.save(); err != nil {
+		return err
+	}
+	d.notify()
+	return nil
+}
+
+// DeliverDue sends the deliveries which attempt time has come. Failed
+// deliveries are scheduled for retry or moved to the dead letters.
+func (d *Dispatcher) DeliverDue(ctx context.Context) {
+	d.sendMu.Lock()
+	defer d.sendMu.Unlock()
+
+	d.mu.Lock()
+	now := d.opts.clock.Now()
+	var due []Delivery
+	for _, dl := range d.state.Pending {
+		if !dl.NextAt.After(now) {
+			due = append(due, dl)
+		}
+	}
+	d.mu.Unlock()
+
+	for _, dl := range due {
+		if ctx.Err() != nil {
+			return
+		}
+
+		d.mu.Lock()
+		i := d.endpointIndex(dl.EndpointID)
+		var ep Endpoint
+		if i >= 0 {
+			ep = d.state.Endpoints[i]
+		}
+		d.mu.Unlock()
+		if i < 0 {
+			continue // removed meanwhile
+		}
+
+		err := d.send(ctx, &ep, &dl)
+		if err != nil {
+			d.logf("delivery %q of event %q to %s failed: %v", dl.ID, dl.EventID, ep.URL, err)
+		}
+		d.finish(dl.ID, err)
+	}
+}
+
+// Start starts sending deliveries in the background.
+func (d *Dispatcher) Start() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.started || d.stopped {
+		return errors.New("webhook dispatcher cannot be started twice")
+	}
+	d.started = true
+
+	d.wg.Add(1)
+	go d.loop()
+	return nil
+}
+
+// Stop stops sending deliveries. It waits for requests in flight until ctx is
+// done, then cancels them and returns ctx error. Unsent deliveries are kept
+// in the state.
+func (d *Dispatcher) Stop(ctx context.Context) error {
+	d.mu.Lock()
+	if d.stopped {
+		d.mu.Unlock()
+		return nil
+	}
+	d.stopped = true
+	close(d.stop)
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		d.cancel()
+		return nil
+	case <-ctx.Done():
+		d.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// loop sends due deliveries, then waits for the next attempt time or a new
+// delivery, until the dispatcher stops.
+func (d *Dispatcher) loop() {
+	defer d.wg.Done()
+
+	for {
+		d.DeliverDue(d.ctx)
+
+		var next <-chan time.Time
+		if at, ok := d.nextAt(); ok {
+			next = d.opts.clock.After(at.Sub(d.opts.clock.Now()))
+		}
+		select {
+		case <-d.stop:
+			return
+		case <-d.wake:
+		case <-next:
+		}
+	}
+}
+
+// send posts the delivery payload to the endpoint.
+func (d *Dispatcher) send(ctx context.Context, ep *Endpoint, dl *Delivery) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ep.URL, bytes.NewReader(dl.Payload))
+	if err != nil {
+		return err
+	}
+	ts := d.opts.clock.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "go-invoice-webhook")
+	req.Header.Set(HeaderID, dl.EventID)
+	req.Header.Set(HeaderEvent, dl.EventType)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(ts, 10))
+	req.Header.Set(HeaderSignature, Sign(ep.Secret, ts, dl.Payload))
+
+	resp, err := d.opts.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxReadBodyKB<<10)) // nolint:errcheck
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("endpoint responded %s", resp.Status)
+	}
+	return nil
+}
+
+// finish removes the sent delivery, or records the failed attempt.
+func (d *Dispatcher) finish(id string, sendErr error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	i := -1
+	for j := range d.state.Pending {
+		if d.state.Pending[j].ID == id {
+			i = j
+			break
+		}
+	}
+	if i < 0 {
+		return // endpoint removed meanwhile
+	}
+
+	if sendErr == nil {
+		d.state.Pending = append(d.state.Pending[:i], d.state.Pending[i+1:]...)
+	} else {
+		dl := &d.state.Pending[i]
+		dl.Attempts++
+		dl.LastError = sendErr.Error()
+		dl.NextAt = d.opts.clock.Now().UTC().Add(d.opts.policy.delay(dl.Attempts))
+		if dl.Attempts >= d.opts.policy.MaxAttempts {
+			d.state.Dead = append(d.state.Dead, *dl)
+			d.state.Pending = append(d.state.Pending[:i], d.state.Pending[i+1:]...)
+		}
+	}
+
+	if err := d.save(); err != nil {
+		d.logf("delivery %q not saved: %v", id, err)
+	}
+}
+
+// nextAt returns the earliest attempt time of pending deliveries.
+func (d *Dispatcher) nextAt() (time.Time, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var next time.Time
+	for _, dl := range d.state.Pending {
+		if next.IsZero() || dl.NextAt.Before(next) {
+			next = dl.NextAt
+		}
+	}
+	return next, !next.IsZero()
+}
+
+// queued returns true when the event delivery to the endpoint is pending or
+// dead. It should be called with the lock held.
+func (d *Dispatcher) queued(endpointID, eventID string) bool {
+	for _, dls := range [][]Delivery{d.state.Pending, d.state.Dead} {
+		for i := range dls {
+			if dls[i].EndpointID == endpointID && dls[i].EventID == eventID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// notify wakes up the delivery loop, it does not block.
+func (d *Dispatcher) notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// endpointIndex returns the index of the endpoint, -1 when not found. It
+// should be called with the lock held.
+func (d *Dispatcher) endpointIndex(id string) int {
+	for i := range d.state.Endpoints {
+		if d.state.Endpoints[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// save writes the state file when it set. It should be called with the lock
+// held.
+func (d *Dispatcher) save() error {
+	if d.opts.stateFile == "" {
+		return nil
+	}
+	return saveState(d.opts.stateFile, d.state)
+}
+
+func (d *Dispatcher) logf(format string, args ...interface{}) {
+	fmt.Fprintf(d.opts.log, "webhook: "+format+"\n", args...)
+}
+
+// parseEvents validates the event types filter, nil means all events.
+func parseEvents(events []string) ([]string, error) {
+	known := make(map[string]bool)
+	for _, t := range invoice.EventTypes() {
+		known[t] = true
+	}
+
+	var filter []string
+	for _, t := range events {
+		if t == AllEvents {
+			return nil, nil
+		}
+		if !known[t] {
+			return nil, fmt.Errorf("unknown event type %q", t)
+		}
+		filter = append(filter, t)
+	}
+	return filter, nil
+}
+
+func newSecret() (string, error) {
+	b := make([]byte, secretSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "generate endpoint secret failed")
+	}
+	return hex.EncodeToString(b), nil
+}
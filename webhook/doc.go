@@ -0,0 +1,6 @@
+// Package webhook delivers invoice events to the registered HTTP endpoints.
+// Every event is posted as the JSON payload signed with the endpoint secret.
+// Deliveries are retried with exponential backoff until they succeed or run
+// out of attempts, then they are moved to the dead letters, from where they
+// can be redelivered.
+package webhook
@@ -0,0 +1,110 @@
+package webhook
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/antklim/go-invoice/scheduler"
+)
+
+// RetryPolicy defines how failed deliveries are retried. The delay doubles
+// after every failed attempt up to MaxDelay.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// DefaultRetryPolicy retries the delivery for about a day.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 10,               // nolint:gomnd
+	BaseDelay:   30 * time.Second, // nolint:gomnd
+	MaxDelay:    6 * time.Hour,    // nolint:gomnd
+}
+
+// delay returns the delay after the nth failed attempt.
+func (p RetryPolicy) delay(n int) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < n && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	if d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	return d
+}
+
+// DefaultTimeout is the default timeout of the delivery request.
+const DefaultTimeout = 10 * time.Second
+
+type options struct {
+	clock     scheduler.Clock
+	stateFile string
+	policy    RetryPolicy
+	client    *http.Client
+	log       io.Writer
+}
+
+var defaultOptions = options{
+	clock:  scheduler.SystemClock,
+	policy: DefaultRetryPolicy,
+	client: &http.Client{Timeout: DefaultTimeout},
+	log:    io.Discard,
+}
+
+type Option interface {
+	apply(*options)
+}
+
+type funcOption struct {
+	f func(*options)
+}
+
+func (f *funcOption) apply(o *options) {
+	f.f(o)
+}
+
+func newFuncOption(f func(*options)) Option {
+	return &funcOption{f: f}
+}
+
+// WithClock sets the clock deliveries are scheduled by. The system clock is
+// used by default.
+func WithClock(v scheduler.Clock) Option {
+	return newFuncOption(func(o *options) {
+		o.clock = v
+	})
+}
+
+// WithStateFile sets the JSON file endpoints and deliveries are kept in. By
+// default they are kept in memory and lost on restart.
+func WithStateFile(v string) Option {
+	return newFuncOption(func(o *options) {
+		o.stateFile = v
+	})
+}
+
+// WithRetryPolicy sets the retry policy of failed deliveries. The default
+// policy is DefaultRetryPolicy.
+func WithRetryPolicy(v RetryPolicy) Option {
+	return newFuncOption(func(o *options) {
+		o.policy = v
+	})
+}
+
+// WithTimeout sets the timeout of the delivery request. The default timeout
+// is DefaultTimeout.
+func WithTimeout(v time.Duration) Option {
+	return newFuncOption(func(o *options) {
+		o.client = &http.Client{Timeout: v}
+	})
+}
+
+// WithLog sets where failed deliveries are logged. They are not logged by
+// default.
+func WithLog(v io.Writer) Option {
+	return newFuncOption(func(o *options) {
+		o.log = v
+	})
+}
@@ -0,0 +1,81 @@
+package webhook
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/antklim/go-invoice/invoice"
+)
+
+// Payload is the JSON body of the delivery request.
+type Payload struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"createdAt"`
+	Data      Data      `json:"data"`
+}
+
+// Data describes the invoice after the event and its status transition.
+type Data struct {
+	Invoice    Invoice     `json:"invoice"`
+	Transition *Transition `json:"transition,omitempty"` // nil when the invoice created
+}
+
+type Transition struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Invoice is the invoice view of the payload, prices are in cents.
+type Invoice struct {
+	ID           string     `json:"id"`
+	CustomerName string     `json:"customerName"`
+	Status       string     `json:"status"`
+	Date         *time.Time `json:"date,omitempty"`
+	Items        []Item     `json:"items"`
+	Total        int        `json:"total"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	UpdatedAt    time.Time  `json:"updatedAt"`
+}
+
+type Item struct {
+	ID          string `json:"id"`
+	ProductName string `json:"productName"`
+	Price       int    `json:"price"`
+	Qty         int    `json:"qty"`
+}
+
+// NewPayload returns the payload of the event.
+func NewPayload(e invoice.Event) Payload {
+	inv := e.Invoice
+	items := make([]Item, len(inv.Items))
+	for i, item := range inv.Items {
+		items[i] = Item{ID: item.ID, ProductName: item.ProductName, Price: item.Price, Qty: item.Qty}
+	}
+
+	p := Payload{
+		ID:        e.ID,
+		Type:      e.Type,
+		CreatedAt: e.At.UTC(),
+		Data: Data{
+			Invoice: Invoice{
+				ID:           inv.ID,
+				CustomerName: inv.CustomerName,
+				Status:       inv.Status.String(),
+				Date:         inv.Date,
+				Items:        items,
+				Total:        inv.Total(),
+				CreatedAt:    inv.CreatedAt,
+				UpdatedAt:    inv.UpdatedAt,
+			},
+		},
+	}
+	if e.From != "" {
+		p.Data.Transition = &Transition{From: e.From, To: inv.Status.String()}
+	}
+	return p
+}
+
+func (p Payload) marshal() (json.RawMessage, error) {
+	return json.Marshal(p)
+}
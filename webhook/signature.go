@@ -0,0 +1,59 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Headers of the delivery request.
+const (
+	HeaderID        = "X-Webhook-Id"        // event ID, the same for all deliveries of the event
+	HeaderEvent     = "X-Webhook-Event"     // event type
+	HeaderTimestamp = "X-Webhook-Timestamp" // Unix time the request was signed at
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+const signatureVersion = "v1="
+
+var (
+	// ErrInvalidSignature is returned when the signature does not match the
+	// payload.
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	// ErrExpiredSignature is returned when the signature timestamp is out of
+	// the tolerance.
+	ErrExpiredSignature = errors.New("expired webhook signature")
+)
+
+// Sign returns the signature of the payload sent at the timestamp: "v1=" and
+// the hex HMAC-SHA256 of "<timestamp>.<payload>" keyed by the secret.
+func Sign(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10))) // nolint:errcheck
+	mac.Write([]byte("."))                              // nolint:errcheck
+	mac.Write(payload)                                  // nolint:errcheck
+	return signatureVersion + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature and the timestamp headers of the payload
+// received at now. The timestamp should be within the tolerance from now, so
+// captured requests cannot be replayed later.
+func Verify(secret, timestamp, signature string, payload []byte, now time.Time, tolerance time.Duration) error {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.Wrap(ErrInvalidSignature, "invalid timestamp")
+	}
+	if !strings.HasPrefix(signature, signatureVersion) ||
+		!hmac.Equal([]byte(signature), []byte(Sign(secret, ts, payload))) {
+		return ErrInvalidSignature
+	}
+	if d := now.Sub(time.Unix(ts, 0)); d > tolerance || d < -tolerance {
+		return ErrExpiredSignature
+	}
+	return nil
+}
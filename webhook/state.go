@@ -0,0 +1,100 @@
+package webhook
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Endpoint is the registered receiver of events.
+type Endpoint struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret"`
+	Events    []string  `json:"events,omitempty"` // event types, all events when empty
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// accepts returns true when the endpoint receives events of the type.
+func (e *Endpoint) accepts(typ string) bool {
+	if len(e.Events) == 0 {
+		return true
+	}
+	for _, t := range e.Events {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// Delivery is the event payload to post to the endpoint.
+type Delivery struct {
+	ID         string          `json:"id"`
+	EndpointID string          `json:"endpointId"`
+	EventID    string          `json:"eventId"`
+	EventType  string          `json:"eventType"`
+	Payload    json.RawMessage `json:"payload"`
+	Attempts   int             `json:"attempts"`            // failed attempts
+	NextAt     time.Time       `json:"nextAt"`              // time of the next attempt
+	LastError  string          `json:"lastError,omitempty"` // error of the last failed attempt
+	CreatedAt  time.Time       `json:"createdAt"`
+}
+
+// state is what the dispatcher keeps between restarts.
+type state struct {
+	Endpoints []Endpoint `json:"endpoints"`
+	Pending   []Delivery `json:"pending"`
+	Dead      []Delivery `json:"dead"`
+}
+
+// loadState reads the state file. The state is empty when the file does not
+// exist.
+func loadState(path string) (state, error) {
+	var s state
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return s, errors.Wrap(err, "read webhook state failed")
+	}
+	if err := json.Unmarshal(data, &s); err != nil {
+		return s, errors.Wrapf(err, "webhook state %q invalid", path)
+	}
+	return s, nil
+}
+
+// saveState replaces the state file through a temporary file, so it is never
+// torn.
+func saveState(path string, s state) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "write webhook state failed")
+	}
+	return errors.Wrap(writeFile(path, data), "write webhook state failed")
+}
+
+func writeFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
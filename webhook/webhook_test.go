@@ -0,0 +1,313 @@
+package webhook_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/antklim/go-invoice/invoice"
+	"github.com/antklim/go-invoice/test/fakes"
+	"github.com/antklim/go-invoice/webhook"
+)
+
+const secret = "s3cret"
+
+// receiver is the endpoint server responding with the queued statuses, 200
+// when there are none.
+type receiver struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   [][]byte
+	srv      *httptest.Server
+}
+
+func newReceiver(statuses ...int) *receiver {
+	r := &receiver{statuses: statuses}
+	r.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.requests = append(r.requests, req)
+		r.bodies = append(r.bodies, body)
+		status := http.StatusOK
+		if len(r.statuses) > 0 {
+			status, r.statuses = r.statuses[0], r.statuses[1:]
+		}
+		w.WriteHeader(status)
+	}))
+	return r
+}
+
+func (r *receiver) received() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.requests)
+}
+
+func event(typ, from string) invoice.Event {
+	inv := invoice.NewInvoice("John Doe")
+	inv.Items = []invoice.Item{{ID: "1", ProductName: "Pen", Price: 150, Qty: 2}}
+	inv.Status = invoice.Paid
+	return invoice.Event{ID: "event-1", Type: typ, At: time.Now(), From: from, Invoice: inv}
+}
+
+func TestSignature(t *testing.T) {
+	payload := []byte(`{"id":"1"}`)
+	now := time.Unix(1700000000, 0)
+	sig := webhook.Sign(secret, now.Unix(), payload)
+	ts := "1700000000"
+
+	if err := webhook.Verify(secret, ts, sig, payload, now.Add(time.Minute), 5*time.Minute); err != nil {
+		t.Errorf("Verify() failed: %v", err)
+	}
+	if err := webhook.Verify("other", ts, sig, payload, now, time.Minute); err == nil {
+		t.Error("Verify() with other secret expected to fail")
+	}
+	if err := webhook.Verify(secret, ts, sig, []byte(`{"id":"2"}`), now, time.Minute); err == nil {
+		t.Error("Verify() of changed payload expected to fail")
+	}
+	if err := webhook.Verify(secret, "1700000001", sig, payload, now, time.Minute); err == nil {
+		t.Error("Verify() of changed timestamp expected to fail")
+	}
+	if err := webhook.Verify(secret, ts, sig, payload, now.Add(time.Hour), time.Minute); err != webhook.ErrExpiredSignature {
+		t.Errorf("Verify() of old request error %v, want %v", err, webhook.ErrExpiredSignature)
+	}
+}
+
+func TestAddEndpoint(t *testing.T) {
+	d, err := webhook.New()
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+
+	ep, err := d.AddEndpoint("https://example.com/hook", "", []string{webhook.AllEvents})
+	if err != nil {
+		t.Fatalf("AddEndpoint() failed: %v", err)
+	}
+	if ep.ID == "" || len(ep.Secret) != 64 || ep.Events != nil {
+		t.Errorf("invalid endpoint %+v", ep)
+	}
+
+	for _, tc := range []struct {
+		url    string
+		events []string
+	}{
+		{"example.com/hook", nil},
+		{"ftp://example.com/hook", nil},
+		{"https://example.com/hook", []string{"invoice.refunded"}},
+	} {
+		if _, err := d.AddEndpoint(tc.url, secret, tc.events); err == nil {
+			t.Errorf("AddEndpoint(%q, %v) expected to fail", tc.url, tc.events)
+		}
+	}
+
+	if err := d.RemoveEndpoint(ep.ID); err != nil {
+		t.Fatalf("RemoveEndpoint() failed: %v", err)
+	}
+	if eps := d.Endpoints(); len(eps) != 0 {
+		t.Errorf("invalid endpoints %v, want none", eps)
+	}
+	if err := d.RemoveEndpoint(ep.ID); err == nil {
+		t.Error("RemoveEndpoint() of removed endpoint expected to fail")
+	}
+}
+
+func TestDeliverDue(t *testing.T) {
+	t.Run("posts signed payload to endpoints accepting event", func(t *testing.T) {
+		r := newReceiver()
+		defer r.srv.Close()
+		clock := fakes.NewClock(time.Now())
+		d, err := webhook.New(webhook.WithClock(clock))
+		if err != nil {
+			t.Fatalf("New() failed: %v", err)
+		}
+		if _, err := d.AddEndpoint(r.srv.URL, secret, []string{"invoice.paid"}); err != nil {
+			t.Fatalf("AddEndpoint() failed: %v", err)
+		}
+		if _, err := d.AddEndpoint(r.srv.URL+"/issued", secret, []string{"invoice.issued"}); err != nil {
+			t.Fatalf("AddEndpoint() failed: %v", err)
+		}
+
+		if err := d.Publish(event("invoice.paid", "issued")); err != nil {
+			t.Fatalf("Publish() failed: %v", err)
+		}
+		d.DeliverDue(context.Background())
+
+		if r.received() != 1 {
+			t.Fatalf("invalid number of requests %d, want 1", r.received())
+		}
+		req, body := r.requests[0], r.bodies[0]
+		if req.URL.Path != "/" || req.Header.Get(webhook.HeaderEvent) != "invoice.paid" ||
+			req.Header.Get(webhook.HeaderID) != "event-1" {
+			t.Errorf("invalid request %s %v", req.URL, req.Header)
+		}
+		if err := webhook.Verify(secret, req.Header.Get(webhook.HeaderTimestamp),
+			req.Header.Get(webhook.HeaderSignature), body, clock.Now(), time.Minute); err != nil {
+			t.Errorf("Verify() failed: %v", err)
+		}
+
+		var p webhook.Payload
+		if err := json.Unmarshal(body, &p); err != nil {
+			t.Fatalf("invalid payload %s: %v", body, err)
+		}
+		if p.ID != "event-1" || p.Type != "invoice.paid" || p.Data.Invoice.Total != 300 ||
+			p.Data.Invoice.Status != "paid" || len(p.Data.Invoice.Items) != 1 {
+			t.Errorf("invalid payload %s", body)
+		}
+		if p.Data.Transition == nil || *p.Data.Transition != (webhook.Transition{From: "issued", To: "paid"}) {
+			t.Errorf("invalid payload transition %v", p.Data.Transition)
+		}
+		if pending := d.Pending(); len(pending) != 0 {
+			t.Errorf("invalid pending deliveries %v, want none", pending)
+		}
+	})
+
+	t.Run("retries with backoff and dead letters", func(t *testing.T) {
+		r := newReceiver(http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable)
+		defer r.srv.Close()
+		clock := fakes.NewClock(time.Now())
+		policy := webhook.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Minute, MaxDelay: time.Hour}
+		d, err := webhook.New(webhook.WithClock(clock), webhook.WithRetryPolicy(policy))
+		if err != nil {
+			t.Fatalf("New() failed: %v", err)
+		}
+		if _, err := d.AddEndpoint(r.srv.URL, secret, nil); err != nil {
+			t.Fatalf("AddEndpoint() failed: %v", err)
+		}
+
+		if err := d.Publish(event("invoice.created", "")); err != nil {
+			t.Fatalf("Publish() failed: %v", err)
+		}
+		d.DeliverDue(context.Background())
+		pending := d.Pending()
+		if len(pending) != 1 || pending[0].Attempts != 1 || !pending[0].NextAt.Equal(clock.Now().UTC().Add(time.Minute)) {
+			t.Fatalf("invalid pending deliveries %+v", pending)
+		}
+
+		d.DeliverDue(context.Background())
+		if r.received() != 1 {
+			t.Fatalf("delivery retried before its time, requests %d", r.received())
+		}
+
+		clock.Advance(time.Minute)
+		d.DeliverDue(context.Background())
+		if pending := d.Pending(); len(pending) != 1 || pending[0].Attempts != 2 ||
+			!pending[0].NextAt.Equal(clock.Now().UTC().Add(2*time.Minute)) {
+			t.Fatalf("invalid pending deliveries %+v", pending)
+		}
+
+		clock.Advance(2 * time.Minute)
+		d.DeliverDue(context.Background())
+		dead := d.DeadLetters()
+		if len(dead) != 1 || dead[0].Attempts != 3 || dead[0].LastError != "endpoint responded 503 Service Unavailable" {
+			t.Fatalf("invalid dead letters %+v", dead)
+		}
+		if pending := d.Pending(); len(pending) != 0 {
+			t.Errorf("invalid pending deliveries %v, want none", pending)
+		}
+
+		if err := d.Redeliver(dead[0].ID); err != nil {
+			t.Fatalf("Redeliver() failed: %v", err)
+		}
+		d.DeliverDue(context.Background())
+		if r.received() != 4 || len(d.Pending()) != 0 || len(d.DeadLetters()) != 0 {
+			t.Errorf("redelivery not sent, requests %d", r.received())
+		}
+		if err := d.Redeliver(dead[0].ID); err == nil {
+			t.Error("Redeliver() of delivered letter expected to fail")
+		}
+	})
+}
+
+func TestPublishDeduplicates(t *testing.T) {
+	d, err := webhook.New()
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	if _, err := d.AddEndpoint("https://example.com/hook", secret, nil); err != nil {
+		t.Fatalf("AddEndpoint() failed: %v", err)
+	}
+
+	e := event("invoice.created", "")
+	for i := 0; i < 2; i++ {
+		if err := d.Publish(e); err != nil {
+			t.Fatalf("Publish() failed: %v", err)
+		}
+	}
+	if pending := d.Pending(); len(pending) != 1 || pending[0].EventID != e.ID {
+		t.Errorf("invalid pending deliveries %v, want one delivery of %q", pending, e.ID)
+	}
+}
+
+func TestStateFile(t *testing.T) {
+	r := newReceiver()
+	defer r.srv.Close()
+	path := filepath.Join(t.TempDir(), "webhooks.json")
+
+	d, err := webhook.New(webhook.WithStateFile(path))
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	ep, err := d.AddEndpoint(r.srv.URL, secret, nil)
+	if err != nil {
+		t.Fatalf("AddEndpoint() failed: %v", err)
+	}
+	if err := d.Publish(event("invoice.created", "")); err != nil {
+		t.Fatalf("Publish() failed: %v", err)
+	}
+
+	restarted, err := webhook.New(webhook.WithStateFile(path))
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	if eps := restarted.Endpoints(); len(eps) != 1 || eps[0].ID != ep.ID || eps[0].Secret != secret {
+		t.Errorf("invalid restored endpoints %v", eps)
+	}
+	if pending := restarted.Pending(); len(pending) != 1 {
+		t.Fatalf("invalid restored pending deliveries %v", pending)
+	}
+	restarted.DeliverDue(context.Background())
+	if r.received() != 1 {
+		t.Errorf("invalid number of requests %d, want 1", r.received())
+	}
+}
+
+func TestStartStop(t *testing.T) {
+	r := newReceiver()
+	defer r.srv.Close()
+	d, err := webhook.New()
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	if _, err := d.AddEndpoint(r.srv.URL, secret, nil); err != nil {
+		t.Fatalf("AddEndpoint() failed: %v", err)
+	}
+	if err := d.Start(); err != nil {
+		t.Fatalf("Start() failed: %v", err)
+	}
+
+	if err := d.Publish(event("invoice.created", "")); err != nil {
+		t.Fatalf("Publish() failed: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for r.received() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if r.received() != 1 {
+		t.Errorf("invalid number of requests %d, want 1", r.received())
+	}
+
+	if err := d.Stop(context.Background()); err != nil {
+		t.Errorf("Stop() failed: %v", err)
+	}
+	if err := d.Start(); err == nil {
+		t.Error("Start() of stopped dispatcher expected to fail")
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/antklim/go-invoice/cli"
+	"github.com/antklim/go-invoice/invoice"
+	"github.com/antklim/go-invoice/webhook"
+)
+
+// webhooksStopTimeout is how long requests in flight are waited for on exit
+// before they are canceled.
+const webhooksStopTimeout = 10 * time.Second
+
+// initWebhooks creates the webhook dispatcher, of the state file when it is
+// set, and starts delivering events. Failed deliveries are printed.
+func initWebhooks() *webhook.Dispatcher {
+	opts := []webhook.Option{webhook.WithLog(os.Stdout)}
+	if webhooksState != "" {
+		opts = append(opts, webhook.WithStateFile(webhooksState))
+	}
+	d, err := webhook.New(opts...)
+	if err != nil {
+		panic("svc: " + err.Error())
+	}
+	if err := d.Start(); err != nil {
+		panic("svc: start webhooks failed: " + err.Error())
+	}
+	return d
+}
+
+// stopWebhooks waits for requests in flight to finish.
+func stopWebhooks(d *webhook.Dispatcher) {
+	ctx, cancel := context.WithTimeout(context.Background(), webhooksStopTimeout)
+	defer cancel()
+	if err := d.Stop(ctx); err != nil {
+		fmt.Printf("stop webhooks failed: %v\n", err)
+	}
+}
+
+// initPublishing returns the service option publishing invoice events to the
+// dispatcher and the function stopping it. Events are written to the outbox
+// of the storage and relayed every -relay-interval when the storage has one,
+// otherwise they are published after the change is stored.
+func initPublishing(strg, svcStrg invoice.Storage, d *webhook.Dispatcher) (invoice.Option, func()) {
+	if _, ok := strg.(invoice.Outbox); !ok {
+		return invoice.WithPublisher(d), func() {}
+	}
+
+	relay := invoice.NewRelay(svcStrg.(invoice.Outbox), d)
+	run := func() {
+		if _, err := relay.Relay(invoice.DefaultRelayBatch); err != nil {
+			fmt.Printf("relay events failed: %v\n", err)
+		}
+	}
+
+	stop, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(relayInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				run()
+			case <-stop:
+				run()
+				return
+			}
+		}
+	}()
+	return invoice.WithOutbox(), func() {
+		close(stop)
+		<-done
+	}
+}
+
+// webhookAddHandler registers the endpoint of the URL receiving the event
+// types that follow it, all events when none. The generated secret is printed
+// once.
+func webhookAddHandler(d *webhook.Dispatcher) cli.RunnerFunc {
+	return func(out io.Writer, args ...string) {
+		if len(args) == 0 || args[0] == "" {
+			fmt.Fprint(out, "add webhook failed: missing endpoint URL\n")
+			return
+		}
+
+		var events []string
+		for _, arg := range args[1:] {
+			if e := strings.TrimSpace(arg); e != "" {
+				events = append(events, e)
+			}
+		}
+
+		ep, err := d.AddEndpoint(strings.TrimSpace(args[0]), "", events)
+		if err != nil {
+			fmt.Fprintf(out, "add webhook failed: %v\n", err)
+			return
+		}
+
+		fmt.Fprintf(out, "%q webhook successfully added, signing secret: %s\n", ep.ID, ep.Secret)
+	}
+}
+
+func webhookListHandler(d *webhook.Dispatcher) cli.RunnerFunc {
+	return func(out io.Writer, args ...string) {
+		eps := d.Endpoints()
+		if len(eps) == 0 {
+			fmt.Fprint(out, "no webhooks added\n")
+			return
+		}
+
+		pending := make(map[string]int)
+		for _, dl := range d.Pending() {
+			pending[dl.EndpointID]++
+		}
+
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0) // nolint:gomnd
+		fmt.Fprintln(w, "ID\tURL\tEVENTS\tPENDING")
+		for _, ep := range eps {
+			events := webhook.AllEvents
+			if len(ep.Events) > 0 {
+				events = strings.Join(ep.Events, ",")
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\n", ep.ID, ep.URL, events, pending[ep.ID])
+		}
+		w.Flush()
+	}
+}
+
+func webhookRemoveHandler(d *webhook.Dispatcher) cli.RunnerFunc {
+	return func(out io.Writer, args ...string) {
+		if len(args) == 0 || args[0] == "" {
+			fmt.Fprint(out, "remove webhook failed: missing webhook ID\n")
+			return
+		}
+
+		id := strings.TrimSpace(args[0])
+		if err := d.RemoveEndpoint(id); err != nil {
+			fmt.Fprintf(out, "remove webhook failed: %v\n", err)
+			return
+		}
+
+		fmt.Fprintf(out, "%q webhook successfully removed\n", id)
+	}
+}
+
+func webhookDeadHandler(d *webhook.Dispatcher) cli.RunnerFunc {
+	return func(out io.Writer, args ...string) {
+		dead := d.DeadLetters()
+		if len(dead) == 0 {
+			fmt.Fprint(out, "no dead letters\n")
+			return
+		}
+
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0) // nolint:gomnd
+		fmt.Fprintln(w, "ID\tWEBHOOK\tEVENT\tCREATED\tATTEMPTS\tLAST ERROR")
+		for _, dl := range dead {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\n",
+				dl.ID, dl.EndpointID, dl.EventType, dl.CreatedAt.Format(time.RFC3339), dl.Attempts, dl.LastError)
+		}
+		w.Flush()
+	}
+}
+
+func webhookRedeliverHandler(d *webhook.Dispatcher) cli.RunnerFunc {
+	return func(out io.Writer, args ...string) {
+		if len(args) == 0 || args[0] == "" {
+			fmt.Fprint(out, "redeliver webhook failed: missing dead letter ID\n")
+			return
+		}
+
+		id := strings.TrimSpace(args[0])
+		if err := d.Redeliver(id); err != nil {
+			fmt.Fprintf(out, "redeliver webhook failed: %v\n", err)
+			return
+		}
+
+		fmt.Fprintf(out, "%q dead letter successfully queued for redelivery\n", id)
+	}
+}